@@ -0,0 +1,367 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/types"
+)
+
+const (
+	trafficKeyPrefix = "traffic"
+	// How often the endpoints traffic counters are sampled
+	trafficSampleInterval = time.Minute
+	// Time span covered by each bucket of the traffic history
+	trafficBucketSize = time.Hour
+	// How long the traffic history of an endpoint is kept
+	trafficRetention = 31 * 24 * time.Hour
+)
+
+// TrafficCounters holds the number of bytes and packets received and
+// transmitted by an endpoint
+type TrafficCounters struct {
+	RxBytes   uint64
+	RxPackets uint64
+	TxBytes   uint64
+	TxPackets uint64
+}
+
+// EndpointTraffic is the traffic of an endpoint split by its peers: the
+// endpoints on the same network, the endpoints on the other networks of the
+// controller, and anything else
+type EndpointTraffic struct {
+	IntraNetwork TrafficCounters
+	CrossNetwork TrafficCounters
+	External     TrafficCounters
+}
+
+// TrafficBucket is the traffic accounted to an endpoint over the hour
+// starting at Start
+type TrafficBucket struct {
+	Start   time.Time
+	Traffic EndpointTraffic
+}
+
+func (tc *TrafficCounters) add(o TrafficCounters) {
+	tc.RxBytes += o.RxBytes
+	tc.RxPackets += o.RxPackets
+	tc.TxBytes += o.TxBytes
+	tc.TxPackets += o.TxPackets
+}
+
+// since returns the increase of the counters from the previous sample. A
+// counter lower than in the previous sample was reset, in which case all
+// of its current value is new traffic.
+func (tc TrafficCounters) since(prev TrafficCounters) TrafficCounters {
+	delta := func(cur, prev uint64) uint64 {
+		if cur < prev {
+			return cur
+		}
+		return cur - prev
+	}
+	return TrafficCounters{
+		RxBytes:   delta(tc.RxBytes, prev.RxBytes),
+		RxPackets: delta(tc.RxPackets, prev.RxPackets),
+		TxBytes:   delta(tc.TxBytes, prev.TxBytes),
+		TxPackets: delta(tc.TxPackets, prev.TxPackets),
+	}
+}
+
+func (et *EndpointTraffic) add(o EndpointTraffic) {
+	et.IntraNetwork.add(o.IntraNetwork)
+	et.CrossNetwork.add(o.CrossNetwork)
+	et.External.add(o.External)
+}
+
+func (et EndpointTraffic) since(prev EndpointTraffic) EndpointTraffic {
+	return EndpointTraffic{
+		IntraNetwork: et.IntraNetwork.since(prev.IntraNetwork),
+		CrossNetwork: et.CrossNetwork.since(prev.CrossNetwork),
+		External:     et.External.since(prev.External),
+	}
+}
+
+// endpointTraffic is the traffic history of an endpoint, persisted in the
+// local store so that it survives container and daemon restarts as well as
+// the endpoint deletion
+type endpointTraffic struct {
+	id      string
+	Network string
+	Buckets []TrafficBucket
+	// Last holds the counters read by the previous sample
+	Last     EndpointTraffic
+	Updated  time.Time
+	dbIndex  uint64
+	dbExists bool
+	sync.Mutex
+}
+
+func (et *endpointTraffic) Key() []string {
+	et.Lock()
+	defer et.Unlock()
+
+	return []string{trafficKeyPrefix, et.id}
+}
+
+func (et *endpointTraffic) KeyPrefix() []string {
+	return []string{trafficKeyPrefix}
+}
+
+func (et *endpointTraffic) Value() []byte {
+	et.Lock()
+	defer et.Unlock()
+
+	b, err := json.Marshal(et)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (et *endpointTraffic) SetValue(value []byte) error {
+	et.Lock()
+	defer et.Unlock()
+
+	return json.Unmarshal(value, et)
+}
+
+func (et *endpointTraffic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"id":      et.id,
+		"Network": et.Network,
+		"Buckets": et.Buckets,
+		"Last":    et.Last,
+		"Updated": et.Updated,
+	})
+}
+
+func (et *endpointTraffic) UnmarshalJSON(b []byte) error {
+	var v struct {
+		ID      string `json:"id"`
+		Network string
+		Buckets []TrafficBucket
+		Last    EndpointTraffic
+		Updated time.Time
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	et.id = v.ID
+	et.Network = v.Network
+	et.Buckets = v.Buckets
+	et.Last = v.Last
+	et.Updated = v.Updated
+	return nil
+}
+
+func (et *endpointTraffic) Index() uint64 {
+	et.Lock()
+	defer et.Unlock()
+
+	return et.dbIndex
+}
+
+func (et *endpointTraffic) SetIndex(index uint64) {
+	et.Lock()
+	et.dbIndex = index
+	et.dbExists = true
+	et.Unlock()
+}
+
+func (et *endpointTraffic) Exists() bool {
+	et.Lock()
+	defer et.Unlock()
+
+	return et.dbExists
+}
+
+func (et *endpointTraffic) Skip() bool {
+	return false
+}
+
+func (et *endpointTraffic) New() datastore.KVObject {
+	return &endpointTraffic{}
+}
+
+func (et *endpointTraffic) CopyTo(o datastore.KVObject) error {
+	et.Lock()
+	defer et.Unlock()
+
+	dstEt := o.(*endpointTraffic)
+	dstEt.id = et.id
+	dstEt.Network = et.Network
+	dstEt.Buckets = make([]TrafficBucket, len(et.Buckets))
+	copy(dstEt.Buckets, et.Buckets)
+	dstEt.Last = et.Last
+	dstEt.Updated = et.Updated
+	dstEt.dbIndex = et.dbIndex
+	dstEt.dbExists = et.dbExists
+
+	return nil
+}
+
+func (et *endpointTraffic) DataScope() string {
+	return datastore.LocalScope
+}
+
+// record accounts the counters read at the passed time. Once the endpoint
+// leaves its sandbox, its counters are gone: the next sample starts over.
+func (et *endpointTraffic) record(sample EndpointTraffic, now time.Time, final bool) {
+	et.Lock()
+	defer et.Unlock()
+
+	delta := sample.since(et.Last)
+	et.Last = sample
+	if final {
+		et.Last = EndpointTraffic{}
+	}
+	et.Updated = now
+
+	start := now.Truncate(trafficBucketSize)
+	if n := len(et.Buckets); n > 0 && et.Buckets[n-1].Start.Equal(start) {
+		et.Buckets[n-1].Traffic.add(delta)
+	} else {
+		et.Buckets = append(et.Buckets, TrafficBucket{Start: start, Traffic: delta})
+	}
+
+	i := 0
+	for i < len(et.Buckets) && now.Sub(et.Buckets[i].Start) > trafficRetention {
+		i++
+	}
+	et.Buckets = et.Buckets[i:]
+}
+
+func (c *controller) recordTraffic(eid, nid string, sample EndpointTraffic, final bool) error {
+	store := c.getStore(datastore.LocalScope)
+	if store == nil {
+		return nil
+	}
+
+	for {
+		et := &endpointTraffic{id: eid}
+		if err := store.GetObject(datastore.Key(et.Key()...), et); err != nil && err != datastore.ErrKeyNotFound {
+			return err
+		}
+		et.id = eid
+		et.Network = nid
+		et.record(sample, time.Now(), final)
+		if err := c.updateToStore(et); err != datastore.ErrKeyModified {
+			return err
+		}
+	}
+}
+
+// sampleTraffic accounts the traffic of all the endpoints attached to a sandbox
+func (c *controller) sampleTraffic() {
+	for _, sb := range c.Sandboxes() {
+		sb := sb.(*sandbox)
+		counters, err := sb.readTrafficCounters()
+		if err != nil {
+			log.Warnf("Failed to read the traffic counters of sandbox %s: %v", sb.ID(), err)
+			continue
+		}
+		for _, ep := range sb.getConnectedEndpoints() {
+			sample, ok := counters[ep.ID()]
+			if !ok {
+				continue
+			}
+			if err := c.recordTraffic(ep.ID(), ep.getNetwork().ID(), *sample, false); err != nil {
+				log.Warnf("Failed to record the traffic of endpoint %s: %v", ep.ID(), err)
+			}
+		}
+	}
+
+	c.pruneTraffic()
+}
+
+// pruneTraffic removes the history of the endpoints without any traffic
+// accounted for longer than the retention period
+func (c *controller) pruneTraffic() {
+	store := c.getStore(datastore.LocalScope)
+	if store == nil {
+		return
+	}
+
+	kvol, err := store.List(datastore.Key(trafficKeyPrefix), &endpointTraffic{})
+	if err != nil {
+		if err != datastore.ErrKeyNotFound {
+			log.Warnf("Failed to list the endpoints traffic history: %v", err)
+		}
+		return
+	}
+
+	for _, kvo := range kvol {
+		et := kvo.(*endpointTraffic)
+		if time.Since(et.Updated) <= trafficRetention {
+			continue
+		}
+		if err := c.deleteFromStore(et); err != nil {
+			log.Warnf("Failed to remove the traffic history of endpoint %s: %v", et.id, err)
+		}
+	}
+}
+
+// trafficAccounting returns whether the traffic of the endpoints is
+// accounted
+func (c *controller) trafficAccounting() bool {
+	return c.cfg != nil && c.cfg.Daemon.TrafficAccounting
+}
+
+func (c *controller) startTrafficAccounting() {
+	c.Lock()
+	if c.trafficStopCh != nil {
+		c.Unlock()
+		return
+	}
+	stopCh := make(chan struct{})
+	c.trafficStopCh = stopCh
+	c.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(trafficSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.sampleTraffic()
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (c *controller) stopTrafficAccounting() {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.trafficStopCh != nil {
+		close(c.trafficStopCh)
+		c.trafficStopCh = nil
+	}
+}
+
+func (c *controller) TrafficHistory(eid string) ([]TrafficBucket, error) {
+	store := c.getStore(datastore.LocalScope)
+	if store == nil {
+		return nil, types.NotFoundErrorf("no traffic history available: local store is not initialized")
+	}
+
+	et := &endpointTraffic{id: eid}
+	if err := store.GetObject(datastore.Key(et.Key()...), et); err != nil {
+		if err == datastore.ErrKeyNotFound {
+			return nil, types.NotFoundErrorf("no traffic history for endpoint %s", eid)
+		}
+		return nil, types.InternalErrorf("failed to retrieve the traffic history of endpoint %s: %v", eid, err)
+	}
+
+	et.Lock()
+	defer et.Unlock()
+	buckets := make([]TrafficBucket, len(et.Buckets))
+	copy(buckets, et.Buckets)
+
+	return buckets, nil
+}
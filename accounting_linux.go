@@ -0,0 +1,271 @@
+package libnetwork
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/iptables"
+)
+
+// trafficChain holds the rules counting the traffic of the sandbox
+// endpoints. It is programmed in the sandbox namespace, where the endpoint
+// interfaces are not shared with other containers. Only IPv4 traffic is
+// accounted.
+const trafficChain = "DOCKER_ACCOUNTING"
+
+const (
+	trafficIntra    = "intra"
+	trafficCross    = "cross"
+	trafficExternal = "external"
+	trafficRx       = "rx"
+	trafficTx       = "tx"
+)
+
+// trafficRules returns the rules accounting the endpoint traffic on the
+// sandbox interface. Each rule is tagged with the endpoint id, the traffic
+// class and direction. The first matching rule stops the traversal, hence
+// the catch-all external rules come last.
+func trafficRules(eid, ifName string, local, remote []*net.IPNet) [][]string {
+	var rules [][]string
+	for _, dir := range []struct{ name, ifFlag, peerFlag string }{
+		{trafficTx, "-o", "-d"},
+		{trafficRx, "-i", "-s"},
+	} {
+		rule := func(class string, peer *net.IPNet) []string {
+			r := []string{dir.ifFlag, ifName}
+			if peer != nil {
+				r = append(r, dir.peerFlag, peer.String())
+			}
+			return append(r, "-m", "comment", "--comment", eid+"/"+class+"/"+dir.name, "-j", "RETURN")
+		}
+		for _, nw := range local {
+			rules = append(rules, rule(trafficIntra, nw))
+		}
+		for _, nw := range remote {
+			rules = append(rules, rule(trafficCross, nw))
+		}
+		rules = append(rules, rule(trafficExternal, nil))
+	}
+	return rules
+}
+
+// trafficSubnets returns the IPv4 subnets of the endpoint network and the
+// ones of the other networks of the controller
+func (ep *endpoint) trafficSubnets() (local, remote []*net.IPNet) {
+	n := ep.getNetwork()
+	for _, nw := range n.getController().Networks() {
+		v4Info, _ := nw.Info().IpamInfo()
+		for _, info := range v4Info {
+			if info.Pool == nil {
+				continue
+			}
+			if nw.ID() == n.ID() {
+				local = append(local, info.Pool)
+			} else {
+				remote = append(remote, info.Pool)
+			}
+		}
+	}
+	return local, remote
+}
+
+// setupTrafficAccounting programs the rules accounting the traffic of the
+// endpoint on the given sandbox interface
+func (sb *sandbox) setupTrafficAccounting(ep *endpoint, ifName string) error {
+	sb.Lock()
+	osSbox := sb.osSbox
+	sb.Unlock()
+	if osSbox == nil || sb.config.useDefaultSandBox {
+		return nil
+	}
+
+	local, remote := ep.trafficSubnets()
+
+	var err error
+	if ierr := osSbox.InvokeFunc(func() {
+		if _, lerr := iptables.RawNative("-n", "-L", trafficChain); lerr != nil {
+			if err = iptables.RawCombinedOutputNative("-N", trafficChain); err != nil {
+				return
+			}
+		}
+		for _, hook := range []string{"INPUT", "OUTPUT"} {
+			if iptables.RawCombinedOutputNative("-C", hook, "-j", trafficChain) != nil {
+				if err = iptables.RawCombinedOutputNative("-I", hook, "-j", trafficChain); err != nil {
+					return
+				}
+			}
+		}
+		for _, rule := range trafficRules(ep.ID(), ifName, local, remote) {
+			if err = iptables.RawCombinedOutputNative(append([]string{"-A", trafficChain}, rule...)...); err != nil {
+				return
+			}
+		}
+	}); ierr != nil {
+		return ierr
+	}
+
+	return err
+}
+
+// removeTrafficAccounting removes the rules accounting the endpoint traffic
+func (sb *sandbox) removeTrafficAccounting(ep *endpoint) error {
+	sb.Lock()
+	osSbox := sb.osSbox
+	sb.Unlock()
+	if osSbox == nil || sb.config.useDefaultSandBox {
+		return nil
+	}
+
+	var err error
+	if ierr := osSbox.InvokeFunc(func() {
+		out, lerr := iptables.RawNative("-S", trafficChain)
+		if lerr != nil {
+			// No accounting in place
+			return
+		}
+		for _, rule := range endpointTrafficRules(out, ep.ID()) {
+			if err = iptables.RawCombinedOutputNative(append([]string{"-D"}, rule...)...); err != nil {
+				return
+			}
+		}
+	}); ierr != nil {
+		return ierr
+	}
+
+	return err
+}
+
+// endpointTrafficRules returns the rules of the accounting chain, as listed
+// by "iptables -S", which belong to the endpoint, without the leading "-A"
+func endpointTrafficRules(out []byte, eid string) [][]string {
+	var rules [][]string
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || fields[0] != "-A" {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.Trim(fields[i], "\"")
+		}
+		for i, f := range fields {
+			if f == "--comment" && i+1 < len(fields) && strings.HasPrefix(fields[i+1], eid+"/") {
+				rules = append(rules, fields[1:])
+				break
+			}
+		}
+	}
+	return rules
+}
+
+// readTrafficCounters returns the current traffic counters of the sandbox
+// endpoints, indexed by endpoint id
+func (sb *sandbox) readTrafficCounters() (map[string]*EndpointTraffic, error) {
+	sb.Lock()
+	osSbox := sb.osSbox
+	sb.Unlock()
+	if osSbox == nil || sb.config.useDefaultSandBox {
+		return nil, nil
+	}
+
+	var (
+		out []byte
+		err error
+	)
+	if ierr := osSbox.InvokeFunc(func() {
+		if _, lerr := iptables.RawNative("-n", "-L", trafficChain); lerr != nil {
+			// No accounting in place
+			return
+		}
+		out, err = iptables.RawNative("-L", trafficChain, "-v", "-x", "-n")
+	}); ierr != nil {
+		return nil, ierr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTrafficCounters(out)
+}
+
+// parseTrafficCounters sums the counters of the accounting chain, as
+// listed by "iptables -L -v -x -n", per endpoint and traffic class
+func parseTrafficCounters(out []byte) (map[string]*EndpointTraffic, error) {
+	counters := make(map[string]*EndpointTraffic)
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		start := strings.Index(line, "/* ")
+		end := strings.Index(line, " */")
+		if start < 0 || end < start {
+			continue
+		}
+		tag := strings.Split(line[start+3:end], "/")
+		if len(tag) != 3 {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		pkts, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid packet count in accounting rule %q: %v", line, err)
+		}
+		nbytes, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid byte count in accounting rule %q: %v", line, err)
+		}
+
+		eid, class, dir := tag[0], tag[1], tag[2]
+		et, ok := counters[eid]
+		if !ok {
+			et = &EndpointTraffic{}
+			counters[eid] = et
+		}
+		var tc *TrafficCounters
+		switch class {
+		case trafficIntra:
+			tc = &et.IntraNetwork
+		case trafficCross:
+			tc = &et.CrossNetwork
+		case trafficExternal:
+			tc = &et.External
+		default:
+			continue
+		}
+		switch dir {
+		case trafficRx:
+			tc.RxPackets += pkts
+			tc.RxBytes += nbytes
+		case trafficTx:
+			tc.TxPackets += pkts
+			tc.TxBytes += nbytes
+		}
+	}
+
+	return counters, nil
+}
+
+// finalizeTrafficAccounting records the last traffic of the endpoint
+// leaving the sandbox and removes its accounting rules
+func (sb *sandbox) finalizeTrafficAccounting(ep *endpoint) {
+	counters, err := sb.readTrafficCounters()
+	if err != nil {
+		log.Warnf("Failed to read the traffic counters of sandbox %s: %v", sb.ID(), err)
+	}
+	if sample, ok := counters[ep.ID()]; ok {
+		if err := sb.controller.recordTraffic(ep.ID(), ep.getNetwork().ID(), *sample, true); err != nil {
+			log.Warnf("Failed to record the traffic of endpoint %s: %v", ep.ID(), err)
+		}
+	}
+
+	if err := sb.removeTrafficAccounting(ep); err != nil {
+		log.Warnf("Failed to remove the traffic accounting rules of endpoint %s: %v", ep.ID(), err)
+	}
+}
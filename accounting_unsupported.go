@@ -0,0 +1,14 @@
+// +build !linux
+
+package libnetwork
+
+func (sb *sandbox) setupTrafficAccounting(ep *endpoint, ifName string) error {
+	return nil
+}
+
+func (sb *sandbox) readTrafficCounters() (map[string]*EndpointTraffic, error) {
+	return nil, nil
+}
+
+func (sb *sandbox) finalizeTrafficAccounting(ep *endpoint) {
+}
@@ -5,14 +5,17 @@ package libnetwork
 import (
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"sort"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/go-events"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/networkdb"
 	"github.com/docker/libnetwork/types"
 	"github.com/gogo/protobuf/proto"
@@ -312,6 +315,35 @@ func (c *controller) getPrimaryKeyTag(subsys string) ([]byte, uint64) {
 	return keys[1].Key, keys[1].LamportTime
 }
 
+// nodeLabels returns the node labels of the daemon, stripped of their
+// prefix, to be advertised to the cluster
+func (c *controller) nodeLabels() map[string]string {
+	labels := make(map[string]string)
+	if c.cfg == nil {
+		return labels
+	}
+	for _, label := range c.cfg.Daemon.Labels {
+		key, value := netlabel.KeyValue(label)
+		if !strings.HasPrefix(key, netlabel.NodeLabelPrefix) {
+			continue
+		}
+		labels[strings.TrimPrefix(key, netlabel.NodeLabelPrefix)] = value
+	}
+	return labels
+}
+
+// nodeCapabilities returns the capabilities advertised to the cluster:
+// the network drivers this node runs, in the form driver.<name>
+func (c *controller) nodeCapabilities() []string {
+	var capabilities []string
+	c.drvRegistry.WalkDrivers(func(name string, driver driverapi.Driver, capability driverapi.Capability) bool {
+		capabilities = append(capabilities, "driver."+name)
+		return false
+	})
+	sort.Strings(capabilities)
+	return capabilities
+}
+
 func (c *controller) agentInit(bindAddrOrInterface string) error {
 	if !c.isAgent() {
 		return nil
@@ -325,9 +357,12 @@ func (c *controller) agentInit(bindAddrOrInterface string) error {
 	keys, tags := c.getKeys(subsysGossip)
 	hostname, _ := os.Hostname()
 	nDB, err := networkdb.New(&networkdb.Config{
-		BindAddr: bindAddr,
-		NodeName: hostname,
-		Keys:     keys,
+		BindAddr:     bindAddr,
+		NodeName:     hostname,
+		Keys:         keys,
+		Labels:       c.nodeLabels(),
+		Version:      version,
+		Capabilities: c.nodeCapabilities(),
 	})
 
 	if err != nil {
@@ -361,6 +396,16 @@ func (c *controller) agentInit(bindAddrOrInterface string) error {
 	return nil
 }
 
+func (c *controller) NetworkDBDiagnostics() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.agent == nil {
+			http.Error(w, "node is not part of a cluster", http.StatusServiceUnavailable)
+			return
+		}
+		c.agent.networkDB.DiagnosticHandler().ServeHTTP(w, r)
+	})
+}
+
 func (c *controller) agentJoin(remote string) error {
 	if c.agent == nil {
 		return nil
@@ -3,12 +3,17 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/docker/libnetwork"
+	"github.com/docker/libnetwork/ipamapi"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/types"
@@ -41,19 +46,23 @@ const (
 	sbPIDQr  = "{" + urlSbPID + ":" + qregx + "}"
 	cnIDQr   = "{" + urlCnID + ":" + qregx + "}"
 	cnPIDQr  = "{" + urlCnPID + ":" + qregx + "}"
+	asNameQr = "{" + urlAsName + ":" + qregx + "}"
+	rsvName  = "{" + urlRsvName + ":" + regex + "}"
 
 	// Internal URL variable name.They can be anything as
 	// long as they do not collide with query fields.
-	urlNwName = "network-name"
-	urlNwID   = "network-id"
-	urlNwPID  = "network-partial-id"
-	urlEpName = "endpoint-name"
-	urlEpID   = "endpoint-id"
-	urlEpPID  = "endpoint-partial-id"
-	urlSbID   = "sandbox-id"
-	urlSbPID  = "sandbox-partial-id"
-	urlCnID   = "container-id"
-	urlCnPID  = "container-partial-id"
+	urlNwName  = "network-name"
+	urlNwID    = "network-id"
+	urlNwPID   = "network-partial-id"
+	urlEpName  = "endpoint-name"
+	urlEpID    = "endpoint-id"
+	urlEpPID   = "endpoint-partial-id"
+	urlSbID    = "sandbox-id"
+	urlSbPID   = "sandbox-partial-id"
+	urlCnID    = "container-id"
+	urlCnPID   = "container-partial-id"
+	urlAsName  = "address-space"
+	urlRsvName = "reservation-name"
 )
 
 // NewHTTPHandler creates and initialize the HTTP handler to serve the requests for libnetwork
@@ -74,6 +83,10 @@ func (r *responseStatus) isOK() bool {
 
 type processor func(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus)
 
+// streamProcessor serves the resources which are returned as a stream of
+// data rather than as a single JSON document
+type streamProcessor func(c libnetwork.NetworkController, vars map[string]string, query url.Values) (io.ReadCloser, string, *responseStatus)
+
 type httpHandler struct {
 	c libnetwork.NetworkController
 	r *mux.Router
@@ -106,6 +119,7 @@ func (h *httpHandler) initRouter() {
 			{"/networks/" + nwID + "/endpoints", []string{"partial-id", epPIDQr}, procGetEndpoints},
 			{"/networks/" + nwID + "/endpoints", nil, procGetEndpoints},
 			{"/networks/" + nwID + "/endpoints/" + epID, nil, procGetEndpoint},
+			{"/networks/" + nwID + "/endpoints/" + epID + "/traffic", nil, procGetEndpointTraffic},
 			{"/services", []string{"network", nwNameQr}, procGetServices},
 			{"/services", []string{"name", epNameQr}, procGetServices},
 			{"/services", []string{"partial-id", epPIDQr}, procGetServices},
@@ -117,6 +131,10 @@ func (h *httpHandler) initRouter() {
 			{"/sandboxes", []string{"partial-id", sbPIDQr}, procGetSandboxes},
 			{"/sandboxes", nil, procGetSandboxes},
 			{"/sandboxes/" + sbID, nil, procGetSandbox},
+			{"/ipam/reservations", []string{"address-space", asNameQr}, procGetReservations},
+			{"/ipam/reservations", nil, procGetReservations},
+			{"/ipam/audit", nil, procGetIpamAudit},
+			{"/state", nil, procExportState},
 		},
 		"POST": {
 			{"/networks", nil, procCreateNetwork},
@@ -125,6 +143,9 @@ func (h *httpHandler) initRouter() {
 			{"/services", nil, procPublishService},
 			{"/services/" + epID + "/backend", nil, procAttachBackend},
 			{"/sandboxes", nil, procCreateSandbox},
+			{"/ipam/reservations", nil, procCreateReservation},
+			{"/ipam/audit", nil, procRepairIpam},
+			{"/state", nil, procImportState},
 		},
 		"DELETE": {
 			{"/networks/" + nwID, nil, procDeleteNetwork},
@@ -133,6 +154,8 @@ func (h *httpHandler) initRouter() {
 			{"/services/" + epID, nil, procUnpublishService},
 			{"/services/" + epID + "/backend/" + sbID, nil, procDetachBackend},
 			{"/sandboxes/" + sbID, nil, procDeleteSandbox},
+			{"/ipam/reservations/" + rsvName, []string{"address-space", asNameQr}, procDeleteReservation},
+			{"/ipam/reservations/" + rsvName, nil, procDeleteReservation},
 		},
 	}
 
@@ -150,6 +173,22 @@ func (h *httpHandler) initRouter() {
 			}
 		}
 	}
+
+	streams := map[string][]struct {
+		url string
+		fct streamProcessor
+	}{
+		"GET": {
+			{"/sandboxes/" + sbID + "/capture", procCaptureSandbox},
+		},
+	}
+
+	for method, routes := range streams {
+		for _, route := range routes {
+			h.r.Path("/{.*}" + route.url).Methods(method).HandlerFunc(makeStreamHandler(h.c, route.fct))
+			h.r.Path(route.url).Methods(method).HandlerFunc(makeStreamHandler(h.c, route.fct))
+		}
+	}
 }
 
 func makeHandler(ctrl libnetwork.NetworkController, fct processor) http.HandlerFunc {
@@ -177,6 +216,58 @@ func makeHandler(ctrl libnetwork.NetworkController, fct processor) http.HandlerF
 	}
 }
 
+func makeStreamHandler(ctrl libnetwork.NetworkController, fct streamProcessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		stream, ctype, rsp := fct(ctrl, mux.Vars(req), req.URL.Query())
+		if !rsp.isOK() {
+			http.Error(w, rsp.Status, rsp.StatusCode)
+			return
+		}
+		defer stream.Close()
+
+		// Flush every chunk so the client receives the data as it is produced,
+		// starting with the headers. A failed write means the client went
+		// away, which ends the stream.
+		flusher, _ := w.(http.Flusher)
+		w.Header().Set("Content-Type", ctype)
+		w.WriteHeader(rsp.StatusCode)
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		// Close the stream as soon as the client goes away, as a stream
+		// producing no data would otherwise never notice it.
+		if cn, ok := w.(http.CloseNotifier); ok {
+			done := make(chan struct{})
+			defer close(done)
+			closed := cn.CloseNotify()
+			go func() {
+				select {
+				case <-closed:
+					stream.Close()
+				case <-done:
+				}
+			}()
+		}
+
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := stream.Read(buf)
+			if n > 0 {
+				if _, werr := w.Write(buf[:n]); werr != nil {
+					return
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
 /*****************
  Resource Builders
 ******************/
@@ -207,6 +298,57 @@ func buildEndpointResource(ep libnetwork.Endpoint) *endpointResource {
 	return r
 }
 
+func buildTrafficCountersResource(tc libnetwork.TrafficCounters) trafficCountersResource {
+	return trafficCountersResource{
+		RxBytes:   tc.RxBytes,
+		RxPackets: tc.RxPackets,
+		TxBytes:   tc.TxBytes,
+		TxPackets: tc.TxPackets,
+	}
+}
+
+func buildReservationResource(r *ipamapi.Reservation) *reservationResource {
+	return &reservationResource{
+		Name:  r.Name,
+		Owner: r.Owner,
+		Label: r.Label,
+		Start: r.Start.String(),
+		End:   r.End.String(),
+	}
+}
+
+func buildIpamAuditResource(a *libnetwork.IpamAudit) *ipamAuditResource {
+	r := &ipamAuditResource{
+		Networks: a.Networks,
+		Skipped:  a.Skipped,
+		Issues:   make([]*ipamAuditIssueResource, 0, len(a.Issues)),
+	}
+	for _, i := range a.Issues {
+		r.Issues = append(r.Issues, &ipamAuditIssueResource{
+			Kind:      i.Kind,
+			Network:   i.Network,
+			PoolID:    i.PoolID,
+			Address:   i.Address.String(),
+			Endpoints: i.Endpoints,
+			Repaired:  i.Repaired,
+		})
+	}
+	return r
+}
+
+func buildTrafficResource(buckets []libnetwork.TrafficBucket) []*trafficBucketResource {
+	list := make([]*trafficBucketResource, 0, len(buckets))
+	for _, b := range buckets {
+		list = append(list, &trafficBucketResource{
+			Start:        b.Start,
+			IntraNetwork: buildTrafficCountersResource(b.Traffic.IntraNetwork),
+			CrossNetwork: buildTrafficCountersResource(b.Traffic.CrossNetwork),
+			External:     buildTrafficCountersResource(b.Traffic.External),
+		})
+	}
+	return list
+}
+
 func buildSandboxResource(sb libnetwork.Sandbox) *sandboxResource {
 	r := &sandboxResource{}
 	if sb != nil {
@@ -424,6 +566,112 @@ func procGetEndpoint(c libnetwork.NetworkController, vars map[string]string, bod
 	return buildEndpointResource(ep), &successResponse
 }
 
+func procGetEndpointTraffic(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	nwT, nwBy := detectNetworkTarget(vars)
+	epT, epBy := detectEndpointTarget(vars)
+
+	// The history outlives the endpoint, which can then only be referred to by id
+	eid := epT
+	ep, errRsp := findEndpoint(c, nwT, epT, nwBy, epBy)
+	if errRsp.isOK() {
+		eid = ep.ID()
+	} else if errRsp.StatusCode != http.StatusNotFound || epBy != byID {
+		return nil, errRsp
+	}
+
+	buckets, err := c.TrafficHistory(eid)
+	if err != nil {
+		return nil, convertNetworkError(err)
+	}
+
+	return buildTrafficResource(buckets), &successResponse
+}
+
+/******************
+ IPAM reservations
+*******************/
+func procGetReservations(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	list, err := c.Reservations(vars[urlAsName])
+	if err != nil {
+		return nil, convertNetworkError(err)
+	}
+
+	rsvs := make([]*reservationResource, 0, len(list))
+	for _, r := range list {
+		rsvs = append(rsvs, buildReservationResource(r))
+	}
+
+	return rsvs, &successResponse
+}
+
+func procCreateReservation(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	var create reservationCreate
+
+	err := json.Unmarshal(body, &create)
+	if err != nil {
+		return nil, &responseStatus{Status: "Invalid body: " + err.Error(), StatusCode: http.StatusBadRequest}
+	}
+
+	rsv := &ipamapi.Reservation{Name: create.Name, Owner: create.Owner, Label: create.Label}
+	if rsv.Start = net.ParseIP(create.Start); rsv.Start == nil {
+		return nil, &responseStatus{Status: "Invalid start address: " + create.Start, StatusCode: http.StatusBadRequest}
+	}
+	if create.End != "" {
+		if rsv.End = net.ParseIP(create.End); rsv.End == nil {
+			return nil, &responseStatus{Status: "Invalid end address: " + create.End, StatusCode: http.StatusBadRequest}
+		}
+	}
+
+	if err := c.Reserve(create.AddressSpace, rsv); err != nil {
+		return nil, convertNetworkError(err)
+	}
+
+	return rsv.Name, &createdResponse
+}
+
+func procDeleteReservation(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	if err := c.Unreserve(vars[urlAsName], vars[urlRsvName]); err != nil {
+		return nil, convertNetworkError(err)
+	}
+
+	return nil, &successResponse
+}
+
+func procGetIpamAudit(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	a, err := c.AuditIpam(false)
+	if err != nil {
+		return nil, convertNetworkError(err)
+	}
+
+	return buildIpamAuditResource(a), &successResponse
+}
+
+func procRepairIpam(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	a, err := c.AuditIpam(true)
+	if err != nil {
+		return nil, convertNetworkError(err)
+	}
+
+	return buildIpamAuditResource(a), &successResponse
+}
+
+func procExportState(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	state, err := c.Export()
+	if err != nil {
+		return nil, convertNetworkError(err)
+	}
+
+	return json.RawMessage(state), &successResponse
+}
+
+func procImportState(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	if err := c.Import(body); err != nil {
+		return nil, convertNetworkError(err)
+	}
+
+	return nil, &successResponse
+}
+
 func procGetEndpoints(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	// Look for query filters and validate
 	name, queryByName := vars[urlEpName]
@@ -813,6 +1061,43 @@ func procDeleteSandbox(c libnetwork.NetworkController, vars map[string]string, b
 	return nil, &successResponse
 }
 
+func procCaptureSandbox(c libnetwork.NetworkController, vars map[string]string, query url.Values) (io.ReadCloser, string, *responseStatus) {
+	sbT, by := detectSandboxTarget(vars)
+
+	sb, errRsp := findSandbox(c, sbT, by)
+	if !errRsp.isOK() {
+		return nil, "", errRsp
+	}
+
+	iface := query.Get("interface")
+	if iface == "" {
+		return nil, "", &responseStatus{Status: "Missing interface to capture on", StatusCode: http.StatusBadRequest}
+	}
+
+	var (
+		snaplen  int
+		duration time.Duration
+		err      error
+	)
+	if v := query.Get("snaplen"); v != "" {
+		if snaplen, err = strconv.Atoi(v); err != nil {
+			return nil, "", &responseStatus{Status: "Invalid snaplen: " + err.Error(), StatusCode: http.StatusBadRequest}
+		}
+	}
+	if v := query.Get("duration"); v != "" {
+		if duration, err = time.ParseDuration(v); err != nil {
+			return nil, "", &responseStatus{Status: "Invalid duration: " + err.Error(), StatusCode: http.StatusBadRequest}
+		}
+	}
+
+	stream, err := sb.Capture(iface, query.Get("filter"), snaplen, duration)
+	if err != nil {
+		return nil, "", convertNetworkError(err)
+	}
+
+	return stream, "application/vnd.tcpdump.pcap", &successResponse
+}
+
 /***********
   Utilities
 ************/
@@ -6,16 +6,22 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"regexp"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/docker/libnetwork"
+	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/drivers/bridge"
+	"github.com/docker/libnetwork/ipamapi"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/testutils"
@@ -261,6 +267,230 @@ func TestCreateDeleteNetwork(t *testing.T) {
 	}
 }
 
+func TestProcReservations(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	// Cleanup local datastore file
+	os.Remove(datastore.DefaultScopes("")[datastore.LocalScope].Client.Address)
+
+	c, err := libnetwork.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	vars := make(map[string]string)
+	badBody, err := json.Marshal(reservationCreate{Name: "vip", Start: "10.10.0.300"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, errRsp := procCreateReservation(c, vars, badBody)
+	if errRsp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected StatusBadRequest status code, got: %v", errRsp)
+	}
+
+	// The local default address space is not persisted by the builtin
+	// IPAM, the reservations are refused there
+	goodBody, err := json.Marshal(reservationCreate{Name: "vip", Owner: "lb", Label: "load balancer VIP", Start: "10.10.0.2", End: "10.10.0.4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, errRsp = procCreateReservation(c, vars, goodBody)
+	if errRsp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected StatusForbidden status code, got: %v", errRsp)
+	}
+
+	i, errRsp := procGetReservations(c, vars, nil)
+	if errRsp != &successResponse {
+		t.Fatalf("Unexepected failure: %v", errRsp)
+	}
+	if list := i.([]*reservationResource); len(list) != 0 {
+		t.Fatalf("Unexpected reservations: %v", list)
+	}
+
+	vars[urlRsvName] = "vip"
+	_, errRsp = procDeleteReservation(c, vars, nil)
+	if errRsp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected StatusNotFound status code, got: %v", errRsp)
+	}
+}
+
+func TestProcIpamAudit(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	// Cleanup local datastore file
+	os.Remove(datastore.DefaultScopes("")[datastore.LocalScope].Client.Address)
+
+	c, err := libnetwork.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	nw, err := c.NewNetwork(bridgeNetType, "auditnw", "",
+		libnetwork.NetworkOptionIpam(ipamapi.DefaultIPAM, "", []*libnetwork.IpamConf{{PreferredPool: "192.168.100.0/24"}}, nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nw.Delete()
+
+	ep, err := nw.CreateEndpoint("ep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete(false)
+
+	i, errRsp := procGetIpamAudit(c, nil, nil)
+	if errRsp != &successResponse {
+		t.Fatalf("Unexepected failure: %v", errRsp)
+	}
+	audit := i.(*ipamAuditResource)
+	if len(audit.Networks) != 1 || audit.Networks[0] != nw.ID() || len(audit.Issues) != 0 {
+		t.Fatalf("Unexpected audit of a consistent network: %v", audit)
+	}
+
+	_, errRsp = procRepairIpam(c, nil, nil)
+	if errRsp != &successResponse {
+		t.Fatalf("Unexepected failure: %v", errRsp)
+	}
+}
+
+func TestProcGetEndpointTraffic(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	// Cleanup local datastore file
+	os.Remove(datastore.DefaultScopes("")[datastore.LocalScope].Client.Address)
+
+	c, err := libnetwork.New(config.OptionTrafficAccounting())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	nw, err := c.NewNetwork(bridgeNetType, "trafficnw", "",
+		libnetwork.NetworkOptionIpam(ipamapi.DefaultIPAM, "", []*libnetwork.IpamConf{{PreferredPool: "192.168.102.0/24"}}, nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nw.Delete()
+
+	ep, err := nw.CreateEndpoint("ep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep2, err := nw.CreateEndpoint("ep2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Delete(false)
+
+	// Record an hour of traffic for the endpoint in the local store
+	start := time.Now().Truncate(time.Hour).UTC()
+	record, err := json.Marshal(map[string]interface{}{
+		"id":      ep.ID(),
+		"Network": nw.ID(),
+		"Buckets": []libnetwork.TrafficBucket{{
+			Start: start,
+			Traffic: libnetwork.EndpointTraffic{
+				External: libnetwork.TrafficCounters{TxBytes: 1500, TxPackets: 3},
+			},
+		}},
+		"Updated": start,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := datastore.NewDataStore(datastore.LocalScope, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ds.KVStore().Put(datastore.Key("traffic", ep.ID()), record, nil)
+	ds.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]string{urlNwName: "trafficnw", urlEpName: "ep"}
+	i, errRsp := procGetEndpointTraffic(c, vars, nil)
+	if errRsp != &successResponse {
+		t.Fatalf("Unexepected failure: %v", errRsp)
+	}
+	buckets := i.([]*trafficBucketResource)
+	if len(buckets) != 1 || !buckets[0].Start.Equal(start) ||
+		buckets[0].External.TxBytes != 1500 || buckets[0].External.TxPackets != 3 {
+		t.Fatalf("Unexpected traffic history: %v", buckets)
+	}
+
+	// No traffic accounted to the other endpoint
+	vars = map[string]string{urlNwName: "trafficnw", urlEpName: "ep2"}
+	_, errRsp = procGetEndpointTraffic(c, vars, nil)
+	if errRsp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected StatusNotFound. Got: %v", errRsp)
+	}
+
+	// The history outlives the endpoint, which is then referred to by id
+	if err := ep.Delete(false); err != nil {
+		t.Fatal(err)
+	}
+	vars = map[string]string{urlNwName: "trafficnw", urlEpID: ep.ID()}
+	i, errRsp = procGetEndpointTraffic(c, vars, nil)
+	if errRsp != &successResponse {
+		t.Fatalf("Unexepected failure: %v", errRsp)
+	}
+	if buckets := i.([]*trafficBucketResource); len(buckets) != 1 {
+		t.Fatalf("Unexpected traffic history of the deleted endpoint: %v", buckets)
+	}
+
+	vars = map[string]string{urlNwName: "trafficnw", urlEpName: "ep"}
+	_, errRsp = procGetEndpointTraffic(c, vars, nil)
+	if errRsp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected StatusNotFound. Got: %v", errRsp)
+	}
+}
+
+func TestProcState(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	// Cleanup local datastore file
+	os.Remove(datastore.DefaultScopes("")[datastore.LocalScope].Client.Address)
+
+	c, err := libnetwork.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	nw, err := c.NewNetwork(bridgeNetType, "statenw", "",
+		libnetwork.NetworkOptionIpam(ipamapi.DefaultIPAM, "", []*libnetwork.IpamConf{{PreferredPool: "192.168.101.0/24"}}, nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nw.Delete()
+
+	i, errRsp := procExportState(c, nil, nil)
+	if errRsp != &successResponse {
+		t.Fatalf("Unexepected failure: %v", errRsp)
+	}
+	var state libnetwork.StateExport
+	if err := json.Unmarshal(i.(json.RawMessage), &state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Networks) != 2 {
+		t.Fatalf("Unexpected exported networks: %v", state.Networks)
+	}
+
+	// The network already exists
+	_, errRsp = procImportState(c, nil, i.(json.RawMessage))
+	if errRsp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected StatusForbidden. Got: %v", errRsp)
+	}
+
+	_, errRsp = procImportState(c, nil, []byte("{\"version\":42}"))
+	if errRsp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected StatusBadRequest. Got: %v", errRsp)
+	}
+}
+
 func TestGetNetworksAndEndpoints(t *testing.T) {
 	defer testutils.SetupTestOSContext(t)()
 
@@ -995,6 +1225,96 @@ func TestAttachDetachBackend(t *testing.T) {
 	}
 }
 
+func TestProcCaptureSandbox(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	c, err := libnetwork.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	vars := map[string]string{urlSbID: "nonexistent"}
+	_, _, errRsp := procCaptureSandbox(c, vars, url.Values{"interface": {"lo"}})
+	if errRsp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected %d. Got: %v", http.StatusNotFound, errRsp)
+	}
+
+	sbox, err := c.NewSandbox("capturecontainer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbox.Delete()
+
+	vars[urlSbID] = sbox.ID()
+	for _, q := range []url.Values{
+		{},
+		{"interface": {"lo"}, "snaplen": {"big"}},
+		{"interface": {"lo"}, "duration": {"forever"}},
+	} {
+		_, _, errRsp = procCaptureSandbox(c, vars, q)
+		if errRsp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected %d for query %v. Got: %v", http.StatusBadRequest, q, errRsp)
+		}
+	}
+
+	stream, ctype, errRsp := procCaptureSandbox(c, vars, url.Values{"interface": {"lo"}, "duration": {"100ms"}})
+	if errRsp != &successResponse {
+		t.Fatalf("Unexpected failure, got: %v", errRsp)
+	}
+	defer stream.Close()
+
+	if ctype != "application/vnd.tcpdump.pcap" {
+		t.Fatalf("Unexpected content type %s", ctype)
+	}
+	if _, err := ioutil.ReadAll(stream); err != nil {
+		t.Fatalf("Failed to read the capture stream: %v", err)
+	}
+}
+
+// idleStream is a stream producing no data until it is closed
+type idleStream struct {
+	closed chan struct{}
+}
+
+func (s *idleStream) Read(p []byte) (int, error) {
+	<-s.closed
+	return 0, io.EOF
+}
+
+func (s *idleStream) Close() error {
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+	return nil
+}
+
+func TestStreamHandlerClientGone(t *testing.T) {
+	stream := &idleStream{closed: make(chan struct{})}
+	fct := func(c libnetwork.NetworkController, vars map[string]string, query url.Values) (io.ReadCloser, string, *responseStatus) {
+		return stream, "application/octet-stream", &successResponse
+	}
+	srv := httptest.NewServer(makeStreamHandler(nil, fct))
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected %d. Got: %d", http.StatusOK, rsp.StatusCode)
+	}
+	rsp.Body.Close()
+
+	select {
+	case <-stream.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream was not closed when the client went away")
+	}
+}
+
 func TestDetectGetNetworksInvalidQueryComposition(t *testing.T) {
 	// Cleanup local datastore file
 	os.Remove(datastore.DefaultScopes("")[datastore.LocalScope].Client.Address)
@@ -1,6 +1,10 @@
 package api
 
-import "github.com/docker/libnetwork/types"
+import (
+	"time"
+
+	"github.com/docker/libnetwork/types"
+)
 
 /***********
  Resources
@@ -21,6 +25,49 @@ type endpointResource struct {
 	Network string `json:"network"`
 }
 
+// trafficCountersResource holds the bytes and packets received and
+// transmitted by an endpoint
+type trafficCountersResource struct {
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+}
+
+// trafficBucketResource is an element of the body of the "get endpoint traffic" http response message
+type trafficBucketResource struct {
+	Start        time.Time               `json:"start"`
+	IntraNetwork trafficCountersResource `json:"intra_network"`
+	CrossNetwork trafficCountersResource `json:"cross_network"`
+	External     trafficCountersResource `json:"external"`
+}
+
+// reservationResource is an element of the body of the "get reservations" http response message
+type reservationResource struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+	Label string `json:"label"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// ipamAuditIssueResource is an element of the body of the "get ipam audit" http response message
+type ipamAuditIssueResource struct {
+	Kind      string   `json:"kind"`
+	Network   string   `json:"network"`
+	PoolID    string   `json:"pool_id"`
+	Address   string   `json:"address"`
+	Endpoints []string `json:"endpoints"`
+	Repaired  bool     `json:"repaired"`
+}
+
+// ipamAuditResource is the body of the "get ipam audit" http response message
+type ipamAuditResource struct {
+	Networks []string                  `json:"networks"`
+	Skipped  []string                  `json:"skipped"`
+	Issues   []*ipamAuditIssueResource `json:"issues"`
+}
+
 // sandboxResource is the body of "get service backend" response message
 type sandboxResource struct {
 	ID          string `json:"id"`
@@ -70,6 +117,16 @@ type sandboxCreate struct {
 	PortMapping       []types.PortBinding   `json:"port_mapping"`
 }
 
+// reservationCreate is the expected body of the "create reservation" http request message
+type reservationCreate struct {
+	Name         string `json:"name"`
+	AddressSpace string `json:"address_space"`
+	Owner        string `json:"owner"`
+	Label        string `json:"label"`
+	Start        string `json:"start"`
+	End          string `json:"end"`
+}
+
 // endpointJoin represents the expected body of the "join endpoint" or "leave endpoint" http request messages
 type endpointJoin struct {
 	SandboxID string   `json:"sandbox_id"`
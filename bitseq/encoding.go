@@ -0,0 +1,187 @@
+package bitseq
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// The handle is encoded as a header followed by a payload:
+//
+//	magic (3 bytes) | version (1 byte) | flags (1 byte) | payload
+//
+// The version 1 payload, deflate compressed if flagCompressed is set, is:
+//
+//	uvarint bits | uvarint unselected | sequence ...
+//
+// where each sequence is a tag byte telling how the block is encoded,
+// followed by the block if not implied by the tag, and by the uvarint count.
+//
+// The legacy encoding has no header, it is the 8 bytes bits and unselected
+// followed by 12 bytes per sequence: the 4 bytes block and the 8 bytes count.
+// Its first byte is the highest byte of the number of bits, the magic was
+// chosen not to match any practical number of bits.
+var encodingMagic = []byte{0x00, 'b', 's'}
+
+const (
+	encodingVersion  = byte(1)
+	encodingHdrLen   = 5
+	legacyHdrLen     = 16
+	legacySeqLen     = 12
+	flagCompressed   = byte(1 << 0)
+	compressMinBytes = 256
+)
+
+// Sequence tags
+const (
+	tagEmpty = byte(iota) // block is 0x0
+	tagFull               // block is blockMAX
+	tagBlock              // block follows
+)
+
+// The flate writers and readers are expensive to allocate, they are reused
+var (
+	flateWriters = sync.Pool{
+		New: func() interface{} {
+			w, _ := flate.NewWriter(nil, flate.BestSpeed)
+			return w
+		},
+	}
+	flateReaders = sync.Pool{
+		New: func() interface{} {
+			return flate.NewReader(nil)
+		},
+	}
+)
+
+// encode returns the version 1 encoding of the handle data
+func encode(bits, unselected uint64, head *sequence) ([]byte, error) {
+	var v [binary.MaxVarintLen64]byte
+
+	payload := make([]byte, 0, 64)
+	payload = append(payload, v[:binary.PutUvarint(v[:], bits)]...)
+	payload = append(payload, v[:binary.PutUvarint(v[:], unselected)]...)
+	for p := head; p != nil; p = p.next {
+		switch p.block {
+		case 0x0:
+			payload = append(payload, tagEmpty)
+		case blockMAX:
+			payload = append(payload, tagFull)
+		default:
+			payload = append(payload, tagBlock, byte(p.block>>24), byte(p.block>>16), byte(p.block>>8), byte(p.block))
+		}
+		payload = append(payload, v[:binary.PutUvarint(v[:], p.count)]...)
+	}
+
+	ba := make([]byte, encodingHdrLen, encodingHdrLen+len(payload))
+	copy(ba, encodingMagic)
+	ba[3] = encodingVersion
+
+	// Only keep the compressed payload if it pays off
+	if len(payload) >= compressMinBytes {
+		cb := bytes.NewBuffer(ba)
+		w := flateWriters.Get().(*flate.Writer)
+		defer flateWriters.Put(w)
+		w.Reset(cb)
+		if _, err := w.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		if cb.Len()-encodingHdrLen < len(payload) {
+			ba = cb.Bytes()
+			ba[4] |= flagCompressed
+			return ba, nil
+		}
+	}
+
+	return append(ba, payload...), nil
+}
+
+// isLegacyEncoding returns whether the byte array holds the legacy encoding
+func isLegacyEncoding(ba []byte) bool {
+	return len(ba) < encodingHdrLen || !bytes.Equal(ba[:len(encodingMagic)], encodingMagic)
+}
+
+// decode decodes the versioned encoding of the handle data
+func decode(ba []byte) (uint64, uint64, *sequence, error) {
+	if version := ba[3]; version != encodingVersion {
+		return 0, 0, nil, fmt.Errorf("unsupported encoding version %d", version)
+	}
+
+	payload := ba[encodingHdrLen:]
+	if ba[4]&flagCompressed != 0 {
+		r := flateReaders.Get().(io.ReadCloser)
+		defer flateReaders.Put(r)
+		if err := r.(flate.Resetter).Reset(bytes.NewReader(payload), nil); err != nil {
+			return 0, 0, nil, err
+		}
+		var err error
+		if payload, err = ioutil.ReadAll(r); err != nil {
+			return 0, 0, nil, fmt.Errorf("failed to decompress: %v", err)
+		}
+	}
+
+	var (
+		vals [2]uint64
+		n    int
+	)
+	for i := range vals {
+		if vals[i], n = binary.Uvarint(payload); n <= 0 {
+			return 0, 0, nil, fmt.Errorf("invalid header")
+		}
+		payload = payload[n:]
+	}
+
+	var head, p *sequence
+	for len(payload) > 0 {
+		s := &sequence{}
+		tag := payload[0]
+		payload = payload[1:]
+		switch tag {
+		case tagEmpty:
+		case tagFull:
+			s.block = blockMAX
+		case tagBlock:
+			if len(payload) < 4 {
+				return 0, 0, nil, fmt.Errorf("truncated block")
+			}
+			s.block = binary.BigEndian.Uint32(payload)
+			payload = payload[4:]
+		default:
+			return 0, 0, nil, fmt.Errorf("invalid sequence tag %d", tag)
+		}
+		if s.count, n = binary.Uvarint(payload); n <= 0 {
+			return 0, 0, nil, fmt.Errorf("truncated sequence")
+		}
+		payload = payload[n:]
+		if head == nil {
+			head = s
+		} else {
+			p.next = s
+		}
+		p = s
+	}
+	if head == nil {
+		return 0, 0, nil, fmt.Errorf("no sequence")
+	}
+
+	return vals[0], vals[1], head, nil
+}
+
+// decodeLegacy decodes the legacy encoding of the handle data
+func decodeLegacy(ba []byte) (uint64, uint64, *sequence, error) {
+	if len(ba) < legacyHdrLen+legacySeqLen {
+		return 0, 0, nil, fmt.Errorf("cannot deserialize byte sequence of length %d", len(ba))
+	}
+	head := &sequence{}
+	if err := head.fromByteArray(ba[legacyHdrLen:]); err != nil {
+		return 0, 0, nil, err
+	}
+	return binary.BigEndian.Uint64(ba[0:8]), binary.BigEndian.Uint64(ba[8:16]), head, nil
+}
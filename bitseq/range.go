@@ -0,0 +1,241 @@
+package bitseq
+
+import (
+	"fmt"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/types"
+)
+
+// SetRange atomically sets the bits between start and end included. If any
+// of them is already set, ErrBitAllocated is returned and the sequence is
+// left untouched.
+func (h *Handle) SetRange(start, end uint64) error {
+	return h.setRange(start, end, false)
+}
+
+// UnsetRange atomically unsets the bits between start and end included
+func (h *Handle) UnsetRange(start, end uint64) error {
+	return h.setRange(start, end, true)
+}
+
+// setRange applies the range operation on a private copy of the sequence
+// and commits it with a single store write
+func (h *Handle) setRange(start, end uint64, release bool) error {
+	h.Lock()
+	bits := h.bits
+	h.Unlock()
+	if start > end || end >= bits {
+		return fmt.Errorf("invalid bit range [%d, %d]", start, end)
+	}
+
+	for {
+		var store datastore.DataStore
+		h.Lock()
+		store = h.store
+		h.Unlock()
+		if store != nil {
+			if err := store.GetObject(datastore.Key(h.Key()...), h); err != nil && err != datastore.ErrKeyNotFound {
+				return err
+			}
+		}
+
+		// Create a private copy of h and work on it
+		h.Lock()
+		nh := h.getCopy()
+		h.Unlock()
+
+		head, changed, err := pushRange(nh.head, start, end, release)
+		if err != nil {
+			return err
+		}
+		if changed == 0 {
+			return nil
+		}
+		nh.head = head
+		if release {
+			nh.unselected += changed
+		} else {
+			nh.unselected -= changed
+		}
+
+		// Attempt to write private copy to store
+		if err := nh.writeToStore(); err != nil {
+			if _, ok := err.(types.RetryError); !ok {
+				return fmt.Errorf("internal failure while setting the bit range: %v", err)
+			}
+			// Retry
+			continue
+		}
+
+		// Previous atomic push was succesfull. Save private copy to local copy
+		h.Lock()
+		h.unselected = nh.unselected
+		h.head = nh.head
+		h.dbExists = nh.dbExists
+		h.dbIndex = nh.dbIndex
+		h.Unlock()
+		return nil
+	}
+}
+
+// pushRange sets or resets the bits between start and end included and
+// returns the new head along with the number of bits actually changed.
+// The sequences holding the first and the last block of the range are split
+// for those blocks to stand alone, the blocks in between are wholly covered
+// by the range. When setting, it fails with ErrBitAllocated if any bit of the
+// range is already set, in which case the passed list is left split but
+// equivalent.
+func pushRange(head *sequence, start, end uint64, release bool) (*sequence, uint64, error) {
+	first, last := start/uint64(blockLen), end/uint64(blockLen)
+	for _, b := range []uint64{first, first + 1, last, last + 1} {
+		splitSequence(head, b)
+	}
+
+	if !release {
+		for s, b := rangeSequence(head, first); s != nil && b <= last; b, s = b+s.count, s.next {
+			if s.block&rangeMask(b, start, end) != 0 {
+				mergeSequences(head)
+				return head, 0, ErrBitAllocated
+			}
+		}
+	}
+
+	var changed uint64
+	for s, b := rangeSequence(head, first); s != nil && b <= last; b, s = b+s.count, s.next {
+		mask := rangeMask(b, start, end)
+		newBlock := s.block | mask
+		if release {
+			newBlock = s.block &^ mask
+		}
+		changed += uint64(popCount(s.block^newBlock)) * s.count
+		s.block = newBlock
+	}
+	mergeSequences(head)
+
+	return head, changed, nil
+}
+
+// splitSequence makes a sequence start at the passed block index, splitting
+// the sequence holding that block in two if needed
+func splitSequence(head *sequence, index uint64) {
+	var b uint64
+	for s := head; s != nil; s = s.next {
+		if index < b+s.count {
+			if index > b {
+				s.next = &sequence{block: s.block, count: b + s.count - index, next: s.next}
+				s.count = index - b
+			}
+			return
+		}
+		b += s.count
+	}
+}
+
+// rangeSequence returns the sequence starting at the passed block index
+// along with that index, or nil if the list has no such sequence
+func rangeSequence(head *sequence, index uint64) (*sequence, uint64) {
+	var b uint64
+	for s := head; s != nil; s = s.next {
+		if b == index {
+			return s, b
+		}
+		b += s.count
+	}
+	return nil, 0
+}
+
+// rangeMask returns the mask of the bits of the block at the passed index
+// falling between start and end included
+func rangeMask(index, start, end uint64) uint32 {
+	base := index * uint64(blockLen)
+	lo, hi := uint64(0), uint64(blockLen-1)
+	if start > base {
+		lo = start - base
+	}
+	if end < base+uint64(blockLen-1) {
+		hi = end - base
+	}
+	return blockMAX>>lo &^ (blockMAX >> (hi + 1))
+}
+
+func popCount(b uint32) int {
+	n := 0
+	for ; b != 0; b &= b - 1 {
+		n++
+	}
+	return n
+}
+
+// Iterator walks forward the set, or the unset, ordinals of a snapshot of
+// the bit sequence. It moves over the run-length encoded blocks, skipping
+// at once the sequences holding no matching bit.
+type Iterator struct {
+	seq  *sequence
+	base uint64 // ordinal of the first bit of seq
+	next uint64 // next ordinal to examine
+	end  uint64
+	set  bool
+}
+
+// IterateSet returns an iterator over the set ordinals between start and
+// end included
+func (h *Handle) IterateSet(start, end uint64) *Iterator {
+	return h.iterate(start, end, true)
+}
+
+// IterateUnset returns an iterator over the unset ordinals between start
+// and end included
+func (h *Handle) IterateUnset(start, end uint64) *Iterator {
+	return h.iterate(start, end, false)
+}
+
+func (h *Handle) iterate(start, end uint64, set bool) *Iterator {
+	h.Lock()
+	defer h.Unlock()
+
+	it := &Iterator{next: start, end: end, set: set}
+	if h.bits == 0 {
+		return it
+	}
+	if it.end >= h.bits {
+		it.end = h.bits - 1
+	}
+	it.seq = h.head.getCopy()
+
+	return it
+}
+
+// Next returns the next matching ordinal, or false once the iteration is over
+func (it *Iterator) Next() (uint64, bool) {
+	for it.seq != nil && it.next <= it.end {
+		seqEnd := it.base + it.seq.count*uint64(blockLen)
+		block := it.seq.block
+		if !it.set {
+			block = ^block
+		}
+		if block == 0 || it.next >= seqEnd {
+			// Move to the next sequence
+			if it.next < seqEnd {
+				it.next = seqEnd
+			}
+			it.base = seqEnd
+			it.seq = it.seq.next
+			continue
+		}
+
+		bit := uint32((it.next - it.base) % uint64(blockLen))
+		if block&(blockMAX>>bit) == 0 {
+			// No matching bit left in this block
+			it.next += uint64(blockLen - bit)
+			continue
+		}
+		ordinal := it.next
+		it.next++
+		if block&(blockFirstBit>>bit) != 0 {
+			return ordinal, true
+		}
+	}
+
+	return invalidPos, false
+}
@@ -35,12 +35,15 @@ var (
 type Handle struct {
 	bits       uint64
 	unselected uint64
+	curr       uint64
 	head       *sequence
 	app        string
 	id         string
 	dbIndex    uint64
 	dbExists   bool
 	store      datastore.DataStore
+	// legacy is set when the handle was read in the legacy encoding
+	legacy bool
 	sync.Mutex
 }
 
@@ -72,6 +75,17 @@ func NewHandle(app string, ds datastore.DataStore, id string, numElements uint64
 		if err := h.writeToStore(); err != nil {
 			return nil, fmt.Errorf("failed to write bitsequence to store: %v", err)
 		}
+		return h, nil
+	}
+
+	// Migrate the handle stored in the legacy encoding. A concurrent
+	// update already migrated it.
+	if h.isLegacy() {
+		if err := h.writeToStore(); err != nil {
+			if _, ok := err.(types.RetryError); !ok {
+				return nil, fmt.Errorf("failed to migrate bitsequence in store: %v", err)
+			}
+		}
 	}
 
 	return h, nil
@@ -186,6 +200,7 @@ func (h *Handle) getCopy() *Handle {
 	return &Handle{
 		bits:       h.bits,
 		unselected: h.unselected,
+		curr:       h.curr,
 		head:       h.head.getCopy(),
 		app:        h.app,
 		id:         h.id,
@@ -203,7 +218,34 @@ func (h *Handle) SetAnyInRange(start, end uint64) (uint64, error) {
 	if h.Unselected() == 0 {
 		return invalidPos, ErrNoBitAvailable
 	}
-	return h.set(0, start, end, true, false)
+	return h.set(start, start, end, true, false, false)
+}
+
+// SetAnyInRangeFrom atomically sets the first unset bit in the specified range
+// starting the search from the passed ordinal and wrapping around to the start
+// of the range, and returns the corresponding ordinal
+func (h *Handle) SetAnyInRangeFrom(start, end, from uint64) (uint64, error) {
+	if start > end || end >= h.bits {
+		return invalidPos, fmt.Errorf("invalid bit range [%d, %d]", start, end)
+	}
+	if h.Unselected() == 0 {
+		return invalidPos, ErrNoBitAvailable
+	}
+	return h.set(from, start, end, true, false, false)
+}
+
+// SetAnySerialInRange atomically sets the first unset bit in the specified range
+// following the last one set by this function, wrapping around to the start of
+// the range, and returns the corresponding ordinal. The position of the last set
+// bit is persisted with the sequence.
+func (h *Handle) SetAnySerialInRange(start, end uint64) (uint64, error) {
+	if start > end || end >= h.bits {
+		return invalidPos, fmt.Errorf("invalid bit range [%d, %d]", start, end)
+	}
+	if h.Unselected() == 0 {
+		return invalidPos, ErrNoBitAvailable
+	}
+	return h.set(0, start, end, true, false, true)
 }
 
 // SetAny atomically sets the first unset bit in the sequence and returns the corresponding ordinal
@@ -211,7 +253,7 @@ func (h *Handle) SetAny() (uint64, error) {
 	if h.Unselected() == 0 {
 		return invalidPos, ErrNoBitAvailable
 	}
-	return h.set(0, 0, h.bits-1, true, false)
+	return h.set(0, 0, h.bits-1, true, false, false)
 }
 
 // Set atomically sets the corresponding bit in the sequence
@@ -219,7 +261,7 @@ func (h *Handle) Set(ordinal uint64) error {
 	if err := h.validateOrdinal(ordinal); err != nil {
 		return err
 	}
-	_, err := h.set(ordinal, 0, 0, false, false)
+	_, err := h.set(ordinal, 0, 0, false, false, false)
 	return err
 }
 
@@ -228,7 +270,7 @@ func (h *Handle) Unset(ordinal uint64) error {
 	if err := h.validateOrdinal(ordinal); err != nil {
 		return err
 	}
-	_, err := h.set(ordinal, 0, 0, false, true)
+	_, err := h.set(ordinal, 0, 0, false, true, false)
 	return err
 }
 
@@ -244,6 +286,17 @@ func (h *Handle) IsSet(ordinal uint64) bool {
 	return err != nil
 }
 
+// WalkSet invokes the walker, in ascending order, for each set ordinal
+// between start and end included. The walk stops when the walker returns true.
+func (h *Handle) WalkSet(start, end uint64, walker func(ordinal uint64) bool) {
+	it := h.IterateSet(start, end)
+	for o, ok := it.Next(); ok; o, ok = it.Next() {
+		if walker(o) {
+			return
+		}
+	}
+}
+
 func (h *Handle) runConsistencyCheck() bool {
 	corrupted := false
 	for p, c := h.head, h.head.next; c != nil; c = c.next {
@@ -296,8 +349,9 @@ func (h *Handle) CheckConsistency() error {
 	}
 }
 
-// set/reset the bit
-func (h *Handle) set(ordinal, start, end uint64, any bool, release bool) (uint64, error) {
+// set/reset the bit. When looking for any unset bit, ordinal is where the search
+// starts, or the bit following the last serially set one if serial is true.
+func (h *Handle) set(ordinal, start, end uint64, any bool, release bool, serial bool) (uint64, error) {
 	var (
 		bitPos  uint64
 		bytePos uint64
@@ -322,8 +376,20 @@ func (h *Handle) set(ordinal, start, end uint64, any bool, release bool) (uint64
 			bytePos, bitPos = ordinalToPos(ordinal)
 		} else {
 			if any {
-				bytePos, bitPos, err = getFirstAvailable(h.head, start)
+				from := ordinal
+				if serial {
+					from = h.curr
+				}
+				if from < start || from > end {
+					from = start
+				}
+				bytePos, bitPos, err = getFirstAvailable(h.head, from)
 				ret = posToOrdinal(bytePos, bitPos)
+				if (err != nil || end < ret) && from > start {
+					// Wrap around
+					bytePos, bitPos, err = getFirstAvailable(h.head, start)
+					ret = posToOrdinal(bytePos, bitPos)
+				}
 				if end < ret {
 					err = ErrNoBitAvailable
 				}
@@ -347,6 +413,9 @@ func (h *Handle) set(ordinal, start, end uint64, any bool, release bool) (uint64
 		} else {
 			nh.unselected--
 		}
+		if serial {
+			nh.curr = ret + 1
+		}
 
 		// Attempt to write private copy to store
 		if err := nh.writeToStore(); err != nil {
@@ -361,6 +430,7 @@ func (h *Handle) set(ordinal, start, end uint64, any bool, release bool) (uint64
 		h.Lock()
 		defer h.Unlock()
 		h.unselected = nh.unselected
+		h.curr = nh.curr
 		h.head = nh.head
 		h.dbExists = nh.dbExists
 		h.dbIndex = nh.dbIndex
@@ -400,47 +470,61 @@ func (h *Handle) Destroy() error {
 
 // ToByteArray converts this handle's data into a byte array
 func (h *Handle) ToByteArray() ([]byte, error) {
-
 	h.Lock()
 	defer h.Unlock()
-	ba := make([]byte, 16)
-	binary.BigEndian.PutUint64(ba[0:], h.bits)
-	binary.BigEndian.PutUint64(ba[8:], h.unselected)
-	bm, err := h.head.toByteArray()
+
+	ba, err := encode(h.bits, h.unselected, h.head)
 	if err != nil {
 		return nil, fmt.Errorf("failed to serialize head: %s", err.Error())
 	}
-	ba = append(ba, bm...)
 
 	return ba, nil
 }
 
-// FromByteArray reads his handle's data from a byte array
+// FromByteArray reads his handle's data from a byte array. Both the current
+// and the legacy encodings are accepted.
 func (h *Handle) FromByteArray(ba []byte) error {
 	if ba == nil {
 		return fmt.Errorf("nil byte array")
 	}
 
-	nh := &sequence{}
-	err := nh.fromByteArray(ba[16:])
+	decodeFn := decode
+	legacy := isLegacyEncoding(ba)
+	if legacy {
+		decodeFn = decodeLegacy
+	}
+	bits, unselected, nh, err := decodeFn(ba)
 	if err != nil {
 		return fmt.Errorf("failed to deserialize head: %s", err.Error())
 	}
 
 	h.Lock()
 	h.head = nh
-	h.bits = binary.BigEndian.Uint64(ba[0:8])
-	h.unselected = binary.BigEndian.Uint64(ba[8:16])
+	h.bits = bits
+	h.unselected = unselected
+	h.legacy = legacy
 	h.Unlock()
 
 	return nil
 }
 
+func (h *Handle) isLegacy() bool {
+	h.Lock()
+	defer h.Unlock()
+	return h.legacy
+}
+
 // Bits returns the length of the bit sequence
 func (h *Handle) Bits() uint64 {
 	return h.bits
 }
 
+func (h *Handle) cursor() uint64 {
+	h.Lock()
+	defer h.Unlock()
+	return h.curr
+}
+
 // Unselected returns the number of bits which are not selected
 func (h *Handle) Unselected() uint64 {
 	h.Lock()
@@ -466,6 +550,9 @@ func (h *Handle) MarshalJSON() ([]byte, error) {
 		return nil, err
 	}
 	m["sequence"] = b
+	if curr := h.cursor(); curr != 0 {
+		m["curr"] = curr
+	}
 	return json.Marshal(m)
 }
 
@@ -480,6 +567,16 @@ func (h *Handle) UnmarshalJSON(data []byte) error {
 		return err
 	}
 	h.id = m["id"].(string)
+	// Decode the cursor on its own, not to lose precision through float64
+	var c struct {
+		Curr uint64 `json:"curr"`
+	}
+	if err = json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	h.Lock()
+	h.curr = c.Curr
+	h.Unlock()
 	bi, _ := json.Marshal(m["sequence"])
 	if err := json.Unmarshal(bi, &b); err != nil {
 		return err
@@ -1,9 +1,13 @@
 package bitseq
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"reflect"
 	"testing"
 	"time"
 
@@ -1133,3 +1137,468 @@ func TestIsCorrupted(t *testing.T) {
 		}
 	}
 }
+
+func TestWalkSet(t *testing.T) {
+	hnd, err := NewHandle("", nil, "", 1024*32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := []uint64{0, 1, 31, 32, 100, 1000, 20000, 32767}
+	for _, o := range set {
+		if err := hnd.Set(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var walked []uint64
+	hnd.WalkSet(0, hnd.Bits()-1, func(o uint64) bool {
+		walked = append(walked, o)
+		return false
+	})
+	if !reflect.DeepEqual(walked, set) {
+		t.Fatalf("Unexpected walk. Expected %v, got %v", set, walked)
+	}
+
+	walked = nil
+	hnd.WalkSet(31, 1000, func(o uint64) bool {
+		walked = append(walked, o)
+		return false
+	})
+	if expected := []uint64{31, 32, 100, 1000}; !reflect.DeepEqual(walked, expected) {
+		t.Fatalf("Unexpected walk in range. Expected %v, got %v", expected, walked)
+	}
+
+	walked = nil
+	hnd.WalkSet(2, hnd.Bits(), func(o uint64) bool {
+		walked = append(walked, o)
+		return len(walked) == 2
+	})
+	if expected := []uint64{31, 32}; !reflect.DeepEqual(walked, expected) {
+		t.Fatalf("Unexpected interrupted walk. Expected %v, got %v", expected, walked)
+	}
+
+	// Fully allocated blocks
+	for o := uint64(64); o < 128; o++ {
+		hnd.Set(o)
+	}
+	var count int
+	hnd.WalkSet(64, 127, func(o uint64) bool {
+		count++
+		return false
+	})
+	if count != 64 {
+		t.Fatalf("Expected 64 set ordinals, got %d", count)
+	}
+}
+
+func TestSetAnySerialInRange(t *testing.T) {
+	ds, err := randomLocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hnd, err := NewHandle("bitseq-test/data/", ds, "test-serial", 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := uint64(2); i < 5; i++ {
+		o, err := hnd.SetAnySerialInRange(2, 9)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o != i {
+			t.Fatalf("Unexpected ordinal. Expected %d. Got %d", i, o)
+		}
+	}
+
+	// A released bit is not reused until the range wraps around
+	if err := hnd.Unset(3); err != nil {
+		t.Fatal(err)
+	}
+	o, err := hnd.SetAnySerialInRange(2, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o != 5 {
+		t.Fatalf("Unexpected ordinal. Expected 5. Got %d", o)
+	}
+
+	// The cursor is persisted with the sequence
+	hnd, err = NewHandle("bitseq-test/data/", ds, "test-serial", 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []uint64{6, 7, 8, 9, 3} {
+		o, err := hnd.SetAnySerialInRange(2, 9)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o != expected {
+			t.Fatalf("Unexpected ordinal. Expected %d. Got %d", expected, o)
+		}
+	}
+	if _, err := hnd.SetAnySerialInRange(2, 9); err != ErrNoBitAvailable {
+		t.Fatalf("Expected ErrNoBitAvailable. Got %v", err)
+	}
+
+	// The search from a given ordinal wraps around as well
+	o, err = hnd.SetAnyInRangeFrom(0, 63, 62)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o != 62 {
+		t.Fatalf("Unexpected ordinal. Expected 62. Got %d", o)
+	}
+	if _, err := hnd.SetAnyInRangeFrom(0, 63, 63); err != nil {
+		t.Fatal(err)
+	}
+	o, err = hnd.SetAnyInRangeFrom(0, 63, 63)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o != 0 {
+		t.Fatalf("Unexpected ordinal. Expected 0. Got %d", o)
+	}
+
+	if err := hnd.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetUnsetRange(t *testing.T) {
+	numBits := uint64(64 * blockLen)
+	hnd, err := NewHandle("", nil, "", numBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := NewHandle("", nil, "", numBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ranges := [][2]uint64{
+		{0, 0},
+		{3, 9},
+		{31, 32},
+		{100, 355},
+		{512, 1023},
+		{1030, 1030 + 3*uint64(blockLen)},
+		{numBits - 5, numBits - 1},
+	}
+	for _, r := range ranges {
+		if err := hnd.SetRange(r[0], r[1]); err != nil {
+			t.Fatalf("Unexpected failure setting range %v: %v", r, err)
+		}
+		for o := r[0]; o <= r[1]; o++ {
+			if err := ref.Set(o); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !hnd.head.equal(ref.head) || hnd.Unselected() != ref.Unselected() {
+			t.Fatalf("Unexpected sequence after setting range %v:\n%s\n%s", r, hnd, ref)
+		}
+	}
+
+	// A range overlapping a set bit fails and leaves the sequence untouched
+	before := hnd.head.getCopy()
+	if err := hnd.SetRange(356, 520); err != ErrBitAllocated {
+		t.Fatalf("Expected ErrBitAllocated, got %v", err)
+	}
+	if !hnd.head.equal(before) || hnd.Unselected() != ref.Unselected() {
+		t.Fatalf("Sequence changed by failed range set:\n%s", hnd)
+	}
+
+	// Unsetting a range is not affected by the already unset bits
+	if err := hnd.UnsetRange(5, 700); err != nil {
+		t.Fatal(err)
+	}
+	unselected := ref.Unselected()
+	for o := uint64(5); o <= 700; o++ {
+		if ref.IsSet(o) {
+			ref.Unset(o)
+			unselected++
+		}
+	}
+	if !hnd.head.equal(ref.head) || hnd.Unselected() != unselected {
+		t.Fatalf("Unexpected sequence after unsetting range:\n%s\n%s", hnd, ref)
+	}
+
+	if err := hnd.UnsetRange(0, numBits-1); err != nil {
+		t.Fatal(err)
+	}
+	if hnd.Unselected() != numBits || !hnd.head.equal(&sequence{block: 0x0, count: 64}) {
+		t.Fatalf("Unexpected sequence after unsetting all bits: %s", hnd)
+	}
+
+	if err := hnd.SetRange(10, 5); err == nil {
+		t.Fatal("Expected failure on inverted range")
+	}
+	if err := hnd.SetRange(0, numBits); err == nil {
+		t.Fatal("Expected failure on out of bounds range")
+	}
+}
+
+func TestSetRangeStore(t *testing.T) {
+	ds, err := randomLocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numBits := uint64(1 << 16)
+	hnd, err := NewHandle("bitseq-test/data/", ds, "range", numBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hnd.Destroy()
+
+	index := hnd.Index()
+	if err := hnd.SetRange(256, 511); err != nil {
+		t.Fatal(err)
+	}
+	if hnd.Index() != index+1 {
+		t.Fatalf("Expected a single store write, index moved from %d to %d", index, hnd.Index())
+	}
+
+	hnd2, err := NewHandle("bitseq-test/data/", ds, "range", numBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hnd2.Unselected() != numBits-256 || !hnd2.IsSet(256) || !hnd2.IsSet(511) || hnd2.IsSet(512) {
+		t.Fatalf("Unexpected sequence read from store: %s", hnd2)
+	}
+
+	// The stale handle picks up the store state before applying the range
+	if err := hnd.UnsetRange(256, 300); err != nil {
+		t.Fatal(err)
+	}
+	if err := hnd2.UnsetRange(400, 511); err != nil {
+		t.Fatal(err)
+	}
+	if err := hnd.SetRange(0, 255); err != nil {
+		t.Fatal(err)
+	}
+	if hnd.Unselected() != numBits-256-(399-301+1) {
+		t.Fatalf("Unexpected unselected count: %s", hnd)
+	}
+}
+
+func TestIterator(t *testing.T) {
+	numBits := uint64(40*blockLen + 7)
+	hnd, err := NewHandle("", nil, "", numBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rand.Seed(time.Now().Unix())
+	for i := 0; i < 300; i++ {
+		hnd.Set(uint64(rand.Int63n(int64(numBits))))
+	}
+	hnd.SetRange(320, 640)
+
+	for _, r := range [][2]uint64{{0, numBits - 1}, {0, numBits + 100}, {33, 33}, {17, 700}, {numBits - 10, numBits - 1}, {5, 4}} {
+		var set, unset []uint64
+		for o := r[0]; o <= r[1] && o < numBits; o++ {
+			if hnd.IsSet(o) {
+				set = append(set, o)
+			} else {
+				unset = append(unset, o)
+			}
+		}
+
+		var got []uint64
+		it := hnd.IterateSet(r[0], r[1])
+		for o, ok := it.Next(); ok; o, ok = it.Next() {
+			got = append(got, o)
+		}
+		if !reflect.DeepEqual(got, set) {
+			t.Fatalf("Unexpected set ordinals in %v. Expected %v, got %v", r, set, got)
+		}
+
+		got = nil
+		it = hnd.IterateUnset(r[0], r[1])
+		for o, ok := it.Next(); ok; o, ok = it.Next() {
+			got = append(got, o)
+		}
+		if !reflect.DeepEqual(got, unset) {
+			t.Fatalf("Unexpected unset ordinals in %v. Expected %v, got %v", r, unset, got)
+		}
+	}
+}
+
+// legacyByteArray returns the handle data in the legacy encoding
+func legacyByteArray(h *Handle) []byte {
+	ba := make([]byte, 16)
+	binary.BigEndian.PutUint64(ba[0:], h.bits)
+	binary.BigEndian.PutUint64(ba[8:], h.unselected)
+	bm, _ := h.head.toByteArray()
+	return append(ba, bm...)
+}
+
+// fragmentedHandle returns a handle of numBits bits whose allocations are
+// scattered in short runs
+func fragmentedHandle(numBits uint64) *Handle {
+	h, _ := NewHandle("", nil, "", numBits)
+	r := rand.New(rand.NewSource(1))
+	for o := uint64(0); o < numBits; {
+		run := uint64(r.Intn(64) + 1)
+		if o+run > numBits {
+			run = numBits - o
+		}
+		if r.Intn(2) == 0 {
+			h.SetRange(o, o+run-1)
+		}
+		o += run
+	}
+	return h
+}
+
+func TestEncoding(t *testing.T) {
+	for _, h := range []*Handle{fragmentedHandle(32), fragmentedHandle(1 << 10), fragmentedHandle(1 << 16)} {
+		h.Set(h.Bits() - 1)
+
+		ba, err := h.ToByteArray()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isLegacyEncoding(ba) {
+			t.Fatal("Handle encoded in the legacy format")
+		}
+		legacy := legacyByteArray(h)
+		if len(ba) >= len(legacy) {
+			t.Fatalf("Encoding is not more compact than the legacy one: %d >= %d bytes", len(ba), len(legacy))
+		}
+		if h.Bits() == 1<<16 && ba[4]&flagCompressed == 0 {
+			t.Fatal("Expected the large sequence to be compressed")
+		}
+
+		for _, b := range [][]byte{ba, legacy} {
+			nh := &Handle{}
+			if err := nh.FromByteArray(b); err != nil {
+				t.Fatal(err)
+			}
+			if nh.bits != h.bits || nh.unselected != h.unselected || !nh.head.equal(h.head) {
+				t.Fatalf("Unexpected decoded handle:\n%s\n%s", nh, h)
+			}
+			if nh.isLegacy() != isLegacyEncoding(b) {
+				t.Fatalf("Unexpected legacy flag: %v", nh.isLegacy())
+			}
+		}
+	}
+
+	nh := &Handle{}
+	for _, b := range [][]byte{{}, {0x00, 'b', 's', 2, 0, 1, 1}, {0x00, 'b', 's', 1, 0, 32, 32, 9, 1}, make([]byte, 20)} {
+		if err := nh.FromByteArray(b); err == nil {
+			t.Fatalf("Expected failure decoding %v", b)
+		}
+	}
+}
+
+func TestLegacyEncodingMigration(t *testing.T) {
+	ds, err := randomLocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := fragmentedHandle(1 << 12)
+	h.id = "legacy"
+	value := legacyValue(h)
+	key := datastore.Key("bitseq-test/data/", "legacy")
+	if err := ds.KVStore().Put(key, value, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	hnd, err := NewHandle("bitseq-test/data/", ds, "legacy", 1<<12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hnd.Destroy()
+	if !hnd.head.equal(h.head) || hnd.Unselected() != h.Unselected() {
+		t.Fatalf("Unexpected handle read from the legacy encoding:\n%s\n%s", hnd, h)
+	}
+
+	kvp, err := ds.KVStore().Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(kvp.Value, recordMagic) {
+		t.Fatal("Handle was not migrated in store")
+	}
+}
+
+func TestStoreValue(t *testing.T) {
+	h := fragmentedHandle(1 << 12)
+	h.id = "value"
+	h.curr = 1<<12 - 1
+
+	// The JSON encoding of the previous versions is still read
+	jv, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range [][]byte{h.Value(), jv} {
+		nh := &Handle{}
+		if err := nh.SetValue(value); err != nil {
+			t.Fatal(err)
+		}
+		if nh.id != h.id || nh.curr != h.curr || nh.bits != h.bits ||
+			nh.unselected != h.unselected || !nh.head.equal(h.head) {
+			t.Fatalf("Unexpected handle read from the store value:\n%s\n%s", nh, h)
+		}
+	}
+
+	value := h.Value()
+	for _, b := range [][]byte{value[:len(recordMagic)], value[:len(recordMagic)+3], value[:len(recordMagic)+7]} {
+		if err := (&Handle{}).SetValue(b); err == nil {
+			t.Fatalf("Expected failure decoding %v", b)
+		}
+	}
+}
+
+// The benchmarks measure the round trip of a fragmented handle through its
+// datastore value, in the current encoding, in the JSON record of the
+// previous version and in the legacy encoding
+func BenchmarkStoreValue(b *testing.B) {
+	h := fragmentedHandle(1 << 16)
+	jv, _ := json.Marshal(h)
+	b.Logf("%d bytes value, %d bytes as JSON, %d bytes with the legacy encoding", len(h.Value()), len(jv), len(legacyValue(h)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := (&Handle{}).SetValue(h.Value()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStoreValueJSON(b *testing.B) {
+	h := fragmentedHandle(1 << 16)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		value, err := json.Marshal(h)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := (&Handle{}).SetValue(value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStoreValueLegacy(b *testing.B) {
+	h := fragmentedHandle(1 << 16)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := (&Handle{}).SetValue(legacyValue(h)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func legacyValue(h *Handle) []byte {
+	value, _ := json.Marshal(map[string]interface{}{"id": h.id, "sequence": legacyByteArray(h)})
+	return value
+}
@@ -1,6 +1,8 @@
 package bitseq
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 
@@ -22,18 +24,62 @@ func (h *Handle) KeyPrefix() []string {
 	return []string{h.app}
 }
 
+// The handle is stored in the KV store as a record holding the raw
+// encoding of the handle data:
+//
+//	magic (3 bytes) | uvarint id length | id | uvarint cursor | handle data
+//
+// The records written by the previous versions are the JSON encoding of
+// the handle, they are still read.
+var recordMagic = []byte{0x00, 'b', 'r'}
+
 // Value marshals the data to be stored in the KV store
 func (h *Handle) Value() []byte {
-	b, err := json.Marshal(h)
+	ba, err := h.ToByteArray()
 	if err != nil {
 		return nil
 	}
-	return b
+
+	var v [binary.MaxVarintLen64]byte
+	h.Lock()
+	b := make([]byte, 0, len(recordMagic)+2*binary.MaxVarintLen64+len(h.id)+len(ba))
+	b = append(b, recordMagic...)
+	b = append(b, v[:binary.PutUvarint(v[:], uint64(len(h.id)))]...)
+	b = append(b, h.id...)
+	b = append(b, v[:binary.PutUvarint(v[:], h.curr)]...)
+	h.Unlock()
+
+	return append(b, ba...)
 }
 
 // SetValue unmarshals the data from the KV store
 func (h *Handle) SetValue(value []byte) error {
-	return json.Unmarshal(value, h)
+	if !bytes.HasPrefix(value, recordMagic) {
+		return json.Unmarshal(value, h)
+	}
+
+	b := value[len(recordMagic):]
+	l, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < l {
+		return fmt.Errorf("invalid bitsequence record id")
+	}
+	id := string(b[n : n+int(l)])
+	b = b[n+int(l):]
+	curr, n := binary.Uvarint(b)
+	if n <= 0 {
+		return fmt.Errorf("invalid bitsequence record cursor")
+	}
+
+	if err := h.FromByteArray(b[n:]); err != nil {
+		return err
+	}
+
+	h.Lock()
+	h.id = id
+	h.curr = curr
+	h.Unlock()
+
+	return nil
 }
 
 // Index returns the latest DB Index as seen by this object
@@ -81,12 +127,14 @@ func (h *Handle) CopyTo(o datastore.KVObject) error {
 	dstH.Lock()
 	dstH.bits = h.bits
 	dstH.unselected = h.unselected
+	dstH.curr = h.curr
 	dstH.head = h.head.getCopy()
 	dstH.app = h.app
 	dstH.id = h.id
 	dstH.dbIndex = h.dbIndex
 	dstH.dbExists = h.dbExists
 	dstH.store = h.store
+	dstH.legacy = h.legacy
 	dstH.Unlock()
 
 	return nil
@@ -28,6 +28,23 @@ type SandboxResource struct {
 	ContainerID string `json:"container_id"`
 }
 
+// IpamAuditIssueResource is an element of the body of the "get ipam audit" http response message
+type IpamAuditIssueResource struct {
+	Kind      string   `json:"kind"`
+	Network   string   `json:"network"`
+	PoolID    string   `json:"pool_id"`
+	Address   string   `json:"address"`
+	Endpoints []string `json:"endpoints"`
+	Repaired  bool     `json:"repaired"`
+}
+
+// IpamAuditResource is the body of the "get ipam audit" http response message
+type IpamAuditResource struct {
+	Networks []string                  `json:"networks"`
+	Skipped  []string                  `json:"skipped"`
+	Issues   []*IpamAuditIssueResource `json:"issues"`
+}
+
 /***********
   Body types
   ************/
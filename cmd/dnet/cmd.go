@@ -5,12 +5,21 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/codegangsta/cli"
+	"github.com/docker/docker/pkg/stringid"
 	"github.com/docker/docker/pkg/term"
 	"github.com/docker/libnetwork/client"
+	"github.com/docker/libnetwork/networkdb"
 )
 
 var (
@@ -26,9 +35,118 @@ var (
 		Action: runContainerRm,
 	}
 
+	containerCaptureCommand = cli.Command{
+		Name:  "capture",
+		Usage: "Capture the packets of a container interface in pcap format",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "i, -interface",
+				Value: "eth0",
+				Usage: "Container interface to capture on",
+			},
+			cli.StringFlag{
+				Name:  "f, -filter",
+				Value: "",
+				Usage: "BPF program in the tcpdump -ddd format",
+			},
+			cli.IntFlag{
+				Name:  "s, -snaplen",
+				Value: 0,
+				Usage: "Number of bytes to capture from each packet",
+			},
+			cli.StringFlag{
+				Name:  "t, -duration",
+				Value: "10s",
+				Usage: "Duration of the capture",
+			},
+			cli.StringFlag{
+				Name:  "w, -write",
+				Value: "",
+				Usage: "Write the capture to a file instead of stdout",
+			},
+		},
+		Action: runContainerCapture,
+	}
+
 	containerCommands = []cli.Command{
 		containerCreateCommand,
 		containerRmCommand,
+		containerCaptureCommand,
+	}
+
+	ipamAuditCommand = cli.Command{
+		Name:  "audit",
+		Usage: "Cross-check the endpoint addresses against the IPAM allocations",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "r, -repair",
+				Usage: "Release the addresses already reported as leaked a minute earlier",
+			},
+		},
+		Action: runIpamAudit,
+	}
+
+	ipamCommands = []cli.Command{
+		ipamAuditCommand,
+	}
+
+	stateExportCommand = cli.Command{
+		Name:  "export",
+		Usage: "Export the local scope networking state",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "o, -output",
+				Value: "",
+				Usage: "Write the state to a file instead of stdout",
+			},
+		},
+		Action: runStateExport,
+	}
+
+	stateImportCommand = cli.Command{
+		Name:   "import",
+		Usage:  "Import a networking state exported from another host",
+		Action: runStateImport,
+	}
+
+	stateCommands = []cli.Command{
+		stateExportCommand,
+		stateImportCommand,
+	}
+
+	networkdbCommands = []cli.Command{
+		{
+			Name:   "members",
+			Usage:  "List the cluster members and their state",
+			Action: runNetworkDBMembers,
+		},
+		{
+			Name:   "networks",
+			Usage:  "List the networks each node participates in",
+			Action: runNetworkDBNetworks,
+		},
+		{
+			Name:  "entries",
+			Usage: "List the table entries with their owner and Lamport time",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "n, -network",
+					Value: "",
+					Usage: "Only list the entries of the network",
+				},
+			},
+			Action: runNetworkDBEntries,
+		},
+		{
+			Name:   "queues",
+			Usage:  "Show the broadcast queue lengths",
+			Action: runNetworkDBQueues,
+		},
+		{
+			Name:   "bulksync",
+			Usage:  "Show the bulk sync history",
+			Action: runNetworkDBBulkSync,
+		},
 	}
 
 	dnetCommands = []cli.Command{
@@ -39,6 +157,21 @@ var (
 			Usage:       "Container management commands",
 			Subcommands: containerCommands,
 		},
+		{
+			Name:        "ipam",
+			Usage:       "IPAM management commands",
+			Subcommands: ipamCommands,
+		},
+		{
+			Name:        "state",
+			Usage:       "Networking state backup and restore commands",
+			Subcommands: stateCommands,
+		},
+		{
+			Name:        "networkdb",
+			Usage:       "NetworkDB diagnostic commands, the daemon must run with --networkdb-diagnostics",
+			Subcommands: networkdbCommands,
+		},
 	}
 )
 
@@ -98,6 +231,280 @@ func runContainerRm(c *cli.Context) {
 	}
 }
 
+func runContainerCapture(c *cli.Context) {
+	var sbList []*client.SandboxResource
+
+	if len(c.Args()) == 0 {
+		fmt.Printf("Please provide container id argument\n")
+		os.Exit(1)
+	}
+
+	obj, _, err := readBody(epConn.httpCall("GET", "/sandboxes?partial-container-id="+c.Args()[0], nil, nil))
+	if err != nil {
+		fmt.Printf("GET failed during container id lookup: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = json.Unmarshal(obj, &sbList)
+	if err != nil {
+		fmt.Printf("Unmarshall of container id lookup response failed: %v", err)
+		os.Exit(1)
+	}
+
+	if len(sbList) == 0 {
+		fmt.Printf("No sandbox for container %s found\n", c.Args()[0])
+		os.Exit(1)
+	}
+
+	query := url.Values{}
+	query.Set("interface", c.String("i"))
+	query.Set("duration", c.String("t"))
+	if f := c.String("f"); f != "" {
+		query.Set("filter", f)
+	}
+	if s := c.Int("s"); s > 0 {
+		query.Set("snaplen", strconv.Itoa(s))
+	}
+
+	out := io.Writer(os.Stdout)
+	if path := c.String("w"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			fmt.Printf("Failed to create capture file %s: %v\n", path, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	stream, _, statusCode, err := epConn.httpCall("GET", "/sandboxes/"+sbList[0].ID+"/capture?"+query.Encode(), nil, nil)
+	if err != nil {
+		fmt.Printf("GET failed during capture of container %s: %v\n", c.Args()[0], err)
+		os.Exit(1)
+	}
+	defer stream.Close()
+
+	if statusCode != http.StatusOK {
+		fmt.Printf("Capture of container %s failed with status %d\n", c.Args()[0], statusCode)
+		os.Exit(1)
+	}
+
+	if _, err := io.Copy(out, stream); err != nil {
+		fmt.Fprintf(os.Stderr, "Capture of container %s interrupted: %v\n", c.Args()[0], err)
+		os.Exit(1)
+	}
+}
+
+func runIpamAudit(c *cli.Context) {
+	var audit client.IpamAuditResource
+
+	method := "GET"
+	if c.Bool("r") {
+		method = "POST"
+	}
+
+	obj, statusCode, err := readBody(epConn.httpCall(method, "/ipam/audit", nil, nil))
+	if err != nil {
+		fmt.Printf("%s failed during ipam audit: %v\n", method, err)
+		os.Exit(1)
+	}
+	if statusCode != http.StatusOK {
+		fmt.Printf("Ipam audit failed: %s\n", strings.TrimSpace(string(obj)))
+		os.Exit(1)
+	}
+
+	if err := json.Unmarshal(obj, &audit); err != nil {
+		fmt.Printf("Unmarshall of ipam audit response failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	wr := tabwriter.NewWriter(os.Stdout, 10, 1, 3, ' ', 0)
+	fmt.Fprintln(wr, "KIND\tNETWORK\tADDRESS\tENDPOINTS\tREPAIRED")
+	for _, i := range audit.Issues {
+		eps := make([]string, 0, len(i.Endpoints))
+		for _, id := range i.Endpoints {
+			eps = append(eps, stringid.TruncateID(id))
+		}
+		fmt.Fprintf(wr, "%s\t%s\t%s\t%s\t%t\n", i.Kind, stringid.TruncateID(i.Network), i.Address, strings.Join(eps, ","), i.Repaired)
+	}
+	wr.Flush()
+
+	fmt.Printf("\n%d networks audited, %d skipped, %d issues found\n", len(audit.Networks), len(audit.Skipped), len(audit.Issues))
+}
+
+func runStateExport(c *cli.Context) {
+	obj, _, err := readBody(epConn.httpCall("GET", "/state", nil, nil))
+	if err != nil {
+		fmt.Printf("GET failed during state export: %v\n", err)
+		os.Exit(1)
+	}
+
+	out := io.Writer(os.Stdout)
+	if path := c.String("o"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			fmt.Printf("Failed to create state file %s: %v\n", path, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if _, err := out.Write(obj); err != nil {
+		fmt.Printf("Failed to write the state: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runStateImport(c *cli.Context) {
+	if len(c.Args()) == 0 {
+		fmt.Printf("Please provide state file argument\n")
+		os.Exit(1)
+	}
+
+	state, err := ioutil.ReadFile(c.Args()[0])
+	if err != nil {
+		fmt.Printf("Failed to read state file %s: %v\n", c.Args()[0], err)
+		os.Exit(1)
+	}
+	if !json.Valid(state) {
+		fmt.Printf("State file %s is not a json document\n", c.Args()[0])
+		os.Exit(1)
+	}
+
+	if _, _, err := readBody(epConn.httpCall("POST", "/state", json.RawMessage(state), nil)); err != nil {
+		fmt.Printf("POST failed during state import: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("State imported\n")
+}
+
+// getNetworkDBView decodes the NetworkDB diagnostic view at path in v
+func getNetworkDBView(path string, v interface{}) {
+	obj, statusCode, err := readBody(epConn.httpCall("GET", "/networkdb"+path, nil, nil))
+	if err != nil {
+		fmt.Printf("GET failed during networkdb diagnostics: %v\n", err)
+		os.Exit(1)
+	}
+	if statusCode != http.StatusOK {
+		fmt.Printf("NetworkDB diagnostics failed: %s\n", strings.TrimSpace(string(obj)))
+		os.Exit(1)
+	}
+
+	if err := json.Unmarshal(obj, v); err != nil {
+		fmt.Printf("Unmarshall of networkdb diagnostics failed: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runNetworkDBMembers(c *cli.Context) {
+	var members []*networkdb.MemberInfo
+	getNetworkDBView("/members", &members)
+
+	wr := tabwriter.NewWriter(os.Stdout, 10, 1, 3, ' ', 0)
+	fmt.Fprintln(wr, "NAME\tADDRESS\tSTATE\tVERSION\tLABELS")
+	for _, m := range members {
+		name := m.Name
+		if m.Self {
+			name += " *"
+		}
+		addr := ""
+		if m.Addr != nil {
+			addr = net.JoinHostPort(m.Addr.String(), strconv.Itoa(int(m.Port)))
+		}
+		labels := make([]string, 0, len(m.Labels))
+		for k, v := range m.Labels {
+			labels = append(labels, k+"="+v)
+		}
+		sort.Strings(labels)
+		fmt.Fprintf(wr, "%s\t%s\t%s\t%s\t%s\n", name, addr, m.State, m.Version, strings.Join(labels, ","))
+	}
+	wr.Flush()
+}
+
+func runNetworkDBNetworks(c *cli.Context) {
+	var nodeNetworks map[string][]*networkdb.NetworkInfo
+	getNetworkDBView("/networks", &nodeNetworks)
+
+	nodes := make([]string, 0, len(nodeNetworks))
+	for node := range nodeNetworks {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	wr := tabwriter.NewWriter(os.Stdout, 10, 1, 3, ' ', 0)
+	fmt.Fprintln(wr, "NODE\tNETWORK\tLTIME\tLEAVING")
+	for _, node := range nodes {
+		for _, n := range nodeNetworks[node] {
+			fmt.Fprintf(wr, "%s\t%s\t%d\t%t\n", node, stringid.TruncateID(n.ID), n.LTime, n.Leaving)
+		}
+	}
+	wr.Flush()
+}
+
+func runNetworkDBEntries(c *cli.Context) {
+	var entries []*networkdb.EntryInfo
+	path := "/entries"
+	if nid := c.String("n"); nid != "" {
+		path += "?network=" + url.QueryEscape(nid)
+	}
+	getNetworkDBView(path, &entries)
+
+	wr := tabwriter.NewWriter(os.Stdout, 10, 1, 3, ' ', 0)
+	fmt.Fprintln(wr, "NETWORK\tTABLE\tKEY\tOWNER\tLTIME\tDELETING")
+	for _, e := range entries {
+		fmt.Fprintf(wr, "%s\t%s\t%s\t%s\t%d\t%t\n", stringid.TruncateID(e.Network), e.Table, stringid.TruncateID(e.Key), e.Owner, e.LTime, e.Deleting)
+	}
+	wr.Flush()
+}
+
+func runNetworkDBQueues(c *cli.Context) {
+	var qi networkdb.QueueInfo
+	getNetworkDBView("/queues", &qi)
+
+	nids := make([]string, 0, len(qi.Tables))
+	for nid := range qi.Tables {
+		nids = append(nids, nid)
+	}
+	sort.Strings(nids)
+
+	wr := tabwriter.NewWriter(os.Stdout, 10, 1, 3, ' ', 0)
+	fmt.Fprintln(wr, "QUEUE\tQUEUED")
+	fmt.Fprintf(wr, "network events\t%d\n", qi.Network)
+	for _, nid := range nids {
+		fmt.Fprintf(wr, "table events %s\t%d\n", stringid.TruncateID(nid), qi.Tables[nid])
+	}
+	wr.Flush()
+}
+
+func runNetworkDBBulkSync(c *cli.Context) {
+	var history []*networkdb.BulkSyncRecord
+	getNetworkDBView("/bulksync", &history)
+
+	wr := tabwriter.NewWriter(os.Stdout, 10, 1, 3, ' ', 0)
+	fmt.Fprintln(wr, "TIME\tDIRECTION\tNODE\tNETWORKS\tENTRIES\tDURATION\tRESULT")
+	for _, b := range history {
+		direction := "received"
+		if b.Sent {
+			direction = "sent"
+		}
+		nids := make([]string, 0, len(b.Networks))
+		for _, nid := range b.Networks {
+			nids = append(nids, stringid.TruncateID(nid))
+		}
+		result := "ok"
+		switch {
+		case b.Error != "":
+			result = b.Error
+		case b.TimedOut:
+			result = "timed out"
+		}
+		fmt.Fprintf(wr, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", b.Time.Format(time.RFC3339), direction, b.Node, strings.Join(nids, ","), b.Entries, b.Duration, result)
+	}
+	wr.Flush()
+}
+
 func runDockerCommand(c *cli.Context, cmd string) {
 	_, stdout, stderr := term.StdStreams()
 	oldcli := client.NewNetworkCli(stdout, stderr, epConn.httpCall)
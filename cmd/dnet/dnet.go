@@ -120,6 +120,14 @@ func processConfig(cfg *config.Config) []config.Option {
 		options = append(options, config.OptionKVProviderURL(dcfg.Client.Address))
 	}
 
+	if cfg.Daemon.TrafficAccounting {
+		options = append(options, config.OptionTrafficAccounting())
+	}
+
+	if len(cfg.Ipam.LocalPools) > 0 || len(cfg.Ipam.GlobalPools) > 0 {
+		options = append(options, config.OptionIpamPools(cfg.Ipam.LocalPools, cfg.Ipam.GlobalPools))
+	}
+
 	dOptions, err := startDiscovery(&cfg.Cluster)
 	if err != nil {
 		logrus.Infof("Skipping discovery : %s", err.Error())
@@ -243,7 +251,7 @@ type NetworkOrchestration struct {
 	Peer    string
 }
 
-func (d *dnetConnection) dnetDaemon(cfgFile string) error {
+func (d *dnetConnection) dnetDaemon(cfgFile string, diagnostics bool) error {
 	if err := startTestDriver(); err != nil {
 		return fmt.Errorf("failed to start test driver: %v\n", err)
 	}
@@ -287,10 +295,21 @@ func (d *dnetConnection) dnetDaemon(cfgFile string) error {
 	post.Methods("GET", "PUT", "POST", "DELETE").HandlerFunc(httpHandler)
 	post = r.PathPrefix("/services").Subrouter()
 	post.Methods("GET", "PUT", "POST", "DELETE").HandlerFunc(httpHandler)
+	post = r.PathPrefix("/{.*}/ipam").Subrouter()
+	post.Methods("GET", "PUT", "POST", "DELETE").HandlerFunc(httpHandler)
+	post = r.PathPrefix("/ipam").Subrouter()
+	post.Methods("GET", "PUT", "POST", "DELETE").HandlerFunc(httpHandler)
+	post = r.PathPrefix("/{.*}/state").Subrouter()
+	post.Methods("GET", "PUT", "POST", "DELETE").HandlerFunc(httpHandler)
+	post = r.PathPrefix("/state").Subrouter()
+	post.Methods("GET", "PUT", "POST", "DELETE").HandlerFunc(httpHandler)
 	post = r.PathPrefix("/{.*}/sandboxes").Subrouter()
 	post.Methods("GET", "PUT", "POST", "DELETE").HandlerFunc(httpHandler)
 	post = r.PathPrefix("/sandboxes").Subrouter()
 	post.Methods("GET", "PUT", "POST", "DELETE").HandlerFunc(httpHandler)
+	if diagnostics {
+		r.PathPrefix("/networkdb/").Handler(http.StripPrefix("/networkdb", controller.NetworkDBDiagnostics()))
+	}
 
 	handleSignals(controller)
 	setupDumpStackTrap()
@@ -28,6 +28,10 @@ var (
 			Name:  "D, -debug",
 			Usage: "Enable debug mode",
 		},
+		cli.BoolFlag{
+			Name:  "N, -networkdb-diagnostics",
+			Usage: "Expose the NetworkDB diagnostics in daemon mode",
+		},
 		cli.StringFlag{
 			Name:  "c, -cfg-file",
 			Value: "/etc/default/libnetwork.toml",
@@ -75,7 +79,7 @@ func processFlags(c *cli.Context) error {
 	}
 
 	if c.Bool("d") {
-		err = epConn.dnetDaemon(c.String("c"))
+		err = epConn.dnetDaemon(c.String("c"), c.Bool("N"))
 		if err != nil {
 			logrus.Errorf("dnet Daemon exited with an error : %v", err)
 			os.Exit(1)
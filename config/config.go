@@ -10,6 +10,7 @@ import (
 	"github.com/docker/libkv/store"
 	"github.com/docker/libnetwork/cluster"
 	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/ipamutils"
 	"github.com/docker/libnetwork/netlabel"
 )
 
@@ -17,6 +18,7 @@ import (
 type Config struct {
 	Daemon          DaemonCfg
 	Cluster         ClusterCfg
+	Ipam            IpamCfg
 	Scopes          map[string]*datastore.ScopeCfg
 	ActiveSandboxes map[string]interface{}
 }
@@ -31,6 +33,11 @@ type DaemonCfg struct {
 	DriverCfg       map[string]interface{}
 	ClusterProvider cluster.Provider
 	DisableProvider chan struct{}
+	// UpgradeDryRun reports the store records needing a schema upgrade
+	// at startup instead of upgrading them
+	UpgradeDryRun bool
+	// TrafficAccounting enables the accounting of the endpoints traffic
+	TrafficAccounting bool
 }
 
 // ClusterCfg represents cluster configuration
@@ -41,12 +48,28 @@ type ClusterCfg struct {
 	Heartbeat uint64
 }
 
+// IpamCfg represents the built-in ipam configuration
+type IpamCfg struct {
+	// Predefined pools for the local and global default address spaces,
+	// in place of the built-in ones
+	LocalPools  []*ipamutils.PredefinedPool
+	GlobalPools []*ipamutils.PredefinedPool
+}
+
 // LoadDefaultScopes loads default scope configs for scopes which
-// doesn't have explicit user specified configs.
+// doesn't have explicit user specified configs. The scopes configured
+// with only the encryption of their records get the default client.
 func (c *Config) LoadDefaultScopes(dataDir string) {
 	for k, v := range datastore.DefaultScopes(dataDir) {
-		if _, ok := c.Scopes[k]; !ok {
+		sc, ok := c.Scopes[k]
+		if !ok {
 			c.Scopes[k] = v
+			continue
+		}
+		if sc.Client.Provider == "" && sc.Client.Address == "" && sc.Client.Config == nil {
+			encryption := sc.Client.Encryption
+			sc.Client = v.Client
+			sc.Client.Encryption = encryption
 		}
 	}
 }
@@ -197,6 +220,32 @@ func OptionDataDir(dataDir string) Option {
 	}
 }
 
+// OptionUpgradeDryRun function returns an option setter for reporting,
+// instead of performing, the startup schema upgrade of the store records
+func OptionUpgradeDryRun() Option {
+	return func(c *Config) {
+		c.Daemon.UpgradeDryRun = true
+	}
+}
+
+// OptionTrafficAccounting function returns an option setter for accounting
+// the traffic of the endpoints attached to a sandbox
+func OptionTrafficAccounting() Option {
+	return func(c *Config) {
+		c.Daemon.TrafficAccounting = true
+	}
+}
+
+// OptionIpamPools returns an option setter for the predefined pools of the
+// local and global default address spaces
+func OptionIpamPools(local, global []*ipamutils.PredefinedPool) Option {
+	return func(c *Config) {
+		log.Debugf("Option IpamPools: local %d, global %d", len(local), len(global))
+		c.Ipam.LocalPools = local
+		c.Ipam.GlobalPools = global
+	}
+}
+
 // ProcessOptions processes options and stores it in config
 func (c *Config) ProcessOptions(options ...Option) {
 	for _, opt := range options {
@@ -247,6 +296,22 @@ func OptionLocalKVProviderConfig(config *store.Config) Option {
 	}
 }
 
+// OptionLocalKVEncryption function returns an option setter for the
+// encryption of the local kvstore records, with the keys read from the
+// file or, if not set, from the environment variable
+func OptionLocalKVEncryption(keyFile, keyEnv string) Option {
+	return func(c *Config) {
+		log.Debugf("Option OptionLocalKVEncryption: file %q, env %q", keyFile, keyEnv)
+		if _, ok := c.Scopes[datastore.LocalScope]; !ok {
+			c.Scopes[datastore.LocalScope] = &datastore.ScopeCfg{}
+		}
+		c.Scopes[datastore.LocalScope].Client.Encryption = &datastore.EncryptionCfg{
+			KeyFile: strings.TrimSpace(keyFile),
+			KeyEnv:  strings.TrimSpace(keyEnv),
+		}
+	}
+}
+
 // OptionActiveSandboxes function returns an option setter for passing the sandboxes
 // which were active during previous daemon life
 func OptionActiveSandboxes(sandboxes map[string]interface{}) Option {
@@ -19,10 +19,17 @@ func TestInvalidConfig(t *testing.T) {
 }
 
 func TestConfig(t *testing.T) {
-	_, err := ParseConfig("libnetwork.toml")
+	cfg, err := ParseConfig("libnetwork.toml")
 	if err != nil {
 		t.Fatal("Error parsing a valid configuration file :", err)
 	}
+
+	if len(cfg.Ipam.LocalPools) != 2 || len(cfg.Ipam.GlobalPools) != 0 {
+		t.Fatalf("Unexpected ipam pools: %v", cfg.Ipam)
+	}
+	if p := cfg.Ipam.LocalPools[1]; p.Base != "fd00:80::/48" || p.Size != 64 {
+		t.Fatalf("Unexpected ipam pool: %v", p)
+	}
 }
 
 func TestOptionsLabels(t *testing.T) {
@@ -143,3 +150,14 @@ BFrwkQE4HQtQBV60hYQUzzlSk44VFDz+jxIEtacRHaomDRh2FtOTz+I=
 		t.Fatal("TLS.Certificates is not length 1")
 	}
 }
+
+func TestLocalKVEncryption(t *testing.T) {
+	cfg := ParseConfigOptions(OptionLocalKVEncryption("/etc/keys", ""))
+	sCfg := cfg.Scopes[datastore.LocalScope]
+	if !sCfg.IsValid() {
+		t.Fatalf("Expected the default local store client, got %+v", sCfg.Client)
+	}
+	if sCfg.Client.Encryption == nil || sCfg.Client.Encryption.KeyFile != "/etc/keys" {
+		t.Fatalf("Unexpected local store encryption %+v", sCfg.Client.Encryption)
+	}
+}
@@ -47,8 +47,10 @@ import (
 	"container/heap"
 	"fmt"
 	"net"
+	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/discovery"
@@ -120,6 +122,45 @@ type NetworkController interface {
 
 	// SetKeys configures the encryption key for gossip and overlay data path
 	SetKeys(keys []*types.EncryptionKey) error
+
+	// TrafficHistory returns the hourly traffic accounted to the endpoint
+	// with the given id, which may have been deleted since. The traffic is
+	// only accounted when enabled with config.OptionTrafficAccounting.
+	TrafficHistory(eid string) ([]TrafficBucket, error)
+
+	// Reservations returns the address reservations of the default IPAM in
+	// the passed address space, the local default one if empty
+	Reservations(addressSpace string) ([]*ipamapi.Reservation, error)
+
+	// Reserve holds addresses out of the dynamic allocation of the default
+	// IPAM. It fails on the address spaces the IPAM does not persist.
+	Reserve(addressSpace string, r *ipamapi.Reservation) error
+
+	// Unreserve releases the named address reservation of the default IPAM
+	Unreserve(addressSpace, name string) error
+
+	// AuditIpam cross-checks the addresses of the endpoints of all the
+	// networks against the allocations of their IPAM driver and reports the
+	// inconsistencies. With repair set, the addresses reported as leaked by
+	// an audit at least a minute earlier are released.
+	AuditIpam(repair bool) (*IpamAudit, error)
+
+	// Export returns a versioned JSON document of the local scope state:
+	// the networks with their pools, the endpoints, the sandboxes and the
+	// records of the drivers
+	Export() ([]byte, error)
+
+	// Import recreates on the running controller the networks and
+	// endpoints of a document returned by Export, failing if its networks
+	// clash with the existing ones. The endpoints are created detached, the
+	// exported sandboxes are not imported. On failure the imported networks
+	// and endpoints are removed.
+	Import(data []byte) error
+
+	// NetworkDBDiagnostics returns a read-only HTTP handler exposing the
+	// state of the cluster gossip, see networkdb.DiagnosticHandler. It
+	// fails with 503 while the node is not part of a cluster.
+	NetworkDBDiagnostics() http.Handler
 }
 
 // NetworkWalker is a client provided function which will be used to walk the Networks.
@@ -152,6 +193,14 @@ type controller struct {
 	agentInitDone          chan struct{}
 	keys                   []*types.EncryptionKey
 	clusterConfigAvailable bool
+	trafficStopCh          chan struct{}
+	// drainingPools holds the ids of the pools being removed from their
+	// network, the endpoint addresses are no longer allocated from them
+	drainingPools map[string]bool
+	poolsLock     sync.RWMutex
+	// leaks holds the addresses reported as leaked by the last ipam audit,
+	// along with the time they were first reported
+	leaks map[string]time.Time
 	sync.Mutex
 }
 
@@ -169,6 +218,7 @@ func New(cfgOptions ...config.Option) (NetworkController, error) {
 		svcRecords:      make(map[string]svcInfo),
 		serviceBindings: make(map[serviceKey]*service),
 		agentInitDone:   make(chan struct{}),
+		drainingPools:   make(map[string]bool),
 	}
 
 	if err := c.initStores(); err != nil {
@@ -194,7 +244,11 @@ func New(cfgOptions ...config.Option) (NetworkController, error) {
 		}
 	}
 
-	if err = initIPAMDrivers(drvRegistry, nil, c.getStore(datastore.GlobalScope)); err != nil {
+	var ipamCfg *config.IpamCfg
+	if c.cfg != nil {
+		ipamCfg = &c.cfg.Ipam
+	}
+	if err = initIPAMDrivers(drvRegistry, nil, c.getStore(datastore.GlobalScope), ipamCfg); err != nil {
 		return nil, err
 	}
 
@@ -224,6 +278,10 @@ func New(cfgOptions ...config.Option) (NetworkController, error) {
 		return nil, err
 	}
 
+	if c.trafficAccounting() {
+		c.startTrafficAccounting()
+	}
+
 	return c, nil
 }
 
@@ -335,10 +393,11 @@ func (c *controller) makeDriverConfig(ntype string) map[string]interface{} {
 			continue
 		}
 		config[netlabel.MakeKVClient(k)] = discoverapi.DatastoreConfigData{
-			Scope:    k,
-			Provider: v.Client.Provider,
-			Address:  v.Client.Address,
-			Config:   v.Client.Config,
+			Scope:      k,
+			Provider:   v.Client.Provider,
+			Address:    v.Client.Address,
+			Config:     v.Client.Config,
+			Encryption: v.Client.Encryption,
 		}
 	}
 
@@ -384,10 +443,11 @@ func (c *controller) ReloadConfiguration(cfgOptions ...config.Option) error {
 			continue
 		}
 		dsConfig = &discoverapi.DatastoreConfigData{
-			Scope:    scope,
-			Provider: sCfg.Client.Provider,
-			Address:  sCfg.Client.Address,
-			Config:   sCfg.Client.Config,
+			Scope:      scope,
+			Provider:   sCfg.Client.Provider,
+			Address:    sCfg.Client.Address,
+			Config:     sCfg.Client.Config,
+			Encryption: sCfg.Client.Encryption,
 		}
 		break
 	}
@@ -591,15 +651,25 @@ func (c *controller) NewNetwork(networkType, name string, id string, options ...
 		return nil, types.ForbiddenErrorf("Cannot create a multi-host network from a worker node. Please create the network from a manager node.")
 	}
 
+	if err := c.createNetwork(network); err != nil {
+		return nil, err
+	}
+
+	return network, nil
+}
+
+// createNetwork allocates the pools of the network, creates it in its driver
+// and stores it, rolling back on failure
+func (c *controller) createNetwork(network *network) (err error) {
 	// Make sure we have a driver available for this network type
 	// before we allocate anything.
 	if _, err := network.driver(true); err != nil {
-		return nil, err
+		return err
 	}
 
 	err = network.ipamAllocate()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer func() {
 		if err != nil {
@@ -609,7 +679,7 @@ func (c *controller) NewNetwork(networkType, name string, id string, options ...
 
 	err = c.addNetwork(network)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer func() {
 		if err != nil {
@@ -619,12 +689,13 @@ func (c *controller) NewNetwork(networkType, name string, id string, options ...
 		}
 	}()
 
-	// First store the endpoint count, then the network. To avoid to
-	// end up with a datastore containing a network and not an epCnt,
-	// in case of an ungraceful shutdown during this function call.
+	// Store the endpoint count and the network in a single transaction,
+	// to avoid to end up with a datastore containing a network and not
+	// an epCnt, in case of an ungraceful shutdown during this function call.
 	epCnt := &endpointCnt{n: network}
-	if err = c.updateToStore(epCnt); err != nil {
-		return nil, err
+	network.epCnt = epCnt
+	if err = c.updateToStoreTxn(epCnt, network); err != nil {
+		return err
 	}
 	defer func() {
 		if err != nil {
@@ -634,18 +705,13 @@ func (c *controller) NewNetwork(networkType, name string, id string, options ...
 		}
 	}()
 
-	network.epCnt = epCnt
-	if err = c.updateToStore(network); err != nil {
-		return nil, err
-	}
-
 	if err = network.joinCluster(); err != nil {
-		log.Errorf("Failed to join network %s into agent cluster: %v", name, err)
+		log.Errorf("Failed to join network %s into agent cluster: %v", network.name, err)
 	}
 
 	network.addDriverWatches()
 
-	return network, nil
+	return nil
 }
 
 func (c *controller) reservePools() {
@@ -659,26 +725,7 @@ func (c *controller) reservePools() {
 		if !doReplayPoolReserve(n) {
 			continue
 		}
-		// Construct pseudo configs for the auto IP case
-		autoIPv4 := (len(n.ipamV4Config) == 0 || (len(n.ipamV4Config) == 1 && n.ipamV4Config[0].PreferredPool == "")) && len(n.ipamV4Info) > 0
-		autoIPv6 := (len(n.ipamV6Config) == 0 || (len(n.ipamV6Config) == 1 && n.ipamV6Config[0].PreferredPool == "")) && len(n.ipamV6Info) > 0
-		if autoIPv4 {
-			n.ipamV4Config = []*IpamConf{{PreferredPool: n.ipamV4Info[0].Pool.String()}}
-		}
-		if n.enableIPv6 && autoIPv6 {
-			n.ipamV6Config = []*IpamConf{{PreferredPool: n.ipamV6Info[0].Pool.String()}}
-		}
-		// Account current network gateways
-		for i, c := range n.ipamV4Config {
-			if c.Gateway == "" && n.ipamV4Info[i].Gateway != nil {
-				c.Gateway = n.ipamV4Info[i].Gateway.IP.String()
-			}
-		}
-		for i, c := range n.ipamV6Config {
-			if c.Gateway == "" && n.ipamV6Info[i].Gateway != nil {
-				c.Gateway = n.ipamV6Info[i].Gateway.IP.String()
-			}
-		}
+		n.replayIpamConfig()
 		// Reserve pools
 		if err := n.ipamAllocate(); err != nil {
 			log.Warnf("Failed to allocate ipam pool(s) for network %q (%s): %v", n.Name(), n.ID(), err)
@@ -703,6 +750,31 @@ func (c *controller) reservePools() {
 	}
 }
 
+// replayIpamConfig sets the ipam configs of a network restored from the store
+// so that its pools and gateways are requested again
+func (n *network) replayIpamConfig() {
+	// Construct pseudo configs for the auto IP case
+	autoIPv4 := (len(n.ipamV4Config) == 0 || (len(n.ipamV4Config) == 1 && n.ipamV4Config[0].PreferredPool == "")) && len(n.ipamV4Info) > 0
+	autoIPv6 := (len(n.ipamV6Config) == 0 || (len(n.ipamV6Config) == 1 && n.ipamV6Config[0].PreferredPool == "")) && len(n.ipamV6Info) > 0
+	if autoIPv4 {
+		n.ipamV4Config = []*IpamConf{{PreferredPool: n.ipamV4Info[0].Pool.String()}}
+	}
+	if n.enableIPv6 && autoIPv6 {
+		n.ipamV6Config = []*IpamConf{{PreferredPool: n.ipamV6Info[0].Pool.String()}}
+	}
+	// Account current network gateways
+	for i, c := range n.ipamV4Config {
+		if c.Gateway == "" && n.ipamV4Info[i].Gateway != nil {
+			c.Gateway = n.ipamV4Info[i].Gateway.IP.String()
+		}
+	}
+	for i, c := range n.ipamV6Config {
+		if c.Gateway == "" && n.ipamV6Info[i].Gateway != nil {
+			c.Gateway = n.ipamV6Info[i].Gateway.IP.String()
+		}
+	}
+}
+
 func doReplayPoolReserve(n *network) bool {
 	_, caps, err := n.getController().getIPAMDriver(n.ipamType)
 	if err != nil {
@@ -1019,6 +1091,7 @@ func (c *controller) getIPAMDriver(name string) (ipamapi.Ipam, *ipamapi.Capabili
 }
 
 func (c *controller) Stop() {
+	c.stopTrafficAccounting()
 	c.closeStores()
 	c.stopExternalKeyListener()
 	osl.GC()
@@ -19,6 +19,16 @@ func newCache(ds *datastore) *cache {
 	return &cache{kmm: make(map[string]kvMap), ds: ds}
 }
 
+// resetCache drops the cached objects, to be reloaded from the store
+func (ds *datastore) resetCache() {
+	if ds.cache == nil {
+		return
+	}
+	ds.cache.Lock()
+	ds.cache.kmm = make(map[string]kvMap)
+	ds.cache.Unlock()
+}
+
 func (c *cache) kmap(kvObject KVObject) (kvMap, error) {
 	var err error
 
@@ -56,8 +66,13 @@ func (c *cache) kmap(kvObject KVObject) (kvMap, error) {
 			continue
 		}
 
+		value, _, err := upgradeValue(kindOf(kvObject.KeyPrefix()), kvPair.Value)
+		if err != nil {
+			return nil, err
+		}
+
 		dstO := ctor.New()
-		err = dstO.SetValue(kvPair.Value)
+		err = dstO.SetValue(value)
 		if err != nil {
 			return nil, err
 		}
@@ -137,6 +152,24 @@ func (c *cache) del(kvObject KVObject, atomic bool) error {
 	return nil
 }
 
+// check returns ErrKeyModified if the cached object has a different index
+// than the passed one
+func (c *cache) check(kvObject KVObject) error {
+	kmap, err := c.kmap(kvObject)
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if prev, ok := kmap[Key(kvObject.Key()...)]; ok && prev.Index() != kvObject.Index() {
+		return ErrKeyModified
+	}
+
+	return nil
+}
+
 func (c *cache) get(key string, kvObject KVObject) error {
 	kmap, err := c.kmap(kvObject)
 	if err != nil {
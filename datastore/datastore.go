@@ -31,6 +31,9 @@ type DataStore interface {
 	Watchable() bool
 	// Watch for changes on a KVObject
 	Watch(kvObject KVObject, stopCh <-chan struct{}) (<-chan KVObject, error)
+	// ResumableWatch watches for changes on a KVObject, or on the KVObjects
+	// under its key prefix, resuming without missing changes on restart
+	ResumableWatch(kvObject KVObject, tree bool, stopCh <-chan struct{}) (*Watcher, error)
 	// RestartWatch retriggers stopped Watches
 	RestartWatch()
 	// Active returns if the store is active
@@ -43,6 +46,19 @@ type DataStore interface {
 	Scope() string
 	// KVStore returns access to the KV Store
 	KVStore() store.Store
+	// Upgrade migrates the stored records to their current schema version
+	// and returns the keys of the upgraded records, or of the records
+	// needing an upgrade in dry run mode
+	Upgrade(dryRun bool) ([]string, error)
+	// NewTxn returns a transaction grouping object puts and deletes to be
+	// committed atomically
+	NewTxn() *Txn
+	// RecoverTxns completes or undoes the transactions interrupted while
+	// being committed
+	RecoverTxns() error
+	// Reencrypt rewrites the records not encrypted with the current key
+	// and returns their number
+	Reencrypt() (int, error)
 	// Close closes the data store
 	Close()
 }
@@ -103,9 +119,10 @@ type ScopeCfg struct {
 
 // ScopeClientCfg represents Datastore Client-only mode configuration
 type ScopeClientCfg struct {
-	Provider string
-	Address  string
-	Config   *store.Config
+	Provider   string
+	Address    string
+	Config     *store.Config
+	Encryption *EncryptionCfg
 }
 
 const (
@@ -202,7 +219,8 @@ func newClient(scope string, kv string, addr string, config *store.Config, cache
 
 	var addrs []string
 
-	if kv == string(store.BOLTDB) {
+	bolt := kv == string(store.BOLTDB)
+	if bolt {
 		// Parse file path
 		addrs = strings.Split(addr, ",")
 	} else {
@@ -220,6 +238,10 @@ func newClient(scope string, kv string, addr string, config *store.Config, cache
 	if err != nil {
 		return nil, err
 	}
+	if bolt && !config.PersistConnection {
+		// The transactions open the bolt file as libkv does
+		store = newBoltStore(store, addrs[0], config.Bucket)
+	}
 
 	ds := &datastore{scope: scope, store: store, active: true, watchCh: make(chan struct{}), sequential: sequential}
 	if cached {
@@ -237,15 +259,37 @@ func NewDataStore(scope string, cfg *ScopeCfg) (DataStore, error) {
 			return nil, fmt.Errorf("unexpected scope %s without configuration passed", scope)
 		}
 
+		if cfg != nil && cfg.Client.Encryption != nil {
+			c = &ScopeCfg{Client: c.Client}
+			c.Client.Encryption = cfg.Client.Encryption
+		}
 		cfg = c
 	}
 
+	var keys []*storeKey
+	if cfg.Client.Encryption != nil {
+		var err error
+		if keys, err = cfg.Client.Encryption.loadKeys(); err != nil {
+			return nil, err
+		}
+	}
+
 	var cached bool
 	if scope == LocalScope {
 		cached = true
 	}
 
-	return newClient(scope, cfg.Client.Provider, cfg.Client.Address, cfg.Client.Config, cached)
+	ds, err := newClient(scope, cfg.Client.Provider, cfg.Client.Address, cfg.Client.Config, cached)
+	if err != nil {
+		return nil, err
+	}
+
+	if keys != nil {
+		d := ds.(*datastore)
+		d.store = newEncryptedStore(d.store, keys)
+	}
+
+	return ds, nil
 }
 
 // NewDataStoreFromConfig creates a new instance of LibKV data store starting from the datastore config data
@@ -260,11 +304,17 @@ func NewDataStoreFromConfig(dsc discoverapi.DatastoreConfigData) (DataStore, err
 		return nil, fmt.Errorf("cannot parse store configuration: %v", dsc.Config)
 	}
 
+	eCfgP, ok := dsc.Encryption.(*EncryptionCfg)
+	if !ok && dsc.Encryption != nil {
+		return nil, fmt.Errorf("cannot parse store encryption configuration: %v", dsc.Encryption)
+	}
+
 	scopeCfg := &ScopeCfg{
 		Client: ScopeClientCfg{
-			Address:  dsc.Address,
-			Provider: dsc.Provider,
-			Config:   sCfgP,
+			Address:    dsc.Address,
+			Provider:   dsc.Provider,
+			Config:     sCfgP,
+			Encryption: eCfgP,
 		},
 	}
 
@@ -335,7 +385,13 @@ func (ds *datastore) Watch(kvObject KVObject, stopCh <-chan struct{}) (<-chan KV
 
 				dstO := ctor.New()
 
-				if err = dstO.SetValue(kvPair.Value); err != nil {
+				value, _, err := upgradeValue(kindOf(kvObject.Key()), kvPair.Value)
+				if err != nil {
+					log.Printf("Could not upgrade kvpair value = %s: %v", string(kvPair.Value), err)
+					break
+				}
+
+				if err = dstO.SetValue(value); err != nil {
 					log.Printf("Could not unmarshal kvpair value = %s", string(kvPair.Value))
 					break
 				}
@@ -399,6 +455,11 @@ func (ds *datastore) PutObjectAtomic(kvObject KVObject) error {
 		goto add_cache
 	}
 
+	kvObjValue, err = stampValue(kindOf(kvObject.Key()), kvObjValue)
+	if err != nil {
+		return err
+	}
+
 	if kvObject.Exists() {
 		previous = &store.KVPair{Key: Key(kvObject.Key()...), LastIndex: kvObject.Index()}
 	} else {
@@ -460,6 +521,11 @@ func (ds *datastore) putObjectWithKey(kvObject KVObject, key ...string) error {
 	if kvObjValue == nil {
 		return types.BadRequestErrorf("invalid KV Object with a nil Value for key %s", Key(kvObject.Key()...))
 	}
+
+	kvObjValue, err := stampValue(kindOf(key), kvObjValue)
+	if err != nil {
+		return err
+	}
 	return ds.store.Put(Key(key...), kvObjValue, nil)
 }
 
@@ -479,7 +545,12 @@ func (ds *datastore) GetObject(key string, o KVObject) error {
 		return err
 	}
 
-	if err := o.SetValue(kvPair.Value); err != nil {
+	value, _, err := upgradeValue(kindOfKey(key), kvPair.Value)
+	if err != nil {
+		return err
+	}
+
+	if err := o.SetValue(value); err != nil {
 		return err
 	}
 
@@ -532,8 +603,13 @@ func (ds *datastore) List(key string, kvObject KVObject) ([]KVObject, error) {
 			continue
 		}
 
+		value, _, err := upgradeValue(kindOf(kvObject.KeyPrefix()), kvPair.Value)
+		if err != nil {
+			return nil, err
+		}
+
 		dstO := ctor.New()
-		if err := dstO.SetValue(kvPair.Value); err != nil {
+		if err := dstO.SetValue(value); err != nil {
 			return nil, err
 		}
 
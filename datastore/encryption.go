@@ -0,0 +1,444 @@
+package datastore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/types"
+)
+
+// EncryptionCfg represents the configuration of the encryption at rest of
+// the store records. The keys are read from KeyFile or, if not set, from the
+// KeyEnv environment variable. They are base64 encoded 32 bytes keys,
+// separated by newlines or commas: the first one encrypts the records, the
+// others are only used to decrypt the records not re-encrypted yet after a
+// key rotation.
+type EncryptionCfg struct {
+	KeyFile string
+	KeyEnv  string
+}
+
+// An encrypted record value is an envelope holding the record data key
+// wrapped by the store key, and the record data encrypted with it:
+//
+//	magic (4 bytes) | version (1 byte) | store key id (8 bytes) |
+//	wrapped data key nonce | wrapped data key | data nonce | encrypted data
+//
+// Values not starting with the magic are legacy plaintext records.
+var encryptionMagic = []byte{0x00, 'l', 'n', 'e'}
+
+const (
+	encryptionVersion = byte(1)
+	storeKeyLen       = 32
+	storeKeyIDLen     = 8
+	dataKeyLen        = 32
+)
+
+// storeKey is a key encrypting the record data keys
+type storeKey struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadKeys reads the store keys from the configured source
+func (cfg *EncryptionCfg) loadKeys() ([]*storeKey, error) {
+	var (
+		data   string
+		source string
+	)
+	switch {
+	case cfg.KeyFile != "":
+		b, err := ioutil.ReadFile(cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the store encryption keys: %v", err)
+		}
+		data, source = string(b), cfg.KeyFile
+	case cfg.KeyEnv != "":
+		data, source = os.Getenv(cfg.KeyEnv), "$"+cfg.KeyEnv
+	default:
+		return nil, types.BadRequestErrorf("no source configured for the store encryption keys")
+	}
+
+	var keys []*storeKey
+	for _, s := range strings.FieldsFunc(data, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid store encryption key in %s: %v", source, err)
+		}
+		if len(key) != storeKeyLen {
+			return nil, fmt.Errorf("invalid store encryption key in %s: %d bytes instead of %d", source, len(key), storeKeyLen)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(key)
+		keys = append(keys, &storeKey{id: sum[:storeKeyIDLen], aead: aead})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no store encryption key in %s", source)
+	}
+
+	return keys, nil
+}
+
+// encryptedStore encrypts the values put in the wrapped store and decrypts
+// the ones read from it, legacy plaintext values are returned as they are
+type encryptedStore struct {
+	store.Store
+	keys []*storeKey
+}
+
+func newEncryptedStore(kvs store.Store, keys []*storeKey) *encryptedStore {
+	return &encryptedStore{Store: kvs, keys: keys}
+}
+
+// recordAAD binds the encrypted value to its key, so that records can't be
+// swapped
+func recordAAD(key string) []byte {
+	return []byte(strings.Trim(key, "/"))
+}
+
+func isEncrypted(value []byte) bool {
+	return len(value) > len(encryptionMagic) && bytes.Equal(value[:len(encryptionMagic)], encryptionMagic)
+}
+
+// encryptionKeyID returns the id of the store key the value is encrypted with
+func encryptionKeyID(value []byte) []byte {
+	hdrLen := len(encryptionMagic) + 1
+	if !isEncrypted(value) || len(value) < hdrLen+storeKeyIDLen {
+		return nil
+	}
+	return value[hdrLen : hdrLen+storeKeyIDLen]
+}
+
+func (es *encryptedStore) encrypt(key string, value []byte) ([]byte, error) {
+	// Directories have no value
+	if len(value) == 0 {
+		return value, nil
+	}
+
+	sk := es.keys[0]
+	dataKey := make([]byte, dataKeyLen)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	keyNonce := make([]byte, sk.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, keyNonce); err != nil {
+		return nil, err
+	}
+	dataNonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, dataNonce); err != nil {
+		return nil, err
+	}
+
+	ev := make([]byte, 0, len(encryptionMagic)+1+storeKeyIDLen+len(keyNonce)+dataKeyLen+len(dataNonce)+len(value)+2*aead.Overhead())
+	ev = append(ev, encryptionMagic...)
+	ev = append(ev, encryptionVersion)
+	ev = append(ev, sk.id...)
+	ev = append(ev, keyNonce...)
+	ev = sk.aead.Seal(ev, keyNonce, dataKey, sk.id)
+	ev = append(ev, dataNonce...)
+	ev = aead.Seal(ev, dataNonce, value, recordAAD(key))
+
+	return ev, nil
+}
+
+func (es *encryptedStore) decrypt(key string, value []byte) ([]byte, error) {
+	if !isEncrypted(value) {
+		return value, nil
+	}
+
+	ev := value[len(encryptionMagic):]
+	if ev[0] != encryptionVersion {
+		return nil, fmt.Errorf("unsupported encryption version %d of record %s", ev[0], key)
+	}
+	ev = ev[1:]
+	if len(ev) < storeKeyIDLen {
+		return nil, fmt.Errorf("truncated encrypted record %s", key)
+	}
+	id := ev[:storeKeyIDLen]
+	ev = ev[storeKeyIDLen:]
+
+	var sk *storeKey
+	for _, k := range es.keys {
+		if bytes.Equal(k.id, id) {
+			sk = k
+			break
+		}
+	}
+	if sk == nil {
+		return nil, fmt.Errorf("record %s is encrypted with unknown key %x", key, id)
+	}
+
+	wrappedLen := sk.aead.NonceSize() + dataKeyLen + sk.aead.Overhead()
+	if len(ev) < wrappedLen {
+		return nil, fmt.Errorf("truncated encrypted record %s", key)
+	}
+	dataKey, err := sk.aead.Open(nil, ev[:sk.aead.NonceSize()], ev[sk.aead.NonceSize():wrappedLen], sk.id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap the data key of record %s: %v", key, err)
+	}
+	ev = ev[wrappedLen:]
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(ev) < aead.NonceSize() {
+		return nil, fmt.Errorf("truncated encrypted record %s", key)
+	}
+	data, err := aead.Open(nil, ev[:aead.NonceSize()], ev[aead.NonceSize():], recordAAD(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt record %s: %v", key, err)
+	}
+
+	return data, nil
+}
+
+func (es *encryptedStore) decryptPair(kvPair *store.KVPair) (*store.KVPair, error) {
+	if kvPair == nil {
+		return nil, nil
+	}
+	value, err := es.decrypt(kvPair.Key, kvPair.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &store.KVPair{Key: kvPair.Key, Value: value, LastIndex: kvPair.LastIndex}, nil
+}
+
+// Get decrypts the value at key
+func (es *encryptedStore) Get(key string) (*store.KVPair, error) {
+	kvPair, err := es.Store.Get(key)
+	if err != nil || kvPair == nil {
+		return kvPair, err
+	}
+	// The backends may not return the key
+	kvPair.Key = key
+	return es.decryptPair(kvPair)
+}
+
+// Put encrypts the value at key
+func (es *encryptedStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	ev, err := es.encrypt(key, value)
+	if err != nil {
+		return err
+	}
+	return es.Store.Put(key, ev, options)
+}
+
+// List decrypts the values under the directory
+func (es *encryptedStore) List(directory string) ([]*store.KVPair, error) {
+	kvList, err := es.Store.List(directory)
+	if err != nil {
+		return nil, err
+	}
+	return es.decryptList(kvList)
+}
+
+func (es *encryptedStore) decryptList(kvList []*store.KVPair) ([]*store.KVPair, error) {
+	dl := make([]*store.KVPair, 0, len(kvList))
+	for _, kvPair := range kvList {
+		dp, err := es.decryptPair(kvPair)
+		if err != nil {
+			return nil, err
+		}
+		dl = append(dl, dp)
+	}
+	return dl, nil
+}
+
+// Watch decrypts the values of the watched key
+func (es *encryptedStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	kvCh, err := es.Store.Watch(key, stopCh)
+	if err != nil {
+		return nil, err
+	}
+	dCh := make(chan *store.KVPair)
+	go func() {
+		defer close(dCh)
+		for kvPair := range kvCh {
+			if kvPair != nil {
+				var err error
+				kvPair = &store.KVPair{Key: key, Value: kvPair.Value, LastIndex: kvPair.LastIndex}
+				if kvPair, err = es.decryptPair(kvPair); err != nil {
+					log.Printf("Could not decrypt watched key %s: %v", key, err)
+					continue
+				}
+			}
+			select {
+			case dCh <- kvPair:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return dCh, nil
+}
+
+// WatchTree decrypts the values under the watched directory
+func (es *encryptedStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	kvCh, err := es.Store.WatchTree(directory, stopCh)
+	if err != nil {
+		return nil, err
+	}
+	dCh := make(chan []*store.KVPair)
+	go func() {
+		defer close(dCh)
+		for kvList := range kvCh {
+			dl, err := es.decryptList(kvList)
+			if err != nil {
+				log.Printf("Could not decrypt watched directory %s: %v", directory, err)
+				continue
+			}
+			select {
+			case dCh <- dl:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return dCh, nil
+}
+
+// AtomicPut encrypts the value at key
+func (es *encryptedStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	ev, err := es.encrypt(key, value)
+	if err != nil {
+		return false, nil, err
+	}
+	ok, kvPair, err := es.Store.AtomicPut(key, ev, previous, options)
+	if err != nil || kvPair == nil {
+		return ok, kvPair, err
+	}
+	return ok, &store.KVPair{Key: key, Value: value, LastIndex: kvPair.LastIndex}, nil
+}
+
+// atomicTxn encrypts the values put by the transaction, it requires the
+// wrapped store to support native transactions
+func (es *encryptedStore) atomicTxn(ops []*intentOp) ([]*store.KVPair, error) {
+	ns, ok := es.Store.(nativeTxnStore)
+	if !ok {
+		return nil, types.NotImplementedErrorf("store does not support transactions")
+	}
+
+	eops := make([]*intentOp, 0, len(ops))
+	for _, op := range ops {
+		eop := *op
+		if !op.Delete {
+			var err error
+			if eop.Value, err = es.encrypt(op.Key, op.Value); err != nil {
+				return nil, err
+			}
+		}
+		eops = append(eops, &eop)
+	}
+	pairs, err := ns.atomicTxn(eops)
+	if err != nil {
+		return nil, err
+	}
+	for i, pair := range pairs {
+		if pair != nil {
+			pair.Value = ops[i].Value
+		}
+	}
+	return pairs, nil
+}
+
+// Reencrypt rewrites with the current key the records encrypted with a
+// previous one, and encrypts the legacy plaintext records. It returns the
+// number of records rewritten.
+func (ds *datastore) Reencrypt() (int, error) {
+	es, ok := ds.store.(*encryptedStore)
+	if !ok {
+		return 0, nil
+	}
+
+	if ds.sequential {
+		ds.Lock()
+		defer ds.Unlock()
+	}
+
+	keys, err := listTree(es.Store, Key(), make(map[string]bool))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list the records: %v", err)
+	}
+
+	var count int
+	for _, key := range keys {
+		done, err := es.reencryptRecord(key)
+		if err != nil {
+			log.Printf("Could not re-encrypt record %s: %v", key, err)
+			continue
+		}
+		if done {
+			count++
+		}
+	}
+
+	if count > 0 {
+		ds.resetCache()
+	}
+
+	return count, nil
+}
+
+// reencryptRecord atomically rewrites the record with the current key and
+// returns whether it needed to
+func (es *encryptedStore) reencryptRecord(key string) (bool, error) {
+	for {
+		kvPair, err := es.Store.Get(key)
+		if err != nil {
+			return false, err
+		}
+		if kvPair == nil || len(kvPair.Value) == 0 || bytes.Equal(encryptionKeyID(kvPair.Value), es.keys[0].id) {
+			return false, nil
+		}
+
+		value, err := es.decrypt(key, kvPair.Value)
+		if err != nil {
+			return false, err
+		}
+		ev, err := es.encrypt(key, value)
+		if err != nil {
+			return false, err
+		}
+		previous := &store.KVPair{Key: key, LastIndex: kvPair.LastIndex}
+		if _, _, err = es.Store.AtomicPut(key, ev, previous, nil); err != nil {
+			if isConflict(err) {
+				// Retry
+				continue
+			}
+			return false, err
+		}
+
+		return true, nil
+	}
+}
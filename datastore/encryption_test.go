@@ -0,0 +1,227 @@
+package datastore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/boltdb"
+)
+
+func newTestKey(t *testing.T) string {
+	key := make([]byte, storeKeyLen)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func newEncryptedTestStore(t *testing.T, ms *MockStore, keys ...string) *datastore {
+	os.Setenv("LIBNETWORK_TEST_KEYS", strings.Join(keys, ","))
+	defer os.Unsetenv("LIBNETWORK_TEST_KEYS")
+
+	sks, err := (&EncryptionCfg{KeyEnv: "LIBNETWORK_TEST_KEYS"}).loadKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &datastore{scope: LocalScope, store: newEncryptedStore(ms, sks), active: true, watchCh: make(chan struct{})}
+}
+
+// putPlaintext stores the object as a legacy record, not encrypted
+func putPlaintext(t *testing.T, ms *MockStore, o *schemaObject) {
+	value, err := stampValue(schemaKind, o.Value())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms.Put(Key(o.Key()...), value, nil)
+}
+
+func TestEncryptionLoadKeys(t *testing.T) {
+	k1, k2 := newTestKey(t), newTestKey(t)
+
+	f, err := ioutil.TempFile("", "libnetwork-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(k1 + "\n" + k2 + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	keys, err := (&EncryptionCfg{KeyFile: f.Name()}).loadKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || bytes.Equal(keys[0].id, keys[1].id) {
+		t.Fatalf("Unexpected keys loaded from file: %d", len(keys))
+	}
+
+	os.Setenv("LIBNETWORK_TEST_KEYS", k2+", "+k1)
+	defer os.Unsetenv("LIBNETWORK_TEST_KEYS")
+	ekeys, err := (&EncryptionCfg{KeyEnv: "LIBNETWORK_TEST_KEYS"}).loadKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ekeys) != 2 || !bytes.Equal(ekeys[0].id, keys[1].id) {
+		t.Fatal("Unexpected keys loaded from the environment")
+	}
+
+	for _, cfg := range []*EncryptionCfg{
+		{},
+		{KeyFile: f.Name() + "-missing"},
+		{KeyEnv: "LIBNETWORK_TEST_NO_KEYS"},
+	} {
+		if _, err := cfg.loadKeys(); err == nil {
+			t.Fatalf("Expected failure loading keys with %+v", cfg)
+		}
+	}
+
+	for _, invalid := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		os.Setenv("LIBNETWORK_TEST_KEYS", invalid)
+		if _, err := (&EncryptionCfg{KeyEnv: "LIBNETWORK_TEST_KEYS"}).loadKeys(); err == nil {
+			t.Fatalf("Expected failure loading key %q", invalid)
+		}
+	}
+}
+
+func TestEncryptedStore(t *testing.T) {
+	ms := NewMockStore()
+	ds := newEncryptedTestStore(t, ms, newTestKey(t))
+
+	o := &schemaObject{ID: "e", Name: "secret-net"}
+	if err := ds.PutObjectAtomic(o); err != nil {
+		t.Fatal(err)
+	}
+
+	key := Key(o.Key()...)
+	raw := storedValue(t, ms, key)
+	if !isEncrypted(raw.Value) || bytes.Contains(raw.Value, []byte("secret-net")) {
+		t.Fatalf("Record stored in plaintext: %q", raw.Value)
+	}
+
+	g := &schemaObject{}
+	if err := ds.GetObject(key, g); err != nil {
+		t.Fatal(err)
+	}
+	if g.Name != "secret-net" || g.Index() != o.Index() {
+		t.Fatalf("Unexpected decrypted object %+v", g)
+	}
+
+	// The encrypted value is bound to its key
+	ms.Put(Key(schemaKind, "swapped"), raw.Value, nil)
+	if err := ds.GetObject(Key(schemaKind, "swapped"), &schemaObject{}); err == nil {
+		t.Fatal("Expected failure decrypting a record moved to another key")
+	}
+	ms.Delete(Key(schemaKind, "swapped"))
+
+	// Legacy plaintext records are read as they are
+	legacy := &schemaObject{ID: "legacy", Name: "plain-net"}
+	putPlaintext(t, ms, legacy)
+	kvol, err := ds.List(Key(schemaKind), &schemaObject{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, kvo := range kvol {
+		names[kvo.(*schemaObject).Name] = true
+	}
+	if len(names) != 2 || !names["secret-net"] || !names["plain-net"] {
+		t.Fatalf("Unexpected listed objects %v", names)
+	}
+
+	// Records encrypted with an unknown key can't be read
+	other := newEncryptedTestStore(t, ms, newTestKey(t))
+	if err := other.GetObject(key, &schemaObject{}); err == nil {
+		t.Fatal("Expected failure decrypting a record with an unknown key")
+	}
+}
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	ms := NewMockStore()
+	k1, k2 := newTestKey(t), newTestKey(t)
+
+	ds := newEncryptedTestStore(t, ms, k1)
+	a := &schemaObject{ID: "a", Name: "net1"}
+	if err := ds.PutObjectAtomic(a); err != nil {
+		t.Fatal(err)
+	}
+	legacy := &schemaObject{ID: "legacy", Name: "net2"}
+	putPlaintext(t, ms, legacy)
+
+	// Rotate to k2, keeping k1 to read the records not re-encrypted yet
+	ds = newEncryptedTestStore(t, ms, k2, k1)
+	if err := ds.GetObject(Key(a.Key()...), &schemaObject{}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := ds.Reencrypt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("Expected 2 re-encrypted records, got %d", n)
+	}
+	if n, err = ds.Reencrypt(); err != nil || n != 0 {
+		t.Fatalf("Expected no record to re-encrypt, got %d: %v", n, err)
+	}
+
+	// k1 is no longer needed
+	ds = newEncryptedTestStore(t, ms, k2)
+	for _, o := range []*schemaObject{a, legacy} {
+		g := &schemaObject{}
+		if err := ds.GetObject(Key(o.Key()...), g); err != nil {
+			t.Fatal(err)
+		}
+		if g.Name != o.Name {
+			t.Fatalf("Unexpected re-encrypted object %+v, expected %+v", g, o)
+		}
+	}
+}
+
+func TestEncryptedBoltStore(t *testing.T) {
+	boltdb.Register()
+	dir, err := ioutil.TempDir("", "libnetwork-encryption")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "keys")
+	if err := ioutil.WriteFile(keyFile, []byte(newTestKey(t)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ds, err := NewDataStore(LocalScope, &ScopeCfg{
+		Client: ScopeClientCfg{
+			Provider: "boltdb",
+			Address:  filepath.Join(dir, "local-kv.db"),
+			Config: &store.Config{
+				Bucket:            "libnetwork",
+				ConnectionTimeout: 3 * time.Second,
+			},
+			Encryption: &EncryptionCfg{KeyFile: keyFile},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+
+	testTxn(t, ds)
+
+	kvList, err := ds.(*datastore).store.(*encryptedStore).Store.List(Key(schemaKind))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kvPair := range kvList {
+		if !isEncrypted(kvPair.Value) {
+			t.Fatalf("Record %s stored in plaintext", kvPair.Key)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/types"
+)
+
+// Migration upgrades the stored value of an object from a schema version to
+// the next one
+type Migration func(value []byte) ([]byte, error)
+
+// schemaField is the reserved field of the stored JSON value holding the
+// schema version of the object. A value without it is at version 0.
+const schemaField = "_schema"
+
+// migrations holds, per object kind, the migration functions indexed by the
+// schema version they upgrade from. The kind of an object is the first
+// element of its key.
+var (
+	migrations   = make(map[string][]Migration)
+	migrationsMu sync.RWMutex
+)
+
+// RegisterMigration registers the function upgrading the objects of the kind
+// from the passed schema version to the next one. Migrations of a kind must
+// be registered in order, the current schema version of the kind being the
+// number of migrations registered for it.
+func RegisterMigration(kind string, from int, m Migration) error {
+	if kind == "" || m == nil {
+		return types.BadRequestErrorf("invalid migration for kind %q", kind)
+	}
+
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+
+	if from != len(migrations[kind]) {
+		return types.ForbiddenErrorf("migration of kind %s from version %d registered while at version %d", kind, from, len(migrations[kind]))
+	}
+	migrations[kind] = append(migrations[kind], m)
+
+	return nil
+}
+
+// SchemaVersion returns the current schema version of the objects of the kind
+func SchemaVersion(kind string) int {
+	migrationsMu.RLock()
+	defer migrationsMu.RUnlock()
+	return len(migrations[kind])
+}
+
+func migrationKinds() []string {
+	migrationsMu.RLock()
+	defer migrationsMu.RUnlock()
+
+	kinds := make([]string, 0, len(migrations))
+	for kind := range migrations {
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+func kindOf(key []string) string {
+	if len(key) == 0 {
+		return ""
+	}
+	return key[0]
+}
+
+func kindOfKey(key string) string {
+	chain, err := ParseKey(key)
+	if err != nil {
+		return ""
+	}
+	return kindOf(chain)
+}
+
+// upgradeValue returns the stored value of an object of the kind upgraded
+// to the current schema version, stripped of the version field, along with
+// the version it was stored at
+func upgradeValue(kind string, value []byte) ([]byte, int, error) {
+	migrationsMu.RLock()
+	ml := migrations[kind]
+	migrationsMu.RUnlock()
+	if len(ml) == 0 {
+		return value, 0, nil
+	}
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(value, &m); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode %s object for schema upgrade: %v", kind, err)
+	}
+
+	var version int
+	if raw, ok := m[schemaField]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, 0, fmt.Errorf("invalid schema version of %s object: %v", kind, err)
+		}
+		delete(m, schemaField)
+		var err error
+		if value, err = json.Marshal(m); err != nil {
+			return nil, 0, err
+		}
+	}
+	if version > len(ml) {
+		return nil, version, fmt.Errorf("%s object at schema version %d, newer than supported version %d", kind, version, len(ml))
+	}
+
+	for v := version; v < len(ml); v++ {
+		var err error
+		if value, err = ml[v](value); err != nil {
+			return nil, version, fmt.Errorf("failed to migrate %s object from schema version %d: %v", kind, v, err)
+		}
+	}
+
+	return value, version, nil
+}
+
+// stampValue returns the value of an object of the kind tagged with the
+// current schema version
+func stampValue(kind string, value []byte) ([]byte, error) {
+	version := SchemaVersion(kind)
+	if version == 0 {
+		return value, nil
+	}
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(value, &m); err != nil {
+		return nil, fmt.Errorf("failed to decode %s object for schema stamping: %v", kind, err)
+	}
+	raw, err := json.Marshal(version)
+	if err != nil {
+		return nil, err
+	}
+	m[schemaField] = raw
+
+	return json.Marshal(m)
+}
+
+// Upgrade migrates the stored records of the kinds having migrations to
+// their current schema version and returns the keys of the upgraded records.
+// In dry run mode the records are left untouched and the keys of the records
+// needing an upgrade are returned.
+func (ds *datastore) Upgrade(dryRun bool) ([]string, error) {
+	if ds.sequential {
+		ds.Lock()
+		defer ds.Unlock()
+	}
+
+	var upgraded []string
+	for _, kind := range migrationKinds() {
+		keys, err := listTree(ds.store, Key(kind), make(map[string]bool))
+		if err != nil {
+			return upgraded, fmt.Errorf("failed to list %s records: %v", kind, err)
+		}
+		for _, key := range keys {
+			done, err := ds.upgradeRecord(kind, key, dryRun)
+			if err != nil {
+				log.Printf("Could not upgrade record %s: %v", key, err)
+				continue
+			}
+			if done {
+				upgraded = append(upgraded, key)
+			}
+		}
+	}
+
+	if !dryRun && len(upgraded) > 0 {
+		ds.resetCache()
+	}
+
+	return upgraded, nil
+}
+
+// listTree returns the keys of the records under the prefix, descending in
+// the directories the backend returns as values-less entries
+func listTree(kvs store.Store, prefix string, visited map[string]bool) ([]string, error) {
+	visited[prefix] = true
+	kvList, err := kvs.List(prefix)
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var keys []string
+	for _, kvPair := range kvList {
+		if len(kvPair.Value) != 0 {
+			keys = append(keys, kvPair.Key)
+			continue
+		}
+		dir := kvPair.Key
+		if dir[len(dir)-1] != '/' {
+			dir += "/"
+		}
+		if visited[dir] {
+			continue
+		}
+		sub, err := listTree(kvs, dir, visited)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, sub...)
+	}
+
+	return keys, nil
+}
+
+// upgradeRecord atomically rewrites the record at the current schema version
+// and returns whether it needed to
+func (ds *datastore) upgradeRecord(kind, key string, dryRun bool) (bool, error) {
+	for {
+		kvPair, err := ds.store.Get(key)
+		if err != nil {
+			return false, err
+		}
+		if kvPair == nil || len(kvPair.Value) == 0 {
+			return false, nil
+		}
+
+		value, version, err := upgradeValue(kind, kvPair.Value)
+		if err != nil {
+			return false, err
+		}
+		if version == SchemaVersion(kind) {
+			return false, nil
+		}
+		if dryRun {
+			return true, nil
+		}
+
+		if value, err = stampValue(kind, value); err != nil {
+			return false, err
+		}
+		previous := &store.KVPair{Key: key, LastIndex: kvPair.LastIndex}
+		if _, _, err = ds.store.AtomicPut(key, value, previous, nil); err != nil {
+			if err == store.ErrKeyModified || err == store.ErrKeyExists {
+				// Retry
+				continue
+			}
+			return false, err
+		}
+
+		return true, nil
+	}
+}
@@ -0,0 +1,255 @@
+package datastore
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+)
+
+const schemaKind = "schema-test"
+
+// schemaObject is stored at version 0 as {"id", "name"}, at version 1 as
+// {"id", "Name"} and at version 2 as {"id", "Name", "Driver"}
+type schemaObject struct {
+	ID       string `json:"id"`
+	Name     string
+	Driver   string
+	dbIndex  uint64
+	dbExists bool
+}
+
+func (o *schemaObject) Key() []string       { return []string{schemaKind, o.ID} }
+func (o *schemaObject) KeyPrefix() []string { return []string{schemaKind} }
+func (o *schemaObject) Index() uint64       { return o.dbIndex }
+func (o *schemaObject) Exists() bool        { return o.dbExists }
+func (o *schemaObject) Skip() bool          { return false }
+func (o *schemaObject) DataScope() string   { return LocalScope }
+func (o *schemaObject) New() KVObject       { return &schemaObject{} }
+
+func (o *schemaObject) Value() []byte {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (o *schemaObject) SetValue(value []byte) error {
+	return json.Unmarshal(value, o)
+}
+
+func (o *schemaObject) SetIndex(index uint64) {
+	o.dbIndex = index
+	o.dbExists = true
+}
+
+func (o *schemaObject) CopyTo(dst KVObject) error {
+	*dst.(*schemaObject) = *o
+	return nil
+}
+
+func migrateField(value []byte, change func(m map[string]interface{})) ([]byte, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(value, &m); err != nil {
+		return nil, err
+	}
+	change(m)
+	return json.Marshal(m)
+}
+
+func init() {
+	if err := RegisterMigration(schemaKind, 0, func(value []byte) ([]byte, error) {
+		return migrateField(value, func(m map[string]interface{}) {
+			m["Name"] = m["name"]
+			delete(m, "name")
+		})
+	}); err != nil {
+		panic(err)
+	}
+	if err := RegisterMigration(schemaKind, 1, func(value []byte) ([]byte, error) {
+		return migrateField(value, func(m map[string]interface{}) {
+			m["Driver"] = "bridge"
+		})
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func storedVersion(t *testing.T, ds DataStore, key string) int {
+	kvPair, err := ds.KVStore().Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(kvPair.Value, &m); err != nil {
+		t.Fatal(err)
+	}
+	v, ok := m[schemaField].(float64)
+	if !ok {
+		return 0
+	}
+	return int(v)
+}
+
+func TestRegisterMigration(t *testing.T) {
+	if v := SchemaVersion(schemaKind); v != 2 {
+		t.Fatalf("Unexpected schema version %d", v)
+	}
+	if v := SchemaVersion(dummyKey); v != 0 {
+		t.Fatalf("Unexpected schema version %d for a kind without migrations", v)
+	}
+
+	noop := func(value []byte) ([]byte, error) { return value, nil }
+	if err := RegisterMigration(schemaKind, 1, noop); err == nil {
+		t.Fatal("Expected failure registering an already registered migration")
+	}
+	if err := RegisterMigration(schemaKind, 3, noop); err == nil {
+		t.Fatal("Expected failure registering a migration out of order")
+	}
+	if err := RegisterMigration("", 0, noop); err == nil {
+		t.Fatal("Expected failure registering a migration without kind")
+	}
+	if v := SchemaVersion(schemaKind); v != 2 {
+		t.Fatalf("Unexpected schema version %d after failed registrations", v)
+	}
+}
+
+func TestSchemaMigration(t *testing.T) {
+	ds := NewTestDataStore()
+	kvs := ds.KVStore()
+
+	key := Key(schemaKind, "old")
+	if err := kvs.Put(key, []byte(`{"id":"old","name":"net1"}`), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	o := &schemaObject{}
+	if err := ds.GetObject(key, o); err != nil {
+		t.Fatal(err)
+	}
+	if o.ID != "old" || o.Name != "net1" || o.Driver != "bridge" {
+		t.Fatalf("Unexpected migrated object: %+v", o)
+	}
+	if v := storedVersion(t, ds, key); v != 0 {
+		t.Fatalf("Reading the object must not rewrite it, got version %d", v)
+	}
+
+	// Objects are stored at the current version
+	if err := ds.PutObjectAtomic(o); err != nil {
+		t.Fatal(err)
+	}
+	if v := storedVersion(t, ds, key); v != 2 {
+		t.Fatalf("Unexpected stored version %d", v)
+	}
+	n := &schemaObject{ID: "new", Name: "net2", Driver: "overlay"}
+	if err := ds.PutObject(n); err != nil {
+		t.Fatal(err)
+	}
+	if v := storedVersion(t, ds, Key(n.Key()...)); v != 2 {
+		t.Fatalf("Unexpected stored version %d", v)
+	}
+
+	o = &schemaObject{}
+	if err := ds.GetObject(Key(n.Key()...), o); err != nil {
+		t.Fatal(err)
+	}
+	if o.Name != "net2" || o.Driver != "overlay" {
+		t.Fatalf("Current version object must not be migrated: %+v", o)
+	}
+
+	list, err := ds.List(Key(schemaKind), &schemaObject{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("Expected 2 objects, got %d", len(list))
+	}
+	for _, lo := range list {
+		if lo.(*schemaObject).Driver == "" {
+			t.Fatalf("Unexpected listed object: %+v", lo)
+		}
+	}
+
+	// A record of a newer version can't be read
+	future := Key(schemaKind, "future")
+	if err := kvs.Put(future, []byte(`{"id":"future","_schema":3}`), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.GetObject(future, &schemaObject{}); err == nil {
+		t.Fatal("Expected failure reading an object of a newer schema version")
+	}
+}
+
+func TestSchemaUpgrade(t *testing.T) {
+	ds := NewTestDataStore()
+	kvs := ds.KVStore()
+
+	records := map[string]string{
+		Key(schemaKind, "a"):        `{"id":"a","name":"net1"}`,
+		Key(schemaKind, "b"):        `{"id":"b","Name":"net2","_schema":1}`,
+		Key(schemaKind, "c"):        `{"id":"c","Name":"net3","Driver":"macvlan","_schema":2}`,
+		Key(schemaKind, "nested/d"): `{"id":"d","name":"net4"}`,
+		Key(dummyKey, "e"):          `{"name":"other"}`,
+	}
+	for k, v := range records {
+		if err := kvs.Put(k, []byte(v), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{Key(schemaKind, "a"), Key(schemaKind, "b"), Key(schemaKind, "nested/d")}
+
+	keys, err := ds.Upgrade(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	if len(keys) != len(expected) {
+		t.Fatalf("Unexpected dry run report: %v", keys)
+	}
+	for i := range keys {
+		if keys[i] != expected[i] {
+			t.Fatalf("Unexpected dry run report: %v", keys)
+		}
+	}
+	for k, v := range records {
+		kvPair, err := kvs.Get(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(kvPair.Value) != v {
+			t.Fatalf("Dry run modified record %s: %s", k, kvPair.Value)
+		}
+	}
+
+	keys, err = ds.Upgrade(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != len(expected) {
+		t.Fatalf("Unexpected upgrade report: %v", keys)
+	}
+	for _, k := range expected {
+		if v := storedVersion(t, ds, k); v != 2 {
+			t.Fatalf("Record %s at version %d after upgrade", k, v)
+		}
+	}
+	kvPair, err := kvs.Get(Key(dummyKey, "e"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(kvPair.Value) != records[Key(dummyKey, "e")] {
+		t.Fatalf("Record of a kind without migrations was modified: %s", kvPair.Value)
+	}
+
+	o := &schemaObject{}
+	if err := ds.GetObject(Key(schemaKind, "b"), o); err != nil {
+		t.Fatal(err)
+	}
+	if o.Name != "net2" || o.Driver != "bridge" {
+		t.Fatalf("Unexpected upgraded object: %+v", o)
+	}
+
+	if keys, err = ds.Upgrade(false); err != nil || len(keys) != 0 {
+		t.Fatalf("Expected nothing to upgrade, got %v (%v)", keys, err)
+	}
+}
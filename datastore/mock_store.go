@@ -2,6 +2,8 @@ package datastore
 
 import (
 	"errors"
+	"strings"
+	"sync"
 
 	"github.com/docker/libkv/store"
 	"github.com/docker/libnetwork/types"
@@ -20,68 +22,193 @@ type MockData struct {
 
 // MockStore exported
 type MockStore struct {
-	db map[string]*MockData
+	db      map[string]*MockData
+	index   uint64
+	watches map[*mockWatch]bool
+	sync.Mutex
+}
+
+// mockWatch is a watch on a key or, if tree is true, on a directory
+type mockWatch struct {
+	key  string
+	tree bool
+	ch   chan []*store.KVPair
 }
 
 // NewMockStore creates a Map backed Datastore that is useful for mocking
 func NewMockStore() *MockStore {
 	db := make(map[string]*MockData)
-	return &MockStore{db}
+	return &MockStore{db: db, watches: make(map[*mockWatch]bool)}
 }
 
 // Get the value at "key", returns the last modified index
 // to use in conjunction to CAS calls
 func (s *MockStore) Get(key string) (*store.KVPair, error) {
+	s.Lock()
+	defer s.Unlock()
+
 	mData := s.db[key]
 	if mData == nil {
 		return nil, nil
 	}
-	return &store.KVPair{Value: mData.Data, LastIndex: mData.Index}, nil
+	return &store.KVPair{Key: key, Value: mData.Data, LastIndex: mData.Index}, nil
 
 }
 
 // Put a value at "key"
 func (s *MockStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.put(key, value)
+	return nil
+}
+
+func (s *MockStore) put(key string, value []byte) {
 	mData := s.db[key]
 	if mData == nil {
 		mData = &MockData{value, 0}
 	}
-	mData.Index = mData.Index + 1
+	s.index++
+	mData.Data = value
+	mData.Index = s.index
 	s.db[key] = mData
-	return nil
+	s.notify(key)
 }
 
 // Delete a value at "key"
 func (s *MockStore) Delete(key string) error {
+	s.Lock()
+	defer s.Unlock()
+
 	delete(s.db, key)
+	s.notify(key)
 	return nil
 }
 
 // Exists checks that the key exists inside the store
 func (s *MockStore) Exists(key string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
 	_, ok := s.db[key]
 	return ok, nil
 }
 
 // List gets a range of values at "directory"
 func (s *MockStore) List(prefix string) ([]*store.KVPair, error) {
-	return nil, ErrNotImplmented
+	s.Lock()
+	defer s.Unlock()
+
+	kvList := s.list(prefix)
+	if len(kvList) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	return kvList, nil
+}
+
+func (s *MockStore) list(prefix string) []*store.KVPair {
+	var kvList []*store.KVPair
+	for key, mData := range s.db {
+		if key != prefix && strings.HasPrefix(key, prefix) {
+			kvList = append(kvList, &store.KVPair{Key: key, Value: mData.Data, LastIndex: mData.Index})
+		}
+	}
+	return kvList
 }
 
 // DeleteTree deletes a range of values at "directory"
 func (s *MockStore) DeleteTree(prefix string) error {
+	s.Lock()
+	defer s.Unlock()
+
 	delete(s.db, prefix)
+	s.notify(prefix)
 	return nil
 }
 
 // Watch a single key for modifications
 func (s *MockStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
-	return nil, ErrNotImplmented
+	w := s.addWatch(key, false, stopCh)
+	kvCh := make(chan *store.KVPair)
+	go func() {
+		defer close(kvCh)
+		for kvList := range w.ch {
+			if len(kvList) == 0 {
+				continue
+			}
+			select {
+			case kvCh <- kvList[0]:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return kvCh, nil
 }
 
 // WatchTree triggers a watch on a range of values at "directory"
 func (s *MockStore) WatchTree(prefix string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
-	return nil, ErrNotImplmented
+	return s.addWatch(prefix, true, stopCh).ch, nil
+}
+
+// addWatch registers a watch, which first gets the current value of the key
+// or directory, then its new ones as they change
+func (s *MockStore) addWatch(key string, tree bool, stopCh <-chan struct{}) *mockWatch {
+	w := &mockWatch{key: key, tree: tree, ch: make(chan []*store.KVPair, 64)}
+
+	s.Lock()
+	s.watches[w] = true
+	s.send(w)
+	s.Unlock()
+
+	go func() {
+		<-stopCh
+		s.Lock()
+		if s.watches[w] {
+			delete(s.watches, w)
+			close(w.ch)
+		}
+		s.Unlock()
+	}()
+
+	return w
+}
+
+// send queues the current value of the watched key or directory
+func (s *MockStore) send(w *mockWatch) {
+	var kvList []*store.KVPair
+	if w.tree {
+		kvList = s.list(w.key)
+	} else if mData, ok := s.db[w.key]; ok {
+		kvList = []*store.KVPair{{Key: w.key, Value: mData.Data, LastIndex: mData.Index}}
+	} else {
+		return
+	}
+	select {
+	case w.ch <- kvList:
+	default:
+	}
+}
+
+func (s *MockStore) notify(key string) {
+	for w := range s.watches {
+		if key == w.key || (w.tree && strings.HasPrefix(key, w.key)) {
+			s.send(w)
+		}
+	}
+}
+
+// DropWatches closes all the watches, as libkv does when the connection to
+// the backend is lost
+func (s *MockStore) DropWatches() {
+	s.Lock()
+	defer s.Unlock()
+
+	for w := range s.watches {
+		delete(s.watches, w)
+		close(w.ch)
+	}
 }
 
 // NewLock exposed
@@ -92,6 +219,9 @@ func (s *MockStore) NewLock(key string, options *store.LockOptions) (store.Locke
 // AtomicPut put a value at "key" if the key has not been
 // modified in the meantime, throws an error if this is the case
 func (s *MockStore) AtomicPut(key string, newValue []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	s.Lock()
+	defer s.Unlock()
+
 	mData := s.db[key]
 
 	if previous == nil {
@@ -106,21 +236,23 @@ func (s *MockStore) AtomicPut(key string, newValue []byte, previous *store.KVPai
 			return false, nil, types.BadRequestErrorf("atomic put failed due to mismatched Index")
 		} // Else OK.
 	}
-	err := s.Put(key, newValue, nil)
-	if err != nil {
-		return false, nil, err
-	}
+	s.put(key, newValue)
 	return true, &store.KVPair{Key: key, Value: newValue, LastIndex: s.db[key].Index}, nil
 }
 
 // AtomicDelete deletes a value at "key" if the key has not
 // been modified in the meantime, throws an error if this is the case
 func (s *MockStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
 	mData := s.db[key]
 	if mData != nil && mData.Index != previous.LastIndex {
 		return false, types.BadRequestErrorf("atomic delete failed due to mismatched Index")
 	}
-	return true, s.Delete(key)
+	delete(s.db, key)
+	s.notify(key)
+	return true, nil
 }
 
 // Close closes the client connection
@@ -0,0 +1,403 @@
+package datastore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/types"
+)
+
+// TxnKeyPrefix is the prefix of the intent records of the transactions in
+// progress on the backends without native transactions
+const TxnKeyPrefix = "txn"
+
+// Txn groups puts and deletes of objects to be committed atomically. As with
+// PutObjectAtomic and DeleteObjectAtomic, each operation is conditioned on the
+// index of the object: if any of the objects was modified in the meantime the
+// commit fails with ErrKeyModified and none of the operations is applied.
+type Txn struct {
+	ds  *datastore
+	ops []*txnOp
+}
+
+// nativeTxnStore is implemented by the stores committing transactions
+// natively
+type nativeTxnStore interface {
+	atomicTxn(ops []*intentOp) ([]*store.KVPair, error)
+}
+
+// nativeTxn returns the store as a native transaction one, if it is
+func nativeTxn(kvs store.Store) (nativeTxnStore, bool) {
+	if es, ok := kvs.(*encryptedStore); ok {
+		if _, ok := es.Store.(nativeTxnStore); !ok {
+			return nil, false
+		}
+		return es, true
+	}
+	ns, ok := kvs.(nativeTxnStore)
+	return ns, ok
+}
+
+type txnOp struct {
+	kvObject KVObject
+	delete   bool
+	// Raw record operations have no object
+	key      []string
+	value    []byte
+	previous *store.KVPair
+}
+
+// intentOp is an operation of a transaction as recorded in its intent. It
+// holds both the states of the key before and after the operation, so that
+// the transaction can be replayed or rolled back.
+type intentOp struct {
+	Key    string `json:"key"`
+	Value  []byte `json:"value,omitempty"`
+	Delete bool   `json:"delete,omitempty"`
+	Exists bool   `json:"exists,omitempty"`
+	Index  uint64 `json:"index,omitempty"`
+	Prev   []byte `json:"prev,omitempty"`
+}
+
+// NewTxn returns a new transaction on the datastore
+func (ds *datastore) NewTxn() *Txn {
+	return &Txn{ds: ds}
+}
+
+// Put adds to the transaction the atomic put of the object
+func (t *Txn) Put(kvObject KVObject) {
+	t.ops = append(t.ops, &txnOp{kvObject: kvObject})
+}
+
+// Delete adds to the transaction the atomic delete of the object
+func (t *Txn) Delete(kvObject KVObject) {
+	t.ops = append(t.ops, &txnOp{kvObject: kvObject, delete: true})
+}
+
+// PutRecord adds to the transaction the atomic put of the raw value at key.
+// The record must be at the index of previous or, if nil, not exist. The
+// value is stored as it is, and the records put this way are not cached.
+func (t *Txn) PutRecord(key []string, value []byte, previous *store.KVPair) {
+	t.ops = append(t.ops, &txnOp{key: key, value: value, previous: previous})
+}
+
+// Commit applies all the operations of the transaction, or none of them
+func (t *Txn) Commit() error {
+	ds := t.ds
+	if ds.sequential {
+		ds.Lock()
+		defer ds.Unlock()
+	}
+
+	var (
+		ops     []*intentOp
+		objects []KVObject
+		raw     bool
+	)
+	for _, op := range t.ops {
+		if op.key != nil {
+			if len(op.key) == 0 || len(op.value) == 0 {
+				return types.BadRequestErrorf("invalid raw record for key %v", op.key)
+			}
+			iop := &intentOp{Key: Key(op.key...), Value: op.value}
+			if op.previous != nil {
+				iop.Exists = true
+				iop.Index = op.previous.LastIndex
+			}
+			ops = append(ops, iop)
+			objects = append(objects, nil)
+			raw = true
+			continue
+		}
+		if op.kvObject == nil {
+			return types.BadRequestErrorf("invalid KV Object : nil")
+		}
+		if op.kvObject.Skip() {
+			// Objects not persisted are only sequenced in the cache
+			if ds.cache != nil {
+				if err := ds.cache.check(op.kvObject); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		iop := &intentOp{Key: Key(op.kvObject.Key()...), Delete: op.delete}
+		if op.delete || op.kvObject.Exists() {
+			iop.Exists = true
+			iop.Index = op.kvObject.Index()
+		}
+		if !op.delete {
+			value := op.kvObject.Value()
+			if value == nil {
+				return types.BadRequestErrorf("invalid KV Object with a nil Value for key %s", iop.Key)
+			}
+			var err error
+			if iop.Value, err = stampValue(kindOf(op.kvObject.Key()), value); err != nil {
+				return err
+			}
+		}
+		ops = append(ops, iop)
+		objects = append(objects, op.kvObject)
+	}
+
+	if len(ops) > 0 {
+		var (
+			pairs []*store.KVPair
+			err   error
+		)
+		if ns, ok := nativeTxn(ds.store); ok {
+			pairs, err = commitNative(ns, ops)
+		} else {
+			pairs, err = ds.commitIntent(ops)
+		}
+		if err != nil {
+			return err
+		}
+		for i, pair := range pairs {
+			if pair != nil && objects[i] != nil {
+				objects[i].SetIndex(pair.LastIndex)
+			}
+		}
+	}
+
+	if ds.cache == nil {
+		return nil
+	}
+	if raw {
+		// The cached objects may be missing the raw records
+		ds.resetCache()
+	}
+	for _, op := range t.ops {
+		if op.kvObject == nil {
+			continue
+		}
+		var err error
+		if op.delete {
+			err = ds.cache.del(op.kvObject, op.kvObject.Skip())
+		} else {
+			err = ds.cache.add(op.kvObject, op.kvObject.Skip())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func previousPair(op *intentOp) *store.KVPair {
+	if !op.Exists {
+		return nil
+	}
+	return &store.KVPair{Key: op.Key, LastIndex: op.Index}
+}
+
+func isConflict(err error) bool {
+	return err == store.ErrKeyModified || err == store.ErrKeyExists || err == store.ErrKeyNotFound
+}
+
+// commitNative commits the operations in a native transaction
+func commitNative(ns nativeTxnStore, ops []*intentOp) ([]*store.KVPair, error) {
+	pairs, err := ns.atomicTxn(ops)
+	if err != nil {
+		if isConflict(err) {
+			return nil, ErrKeyModified
+		}
+		return nil, err
+	}
+	return pairs, nil
+}
+
+// commitIntent emulates a transaction on the backends without native ones.
+// The operations are recorded in an intent record before being applied one
+// by one, should the commit be interrupted RecoverTxns completes or undoes
+// them on the next startup.
+func (ds *datastore) commitIntent(ops []*intentOp) ([]*store.KVPair, error) {
+	// Check the indexes upfront and save the current values for rollback
+	for _, op := range ops {
+		kvPair, err := ds.store.Get(op.Key)
+		if err != nil && err != store.ErrKeyNotFound {
+			return nil, err
+		}
+		exists := err == nil && kvPair != nil
+		if exists != op.Exists || (exists && kvPair.LastIndex != op.Index) {
+			return nil, ErrKeyModified
+		}
+		if exists {
+			op.Prev = kvPair.Value
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the intent owner: %v", err)
+	}
+	intentKey := Key(TxnKeyPrefix, hostname, stringid.GenerateRandomID())
+	intent, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := ds.store.AtomicPut(intentKey, intent, nil, nil); err != nil {
+		return nil, fmt.Errorf("failed to record the transaction intent: %v", err)
+	}
+
+	pairs := make([]*store.KVPair, len(ops))
+	for i, op := range ops {
+		if op.Delete {
+			_, err = ds.store.AtomicDelete(op.Key, previousPair(op))
+		} else {
+			_, pairs[i], err = ds.store.AtomicPut(op.Key, op.Value, previousPair(op), nil)
+		}
+		if err != nil {
+			rollbackOps(ds.store, ops[:i], pairs[:i])
+			if e := ds.store.Delete(intentKey); e != nil {
+				log.Printf("Could not delete the transaction intent %s: %v", intentKey, e)
+			}
+			if isConflict(err) {
+				return nil, ErrKeyModified
+			}
+			return nil, err
+		}
+	}
+
+	if err := ds.store.Delete(intentKey); err != nil {
+		log.Printf("Could not delete the transaction intent %s: %v", intentKey, err)
+	}
+
+	return pairs, nil
+}
+
+// rollbackOps restores the keys of the applied operations to their state
+// before the transaction, given their current pairs
+func rollbackOps(kvs store.Store, ops []*intentOp, pairs []*store.KVPair) {
+	for i, op := range ops {
+		var err error
+		switch {
+		case op.Delete:
+			_, _, err = kvs.AtomicPut(op.Key, op.Prev, nil, nil)
+		case !op.Exists:
+			_, err = kvs.AtomicDelete(op.Key, pairs[i])
+		default:
+			_, _, err = kvs.AtomicPut(op.Key, op.Prev, pairs[i], nil)
+		}
+		if err != nil {
+			log.Printf("Could not roll back the transaction operation on %s: %v", op.Key, err)
+		}
+	}
+}
+
+// RecoverTxns completes or undoes the transactions of this host which were
+// interrupted while being committed. A transaction is replayed if none of
+// its keys were modified by others since, otherwise it is rolled back.
+func (ds *datastore) RecoverTxns() error {
+	if ds.sequential {
+		ds.Lock()
+		defer ds.Unlock()
+	}
+
+	if _, ok := nativeTxn(ds.store); ok {
+		return nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("failed to get the intent owner: %v", err)
+	}
+	kvList, err := ds.store.List(Key(TxnKeyPrefix, hostname))
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return nil
+		}
+		return err
+	}
+
+	for _, kvPair := range kvList {
+		if len(kvPair.Value) == 0 {
+			continue
+		}
+		var ops []*intentOp
+		if err := json.Unmarshal(kvPair.Value, &ops); err != nil {
+			log.Printf("Could not decode the transaction intent %s: %v", kvPair.Key, err)
+			continue
+		}
+		if err := ds.recoverIntent(ops); err != nil {
+			log.Printf("Could not recover the transaction intent %s: %v", kvPair.Key, err)
+			continue
+		}
+		if err := ds.store.Delete(kvPair.Key); err != nil {
+			log.Printf("Could not delete the transaction intent %s: %v", kvPair.Key, err)
+		}
+	}
+
+	// The cached objects may not reflect the recovered state
+	ds.resetCache()
+
+	return nil
+}
+
+// recoverIntent replays or rolls back the operations of an intent
+func (ds *datastore) recoverIntent(ops []*intentOp) error {
+	var (
+		pairs   = make([]*store.KVPair, len(ops))
+		applied = make([]bool, len(ops))
+		replay  = true
+	)
+	for i, op := range ops {
+		kvPair, err := ds.store.Get(op.Key)
+		if err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+		exists := err == nil && kvPair != nil
+		pairs[i] = kvPair
+
+		switch {
+		case exists == op.Exists && (!exists || kvPair.LastIndex == op.Index):
+			// Not applied yet
+		case op.Delete && !exists:
+			applied[i] = true
+		case !op.Delete && exists && bytes.Equal(kvPair.Value, op.Value):
+			applied[i] = true
+		default:
+			// Modified by others
+			replay = false
+		}
+	}
+
+	if !replay {
+		var (
+			rops   []*intentOp
+			rpairs []*store.KVPair
+		)
+		for i, op := range ops {
+			if applied[i] {
+				rops = append(rops, op)
+				rpairs = append(rpairs, pairs[i])
+			}
+		}
+		rollbackOps(ds.store, rops, rpairs)
+		return nil
+	}
+
+	for i, op := range ops {
+		if applied[i] {
+			continue
+		}
+		var err error
+		if op.Delete {
+			_, err = ds.store.AtomicDelete(op.Key, previousPair(op))
+		} else {
+			_, _, err = ds.store.AtomicPut(op.Key, op.Value, previousPair(op), nil)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to replay the operation on %s: %v", op.Key, err)
+		}
+	}
+
+	return nil
+}
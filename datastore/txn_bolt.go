@@ -0,0 +1,109 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/docker/libkv/store"
+)
+
+const (
+	// boltIndexLen is the length of the index libkv prefixes the bolt
+	// values with
+	boltIndexLen = 8
+	boltFilePerm = os.FileMode(0644)
+	boltTimeout  = 10 * time.Second
+	// boltTxnIndexBase is the start of the indexes handed out by the
+	// transactions, far from the ones of the libkv in memory counter
+	boltTxnIndexBase = uint64(1) << 62
+)
+
+// boltStore commits the transactions on a boltdb store natively. The
+// libkv store opens the bolt file for each operation, the transactions
+// open it the same way so that they are serialized with the other
+// operations by the bolt file lock.
+type boltStore struct {
+	store.Store
+	path   string
+	bucket []byte
+	// index is the last index handed out by the transactions. libkv
+	// keeps its own counter in memory, the transactions use a separate
+	// range and go past the current index of the keys they put so that
+	// an index is not reused for a key.
+	index uint64
+	sync.Mutex
+}
+
+func newBoltStore(kvs store.Store, path string, bucket string) *boltStore {
+	return &boltStore{Store: kvs, path: path, bucket: []byte(bucket)}
+}
+
+// atomicTxn applies the operations in a single bolt transaction. If any of
+// the keys is not at the index of its operation, none of the operations is
+// applied. It returns the pairs of the put keys, and nil for the deleted
+// ones.
+func (bs *boltStore) atomicTxn(ops []*intentOp) ([]*store.KVPair, error) {
+	bs.Lock()
+	defer bs.Unlock()
+
+	db, err := bolt.Open(bs.path, boltFilePerm, &bolt.Options{Timeout: boltTimeout})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	pairs := make([]*store.KVPair, len(ops))
+	index := bs.index
+	if index < boltTxnIndexBase {
+		index = boltTxnIndexBase
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bs.bucket)
+		if err != nil {
+			return err
+		}
+
+		for i, op := range ops {
+			val := bucket.Get([]byte(op.Key))
+			exists := len(val) >= boltIndexLen
+			if exists != op.Exists {
+				return ErrKeyModified
+			}
+			if exists {
+				current := binary.LittleEndian.Uint64(val[:boltIndexLen])
+				if current != op.Index {
+					return ErrKeyModified
+				}
+				if current > index {
+					index = current
+				}
+			}
+
+			if op.Delete {
+				if err := bucket.Delete([]byte(op.Key)); err != nil {
+					return err
+				}
+				continue
+			}
+
+			index++
+			dbval := make([]byte, boltIndexLen, boltIndexLen+len(op.Value))
+			binary.LittleEndian.PutUint64(dbval, index)
+			dbval = append(dbval, op.Value...)
+			if err := bucket.Put([]byte(op.Key), dbval); err != nil {
+				return err
+			}
+			pairs[i] = &store.KVPair{Key: op.Key, Value: op.Value, LastIndex: index}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bs.index = index
+	return pairs, nil
+}
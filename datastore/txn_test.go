@@ -0,0 +1,266 @@
+package datastore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/boltdb"
+)
+
+func newBoltTestStore(t *testing.T) (DataStore, func()) {
+	boltdb.Register()
+	dir, err := ioutil.TempDir("", "libnetwork-txn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := NewDataStore(LocalScope, &ScopeCfg{
+		Client: ScopeClientCfg{
+			Provider: "boltdb",
+			Address:  filepath.Join(dir, "local-kv.db"),
+			Config: &store.Config{
+				Bucket:            "libnetwork",
+				ConnectionTimeout: 3 * time.Second,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ds, func() {
+		ds.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func testTxn(t *testing.T, ds DataStore) {
+	a := &schemaObject{ID: "a", Name: "net1"}
+	b := &schemaObject{ID: "b", Name: "net2"}
+
+	txn := ds.NewTxn()
+	txn.Put(a)
+	txn.Put(b)
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Exists() || !b.Exists() {
+		t.Fatal("Committed objects must exist")
+	}
+	for _, o := range []*schemaObject{a, b} {
+		g := &schemaObject{ID: o.ID}
+		if err := ds.GetObject(Key(o.Key()...), g); err != nil {
+			t.Fatal(err)
+		}
+		if g.Name != o.Name || g.Index() != o.Index() {
+			t.Fatalf("Unexpected stored object %+v, expected %+v", g, o)
+		}
+	}
+
+	// A stale object fails the whole transaction
+	stale := &schemaObject{ID: "a", Name: "stale", dbIndex: a.Index(), dbExists: true}
+	a.Name = "net1-updated"
+	if err := ds.PutObjectAtomic(a); err != nil {
+		t.Fatal(err)
+	}
+	c := &schemaObject{ID: "c", Name: "net3"}
+	txn = ds.NewTxn()
+	txn.Put(c)
+	txn.Delete(b)
+	txn.Put(stale)
+	if err := txn.Commit(); err != ErrKeyModified {
+		t.Fatalf("Expected ErrKeyModified, got %v", err)
+	}
+	if storedValue(t, ds.KVStore(), Key(c.Key()...)) != nil {
+		t.Fatal("Object of a failed transaction must not be stored")
+	}
+	g := &schemaObject{ID: "b"}
+	if err := ds.GetObject(Key(b.Key()...), g); err != nil {
+		t.Fatalf("Object deleted by a failed transaction: %v", err)
+	}
+	g = &schemaObject{ID: "a"}
+	if err := ds.GetObject(Key(a.Key()...), g); err != nil {
+		t.Fatal(err)
+	}
+	if g.Name != "net1-updated" {
+		t.Fatalf("Object modified by a failed transaction: %+v", g)
+	}
+
+	// Deletes and updates
+	a.Name = "net1-txn"
+	txn = ds.NewTxn()
+	txn.Put(a)
+	txn.Delete(b)
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if storedValue(t, ds.KVStore(), Key(b.Key()...)) != nil {
+		t.Fatal("Object deleted by the transaction is still stored")
+	}
+	g = &schemaObject{ID: "a"}
+	if err := ds.GetObject(Key(a.Key()...), g); err != nil {
+		t.Fatal(err)
+	}
+	if g.Name != "net1-txn" {
+		t.Fatalf("Unexpected updated object: %+v", g)
+	}
+}
+
+func TestTxnBolt(t *testing.T) {
+	ds, cleanup := newBoltTestStore(t)
+	defer cleanup()
+
+	if _, ok := nativeTxn(ds.KVStore()); !ok {
+		t.Fatal("Expected native transactions on boltdb")
+	}
+	testTxn(t, ds)
+}
+
+func TestTxnIntent(t *testing.T) {
+	ds := NewTestDataStore()
+
+	testTxn(t, ds)
+
+	// No intent is left behind
+	if kvList, err := ds.KVStore().List(Key(TxnKeyPrefix)); err != store.ErrKeyNotFound {
+		t.Fatalf("Unexpected intent records %v (%v)", kvList, err)
+	}
+}
+
+func TestTxnPutRecord(t *testing.T) {
+	testTxnPutRecord(t, NewTestDataStore())
+
+	ds, cleanup := newBoltTestStore(t)
+	defer cleanup()
+	testTxnPutRecord(t, ds)
+}
+
+func testTxnPutRecord(t *testing.T, ds DataStore) {
+	a := &schemaObject{ID: "a", Name: "net1"}
+	if err := ds.PutObjectAtomic(a); err != nil {
+		t.Fatal(err)
+	}
+	// Load the objects in the cache
+	if _, err := ds.List(Key(schemaKind), &schemaObject{}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &schemaObject{ID: "b", Name: "net2"}
+	txn := ds.NewTxn()
+	txn.PutRecord(b.Key(), b.Value(), nil)
+	txn.PutRecord([]string{"txn-test", "raw"}, []byte("raw"), nil)
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The committed records are listed past the cache
+	kvol, err := ds.List(Key(schemaKind), &schemaObject{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvol) != 2 {
+		t.Fatalf("Expected 2 objects, got %d", len(kvol))
+	}
+	if p := storedValue(t, ds.KVStore(), Key("txn-test", "raw")); p == nil || string(p.Value) != "raw" {
+		t.Fatalf("Unexpected raw record %v", p)
+	}
+
+	// Putting an existing record without its index fails as a whole
+	txn = ds.NewTxn()
+	txn.PutRecord([]string{"txn-test", "other"}, []byte("other"), nil)
+	txn.PutRecord(a.Key(), a.Value(), nil)
+	if err := txn.Commit(); err != ErrKeyModified {
+		t.Fatalf("Expected ErrKeyModified, got %v", err)
+	}
+	if p := storedValue(t, ds.KVStore(), Key("txn-test", "other")); p != nil {
+		t.Fatal("Record of the failed transaction was stored")
+	}
+}
+
+func putIntent(t *testing.T, ds DataStore, id string, ops []*intentOp) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := Key(TxnKeyPrefix, hostname, id)
+	if err := ds.KVStore().Put(key, b, nil); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func storedValue(t *testing.T, kvs store.Store, key string) *store.KVPair {
+	kvPair, err := kvs.Get(key)
+	if err != nil && err != store.ErrKeyNotFound {
+		t.Fatal(err)
+	}
+	return kvPair
+}
+
+func TestTxnRecover(t *testing.T) {
+	ds := NewTestDataStore()
+	kvs := ds.KVStore()
+
+	xKey, yKey, zKey := Key("txn-test", "x"), Key("txn-test", "y"), Key("txn-test", "z")
+	if err := kvs.Put(zKey, []byte("z0"), nil); err != nil {
+		t.Fatal(err)
+	}
+	z := storedValue(t, kvs, zKey)
+
+	// Interrupted after the first operation: x is created, y and the
+	// delete of z are replayed
+	if err := kvs.Put(xKey, []byte("x1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	intent := putIntent(t, ds, "1", []*intentOp{
+		{Key: xKey, Value: []byte("x1")},
+		{Key: yKey, Value: []byte("y1")},
+		{Key: zKey, Delete: true, Exists: true, Index: z.LastIndex, Prev: []byte("z0")},
+	})
+	if err := ds.RecoverTxns(); err != nil {
+		t.Fatal(err)
+	}
+	if p := storedValue(t, kvs, yKey); p == nil || string(p.Value) != "y1" {
+		t.Fatalf("Operation on %s not replayed: %v", yKey, p)
+	}
+	if p := storedValue(t, kvs, zKey); p != nil {
+		t.Fatalf("Operation on %s not replayed: %v", zKey, p)
+	}
+	if p := storedValue(t, kvs, intent); p != nil {
+		t.Fatal("Recovered intent not deleted")
+	}
+
+	// Interrupted after the first operation, then the second key got
+	// modified by others: the first operation is rolled back
+	x := storedValue(t, kvs, xKey)
+	if err := kvs.Put(xKey, []byte("x2"), nil); err != nil {
+		t.Fatal(err)
+	}
+	y := storedValue(t, kvs, yKey)
+	intent = putIntent(t, ds, "2", []*intentOp{
+		{Key: xKey, Value: []byte("x2"), Exists: true, Index: x.LastIndex, Prev: []byte("x1")},
+		{Key: yKey, Value: []byte("y2"), Exists: true, Index: y.LastIndex, Prev: []byte("y1")},
+	})
+	if err := kvs.Put(yKey, []byte("y-other"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.RecoverTxns(); err != nil {
+		t.Fatal(err)
+	}
+	if p := storedValue(t, kvs, xKey); p == nil || string(p.Value) != "x1" {
+		t.Fatalf("Operation on %s not rolled back: %v", xKey, p)
+	}
+	if p := storedValue(t, kvs, yKey); p == nil || string(p.Value) != "y-other" {
+		t.Fatalf("Key %s modified by others was overwritten: %v", yKey, p)
+	}
+	if p := storedValue(t, kvs, intent); p != nil {
+		t.Fatal("Recovered intent not deleted")
+	}
+}
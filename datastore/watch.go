@@ -0,0 +1,346 @@
+package datastore
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libkv/store"
+)
+
+// WatchEventType is the type of change reported by a watch event
+type WatchEventType int
+
+const (
+	// WatchCreate reports the creation of an object
+	WatchCreate WatchEventType = iota
+	// WatchUpdate reports the update of an object
+	WatchUpdate
+	// WatchDelete reports the deletion of an object
+	WatchDelete
+)
+
+func (t WatchEventType) String() string {
+	switch t {
+	case WatchCreate:
+		return "create"
+	case WatchUpdate:
+		return "update"
+	case WatchDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("unknown(%d)", int(t))
+}
+
+// WatchEvent is a change of a watched object. The object of a delete event
+// is a new object, not loaded from the store.
+type WatchEvent struct {
+	Type   WatchEventType
+	Key    string
+	Object KVObject
+}
+
+// WatchHealth reports the state of a resumable watch
+type WatchHealth struct {
+	// Active is true while the watch is established with the backend
+	Active bool
+	// LastIndex is the highest store index seen by the watch
+	LastIndex uint64
+	// Restarts is the number of times the watch was re-established
+	Restarts int
+	// Missed is the number of changes recovered when resuming the watch
+	Missed int
+	// LastError is the last error met by the watch, if any
+	LastError string
+	// Since is the time the watch got in its current active state
+	Since time.Time
+}
+
+// Watcher is a watch which survives the disconnections from the backend.
+// It tracks the index of the watched objects and, on restart, diffs them
+// against the store to emit the changes missed while disconnected.
+type Watcher struct {
+	ds      *datastore
+	ctor    KVConstructor
+	kind    string
+	key     string
+	tree    bool
+	eventCh chan WatchEvent
+	stopCh  <-chan struct{}
+	seen    map[string]uint64
+	health  WatchHealth
+	sync.Mutex
+}
+
+// ResumableWatch watches for changes of the object or, if tree is true, of
+// the objects under its key prefix, until stopCh is closed. Unlike Watch,
+// the watch resumes after RestartWatch without missing any change.
+func (ds *datastore) ResumableWatch(kvObject KVObject, tree bool, stopCh <-chan struct{}) (*Watcher, error) {
+	ctor, ok := kvObject.(KVConstructor)
+	if !ok {
+		return nil, fmt.Errorf("error watching object type %T, object does not implement KVConstructor interface", kvObject)
+	}
+
+	w := &Watcher{
+		ds:      ds,
+		ctor:    ctor,
+		kind:    kindOf(kvObject.Key()),
+		key:     Key(kvObject.Key()...),
+		tree:    tree,
+		eventCh: make(chan WatchEvent),
+		stopCh:  stopCh,
+		seen:    make(map[string]uint64),
+		health:  WatchHealth{Since: time.Now()},
+	}
+	if tree {
+		w.kind = kindOf(kvObject.KeyPrefix())
+		w.key = Key(kvObject.KeyPrefix()...)
+	}
+
+	sCh, kvpCh, err := w.start()
+	if err != nil {
+		return nil, err
+	}
+	go w.run(sCh, kvpCh)
+
+	return w, nil
+}
+
+// Events returns the channel of the changes of the watched objects
+func (w *Watcher) Events() <-chan WatchEvent {
+	return w.eventCh
+}
+
+// Health returns the state of the watch
+func (w *Watcher) Health() WatchHealth {
+	w.Lock()
+	defer w.Unlock()
+	return w.health
+}
+
+func (w *Watcher) setActive(active bool, err error) {
+	w.Lock()
+	defer w.Unlock()
+
+	if err != nil {
+		w.health.LastError = err.Error()
+	}
+	if w.health.Active == active {
+		return
+	}
+	w.health.Active = active
+	w.health.Since = time.Now()
+}
+
+// start establishes the watch with the backend. Single key watches are
+// converted to the tree form, as lists of pairs.
+func (w *Watcher) start() (chan struct{}, <-chan []*store.KVPair, error) {
+	sCh := make(chan struct{})
+	if w.tree {
+		kvpCh, err := w.ds.store.WatchTree(w.key, sCh)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sCh, kvpCh, nil
+	}
+
+	kvCh, err := w.ds.store.Watch(w.key, sCh)
+	if err != nil {
+		return nil, nil, err
+	}
+	kvpCh := make(chan []*store.KVPair)
+	go func() {
+		defer close(kvpCh)
+		for {
+			select {
+			case <-sCh:
+				return
+			case kvPair, ok := <-kvCh:
+				// A nil pair tells the backend watch is gone
+				if !ok || kvPair == nil {
+					return
+				}
+				select {
+				case kvpCh <- []*store.KVPair{kvPair}:
+				case <-sCh:
+					return
+				}
+			}
+		}
+	}()
+	return sCh, kvpCh, nil
+}
+
+func (w *Watcher) run(sCh chan struct{}, kvpCh <-chan []*store.KVPair) {
+	first := true
+	for {
+		if kvpCh != nil {
+			w.setActive(true, nil)
+			// Catch up with the changes missed while disconnected
+			missed, stopped := w.resync()
+			if stopped {
+				close(sCh)
+				return
+			}
+			if !first {
+				w.Lock()
+				w.health.Restarts++
+				w.health.Missed += missed
+				w.Unlock()
+				log.Printf("Resumed watch of %s, %d missed changes", w.key, missed)
+			}
+			first = false
+
+			if !w.consume(sCh, kvpCh) {
+				return
+			}
+
+			w.ds.Lock()
+			w.ds.active = false
+			w.ds.Unlock()
+			w.setActive(false, fmt.Errorf("watch of %s interrupted", w.key))
+		}
+
+		// Wait on watch channel for a re-trigger when datastore becomes active
+		w.ds.Lock()
+		watchCh := w.ds.watchCh
+		w.ds.Unlock()
+		select {
+		case <-w.stopCh:
+			return
+		case <-watchCh:
+		}
+
+		var err error
+		if sCh, kvpCh, err = w.start(); err != nil {
+			log.Printf("Could not watch the key %s in store: %v", w.key, err)
+			w.setActive(false, err)
+			kvpCh = nil
+		}
+	}
+}
+
+// consume processes the backend events until the watch is stopped, in which
+// case it returns false, or interrupted
+func (w *Watcher) consume(sCh chan struct{}, kvpCh <-chan []*store.KVPair) bool {
+	for {
+		select {
+		case <-w.stopCh:
+			close(sCh)
+			return false
+		case kvList, ok := <-kvpCh:
+			// If the backend KV store gets reset libkv's go routine
+			// for the watch can exit resulting in a closed channel.
+			if !ok {
+				return true
+			}
+			if _, stopped := w.diff(kvList, w.tree); stopped {
+				close(sCh)
+				return false
+			}
+		}
+	}
+}
+
+// resync diffs the watched objects against the store and returns the number
+// of changes emitted, along with whether the watch got stopped meanwhile
+func (w *Watcher) resync() (int, bool) {
+	var kvList []*store.KVPair
+	if w.tree {
+		l, err := w.ds.store.List(w.key)
+		if err != nil && err != store.ErrKeyNotFound {
+			w.setActive(true, fmt.Errorf("failed to list %s: %v", w.key, err))
+			return 0, false
+		}
+		kvList = l
+	} else {
+		kvPair, err := w.ds.store.Get(w.key)
+		if err != nil && err != store.ErrKeyNotFound {
+			w.setActive(true, fmt.Errorf("failed to get %s: %v", w.key, err))
+			return 0, false
+		}
+		if err == nil && kvPair != nil {
+			kvList = append(kvList, kvPair)
+		}
+	}
+
+	return w.diff(kvList, true)
+}
+
+// diff emits the changes between the seen objects and the passed pairs and
+// returns their number, along with whether the watch got stopped meanwhile.
+// If full is true, the pairs are the complete set of the watched objects
+// and the seen objects missing from it are reported deleted.
+func (w *Watcher) diff(kvList []*store.KVPair, full bool) (int, bool) {
+	var n int
+	present := make(map[string]bool, len(kvList))
+	for _, kvPair := range kvList {
+		if kvPair == nil || len(kvPair.Value) == 0 {
+			continue
+		}
+		key := strings.Trim(kvPair.Key, "/")
+		if !w.tree {
+			key = strings.Trim(w.key, "/")
+		}
+		present[key] = true
+
+		index, ok := w.seen[key]
+		if ok && index >= kvPair.LastIndex {
+			continue
+		}
+		et := WatchUpdate
+		if !ok {
+			et = WatchCreate
+		}
+
+		value, _, err := upgradeValue(w.kind, kvPair.Value)
+		if err != nil {
+			log.Printf("Could not upgrade kvpair value = %s: %v", string(kvPair.Value), err)
+			continue
+		}
+		o := w.ctor.New()
+		if err := o.SetValue(value); err != nil {
+			log.Printf("Could not unmarshal kvpair value = %s", string(kvPair.Value))
+			continue
+		}
+		o.SetIndex(kvPair.LastIndex)
+
+		w.seen[key] = kvPair.LastIndex
+		w.Lock()
+		if kvPair.LastIndex > w.health.LastIndex {
+			w.health.LastIndex = kvPair.LastIndex
+		}
+		w.Unlock()
+		if !w.emit(WatchEvent{Type: et, Key: key, Object: o}) {
+			return n, true
+		}
+		n++
+	}
+
+	if !full {
+		return n, false
+	}
+	for key := range w.seen {
+		if present[key] {
+			continue
+		}
+		delete(w.seen, key)
+		if !w.emit(WatchEvent{Type: WatchDelete, Key: key, Object: w.ctor.New()}) {
+			return n, true
+		}
+		n++
+	}
+
+	return n, false
+}
+
+func (w *Watcher) emit(e WatchEvent) bool {
+	select {
+	case w.eventCh <- e:
+		return true
+	case <-w.stopCh:
+		return false
+	}
+}
@@ -0,0 +1,170 @@
+package datastore
+
+import (
+	"testing"
+	"time"
+)
+
+func newWatchTestStore() (*datastore, *MockStore) {
+	ms := NewMockStore()
+	return &datastore{scope: GlobalScope, store: ms, active: true, watchCh: make(chan struct{})}, ms
+}
+
+func nextEvent(t *testing.T, w *Watcher) WatchEvent {
+	select {
+	case e := <-w.Events():
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a watch event")
+	}
+	return WatchEvent{}
+}
+
+func noEvent(t *testing.T, w *Watcher) {
+	select {
+	case e := <-w.Events():
+		t.Fatalf("Unexpected watch event %s on %s", e.Type, e.Key)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func waitInactive(t *testing.T, w *Watcher) {
+	for i := 0; i < 500; i++ {
+		if !w.Health().Active {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Watch still active after the backend dropped it")
+}
+
+func TestResumableWatch(t *testing.T) {
+	ds, ms := newWatchTestStore()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	o := &schemaObject{ID: "w", Name: "net1"}
+	if err := ds.PutObjectAtomic(o); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := ds.ResumableWatch(o, false, stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := nextEvent(t, w)
+	if e.Type != WatchCreate || e.Object.(*schemaObject).Name != "net1" {
+		t.Fatalf("Unexpected first event %s: %+v", e.Type, e.Object)
+	}
+	noEvent(t, w)
+
+	o.Name = "net2"
+	if err := ds.PutObjectAtomic(o); err != nil {
+		t.Fatal(err)
+	}
+	e = nextEvent(t, w)
+	if e.Type != WatchUpdate || e.Object.(*schemaObject).Name != "net2" || e.Object.Index() != o.Index() {
+		t.Fatalf("Unexpected update event %s: %+v", e.Type, e.Object)
+	}
+	if h := w.Health(); !h.Active || h.LastIndex != o.Index() {
+		t.Fatalf("Unexpected watch health %+v", h)
+	}
+
+	// Changes made while disconnected are emitted on restart
+	ms.DropWatches()
+	waitInactive(t, w)
+	if ds.Active() {
+		t.Fatal("Datastore still active after the backend dropped the watches")
+	}
+	o.Name = "net3"
+	if err := ds.PutObjectAtomic(o); err != nil {
+		t.Fatal(err)
+	}
+	noEvent(t, w)
+
+	ds.RestartWatch()
+	e = nextEvent(t, w)
+	if e.Type != WatchUpdate || e.Object.(*schemaObject).Name != "net3" {
+		t.Fatalf("Unexpected resumed event %s: %+v", e.Type, e.Object)
+	}
+	noEvent(t, w)
+	if h := w.Health(); !h.Active || h.Restarts != 1 || h.Missed != 1 {
+		t.Fatalf("Unexpected watch health %+v", h)
+	}
+
+	// A delete while disconnected is emitted on restart
+	ms.DropWatches()
+	waitInactive(t, w)
+	if err := ds.DeleteObjectAtomic(o); err != nil {
+		t.Fatal(err)
+	}
+	ds.RestartWatch()
+	if e = nextEvent(t, w); e.Type != WatchDelete {
+		t.Fatalf("Unexpected resumed event %s on %s", e.Type, e.Key)
+	}
+}
+
+func TestResumableWatchTree(t *testing.T) {
+	ds, ms := newWatchTestStore()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	a := &schemaObject{ID: "a", Name: "net1"}
+	b := &schemaObject{ID: "b", Name: "net2"}
+	for _, o := range []*schemaObject{a, b} {
+		if err := ds.PutObjectAtomic(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w, err := ds.ResumableWatch(&schemaObject{}, true, stopCh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if e := nextEvent(t, w); e.Type != WatchCreate {
+			t.Fatalf("Unexpected event %s on %s", e.Type, e.Key)
+		}
+	}
+	noEvent(t, w)
+
+	ms.DropWatches()
+	waitInactive(t, w)
+	if err := ds.DeleteObjectAtomic(a); err != nil {
+		t.Fatal(err)
+	}
+	b.Name = "net2-updated"
+	if err := ds.PutObjectAtomic(b); err != nil {
+		t.Fatal(err)
+	}
+	c := &schemaObject{ID: "c", Name: "net3"}
+	if err := ds.PutObjectAtomic(c); err != nil {
+		t.Fatal(err)
+	}
+
+	ds.RestartWatch()
+	events := make(map[string]WatchEvent)
+	for i := 0; i < 3; i++ {
+		e := nextEvent(t, w)
+		events[e.Key] = e
+	}
+	noEvent(t, w)
+
+	if e := events[trimKey(a.Key())]; e.Type != WatchDelete {
+		t.Fatalf("Expected delete of a, got %+v", e)
+	}
+	if e := events[trimKey(b.Key())]; e.Type != WatchUpdate || e.Object.(*schemaObject).Name != "net2-updated" {
+		t.Fatalf("Expected update of b, got %+v", e)
+	}
+	if e := events[trimKey(c.Key())]; e.Type != WatchCreate || e.Object.(*schemaObject).Name != "net3" {
+		t.Fatalf("Expected create of c, got %+v", e)
+	}
+	if h := w.Health(); !h.Active || h.Restarts != 1 || h.Missed != 3 {
+		t.Fatalf("Unexpected watch health %+v", h)
+	}
+}
+
+func trimKey(key []string) string {
+	k := Key(key...)
+	return k[:len(k)-1]
+}
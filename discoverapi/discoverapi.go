@@ -32,10 +32,11 @@ type NodeDiscoveryData struct {
 
 // DatastoreConfigData is the data for the datastore update event message
 type DatastoreConfigData struct {
-	Scope    string
-	Provider string
-	Address  string
-	Config   interface{}
+	Scope      string
+	Provider   string
+	Address    string
+	Config     interface{}
+	Encryption interface{}
 }
 
 // DriverEncryptionConfig contains the initial datapath encryption key(s)
@@ -4,6 +4,7 @@ import (
 	"net"
 
 	"github.com/docker/libnetwork/discoverapi"
+	"github.com/docker/libnetwork/types"
 )
 
 // NetworkPluginEndpointType represents the Endpoint Type used by Plugin system
@@ -75,6 +76,29 @@ type Driver interface {
 	Type() string
 }
 
+// SubnetUpdater is an optional interface the drivers implement to support
+// the addition and the removal of subnets on live networks
+type SubnetUpdater interface {
+	// AddSubnet notifies the driver about a subnet added to the network,
+	// the driver is expected to set up the gateway and the routing for it
+	AddSubnet(nid string, ipData IPAMData, v6 bool) error
+
+	// RemoveSubnet notifies the driver about a subnet removed from the
+	// network. No endpoint holds an address of the subnet anymore.
+	RemoveSubnet(nid string, ipData IPAMData, v6 bool) error
+}
+
+// AddressUpdater is an optional interface the drivers implement to support
+// changing the addresses of an endpoint without recreating it
+type AddressUpdater interface {
+	// UpdateEndpointAddress notifies the driver about the new addresses of
+	// the endpoint, a nil address is left unchanged. The driver reprograms
+	// its forwarding and neighbor state. If the endpoint is joined, jinfo is
+	// not nil and the driver provides again the gateways and the table
+	// entries which depend on the endpoint addresses.
+	UpdateEndpointAddress(nid, eid string, addr, addrv6 *net.IPNet, jinfo JoinInfo) error
+}
+
 // NetworkInfo provides a go interface for drivers to provide network
 // specific information to libnetwork.
 type NetworkInfo interface {
@@ -137,6 +161,14 @@ type JoinInfo interface {
 	AddTableEntry(tableName string, key string, value []byte) error
 }
 
+// QosPolicySetter is an optional interface of the JoinInfo, for the
+// drivers to request bandwidth limits on the endpoint interface
+type QosPolicySetter interface {
+	// SetQosPolicy sets the bandwidth limits libnetwork enforces on the
+	// interface once it is moved into the sandbox.
+	SetQosPolicy(policy types.QosPolicy) error
+}
+
 // DriverCallback provides a Callback interface for Drivers into LibNetwork
 type DriverCallback interface {
 	// RegisterDriver provides a way for Remote drivers to dynamically register new NetworkType and associate with a driver instance
@@ -67,6 +67,7 @@ type networkConfiguration struct {
 	AddressIPv6        *net.IPNet
 	DefaultGatewayIPv4 net.IP
 	DefaultGatewayIPv6 net.IP
+	SubnetsIPv4        []*net.IPNet
 	dbIndex            uint64
 	dbExists           bool
 	Internal           bool
@@ -75,6 +76,8 @@ type networkConfiguration struct {
 // endpointConfiguration represents the user specified configuration for the sandbox endpoint
 type endpointConfiguration struct {
 	MacAddress net.HardwareAddr
+	MirrorTo   string
+	QosPolicy  *types.QosPolicy
 }
 
 // containerConfiguration represents the user specified configuration for a container
@@ -93,6 +96,7 @@ type bridgeEndpoint struct {
 	id              string
 	nid             string
 	srcName         string
+	hostIfName      string
 	addr            *net.IPNet
 	addrv6          *net.IPNet
 	macAddress      net.HardwareAddr
@@ -715,6 +719,9 @@ func (d *driver) createNetwork(config *networkConfiguration) error {
 		// Setup DefaultGatewayIPv6
 		{config.DefaultGatewayIPv6 != nil, setupGatewayIPv6},
 
+		// Restore the subnets added to the live network
+		{len(config.SubnetsIPv4) > 0, network.setupSubnetsIPv4},
+
 		// Add inter-network communication rules.
 		{d.config.EnableIPTables, setupNetworkIsolationRules},
 
@@ -975,8 +982,9 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 		}
 	}
 
-	// Store the sandbox side pipe interface parameters
+	// Store the pipe interface parameters
 	endpoint.srcName = containerIfName
+	endpoint.hostIfName = hostIfName
 	endpoint.macAddress = ifInfo.MacAddress()
 	endpoint.addr = ifInfo.Address()
 	endpoint.addrv6 = ifInfo.AddressIPv6()
@@ -989,6 +997,20 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 		}
 	}
 
+	if epConfig != nil && epConfig.MirrorTo != "" {
+		if err = d.setupMirroring(endpoint, host); err != nil {
+			return err
+		}
+	}
+
+	// Enforce the bandwidth limits on the host side of the pipe, where the
+	// container egress traffic is received and its ingress traffic sent
+	if epConfig != nil && epConfig.QosPolicy != nil {
+		if err = osl.SetRateLimits(host, epConfig.QosPolicy.MaxIngressBandwidth, epConfig.QosPolicy.MaxEgressBandwidth); err != nil {
+			return err
+		}
+	}
+
 	// Up the host interface after finishing all netlink configuration
 	if err = d.nlh.LinkSetUp(host); err != nil {
 		return fmt.Errorf("could not set link up for host interface %s: %v", hostIfName, err)
@@ -1023,6 +1045,9 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 		return fmt.Errorf("failed to save bridge endpoint %s to store: %v", endpoint.id[0:7], err)
 	}
 
+	// Resume copying traffic to this endpoint if it is a mirror collector
+	d.setupCollector(endpoint)
+
 	return nil
 }
 
@@ -1077,6 +1102,9 @@ func (d *driver) DeleteEndpoint(nid, eid string) error {
 		}
 	}()
 
+	// Stop copying traffic to this endpoint if it is a mirror collector
+	d.teardownCollector(ep)
+
 	// Try removal of link. Discard error: it is a best effort.
 	// Also make sure defer does not see this error either.
 	if link, err := d.nlh.LinkByName(ep.srcName); err == nil {
@@ -1175,7 +1203,7 @@ func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo,
 		return err
 	}
 
-	err = jinfo.SetGateway(network.bridge.gatewayIPv4)
+	err = jinfo.SetGateway(network.gatewayFor(endpoint.addr))
 	if err != nil {
 		return err
 	}
@@ -1280,6 +1308,75 @@ func (d *driver) RevokeExternalConnectivity(nid, eid string) error {
 	return nil
 }
 
+// UpdateEndpointAddress moves the port mappings and the links of the
+// endpoint to its new addresses
+func (d *driver) UpdateEndpointAddress(nid, eid string, addr, addrv6 *net.IPNet, jinfo driverapi.JoinInfo) error {
+	defer osl.InitOSContext()()
+
+	network, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	endpoint, err := network.getEndpoint(eid)
+	if err != nil {
+		return err
+	}
+
+	if endpoint == nil {
+		return EndpointNotFoundError(eid)
+	}
+
+	linked := !network.config.EnableICC && endpoint.portMapping != nil
+	if linked {
+		if err := d.link(network, endpoint, false); err != nil {
+			logrus.Warnf("Failed to remove the links of endpoint %s: %v", eid, err)
+		}
+	}
+
+	oldAddr, oldAddrv6 := endpoint.addr, endpoint.addrv6
+	if addr != nil {
+		endpoint.addr = types.GetIPNetCopy(addr)
+	}
+	if addrv6 != nil {
+		endpoint.addrv6 = types.GetIPNetCopy(addrv6)
+	}
+	defer func() {
+		if err != nil {
+			endpoint.addr, endpoint.addrv6 = oldAddr, oldAddrv6
+		}
+	}()
+
+	if endpoint.portMapping != nil {
+		var pm []types.PortBinding
+		if pm, err = network.remapPorts(endpoint, d.config.EnableUserlandProxy); err != nil {
+			return err
+		}
+		endpoint.portMapping = pm
+	}
+
+	if linked {
+		if err = d.link(network, endpoint, true); err != nil {
+			return err
+		}
+	}
+
+	if jinfo != nil {
+		if err = jinfo.SetGateway(network.gatewayFor(endpoint.addr)); err != nil {
+			return err
+		}
+		if err = jinfo.SetGatewayIPv6(network.bridge.gatewayIPv6); err != nil {
+			return err
+		}
+	}
+
+	if err = d.storeUpdate(endpoint); err != nil {
+		return fmt.Errorf("failed to update bridge endpoint %s to store: %v", endpoint.id[0:7], err)
+	}
+
+	return nil
+}
+
 func (d *driver) link(network *bridgeNetwork, endpoint *bridgeEndpoint, enable bool) error {
 	var err error
 
@@ -1387,6 +1484,22 @@ func parseEndpointOptions(epOptions map[string]interface{}) (*endpointConfigurat
 		}
 	}
 
+	if opt, ok := epOptions[netlabel.QosPolicy]; ok {
+		if policy, ok := opt.(types.QosPolicy); ok {
+			ec.QosPolicy = &policy
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if opt, ok := epOptions[netlabel.MirrorTo]; ok {
+		if cid, ok := opt.(string); ok {
+			ec.MirrorTo = cid
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
 	return ec, nil
 }
 
@@ -152,6 +152,14 @@ func (ncfg *networkConfiguration) MarshalJSON() ([]byte, error) {
 		nMap["AddressIPv6"] = ncfg.AddressIPv6.String()
 	}
 
+	if len(ncfg.SubnetsIPv4) > 0 {
+		subnets := make([]string, 0, len(ncfg.SubnetsIPv4))
+		for _, s := range ncfg.SubnetsIPv4 {
+			subnets = append(subnets, s.String())
+		}
+		nMap["SubnetsIPv4"] = subnets
+	}
+
 	return json.Marshal(nMap)
 }
 
@@ -177,6 +185,16 @@ func (ncfg *networkConfiguration) UnmarshalJSON(b []byte) error {
 		}
 	}
 
+	if v, ok := nMap["SubnetsIPv4"]; ok {
+		for _, sv := range v.([]interface{}) {
+			sn, err := types.ParseCIDR(sv.(string))
+			if err != nil {
+				return types.InternalErrorf("failed to decode bridge network subnet after json unmarshal: %s", sv.(string))
+			}
+			ncfg.SubnetsIPv4 = append(ncfg.SubnetsIPv4, sn)
+		}
+	}
+
 	ncfg.DefaultBridge = nMap["DefaultBridge"].(bool)
 	ncfg.DefaultBindingIP = net.ParseIP(nMap["DefaultBindingIP"].(string))
 	ncfg.DefaultGatewayIPv4 = net.ParseIP(nMap["DefaultGatewayIPv4"].(string))
@@ -238,6 +256,10 @@ func (ncfg *networkConfiguration) New() datastore.KVObject {
 func (ncfg *networkConfiguration) CopyTo(o datastore.KVObject) error {
 	dstNcfg := o.(*networkConfiguration)
 	*dstNcfg = *ncfg
+	dstNcfg.SubnetsIPv4 = make([]*net.IPNet, 0, len(ncfg.SubnetsIPv4))
+	for _, s := range ncfg.SubnetsIPv4 {
+		dstNcfg.SubnetsIPv4 = append(dstNcfg.SubnetsIPv4, types.GetIPNetCopy(s))
+	}
 	return nil
 }
 
@@ -250,6 +272,7 @@ func (ep *bridgeEndpoint) MarshalJSON() ([]byte, error) {
 	epMap["id"] = ep.id
 	epMap["nid"] = ep.nid
 	epMap["SrcName"] = ep.srcName
+	epMap["HostIfName"] = ep.hostIfName
 	epMap["MacAddress"] = ep.macAddress.String()
 	epMap["Addr"] = ep.addr.String()
 	if ep.addrv6 != nil {
@@ -291,6 +314,9 @@ func (ep *bridgeEndpoint) UnmarshalJSON(b []byte) error {
 	ep.id = epMap["id"].(string)
 	ep.nid = epMap["nid"].(string)
 	ep.srcName = epMap["SrcName"].(string)
+	if v, ok := epMap["HostIfName"]; ok {
+		ep.hostIfName = v.(string)
+	}
 	d, _ := json.Marshal(epMap["Config"])
 	if err := json.Unmarshal(d, &ep.config); err != nil {
 		logrus.Warnf("Failed to decode endpoint config %v", err)
@@ -33,7 +33,8 @@ func TestEndpointMarshalling(t *testing.T) {
 		addrv6:     ip2,
 		macAddress: mac,
 		srcName:    "veth123456",
-		config:     &endpointConfiguration{MacAddress: mac},
+		hostIfName: "veth7890ab",
+		config:     &endpointConfiguration{MacAddress: mac, MirrorTo: "collector"},
 		containerConfig: &containerConfiguration{
 			ParentEndpoints: []string{"one", "due", "three"},
 			ChildEndpoints:  []string{"four", "five", "six"},
@@ -86,7 +87,7 @@ func TestEndpointMarshalling(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if e.id != ee.id || e.nid != ee.nid || e.srcName != ee.srcName || !bytes.Equal(e.macAddress, ee.macAddress) ||
+	if e.id != ee.id || e.nid != ee.nid || e.srcName != ee.srcName || e.hostIfName != ee.hostIfName || !bytes.Equal(e.macAddress, ee.macAddress) ||
 		!types.CompareIPNet(e.addr, ee.addr) || !types.CompareIPNet(e.addrv6, ee.addrv6) ||
 		!compareEpConfig(e.config, ee.config) ||
 		!compareContainerConfig(e.containerConfig, ee.containerConfig) ||
@@ -103,7 +104,7 @@ func compareEpConfig(a, b *endpointConfiguration) bool {
 	if a == nil || b == nil {
 		return false
 	}
-	return bytes.Equal(a.MacAddress, b.MacAddress)
+	return bytes.Equal(a.MacAddress, b.MacAddress) && a.MirrorTo == b.MirrorTo
 }
 
 func compareContainerConfig(a, b *containerConfiguration) bool {
@@ -986,6 +987,83 @@ func TestSetDefaultGw(t *testing.T) {
 	}
 }
 
+func TestAddRemoveSubnet(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	d := newDriver()
+
+	if err := d.configure(nil); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = &networkConfiguration{BridgeName: DefaultBridgeName}
+
+	ipdList := getIPv4Data(t)
+	if err := d.CreateNetwork("dummy", genericOption, nil, ipdList, nil); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	pool, _ := types.ParseCIDR("192.168.250.0/24")
+	gw, _ := types.ParseCIDR("192.168.250.1/24")
+	ipd := driverapi.IPAMData{Pool: pool, Gateway: gw}
+
+	if err := d.AddSubnet("dummy", ipd, true); err == nil {
+		t.Fatalf("Expected failure on IPv6 subnet")
+	}
+	if err := d.AddSubnet("dummy", ipdList[0], false); err == nil {
+		t.Fatalf("Expected failure on the network primary subnet")
+	}
+	if err := d.AddSubnet("dummy", ipd, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddSubnet("dummy", ipd, false); err == nil {
+		t.Fatalf("Expected failure on duplicate subnet")
+	}
+
+	te := newTestEndpoint(pool, 10)
+	if err := d.CreateEndpoint("dummy", "ep", te.Interface(), nil); err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+	if err := d.Join("dummy", "ep", "sbox", te, nil); err != nil {
+		t.Fatalf("Failed to join endpoint: %v", err)
+	}
+	if !gw.IP.Equal(te.gw) {
+		t.Fatalf("Unexpected gateway for the added subnet. Expected %v. Found %v", gw.IP, te.gw)
+	}
+
+	// Move the endpoint back to the original subnet
+	addr := types.GetIPNetCopy(ipdList[0].Pool)
+	addr.IP[len(addr.IP)-1] = 10
+	if err := d.UpdateEndpointAddress("dummy", "ep", addr, nil, te); err != nil {
+		t.Fatal(err)
+	}
+	n, _ := d.getNetwork("dummy")
+	ep, _ := n.getEndpoint("ep")
+	if !types.CompareIPNet(ep.addr, addr) {
+		t.Fatalf("Unexpected endpoint address %s. Expected %s", ep.addr, addr)
+	}
+	if !ipdList[0].Gateway.IP.Equal(te.gw) {
+		t.Fatalf("Unexpected gateway after the address update. Expected %v. Found %v", ipdList[0].Gateway.IP, te.gw)
+	}
+
+	if err := d.Leave("dummy", "ep"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteEndpoint("dummy", "ep"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.RemoveSubnet("dummy", ipd, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RemoveSubnet("dummy", ipd, false); err == nil {
+		t.Fatalf("Expected failure on removing unknown subnet")
+	}
+}
+
 func TestCleanupIptableRules(t *testing.T) {
 	defer testutils.SetupTestOSContext(t)()
 	bridgeChain := []iptables.ChainInfo{
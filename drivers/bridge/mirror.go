@@ -0,0 +1,187 @@
+package bridge
+
+import (
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+// Priority of the tc filters which copy the endpoint traffic to the collector.
+// They are evaluated after the bandwidth limits policer, if any.
+const mirrorFilterPriority = 2
+
+// mirrorParents are the clsact hooks the mirroring filters are attached to:
+// ingress carries the traffic sent by the container, egress the traffic
+// delivered to it.
+var mirrorParents = []uint32{netlink.HANDLE_MIN_INGRESS, netlink.HANDLE_MIN_EGRESS}
+
+// findEndpoint looks up an endpoint across all the bridge networks
+func (d *driver) findEndpoint(eid string) *bridgeEndpoint {
+	d.Lock()
+	networks := make([]*bridgeNetwork, 0, len(d.networks))
+	for _, n := range d.networks {
+		networks = append(networks, n)
+	}
+	d.Unlock()
+
+	for _, n := range networks {
+		if ep, _ := n.getEndpoint(eid); ep != nil {
+			return ep
+		}
+	}
+
+	return nil
+}
+
+// ensureClsact adds a clsact qdisc to the link unless one is already present.
+// The clsact qdisc requires a kernel version 4.5 or later.
+func ensureClsact(nlh *netlink.Handle, link netlink.Link) error {
+	qdisc := &netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_CLSACT,
+		},
+		QdiscType: "clsact",
+	}
+	if err := nlh.QdiscAdd(qdisc); err != nil && err != syscall.EEXIST {
+		return err
+	}
+	return nil
+}
+
+// setupMirroring copies all the packets sent and received by the endpoint
+// to the host interface of the collector endpoint, using tc mirred actions.
+func (d *driver) setupMirroring(ep *bridgeEndpoint, host netlink.Link) error {
+	cid := ep.config.MirrorTo
+	if cid == ep.id {
+		return types.BadRequestErrorf("endpoint %s cannot mirror its traffic to itself", ep.id)
+	}
+
+	collector := d.findEndpoint(cid)
+	if collector == nil {
+		return types.NotFoundErrorf("mirror collector endpoint %s does not exist", cid)
+	}
+	if collector.hostIfName == "" {
+		return types.ForbiddenErrorf("mirror collector endpoint %s has no known host interface", cid)
+	}
+
+	clink, err := d.nlh.LinkByName(collector.hostIfName)
+	if err != nil {
+		return types.InternalErrorf("failed to find host interface %s of mirror collector %s: %v", collector.hostIfName, cid, err)
+	}
+
+	if err := ensureClsact(d.nlh, host); err != nil {
+		return types.InternalErrorf("failed to add clsact qdisc on %s: %v", host.Attrs().Name, err)
+	}
+
+	for _, parent := range mirrorParents {
+		filter := &netlink.U32{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: host.Attrs().Index,
+				Parent:    parent,
+				Priority:  mirrorFilterPriority,
+				Protocol:  syscall.ETH_P_ALL,
+			},
+			Actions: []netlink.Action{
+				&netlink.MirredAction{
+					ActionAttrs:  netlink.ActionAttrs{Action: netlink.TC_ACT_PIPE},
+					MirredAction: netlink.TCA_EGRESS_MIRROR,
+					Ifindex:      clink.Attrs().Index,
+				},
+			},
+		}
+		if err := d.nlh.FilterAdd(filter); err != nil {
+			return types.InternalErrorf("failed to mirror %s to %s: %v", host.Attrs().Name, collector.hostIfName, err)
+		}
+	}
+
+	logrus.Debugf("Mirroring traffic of endpoint %s to collector endpoint %s", ep.id[0:7], cid[0:7])
+
+	return nil
+}
+
+// teardownMirroring removes the filters copying the endpoint traffic
+func (d *driver) teardownMirroring(ep *bridgeEndpoint) {
+	host, err := d.nlh.LinkByName(ep.hostIfName)
+	if err != nil {
+		// The mirroring is gone along with the interface
+		return
+	}
+
+	for _, parent := range mirrorParents {
+		filter := &netlink.U32{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: host.Attrs().Index,
+				Parent:    parent,
+				Priority:  mirrorFilterPriority,
+				Protocol:  syscall.ETH_P_ALL,
+			},
+		}
+		if err := d.nlh.FilterDel(filter); err != nil {
+			logrus.Warnf("Failed to remove the mirroring of endpoint %s: %v", ep.id[0:7], err)
+		}
+	}
+}
+
+// teardownCollector stops the mirroring of all the endpoints whose traffic
+// is copied to the collector endpoint being removed
+func (d *driver) teardownCollector(collector *bridgeEndpoint) {
+	d.Lock()
+	networks := make([]*bridgeNetwork, 0, len(d.networks))
+	for _, n := range d.networks {
+		networks = append(networks, n)
+	}
+	d.Unlock()
+
+	for _, n := range networks {
+		n.Lock()
+		var mirrored []*bridgeEndpoint
+		for _, ep := range n.endpoints {
+			if ep.config != nil && ep.config.MirrorTo == collector.id {
+				mirrored = append(mirrored, ep)
+			}
+		}
+		n.Unlock()
+
+		for _, ep := range mirrored {
+			d.teardownMirroring(ep)
+		}
+	}
+}
+
+// setupCollector copies again to the collector endpoint being created the
+// traffic of the endpoints mirrored to it, which was stopped when a previous
+// collector with the same id was removed
+func (d *driver) setupCollector(collector *bridgeEndpoint) {
+	d.Lock()
+	networks := make([]*bridgeNetwork, 0, len(d.networks))
+	for _, n := range d.networks {
+		networks = append(networks, n)
+	}
+	d.Unlock()
+
+	for _, n := range networks {
+		n.Lock()
+		var mirrored []*bridgeEndpoint
+		for _, ep := range n.endpoints {
+			if ep.config != nil && ep.config.MirrorTo == collector.id && ep.hostIfName != "" {
+				mirrored = append(mirrored, ep)
+			}
+		}
+		n.Unlock()
+
+		for _, ep := range mirrored {
+			host, err := d.nlh.LinkByName(ep.hostIfName)
+			if err != nil {
+				logrus.Warnf("Failed to find host interface %s of mirrored endpoint %s: %v", ep.hostIfName, ep.id[0:7], err)
+				continue
+			}
+			if err := d.setupMirroring(ep, host); err != nil {
+				logrus.Warnf("Failed to resume the mirroring of endpoint %s: %v", ep.id[0:7], err)
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/testutils"
+	"github.com/vishvananda/netlink"
+)
+
+// checkMirroring verifies the traffic of the endpoint is mirrored to the
+// collector endpoint, or not mirrored at all if collector is empty
+func checkMirroring(t *testing.T, d *driver, eid, collector string) {
+	n, _ := d.getNetwork("net1")
+	ep, _ := n.getEndpoint(eid)
+	host, err := d.nlh.LinkByName(ep.hostIfName)
+	if err != nil {
+		t.Fatalf("Failed to find host interface of endpoint %s: %v", eid, err)
+	}
+
+	cindex := 0
+	if collector != "" {
+		cep, _ := n.getEndpoint(collector)
+		clink, err := d.nlh.LinkByName(cep.hostIfName)
+		if err != nil {
+			t.Fatalf("Failed to find host interface of collector %s: %v", collector, err)
+		}
+		cindex = clink.Attrs().Index
+
+		qdiscs, err := d.nlh.QdiscList(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, q := range qdiscs {
+			if q.Type() == "clsact" {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("No clsact qdisc on the host interface of endpoint %s: %v", eid, qdiscs)
+		}
+	}
+
+	// The u32 filters of the clsact hooks share their hash tables, the
+	// filters of both hooks are listed on each of them
+	mirrors := make(map[uint32]int)
+	for _, parent := range mirrorParents {
+		filters, err := d.nlh.FilterList(host, parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range filters {
+			u32, ok := f.(*netlink.U32)
+			if !ok || u32.Priority != mirrorFilterPriority {
+				continue
+			}
+			for _, a := range u32.Actions {
+				if m, ok := a.(*netlink.MirredAction); ok {
+					mirrors[u32.Handle] = m.Ifindex
+				}
+			}
+		}
+	}
+
+	if collector == "" {
+		if len(mirrors) != 0 {
+			t.Fatalf("Endpoint %s still mirrored: %v", eid, mirrors)
+		}
+		return
+	}
+	if len(mirrors) != len(mirrorParents) {
+		t.Fatalf("Endpoint %s has %d mirroring filters instead of %d: %v", eid, len(mirrors), len(mirrorParents), mirrors)
+	}
+	for _, index := range mirrors {
+		if index != cindex {
+			t.Fatalf("Endpoint %s mirrored to interface %d instead of %d", eid, index, cindex)
+		}
+	}
+}
+
+func TestMirroring(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	d := newDriver()
+
+	if err := d.configure(nil); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = &networkConfiguration{BridgeName: DefaultBridgeName}
+
+	ipdList := getIPv4Data(t)
+	if err := d.CreateNetwork("net1", genericOption, nil, ipdList, nil); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	te := newTestEndpoint(ipdList[0].Pool, 10)
+	if err := d.CreateEndpoint("net1", "collector0", te.Interface(), nil); err != nil {
+		t.Fatalf("Failed to create collector endpoint: %v", err)
+	}
+
+	epOptions := map[string]interface{}{netlabel.MirrorTo: "collector0"}
+	te = newTestEndpoint(ipdList[0].Pool, 11)
+	if err := d.CreateEndpoint("net1", "endpoint1", te.Interface(), epOptions); err != nil {
+		t.Fatalf("Failed to create mirrored endpoint: %v", err)
+	}
+	checkMirroring(t, d, "endpoint1", "collector0")
+
+	te = newTestEndpoint(ipdList[0].Pool, 12)
+	if err := d.CreateEndpoint("net1", "endpoint2", te.Interface(), epOptions); err != nil {
+		t.Fatalf("Failed to create mirrored endpoint: %v", err)
+	}
+	checkMirroring(t, d, "endpoint2", "collector0")
+
+	// The mirroring is gone along with the collector
+	if err := d.DeleteEndpoint("net1", "collector0"); err != nil {
+		t.Fatal(err)
+	}
+	checkMirroring(t, d, "endpoint1", "")
+	checkMirroring(t, d, "endpoint2", "")
+
+	// and comes back with it
+	te = newTestEndpoint(ipdList[0].Pool, 10)
+	if err := d.CreateEndpoint("net1", "collector0", te.Interface(), nil); err != nil {
+		t.Fatalf("Failed to create collector endpoint: %v", err)
+	}
+	checkMirroring(t, d, "endpoint1", "collector0")
+	checkMirroring(t, d, "endpoint2", "collector0")
+
+	// The mirroring is gone along with the mirrored endpoint
+	n, _ := d.getNetwork("net1")
+	ep, _ := n.getEndpoint("endpoint1")
+	if err := d.DeleteEndpoint("net1", "endpoint1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.nlh.LinkByName(ep.hostIfName); err == nil {
+		t.Fatalf("Host interface %s of the mirrored endpoint was not removed", ep.hostIfName)
+	}
+	checkMirroring(t, d, "endpoint2", "collector0")
+
+	for _, eid := range []string{"endpoint2", "collector0"} {
+		if err := d.DeleteEndpoint("net1", eid); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -27,6 +27,23 @@ func (n *bridgeNetwork) allocatePorts(ep *bridgeEndpoint, reqDefBindIP net.IP, u
 	return n.allocatePortsInternal(ep.extConnConfig.PortBindings, ep.addr.IP, defHostIP, ulPxyEnabled)
 }
 
+// remapPorts moves the port bindings of the endpoint to its current address,
+// keeping the host ports the bindings were given
+func (n *bridgeNetwork) remapPorts(ep *bridgeEndpoint, ulPxyEnabled bool) ([]types.PortBinding, error) {
+	bindings := make([]types.PortBinding, 0, len(ep.portMapping))
+	for _, m := range ep.portMapping {
+		b := m.GetCopy()
+		b.HostPortEnd = b.HostPort
+		bindings = append(bindings, b)
+	}
+
+	if err := n.releasePorts(ep); err != nil {
+		logrus.Warn(err)
+	}
+
+	return n.allocatePortsInternal(bindings, ep.addr.IP, defaultBindingIP, ulPxyEnabled)
+}
+
 func (n *bridgeNetwork) allocatePortsInternal(bindings []types.PortBinding, containerIP, defHostIP net.IP, ulPxyEnabled bool) ([]types.PortBinding, error) {
 	bs := make([]types.PortBinding, 0, len(bindings))
 	for _, c := range bindings {
@@ -0,0 +1,169 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+// AddSubnet assigns the gateway address of the subnet added to the network
+// to the bridge and masquerades the subnet traffic
+func (d *driver) AddSubnet(nid string, ipData driverapi.IPAMData, v6 bool) error {
+	defer osl.InitOSContext()()
+
+	if v6 {
+		return types.NotImplementedErrorf("bridge driver does not support adding IPv6 subnets")
+	}
+	if ipData.Gateway == nil {
+		return types.BadRequestErrorf("missing gateway address for subnet %s", ipData.Pool)
+	}
+
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	config := n.config
+	for _, s := range append([]*net.IPNet{config.AddressIPv4}, config.SubnetsIPv4...) {
+		if s == nil {
+			continue
+		}
+		if s.Contains(ipData.Gateway.IP) || ipData.Gateway.Contains(s.IP) {
+			n.Unlock()
+			return types.ForbiddenErrorf("subnet %s overlaps with subnet %s of network %s", ipData.Pool, s, nid)
+		}
+	}
+	if config.Internal {
+		n.Unlock()
+		return types.NotImplementedErrorf("bridge driver does not support adding subnets to internal networks")
+	}
+	n.Unlock()
+
+	gw := types.GetIPNetCopy(ipData.Gateway)
+	if err := n.programSubnetIPv4(config, gw, true); err != nil {
+		return err
+	}
+
+	n.Lock()
+	config.SubnetsIPv4 = append(config.SubnetsIPv4, gw)
+	n.Unlock()
+
+	if err := d.storeUpdate(config); err != nil {
+		n.Lock()
+		config.SubnetsIPv4 = config.SubnetsIPv4[:len(config.SubnetsIPv4)-1]
+		n.Unlock()
+		n.programSubnetIPv4(config, gw, false)
+		return err
+	}
+
+	return nil
+}
+
+// RemoveSubnet removes the gateway address of the subnet from the bridge
+// along with the subnet masquerading
+func (d *driver) RemoveSubnet(nid string, ipData driverapi.IPAMData, v6 bool) error {
+	defer osl.InitOSContext()()
+
+	if v6 {
+		return types.NotImplementedErrorf("bridge driver does not support removing IPv6 subnets")
+	}
+
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	config := n.config
+	i := -1
+	for j, s := range config.SubnetsIPv4 {
+		if ipData.Pool != nil && ipData.Pool.Contains(s.IP) {
+			i = j
+			break
+		}
+	}
+	if i == -1 {
+		n.Unlock()
+		return types.ForbiddenErrorf("subnet %s was not added to network %s", ipData.Pool, nid)
+	}
+	gw := config.SubnetsIPv4[i]
+	config.SubnetsIPv4 = append(config.SubnetsIPv4[:i:i], config.SubnetsIPv4[i+1:]...)
+	n.Unlock()
+
+	if err := d.storeUpdate(config); err != nil {
+		n.Lock()
+		config.SubnetsIPv4 = append(config.SubnetsIPv4, gw)
+		n.Unlock()
+		return err
+	}
+
+	return n.programSubnetIPv4(config, gw, false)
+}
+
+// gatewayFor returns the gateway of the bridge subnet the address belongs to
+func (n *bridgeNetwork) gatewayFor(addr *net.IPNet) net.IP {
+	n.Lock()
+	defer n.Unlock()
+
+	if addr != nil {
+		for _, s := range n.config.SubnetsIPv4 {
+			if s.Contains(addr.IP) {
+				return s.IP
+			}
+		}
+	}
+
+	return n.bridge.gatewayIPv4
+}
+
+// setupSubnetsIPv4 restores the subnets added to the live network
+func (n *bridgeNetwork) setupSubnetsIPv4(config *networkConfiguration, i *bridgeInterface) error {
+	for _, s := range config.SubnetsIPv4 {
+		if err := n.programSubnetIPv4(config, s, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *bridgeNetwork) programSubnetIPv4(config *networkConfiguration, gw *net.IPNet, enable bool) error {
+	addr := &netlink.Addr{IPNet: gw}
+	if enable {
+		logrus.Debugf("Assigning address to bridge interface %s: %s", config.BridgeName, gw)
+		if err := n.bridge.nlh.AddrAdd(n.bridge.Link, addr); err != nil && err != syscall.EEXIST {
+			return &IPv4AddrAddError{IP: gw, Err: err}
+		}
+	} else {
+		if err := n.bridge.nlh.AddrDel(n.bridge.Link, addr); err != nil && err != syscall.EADDRNOTAVAIL {
+			return fmt.Errorf("failed to remove address %s from bridge %s: %v", gw, config.BridgeName, err)
+		}
+	}
+
+	n.driver.Lock()
+	ipTables := n.driver.config.EnableIPTables
+	n.driver.Unlock()
+	if !ipTables || !config.EnableIPMasquerade {
+		return nil
+	}
+
+	subnet := &net.IPNet{IP: gw.IP.Mask(gw.Mask), Mask: gw.Mask}
+	natRule := iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-s", subnet.String(), "!", "-o", config.BridgeName, "-j", "MASQUERADE"}}
+	if err := programChainRule(natRule, "NAT", enable); err != nil {
+		return err
+	}
+	if enable {
+		n.registerIptCleanFunc(func() error {
+			return programChainRule(natRule, "NAT", false)
+		})
+	}
+
+	return nil
+}
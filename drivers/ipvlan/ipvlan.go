@@ -15,11 +15,12 @@ const (
 	vethLen             = 7
 	containerVethPrefix = "eth"
 	vethPrefix          = "veth"
-	ipvlanType          = "ipvlan" // driver type name
-	modeL2              = "l2"     // ipvlan mode l2 is the default
-	modeL3              = "l3"     // ipvlan L3 mode
-	parentOpt           = "parent" // parent interface -o parent
-	modeOpt             = "_mode"  // ipvlan mode ux opt suffix
+	ipvlanType          = "ipvlan"            // driver type name
+	modeL2              = "l2"                // ipvlan mode l2 is the default
+	modeL3              = "l3"                // ipvlan L3 mode
+	parentOpt           = "parent"            // parent interface -o parent
+	parentStateOpt      = "parent_oper_state" // parent link state in endpoint oper info
+	modeOpt             = "_mode"             // ipvlan mode ux opt suffix
 )
 
 var driverModeOpt = ipvlanType + modeOpt // mode -o ipvlan_mode
@@ -29,8 +30,8 @@ type endpointTable map[string]*endpoint
 type networkTable map[string]*network
 
 type driver struct {
-	networks networkTable
-	sync.Once
+	networks    networkTable
+	linkMonitor *osl.HostLinkMonitor
 	sync.Mutex
 	store datastore.DataStore
 }
@@ -42,6 +43,7 @@ type endpoint struct {
 	addr     *net.IPNet
 	addrv6   *net.IPNet
 	srcName  string
+	qos      *types.QosPolicy
 	dbIndex  uint64
 	dbExists bool
 }
@@ -63,6 +65,7 @@ func Init(dc driverapi.DriverCallback, config map[string]interface{}) error {
 	d := &driver{
 		networks: networkTable{},
 	}
+	d.linkMonitor = osl.NewHostLinkMonitor(d.handleParentLinkEvent)
 	d.initStore(config)
 
 	return dc.RegisterDriver(ipvlanType, d, c)
@@ -77,7 +80,15 @@ func (d *driver) NetworkFree(id string) error {
 }
 
 func (d *driver) EndpointOperInfo(nid, eid string) (map[string]interface{}, error) {
-	return make(map[string]interface{}, 0), nil
+	m := make(map[string]interface{}, 0)
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return m, nil
+	}
+	m[parentOpt] = n.config.Parent
+	m[parentStateOpt] = d.linkMonitor.State(n.config.Parent).String()
+
+	return m, nil
 }
 
 func (d *driver) Type() string {
@@ -51,6 +51,13 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 			}
 		}
 	}
+	if opt, ok := epOptions[netlabel.QosPolicy]; ok {
+		policy, ok := opt.(types.QosPolicy)
+		if !ok {
+			return types.BadRequestErrorf("invalid qos policy for %s endpoint %s", ipvlanType, eid)
+		}
+		ep.qos = &policy
+	}
 
 	if err := d.storeUpdate(ep); err != nil {
 		return fmt.Errorf("failed to save ipvlan endpoint %s to store: %v", ep.id[0:7], err)
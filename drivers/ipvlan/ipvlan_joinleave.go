@@ -111,6 +111,15 @@ func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo,
 				ep.addrv6.IP.String(), v6gw.String(), n.config.IpvlanMode, n.config.Parent)
 		}
 	}
+	if ep.qos != nil {
+		qs, ok := jinfo.(driverapi.QosPolicySetter)
+		if !ok {
+			return fmt.Errorf("bandwidth limits are not supported on %s endpoint %s", ipvlanType, ep.id[0:7])
+		}
+		if err := qs.SetQosPolicy(*ep.qos); err != nil {
+			return err
+		}
+	}
 	iNames := jinfo.InterfaceName()
 	err = iNames.SetNames(vethName, containerVethPrefix)
 	if err != nil {
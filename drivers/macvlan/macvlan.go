@@ -15,13 +15,14 @@ const (
 	vethLen             = 7
 	containerVethPrefix = "eth"
 	vethPrefix          = "veth"
-	macvlanType         = "macvlan"  // driver type name
-	modePrivate         = "private"  // macvlan mode private
-	modeVepa            = "vepa"     // macvlan mode vepa
-	modeBridge          = "bridge"   // macvlan mode bridge
-	modePassthru        = "passthru" // macvlan mode passthrough
-	parentOpt           = "parent"   // parent interface -o parent
-	modeOpt             = "_mode"    // macvlan mode ux opt suffix
+	macvlanType         = "macvlan"           // driver type name
+	modePrivate         = "private"           // macvlan mode private
+	modeVepa            = "vepa"              // macvlan mode vepa
+	modeBridge          = "bridge"            // macvlan mode bridge
+	modePassthru        = "passthru"          // macvlan mode passthrough
+	parentOpt           = "parent"            // parent interface -o parent
+	parentStateOpt      = "parent_oper_state" // parent link state in endpoint oper info
+	modeOpt             = "_mode"             // macvlan mode ux opt suffix
 )
 
 var driverModeOpt = macvlanType + modeOpt // mode --option macvlan_mode
@@ -31,8 +32,8 @@ type endpointTable map[string]*endpoint
 type networkTable map[string]*network
 
 type driver struct {
-	networks networkTable
-	sync.Once
+	networks    networkTable
+	linkMonitor *osl.HostLinkMonitor
 	sync.Mutex
 	store datastore.DataStore
 }
@@ -44,6 +45,7 @@ type endpoint struct {
 	addr     *net.IPNet
 	addrv6   *net.IPNet
 	srcName  string
+	qos      *types.QosPolicy
 	dbIndex  uint64
 	dbExists bool
 }
@@ -65,6 +67,7 @@ func Init(dc driverapi.DriverCallback, config map[string]interface{}) error {
 	d := &driver{
 		networks: networkTable{},
 	}
+	d.linkMonitor = osl.NewHostLinkMonitor(d.handleParentLinkEvent)
 	d.initStore(config)
 
 	return dc.RegisterDriver(macvlanType, d, c)
@@ -79,7 +82,15 @@ func (d *driver) NetworkFree(id string) error {
 }
 
 func (d *driver) EndpointOperInfo(nid, eid string) (map[string]interface{}, error) {
-	return make(map[string]interface{}, 0), nil
+	m := make(map[string]interface{}, 0)
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return m, nil
+	}
+	m[parentOpt] = n.config.Parent
+	m[parentStateOpt] = d.linkMonitor.State(n.config.Parent).String()
+
+	return m, nil
 }
 
 func (d *driver) Type() string {
@@ -72,6 +72,15 @@ func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo,
 		logrus.Debugf("Macvlan Endpoint Joined with IPv6_Addr: %s Gateway: %s MacVlan_Mode: %s, Parent: %s",
 			ep.addrv6.IP.String(), v6gw.String(), n.config.MacvlanMode, n.config.Parent)
 	}
+	if ep.qos != nil {
+		qs, ok := jinfo.(driverapi.QosPolicySetter)
+		if !ok {
+			return fmt.Errorf("bandwidth limits are not supported on %s endpoint %s", macvlanType, ep.id[0:7])
+		}
+		if err := qs.SetQosPolicy(*ep.qos); err != nil {
+			return err
+		}
+	}
 	iNames := jinfo.InterfaceName()
 	err = iNames.SetNames(vethName, containerVethPrefix)
 	if err != nil {
@@ -0,0 +1,38 @@
+package macvlan
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/libnetwork/osl"
+)
+
+// handleParentLinkEvent is notified by the link monitor of the state
+// changes of the parent links, and recreates the parent if it was created
+// by the driver and has been removed
+func (d *driver) handleParentLinkEvent(ev osl.LinkEvent, prev osl.LinkState) {
+	for _, n := range d.getNetworks() {
+		if n.config.Parent != ev.Name {
+			continue
+		}
+		logrus.Warnf("%s parent link %s of network %s changed state from %s to %s",
+			macvlanType, ev.Name, stringid.TruncateID(n.id), prev, ev.State)
+		if ev.State == osl.LinkStateDeleted && n.config.CreatedSlaveLink {
+			d.recreateParentLink(n)
+		}
+	}
+}
+
+// recreateParentLink restores a driver created parent link which was deleted
+func (d *driver) recreateParentLink(n *network) {
+	var err error
+	if n.config.Parent == getDummyName(stringid.TruncateID(n.config.ID)) {
+		err = createDummyLink(n.config.Parent, getDummyName(stringid.TruncateID(n.config.ID)))
+	} else {
+		err = createVlanLink(n.config.Parent)
+	}
+	if err != nil {
+		logrus.Errorf("failed to recreate the %s parent link %s: %v", macvlanType, n.config.Parent, err)
+		return
+	}
+	logrus.Infof("recreated the %s parent link %s of network %s", macvlanType, n.config.Parent, stringid.TruncateID(n.id))
+}
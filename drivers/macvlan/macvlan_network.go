@@ -119,6 +119,10 @@ func (d *driver) createNetwork(config *configuration) error {
 	}
 	// add the *network
 	d.addNetwork(n)
+	// track the parent link state for the lifetime of the network
+	if err := d.linkMonitor.Watch(config.Parent); err != nil {
+		logrus.Warnf("failed to monitor the %s parent link %s: %v", macvlanType, config.Parent, err)
+	}
 
 	return nil
 }
@@ -130,6 +134,10 @@ func (d *driver) DeleteNetwork(nid string) error {
 	if n == nil {
 		return fmt.Errorf("network id %s not found", nid)
 	}
+	// delete the *network first so the parent link monitor does not
+	// recreate the slave link removed below
+	d.deleteNetwork(nid)
+	d.linkMonitor.Unwatch(n.config.Parent)
 	// if the driver created the slave interface, delete it, otherwise leave it
 	if ok := n.config.CreatedSlaveLink; ok {
 		// if the interface exists, only delete if it matches iface.vlan or dummy.net_id naming
@@ -151,8 +159,6 @@ func (d *driver) DeleteNetwork(nid string) error {
 			}
 		}
 	}
-	// delete the *network
-	d.deleteNetwork(nid)
 	// delete the network record from persistent cache
 	err := d.storeDelete(n.config)
 	if err != nil {
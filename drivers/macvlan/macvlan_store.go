@@ -264,6 +264,9 @@ func (ep *endpoint) MarshalJSON() ([]byte, error) {
 	if ep.addrv6 != nil {
 		epMap["Addrv6"] = ep.addrv6.String()
 	}
+	if ep.qos != nil {
+		epMap["QosPolicy"] = ep.qos
+	}
 	return json.Marshal(epMap)
 }
 
@@ -292,6 +295,14 @@ func (ep *endpoint) UnmarshalJSON(b []byte) error {
 			return types.InternalErrorf("failed to decode macvlan endpoint IPv6 address (%s) after json unmarshal: %v", v.(string), err)
 		}
 	}
+	if v, ok := epMap["QosPolicy"]; ok {
+		qb, _ := json.Marshal(v)
+		var policy types.QosPolicy
+		if err = json.Unmarshal(qb, &policy); err != nil {
+			return types.InternalErrorf("failed to decode macvlan endpoint qos policy after json unmarshal: %v", err)
+		}
+		ep.qos = &policy
+	}
 	ep.id = epMap["id"].(string)
 	ep.nid = epMap["nid"].(string)
 	ep.srcName = epMap["SrcName"].(string)
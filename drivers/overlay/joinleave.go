@@ -38,7 +38,7 @@ func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo,
 		return fmt.Errorf("cannot join secure network: required modules to install IPSEC rules are missing on host")
 	}
 
-	s := n.getSubnetforIP(ep.addr)
+	s := n.lookupSubnet(ep.addr)
 	if s == nil {
 		return fmt.Errorf("could not find subnet for endpoint %s", eid)
 	}
@@ -222,3 +222,72 @@ func (d *driver) Leave(nid, eid string) error {
 
 	return nil
 }
+
+// UpdateEndpointAddress moves the endpoint to its new address. A joined
+// endpoint is attached to the bridge of its subnet and can only move within
+// that subnet.
+func (d *driver) UpdateEndpointAddress(nid, eid string, addr, addrv6 *net.IPNet, jinfo driverapi.JoinInfo) error {
+	if err := validateID(nid, eid); err != nil {
+		return err
+	}
+
+	if addrv6 != nil {
+		return types.NotImplementedErrorf("overlay driver does not support IPv6 endpoint addresses")
+	}
+	if addr == nil {
+		return nil
+	}
+
+	n := d.network(nid)
+	if n == nil {
+		return fmt.Errorf("could not find network with id %s", nid)
+	}
+
+	ep := n.endpoint(eid)
+	if ep == nil {
+		return fmt.Errorf("could not find endpoint with id %s", eid)
+	}
+
+	s := n.lookupSubnet(addr)
+	if s == nil {
+		return fmt.Errorf("no matching subnet for IP %q in network %q", addr, nid)
+	}
+	if jinfo != nil && s != n.getSubnetforIP(ep.addr) {
+		return types.ForbiddenErrorf("joined endpoint %s cannot move to subnet %s", eid, s.subnetIP)
+	}
+
+	old := *ep
+	ep.addr = types.GetIPNetCopy(addr)
+	if err := d.writeEndpointToStore(ep); err != nil {
+		ep.addr = old.addr
+		return fmt.Errorf("failed to update overlay endpoint %s to local data store: %v", eid[0:7], err)
+	}
+
+	if jinfo == nil {
+		return nil
+	}
+
+	vtep := net.ParseIP(d.bindAddress)
+	d.peerDbDelete(nid, eid, old.addr.IP, old.addr.Mask, ep.mac, vtep)
+	d.peerDbAdd(nid, eid, ep.addr.IP, ep.addr.Mask, ep.mac, vtep, true)
+
+	if d.isSerfAlive() {
+		d.notifyCh <- ovNotify{
+			action: "leave",
+			nw:     n,
+			ep:     &old,
+		}
+	}
+	d.pushLocalEndpointEvent("join", nid, eid)
+
+	buf, err := proto.Marshal(&PeerRecord{
+		EndpointIP:       ep.addr.String(),
+		EndpointMAC:      ep.mac.String(),
+		TunnelEndpointIP: d.bindAddress,
+	})
+	if err != nil {
+		return err
+	}
+
+	return jinfo.AddTableEntry(ovPeerTable, eid, buf)
+}
@@ -810,7 +810,15 @@ func (n *network) SetValue(value []byte) error {
 			sNet := n.getMatchingSubnet(subnetIP)
 			if sNet != nil {
 				sNet.vni = vni
+				continue
 			}
+			// subnet added to the live network by another node
+			n.subnets = append(n.subnets, &subnet{
+				subnetIP: subnetIP,
+				gwIP:     gwIP,
+				vni:      vni,
+				once:     &sync.Once{},
+			})
 		}
 	}
 	return nil
@@ -0,0 +1,173 @@
+package overlay
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+)
+
+// AddSubnet adds the subnet to the live network. The subnet sandbox and
+// vxlan are set up on the first join, other nodes learn about the subnet
+// from the store.
+func (d *driver) AddSubnet(nid string, ipData driverapi.IPAMData, v6 bool) error {
+	if v6 {
+		return types.NotImplementedErrorf("overlay driver does not support IPv6 subnets")
+	}
+	if ipData.Pool == nil || ipData.Gateway == nil {
+		return types.BadRequestErrorf("missing pool or gateway for the subnet")
+	}
+
+	n := d.network(nid)
+	if n == nil {
+		return fmt.Errorf("could not find network with id %s", nid)
+	}
+
+	n.Lock()
+	for _, s := range n.subnets {
+		if s.subnetIP.Contains(ipData.Pool.IP) || ipData.Pool.Contains(s.subnetIP.IP) {
+			n.Unlock()
+			return types.ForbiddenErrorf("subnet %s overlaps with subnet %s of network %s", ipData.Pool, s.subnetIP, nid)
+		}
+	}
+	n.Unlock()
+
+	s := &subnet{
+		subnetIP: types.GetIPNetCopy(ipData.Pool),
+		gwIP:     types.GetIPNetCopy(ipData.Gateway),
+		once:     &sync.Once{},
+	}
+
+	err := n.updateSubnets(func() {
+		if n.getMatchingSubnet(s.subnetIP) == nil {
+			n.subnets = append(n.subnets, s)
+		}
+	})
+	if err != nil {
+		n.Lock()
+		n.removeSubnet(s.subnetIP)
+		n.Unlock()
+		return fmt.Errorf("failed to update data store for network %v: %v", nid, err)
+	}
+
+	return nil
+}
+
+// RemoveSubnet removes the subnet from the live network, tearing down its
+// sandbox interfaces and releasing its vxlan id
+func (d *driver) RemoveSubnet(nid string, ipData driverapi.IPAMData, v6 bool) error {
+	if v6 {
+		return types.NotImplementedErrorf("overlay driver does not support IPv6 subnets")
+	}
+
+	n := d.network(nid)
+	if n == nil {
+		return fmt.Errorf("could not find network with id %s", nid)
+	}
+
+	n.Lock()
+	s := n.getMatchingSubnet(ipData.Pool)
+	n.Unlock()
+	if s == nil {
+		return types.ForbiddenErrorf("subnet %s not found on network %s", ipData.Pool, nid)
+	}
+
+	if err := n.updateSubnets(func() { n.removeSubnet(s.subnetIP) }); err != nil {
+		return fmt.Errorf("failed to update data store for network %v: %v", nid, err)
+	}
+
+	n.destroySubnetSandbox(s)
+
+	if vni := n.vxlanID(s); vni != 0 {
+		if n.driver.vxlanIdm != nil {
+			n.driver.vxlanIdm.Release(uint64(vni))
+		}
+		if n.secure {
+			programMangle(vni, false)
+		}
+		n.setVxlanID(s, 0)
+	}
+
+	return nil
+}
+
+// updateSubnets applies the change to the network subnets and saves the
+// network to the store. If the network was concurrently updated by another
+// node, the change is applied again on top of the stored subnets.
+func (n *network) updateSubnets(change func()) error {
+	for {
+		n.Lock()
+		change()
+		n.Unlock()
+
+		err := n.writeToStore()
+		if err != datastore.ErrKeyModified {
+			return err
+		}
+
+		if err := n.driver.store.GetObject(datastore.Key(n.Key()...), n); err != nil {
+			return fmt.Errorf("getting network %q from datastore failed %v", n.id, err)
+		}
+	}
+}
+
+// removeSubnet removes the subnet matching the passed one from the network.
+// Caller must hold the network lock.
+func (n *network) removeSubnet(subnetIP *net.IPNet) {
+	for i, s := range n.subnets {
+		if types.CompareIPNet(s.subnetIP, subnetIP) {
+			n.subnets = append(n.subnets[:i:i], n.subnets[i+1:]...)
+			return
+		}
+	}
+}
+
+// lookupSubnet returns the subnet the ip belongs to. When the subnet is not
+// known locally, it was possibly added to the live network by another node
+// and the network subnets are refreshed from the store.
+func (n *network) lookupSubnet(ip *net.IPNet) *subnet {
+	if s := n.getSubnetforIP(ip); s != nil {
+		return s
+	}
+
+	if n.driver.store == nil {
+		return nil
+	}
+
+	if err := n.driver.store.GetObject(datastore.Key(n.Key()...), n); err != nil {
+		logrus.Debugf("Failed to refresh network %s subnets from the store: %v", n.id, err)
+		return nil
+	}
+
+	return n.getSubnetforIP(ip)
+}
+
+func (n *network) destroySubnetSandbox(s *subnet) {
+	sbox := n.sandbox()
+	if sbox == nil || s.vxlanName == "" {
+		return
+	}
+
+	for _, iface := range sbox.Info().Interfaces() {
+		if iface.SrcName() != s.brName && iface.SrcName() != s.vxlanName {
+			continue
+		}
+		if err := iface.Remove(); err != nil {
+			logrus.Debugf("Remove interface %s failed: %v", iface.SrcName(), err)
+		}
+	}
+
+	if hostMode {
+		if err := removeFilters(n.id[:12], s.brName); err != nil {
+			logrus.Warnf("Could not remove overlay filters: %v", err)
+		}
+	}
+
+	if err := deleteInterface(s.vxlanName); err != nil {
+		logrus.Warnf("could not cleanup subnet sandbox properly: %v", err)
+	}
+}
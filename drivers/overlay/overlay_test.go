@@ -130,3 +130,31 @@ func TestOverlayType(t *testing.T) {
 			dt.d.Type())
 	}
 }
+
+func TestNetworkSetValueAddsSubnets(t *testing.T) {
+	n1 := &network{id: "testnet"}
+	if err := n1.SetValue([]byte(`{"secure":false,"subnets":[{"SubnetIP":"10.1.0.0/24","GwIP":"10.1.0.1/24","Vni":4097}]}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	n2 := &network{id: "testnet"}
+	if err := n2.SetValue(n1.Value()); err != nil {
+		t.Fatal(err)
+	}
+
+	// another node adds a subnet to the live network
+	_, sn, _ := net.ParseCIDR("10.2.0.0/24")
+	gw := &net.IPNet{IP: net.ParseIP("10.2.0.1"), Mask: sn.Mask}
+	n1.subnets = append(n1.subnets, &subnet{subnetIP: sn, gwIP: gw, vni: 4098})
+
+	if err := n2.SetValue(n1.Value()); err != nil {
+		t.Fatal(err)
+	}
+	if len(n2.subnets) != 2 {
+		t.Fatalf("Expected 2 subnets. Got %d", len(n2.subnets))
+	}
+	s := n2.getSubnetforIP(&net.IPNet{IP: net.ParseIP("10.2.0.5"), Mask: sn.Mask})
+	if s == nil || s.vni != 4098 || !s.gwIP.IP.Equal(gw.IP) || s.once == nil {
+		t.Fatalf("Unexpected subnet after update: %+v", s)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/datastore"
@@ -29,6 +30,11 @@ type driver struct {
 	networks networkTable
 	store    datastore.DataStore
 	vxlanIdm *idm.Idm
+	// leaseTTL is the time to live of the vxlan id leases, the ids
+	// are not leased if zero
+	leaseTTL time.Duration
+	// stopLeases stops the renewal and the reaping of the leases
+	stopLeases func()
 	sync.Mutex
 }
 
@@ -42,6 +48,9 @@ type network struct {
 	id      string
 	driver  *driver
 	subnets []*subnet
+	// leaseErr is set once a vxlan id of the network was handed out to
+	// another owner, the leases of the network are no longer renewed
+	leaseErr error
 	sync.Mutex
 }
 
@@ -57,12 +66,114 @@ func Init(dc driverapi.DriverCallback, config map[string]interface{}) error {
 		config:   config,
 	}
 
-	d.vxlanIdm, err = idm.New(nil, "vxlan-id", vxlanIDStart, vxlanIDEnd)
+	if d.leaseTTL, err = leaseTTL(config); err != nil {
+		return err
+	}
+
+	// Without leases the vxlan ids are kept in memory, the networks
+	// allocated again on restart get back the ids they hold
+	if data, ok := config[netlabel.GlobalKVClient]; ok && d.leaseTTL > 0 {
+		dsc, ok := data.(discoverapi.DatastoreConfigData)
+		if !ok {
+			return types.InternalErrorf("incorrect data in datastore configuration: %v", data)
+		}
+		d.store, err = datastore.NewDataStoreFromConfig(dsc)
+		if err != nil {
+			return types.InternalErrorf("failed to initialize data store: %v", err)
+		}
+	}
+
+	d.vxlanIdm, err = idm.New(d.store, "vxlan-id", vxlanIDStart, vxlanIDEnd)
 	if err != nil {
 		return fmt.Errorf("failed to initialize vxlan id manager: %v", err)
 	}
 
-	return dc.RegisterDriver(networkType, d, c)
+	if d.leaseTTL > 0 {
+		if d.store == nil {
+			logrus.Warnf("The vxlan id leases are not shared with the other nodes as the global datastore is missing")
+		}
+		d.startLeases()
+	}
+
+	if err := dc.RegisterDriver(networkType, d, c); err != nil {
+		if d.stopLeases != nil {
+			d.stopLeases()
+		}
+		return err
+	}
+
+	return nil
+}
+
+// leaseTTL returns the vxlan id leases time to live from the driver config
+func leaseTTL(config map[string]interface{}) (time.Duration, error) {
+	v, ok := config[netlabel.OverlayVxlanIDLeaseTTL]
+	if !ok {
+		return 0, nil
+	}
+
+	var (
+		ttl time.Duration
+		err error
+	)
+	switch val := v.(type) {
+	case time.Duration:
+		ttl = val
+	case string:
+		if ttl, err = time.ParseDuration(val); err != nil {
+			return 0, fmt.Errorf("invalid vxlan id lease ttl %q: %v", val, err)
+		}
+	default:
+		return 0, fmt.Errorf("unexpected vxlan id lease ttl type %T", v)
+	}
+	if ttl != 0 && ttl < time.Second {
+		return 0, fmt.Errorf("invalid vxlan id lease ttl %v, it must be at least one second", ttl)
+	}
+
+	return ttl, nil
+}
+
+// startLeases starts the reaper of the expired vxlan id leases and the
+// periodic renewal of the leases held by the networks of this driver. Both
+// are stopped by d.stopLeases.
+func (d *driver) startLeases() {
+	stopReaper := d.vxlanIdm.StartReaper(d.leaseTTL / 2)
+	stopCh := make(chan struct{})
+	go d.renewLeases(stopCh)
+
+	var once sync.Once
+	d.stopLeases = func() {
+		once.Do(func() {
+			stopReaper()
+			close(stopCh)
+		})
+	}
+}
+
+// renewLeases periodically renews the leases of the vxlan ids held by
+// the networks, until stopCh is closed
+func (d *driver) renewLeases(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(d.leaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+
+		d.Lock()
+		networks := make([]*network, 0, len(d.networks))
+		for _, n := range d.networks {
+			networks = append(networks, n)
+		}
+		d.Unlock()
+
+		for _, n := range networks {
+			n.renewVxlanIDs()
+		}
+	}
 }
 
 func (d *driver) NetworkAllocate(id string, option map[string]string, ipV4Data, ipV6Data []driverapi.IPAMData) (map[string]string, error) {
@@ -163,8 +274,13 @@ func (n *network) obtainVxlanID(s *subnet) error {
 	vni = uint64(s.vni)
 	n.Unlock()
 
+	ttl := n.driver.leaseTTL
 	if vni == 0 {
-		vni, err = n.driver.vxlanIdm.GetID()
+		if ttl > 0 {
+			vni, err = n.driver.vxlanIdm.GetLeasedID(n.leaseOwner(), ttl)
+		} else {
+			vni, err = n.driver.vxlanIdm.GetID()
+		}
 		if err != nil {
 			return err
 		}
@@ -175,14 +291,74 @@ func (n *network) obtainVxlanID(s *subnet) error {
 		return nil
 	}
 
+	if ttl > 0 {
+		return n.driver.vxlanIdm.GetSpecificLeasedID(vni, n.leaseOwner(), ttl)
+	}
 	return n.driver.vxlanIdm.GetSpecificID(vni)
 }
 
+// leaseOwner returns the owner of the network vxlan id leases. The leases
+// belong to the network rather than to the manager node allocating it, so
+// that a new leader allocating the network again takes over the leases
+// right away, the former leader renewing them as well meanwhile.
+func (n *network) leaseOwner() string {
+	return n.id
+}
+
+// renewVxlanIDs renews the leases of the network vxlan ids. A lease which
+// expired in the meantime is obtained again if the id was not handed out.
+// Otherwise the id now belongs to another owner: the network is failed,
+// it stops using the id and its leases are no longer renewed.
+func (n *network) renewVxlanIDs() {
+	n.Lock()
+	if n.leaseErr != nil {
+		n.Unlock()
+		return
+	}
+	vnis := make([]uint64, 0, len(n.subnets))
+	for _, s := range n.subnets {
+		if s.vni != 0 {
+			vnis = append(vnis, uint64(s.vni))
+		}
+	}
+	n.Unlock()
+
+	owner := n.leaseOwner()
+	ttl := n.driver.leaseTTL
+	for _, vni := range vnis {
+		if err := n.driver.vxlanIdm.RenewLease(vni, owner, ttl); err == nil {
+			continue
+		}
+		if err := n.driver.vxlanIdm.GetSpecificLeasedID(vni, owner, ttl); err != nil {
+			n.failLease(vni, err)
+			return
+		}
+	}
+}
+
+// failLease fails the network whose lease of the vxlan id could not be
+// renewed. The id is dropped from the network so that it is not released
+// on behalf of its new owner when the network is freed.
+func (n *network) failLease(vni uint64, err error) {
+	n.Lock()
+	n.leaseErr = fmt.Errorf("lost the lease of vxlan id %d: %v", vni, err)
+	for _, s := range n.subnets {
+		if uint64(s.vni) == vni {
+			s.vni = 0
+		}
+	}
+	n.Unlock()
+
+	logrus.Errorf("Overlay network %s failed: it lost the lease of vxlan id %d, which may now be used by another network: %v", n.id, vni, err)
+}
+
 func (n *network) releaseVxlanID() {
 	n.Lock()
 	vnis := make([]uint32, 0, len(n.subnets))
 	for _, s := range n.subnets {
-		vnis = append(vnis, s.vni)
+		if s.vni != 0 {
+			vnis = append(vnis, s.vni)
+		}
 		s.vni = 0
 	}
 	n.Unlock()
@@ -5,6 +5,7 @@ import (
 	"net"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/idm"
@@ -86,3 +87,149 @@ func TestNetworkAllocateUserDefinedVNIs(t *testing.T) {
 	err = d.NetworkFree("testnetwork")
 	require.NoError(t, err)
 }
+
+func TestLeaseTTLConfig(t *testing.T) {
+	ttl, err := leaseTTL(map[string]interface{}{})
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), ttl)
+
+	ttl, err = leaseTTL(map[string]interface{}{netlabel.OverlayVxlanIDLeaseTTL: "2m"})
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, ttl)
+
+	ttl, err = leaseTTL(map[string]interface{}{netlabel.OverlayVxlanIDLeaseTTL: 30 * time.Second})
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, ttl)
+
+	for _, v := range []interface{}{"forever", 10 * time.Millisecond, -time.Minute, 60} {
+		_, err = leaseTTL(map[string]interface{}{netlabel.OverlayVxlanIDLeaseTTL: v})
+		assert.Error(t, err)
+	}
+}
+
+func TestNetworkAllocateLeasedVNIs(t *testing.T) {
+	d := newDriver(t)
+	d.leaseTTL = time.Hour
+
+	ipamData := []driverapi.IPAMData{
+		{
+			Pool: parseCIDR(t, "10.1.1.0/24"),
+		},
+		{
+			Pool: parseCIDR(t, "10.1.2.0/24"),
+		},
+	}
+
+	options := make(map[string]string)
+	options[netlabel.OverlayVxlanIDList] = "300"
+	_, err := d.NetworkAllocate("testnetwork", options, ipamData[:1], nil)
+	require.NoError(t, err)
+
+	_, err = d.NetworkAllocate("testnetwork2", nil, ipamData[1:], nil)
+	require.NoError(t, err)
+
+	leases := d.vxlanIdm.Leases()
+	require.Equal(t, 2, len(leases))
+	assert.Equal(t, uint64(vxlanIDStart), leases[0].ID)
+	assert.Equal(t, "testnetwork2", leases[0].Owner)
+	assert.Equal(t, uint64(300), leases[1].ID)
+	assert.Equal(t, "testnetwork", leases[1].Owner)
+
+	// An expired lease is obtained again on renewal if the id is still free
+	n := d.networks["testnetwork"]
+	d.leaseTTL = time.Millisecond
+	require.NoError(t, d.vxlanIdm.RenewLease(300, "testnetwork", time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	expired, err := d.vxlanIdm.ReleaseExpired()
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{300}, expired)
+
+	d.leaseTTL = time.Hour
+	n.renewVxlanIDs()
+	leases = d.vxlanIdm.Leases()
+	require.Equal(t, 2, len(leases))
+	assert.Equal(t, uint64(300), leases[1].ID)
+	assert.True(t, leases[1].Expiry.After(time.Now().Add(time.Minute)))
+
+	require.NoError(t, d.NetworkFree("testnetwork"))
+	require.NoError(t, d.NetworkFree("testnetwork2"))
+	assert.Equal(t, 0, len(d.vxlanIdm.Leases()))
+}
+
+func TestNetworkLostVNILease(t *testing.T) {
+	d := newDriver(t)
+	d.leaseTTL = time.Hour
+
+	ipamData := []driverapi.IPAMData{
+		{
+			Pool: parseCIDR(t, "10.1.1.0/24"),
+		},
+	}
+
+	options := make(map[string]string)
+	options[netlabel.OverlayVxlanIDList] = "300"
+	_, err := d.NetworkAllocate("testnetwork", options, ipamData, nil)
+	require.NoError(t, err)
+
+	// The lease expires and the id is handed out to another network by a
+	// manager sharing the id set
+	require.NoError(t, d.vxlanIdm.RenewLease(300, "testnetwork", time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	_, err = d.vxlanIdm.ReleaseExpired()
+	require.NoError(t, err)
+
+	d2 := &driver{networks: networkTable{}, vxlanIdm: d.vxlanIdm, leaseTTL: time.Hour}
+	_, err = d2.NetworkAllocate("testnetwork2", options, ipamData, nil)
+	require.NoError(t, err)
+
+	n := d.networks["testnetwork"]
+	n.renewVxlanIDs()
+	require.Error(t, n.leaseErr)
+	assert.Equal(t, uint32(0), n.subnets[0].vni)
+
+	// Freeing the failed network leaves the id to its new owner
+	require.NoError(t, d.NetworkFree("testnetwork"))
+	leases := d.vxlanIdm.Leases()
+	require.Equal(t, 1, len(leases))
+	assert.Equal(t, uint64(300), leases[0].ID)
+	assert.Equal(t, "testnetwork2", leases[0].Owner)
+}
+
+func TestStopLeases(t *testing.T) {
+	d := newDriver(t)
+	d.leaseTTL = time.Second
+	d.startLeases()
+	d.stopLeases()
+	// Stopping twice is harmless
+	d.stopLeases()
+}
+
+func TestNetworkAllocateLeaderChange(t *testing.T) {
+	d := newDriver(t)
+	d.leaseTTL = time.Hour
+
+	ipamData := []driverapi.IPAMData{
+		{
+			Pool: parseCIDR(t, "10.1.1.0/24"),
+		},
+	}
+
+	vals, err := d.NetworkAllocate("testnetwork", nil, ipamData, nil)
+	require.NoError(t, err)
+
+	// The new leader allocates the network again with its vxlan ids while
+	// the former one still holds and renews their leases
+	d2 := &driver{networks: networkTable{}, vxlanIdm: d.vxlanIdm, leaseTTL: time.Hour}
+	vals2, err := d2.NetworkAllocate("testnetwork", vals, ipamData, nil)
+	require.NoError(t, err)
+	assert.Equal(t, vals[netlabel.OverlayVxlanIDList], vals2[netlabel.OverlayVxlanIDList])
+
+	d.networks["testnetwork"].renewVxlanIDs()
+	assert.NoError(t, d.networks["testnetwork"].leaseErr)
+	d2.networks["testnetwork"].renewVxlanIDs()
+	assert.NoError(t, d2.networks["testnetwork"].leaseErr)
+
+	leases := d.vxlanIdm.Leases()
+	require.Equal(t, 1, len(leases))
+	assert.Equal(t, "testnetwork", leases[0].Owner)
+}
@@ -262,7 +262,7 @@ func (d *driver) peerAdd(nid, eid string, peerIP net.IP, peerIPMask net.IPMask,
 		Mask: peerIPMask,
 	}
 
-	s := n.getSubnetforIP(IP)
+	s := n.lookupSubnet(IP)
 	if s == nil {
 		return fmt.Errorf("couldn't find the subnet %q in network %q\n", IP.String(), n.id)
 	}
@@ -1,18 +1,28 @@
 package libnetwork
 
 import (
+	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/drvregistry"
 	"github.com/docker/libnetwork/ipamapi"
 	builtinIpam "github.com/docker/libnetwork/ipams/builtin"
+	dhcpIpam "github.com/docker/libnetwork/ipams/dhcp"
 	nullIpam "github.com/docker/libnetwork/ipams/null"
 	remoteIpam "github.com/docker/libnetwork/ipams/remote"
 )
 
-func initIPAMDrivers(r *drvregistry.DrvRegistry, lDs, gDs interface{}) error {
+func initIPAMDrivers(r *drvregistry.DrvRegistry, lDs, gDs interface{}, cfg *config.IpamCfg) error {
+	builtinInit := builtinIpam.Init
+	if cfg != nil {
+		builtinInit = func(ic ipamapi.Callback, l, g interface{}) error {
+			return builtinIpam.InitWithPools(ic, l, g, cfg.LocalPools, cfg.GlobalPools)
+		}
+	}
+
 	for _, fn := range [](func(ipamapi.Callback, interface{}, interface{}) error){
-		builtinIpam.Init,
+		builtinInit,
 		remoteIpam.Init,
 		nullIpam.Init,
+		dhcpIpam.Init,
 	} {
 		if err := fn(r, lDs, gDs); err != nil {
 			return err
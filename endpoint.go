@@ -13,6 +13,7 @@ import (
 	"github.com/docker/libnetwork/ipamapi"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/options"
+	"github.com/docker/libnetwork/osl"
 	"github.com/docker/libnetwork/types"
 )
 
@@ -42,6 +43,10 @@ type Endpoint interface {
 
 	// Delete and detaches this endpoint from the network.
 	Delete(force bool) error
+
+	// UpdateAddress changes the IPv4 and IPv6 addresses of the endpoint
+	// without recreating it. A nil address is left unchanged.
+	UpdateAddress(v4, v6 net.IP) error
 }
 
 // EndpointOption is an option setter function type used to pass various options to Network
@@ -72,6 +77,7 @@ type endpoint struct {
 	virtualIP         net.IP
 	svcAliases        []string
 	ingressPorts      []*PortConfig
+	operState         osl.LinkState
 	dbIndex           uint64
 	dbExists          bool
 	sync.Mutex
@@ -899,6 +905,22 @@ func CreateOptionPortMapping(portBindings []types.PortBinding) EndpointOption {
 	}
 }
 
+// CreateOptionQosPolicy function returns an option setter for limiting the
+// bandwidth of the endpoint
+func CreateOptionQosPolicy(policy types.QosPolicy) EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.QosPolicy] = policy
+	}
+}
+
+// CreateOptionMirror function returns an option setter for copying all the
+// traffic of the endpoint to the collector endpoint identified by the passed id
+func CreateOptionMirror(collectorID string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.MirrorTo] = collectorID
+	}
+}
+
 // CreateOptionAnonymous function returns an option setter for setting
 // this endpoint as anonymous
 func CreateOptionAnonymous() EndpointOption {
@@ -1024,10 +1046,22 @@ func (ep *endpoint) assignAddressVersion(ipVer int, ipam ipamapi.Ipam) error {
 		progAdd = (*address).IP
 	}
 
+	// The subnet removal waits for the allocations in progress before it
+	// checks the pool is unused
+	c := n.getController()
+	c.poolsLock.RLock()
+	defer c.poolsLock.RUnlock()
+
 	for _, d := range ipInfo {
 		if progAdd != nil && !d.Pool.Contains(progAdd) {
 			continue
 		}
+		if c.drainingPools[d.PoolID] {
+			if progAdd != nil {
+				return types.ForbiddenErrorf("Invalid address %s: subnet %s is being removed from network %s", progAdd, d.Pool, n.Name())
+			}
+			continue
+		}
 		addr, _, err := ipam.RequestAddress(d.PoolID, progAdd, ep.ipamOptions)
 		if err == nil {
 			ep.Lock()
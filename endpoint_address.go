@@ -0,0 +1,239 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/types"
+)
+
+func (ep *endpoint) UpdateAddress(v4, v6 net.IP) (err error) {
+	if v4 != nil && v4.To4() == nil {
+		return types.BadRequestErrorf("invalid IPv4 address %s", v4)
+	}
+	if v6 != nil && v6.To4() != nil {
+		return types.BadRequestErrorf("invalid IPv6 address %s", v6)
+	}
+
+	n := ep.getNetwork()
+	if n == nil {
+		return fmt.Errorf("network not connected for ep %q", ep.name)
+	}
+	if n.hasSpecialDriver() {
+		return types.ForbiddenErrorf("cannot change the address of endpoint %s on network %s", ep.Name(), n.Name())
+	}
+
+	d, err := n.driver(true)
+	if err != nil {
+		return fmt.Errorf("failed to get driver during endpoint address update: %v", err)
+	}
+	au, ok := d.(driverapi.AddressUpdater)
+	if !ok {
+		return types.NotImplementedErrorf("driver %s does not support endpoint address changes", n.Type())
+	}
+
+	ipam, _, err := n.getController().getIPAMDriver(n.ipamType)
+	if err != nil {
+		return err
+	}
+
+	ep.Lock()
+	oldIface := *ep.iface
+	oldPref, oldPrefV6 := ep.prefAddress, ep.prefAddressV6
+	ep.Unlock()
+
+	if v4 != nil && oldIface.addr != nil && v4.Equal(oldIface.addr.IP) {
+		v4 = nil
+	}
+	if v6 != nil && oldIface.addrv6 != nil && v6.Equal(oldIface.addrv6.IP) {
+		v6 = nil
+	}
+	if v4 == nil && v6 == nil {
+		return nil
+	}
+	if v6 != nil && len(n.getIPInfo(6)) == 0 {
+		return types.BadRequestErrorf("network %s has no IPv6 subnets", n.Name())
+	}
+
+	c := n.getController()
+	c.Lock()
+	netWatch, ok := c.nmap[n.ID()]
+	c.Unlock()
+	if !ok {
+		return fmt.Errorf("watch null for network %q", n.Name())
+	}
+
+	sb, joined := ep.getSandbox()
+
+	// Drop the records of the old addresses, they are restored on failure
+	// once the endpoint is back to its old addresses
+	n.updateSvcRecord(ep, c.getLocalEps(netWatch), false)
+	if joined {
+		if e := ep.deleteFromCluster(); e != nil {
+			log.Errorf("Could not delete state for endpoint %s from cluster: %v", ep.Name(), e)
+		}
+	}
+	defer func() {
+		if err != nil {
+			n.updateSvcRecord(ep, c.getLocalEps(netWatch), true)
+			if joined {
+				if e := ep.addToCluster(); e != nil {
+					log.Errorf("Could not restore state for endpoint %s into cluster: %v", ep.Name(), e)
+				}
+			}
+		}
+	}()
+
+	defer func() {
+		if err != nil {
+			ep.Lock()
+			newIface := *ep.iface
+			ep.iface.addr, ep.iface.v4PoolID = oldIface.addr, oldIface.v4PoolID
+			ep.iface.addrv6, ep.iface.v6PoolID = oldIface.addrv6, oldIface.v6PoolID
+			ep.Unlock()
+			ep.releaseUpdatedAddress(ipam, &newIface, &oldIface)
+		}
+	}()
+
+	if v4 != nil {
+		ep.Lock()
+		ep.prefAddress = v4
+		ep.Unlock()
+		err = ep.assignAddressVersion(4, ipam)
+		ep.Lock()
+		if err != nil || oldPref == nil {
+			ep.prefAddress = oldPref
+		}
+		ep.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	if v6 != nil {
+		ep.Lock()
+		ep.prefAddressV6 = v6
+		ep.Unlock()
+		err = ep.assignAddressVersion(6, ipam)
+		ep.Lock()
+		if err != nil || oldPrefV6 == nil {
+			ep.prefAddressV6 = oldPrefV6
+		}
+		ep.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	var (
+		addr, addrv6       *net.IPNet
+		oldAddr, oldAddrv6 *net.IPNet
+		jinfo              driverapi.JoinInfo
+		oldJoinInfo        endpointJoinInfo
+	)
+	ep.Lock()
+	if v4 != nil {
+		addr, oldAddr = ep.iface.addr, oldIface.addr
+	}
+	if v6 != nil {
+		addrv6, oldAddrv6 = ep.iface.addrv6, oldIface.addrv6
+	}
+	joined = joined && ep.joinInfo != nil
+	if joined {
+		oldJoinInfo = *ep.joinInfo
+		jinfo = ep
+	}
+	ep.Unlock()
+
+	sbUpdated := false
+	defer func() {
+		if err != nil && sbUpdated {
+			if e := sb.updateInterfaceAddress(ep, oldAddr, oldAddrv6); e != nil {
+				log.Warnf("Failed to restore the addresses of endpoint %s in sandbox: %v", ep.Name(), e)
+			}
+		}
+	}()
+
+	defer func() {
+		if err != nil && joined {
+			ep.Lock()
+			*ep.joinInfo = oldJoinInfo
+			ep.Unlock()
+		}
+	}()
+
+	if joined {
+		ep.resetTableEntries()
+	}
+	if err = au.UpdateEndpointAddress(n.ID(), ep.ID(), addr, addrv6, jinfo); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if joined {
+				ep.resetTableEntries()
+			}
+			if e := au.UpdateEndpointAddress(n.ID(), ep.ID(), oldAddr, oldAddrv6, jinfo); e != nil {
+				log.Warnf("Failed to roll back the driver state of endpoint %s: %v", ep.Name(), e)
+			}
+		}
+	}()
+
+	if joined {
+		if err = sb.updateInterfaceAddress(ep, addr, addrv6); err != nil {
+			return err
+		}
+		sbUpdated = true
+	}
+
+	if err = c.updateToStore(ep); err != nil {
+		return err
+	}
+	// Trigger the service records update in the peer nodes
+	n.getEpCnt().updateStore()
+
+	n.updateSvcRecord(ep, c.getLocalEps(netWatch), true)
+	if joined {
+		if e := ep.addToCluster(); e != nil {
+			log.Errorf("Could not update state for endpoint %s into cluster: %v", ep.Name(), e)
+		}
+	}
+
+	ep.Lock()
+	newIface := *ep.iface
+	ep.Unlock()
+	ep.releaseUpdatedAddress(ipam, &oldIface, &newIface)
+
+	log.Debugf("Updated addresses of endpoint %s (%s) on network %s", ep.Name(), ep.ID(), n.Name())
+
+	return nil
+}
+
+// resetTableEntries drops the table entries the driver provided at join,
+// the driver provides them again on an address update
+func (ep *endpoint) resetTableEntries() {
+	ep.Lock()
+	if ep.joinInfo != nil {
+		ep.joinInfo.driverTableEntries = nil
+	}
+	ep.Unlock()
+}
+
+// releaseUpdatedAddress releases the addresses of the from interface which
+// the to interface no longer holds
+func (ep *endpoint) releaseUpdatedAddress(ipam ipamapi.Ipam, from, to *endpointInterface) {
+	if from.addr != nil && !types.CompareIPNet(from.addr, to.addr) {
+		if err := ipam.ReleaseAddress(from.v4PoolID, from.addr.IP); err != nil {
+			log.Warnf("Failed to release ip address %s of endpoint %s (%s): %v", from.addr.IP, ep.Name(), ep.ID(), err)
+		}
+	}
+
+	if from.addrv6 != nil && from.addrv6.IP.IsGlobalUnicast() && !types.CompareIPNet(from.addrv6, to.addrv6) {
+		if err := ipam.ReleaseAddress(from.v6PoolID, from.addrv6.IP); err != nil {
+			log.Warnf("Failed to release ip address %s of endpoint %s (%s): %v", from.addrv6.IP, ep.Name(), ep.ID(), err)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/osl"
 	"github.com/docker/libnetwork/types"
 )
 
@@ -31,6 +32,10 @@ type EndpointInfo interface {
 
 	// Sandbox returns the attached sandbox if there, nil otherwise.
 	Sandbox() Sandbox
+
+	// OperState returns the operational state of the endpoint interface
+	// inside the sandbox. It is only known once a container has joined the endpoint.
+	OperState() osl.LinkState
 }
 
 // InterfaceInfo provides an interface to retrieve interface addresses bound to the endpoint.
@@ -172,6 +177,7 @@ type endpointJoinInfo struct {
 	StaticRoutes          []*types.StaticRoute
 	driverTableEntries    []*tableEntry
 	disableGatewayService bool
+	qosPolicy             *types.QosPolicy
 }
 
 type tableEntry struct {
@@ -351,6 +357,13 @@ func (ep *endpoint) Sandbox() Sandbox {
 	return cnt
 }
 
+func (ep *endpoint) OperState() osl.LinkState {
+	ep.Lock()
+	defer ep.Unlock()
+
+	return ep.operState
+}
+
 func (ep *endpoint) StaticRoutes() []*types.StaticRoute {
 	ep.Lock()
 	defer ep.Unlock()
@@ -415,6 +428,14 @@ func (ep *endpoint) DisableGatewayService() {
 	ep.joinInfo.disableGatewayService = true
 }
 
+func (ep *endpoint) SetQosPolicy(policy types.QosPolicy) error {
+	ep.Lock()
+	defer ep.Unlock()
+
+	ep.joinInfo.qosPolicy = &policy
+	return nil
+}
+
 func (epj *endpointJoinInfo) MarshalJSON() ([]byte, error) {
 	epMap := make(map[string]interface{})
 	if epj.gw != nil {
@@ -425,6 +446,9 @@ func (epj *endpointJoinInfo) MarshalJSON() ([]byte, error) {
 	}
 	epMap["disableGatewayService"] = epj.disableGatewayService
 	epMap["StaticRoutes"] = epj.StaticRoutes
+	if epj.qosPolicy != nil {
+		epMap["qosPolicy"] = epj.qosPolicy
+	}
 	return json.Marshal(epMap)
 }
 
@@ -456,11 +480,24 @@ func (epj *endpointJoinInfo) UnmarshalJSON(b []byte) error {
 	}
 	epj.StaticRoutes = StaticRoutes
 
+	if v, ok := epMap["qosPolicy"]; ok {
+		qb, _ := json.Marshal(v)
+		var policy types.QosPolicy
+		if err := json.Unmarshal(qb, &policy); err != nil {
+			return types.InternalErrorf("failed to decode endpoint qos policy after json unmarshal: %v", err)
+		}
+		epj.qosPolicy = &policy
+	}
+
 	return nil
 }
 
 func (epj *endpointJoinInfo) CopyTo(dstEpj *endpointJoinInfo) error {
 	dstEpj.disableGatewayService = epj.disableGatewayService
+	if epj.qosPolicy != nil {
+		policy := *epj.qosPolicy
+		dstEpj.qosPolicy = &policy
+	}
 	dstEpj.StaticRoutes = make([]*types.StaticRoute, len(epj.StaticRoutes))
 	copy(dstEpj.StaticRoutes, epj.StaticRoutes)
 	dstEpj.gw = types.GetIPCopy(epj.gw)
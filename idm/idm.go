@@ -13,6 +13,7 @@ type Idm struct {
 	start  uint64
 	end    uint64
 	handle *bitseq.Handle
+	leases *leaseSet
 }
 
 // New returns an instance of id manager for a set of [start-end] numerical ids
@@ -29,7 +30,7 @@ func New(ds datastore.DataStore, id string, start, end uint64) (*Idm, error) {
 		return nil, fmt.Errorf("failed to initialize bit sequence handler: %s", err.Error())
 	}
 
-	return &Idm{start: start, end: end, handle: h}, nil
+	return &Idm{start: start, end: end, handle: h, leases: newLeaseSet(ds, id)}, nil
 }
 
 // GetID returns the first available id in the set
@@ -54,7 +55,8 @@ func (i *Idm) GetSpecificID(id uint64) error {
 	return i.handle.Set(id - i.start)
 }
 
-// Release releases the specified id
+// Release releases the specified id, along with its lease if any
 func (i *Idm) Release(id uint64) {
+	i.dropLease(id)
 	i.handle.Unset(id - i.start)
 }
@@ -1,11 +1,41 @@
 package idm
 
 import (
+	"fmt"
+	"io/ioutil"
 	"testing"
+	"time"
 
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/boltdb"
+	"github.com/docker/libnetwork/datastore"
 	_ "github.com/docker/libnetwork/testutils"
 )
 
+func init() {
+	boltdb.Register()
+}
+
+func randomLocalStore() (datastore.DataStore, error) {
+	tmp, err := ioutil.TempFile("", "libnetwork-")
+	if err != nil {
+		return nil, fmt.Errorf("Error creating temp file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("Error closing temp file: %v", err)
+	}
+	return datastore.NewDataStore(datastore.LocalScope, &datastore.ScopeCfg{
+		Client: datastore.ScopeClientCfg{
+			Provider: "boltdb",
+			Address:  "/tmp/libnetwork/test/idm" + tmp.Name(),
+			Config: &store.Config{
+				Bucket:            "libnetwork",
+				ConnectionTimeout: 3 * time.Second,
+			},
+		},
+	})
+}
+
 func TestNew(t *testing.T) {
 	_, err := New(nil, "", 0, 1)
 	if err == nil {
@@ -108,3 +138,134 @@ func TestUninitialized(t *testing.T) {
 		t.Fatalf("Expected failure but succeeded")
 	}
 }
+
+func TestLeases(t *testing.T) {
+	i, err := New(nil, "myleases", 10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := i.GetLeasedID("", time.Minute); err == nil {
+		t.Fatal("Expected failure on missing owner")
+	}
+	if _, err := i.GetLeasedID("a", 0); err == nil {
+		t.Fatal("Expected failure on invalid ttl")
+	}
+
+	id, err := i.GetLeasedID("a", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 10 {
+		t.Fatalf("Unexpected id returned: %d", id)
+	}
+	if err := i.GetSpecificLeasedID(15, "b", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	// The owner obtains again the id it holds, another one cannot
+	if err := i.GetSpecificLeasedID(15, "b", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.GetSpecificLeasedID(15, "a", time.Hour); err == nil {
+		t.Fatal("Expected failure obtaining an id leased to another owner")
+	}
+	if err := i.RenewLease(15, "a", time.Hour); err == nil {
+		t.Fatal("Expected failure renewing the lease of another owner")
+	}
+	// Ids obtained without lease never expire
+	if err := i.GetSpecificID(16); err != nil {
+		t.Fatal(err)
+	}
+
+	leases := i.Leases()
+	if len(leases) != 2 || leases[0].ID != 10 || leases[0].Owner != "a" || leases[1].ID != 15 || leases[1].Owner != "b" {
+		t.Fatalf("Unexpected leases: %v", leases)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	expired, err := i.ReleaseExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0] != 10 {
+		t.Fatalf("Unexpected expired ids: %v", expired)
+	}
+	if err := i.GetSpecificID(10); err != nil {
+		t.Fatalf("Expired id was not released: %v", err)
+	}
+	if err := i.RenewLease(10, "a", time.Hour); err == nil {
+		t.Fatal("Expected failure renewing an expired lease")
+	}
+	if err := i.GetSpecificID(16); err == nil {
+		t.Fatal("Id obtained without lease was released")
+	}
+
+	i.Release(15)
+	if leases := i.Leases(); len(leases) != 0 {
+		t.Fatalf("Unexpected leases after release: %v", leases)
+	}
+}
+
+func TestLeasesReaper(t *testing.T) {
+	i, err := New(nil, "myreaper", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := i.GetLeasedID("a", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := i.StartReaper(5 * time.Millisecond)
+	defer stop()
+
+	for n := 0; n < 100 && len(i.Leases()) != 0; n++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(i.Leases()) != 0 {
+		t.Fatal("Expired lease was not reaped")
+	}
+	if err := i.GetSpecificID(id); err != nil {
+		t.Fatalf("Expired id was not released: %v", err)
+	}
+}
+
+func TestLeasesStore(t *testing.T) {
+	ds, err := randomLocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := New(ds, "mystoredleases", 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i.GetLeasedID("a", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	id, err := i.GetLeasedID("b", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another instance sees the leases and reaps the expired ones
+	j, err := New(ds, "mystoredleases", 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leases := j.Leases(); len(leases) != 2 {
+		t.Fatalf("Unexpected leases read from store: %v", leases)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if expired, err := j.ReleaseExpired(); err != nil || len(expired) != 1 || expired[0] != id {
+		t.Fatalf("Unexpected expired ids: %v (%v)", expired, err)
+	}
+
+	if leases := i.Leases(); len(leases) != 1 || leases[0].Owner != "a" {
+		t.Fatalf("Unexpected leases after reaping: %v", leases)
+	}
+	if err := i.GetSpecificID(id); err != nil {
+		t.Fatalf("Expired id was not released: %v", err)
+	}
+}
@@ -0,0 +1,420 @@
+package idm
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/bitseq"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/types"
+)
+
+// Lease is the holding of an id by an owner until the expiry time. The
+// owner renews the lease before it expires, an expired lease is released by
+// the reaper.
+type Lease struct {
+	ID     uint64
+	Owner  string
+	Expiry time.Time
+}
+
+// GetLeasedID returns the first available id in the set, leased to the
+// owner for the ttl
+func (i *Idm) GetLeasedID(owner string, ttl time.Duration) (uint64, error) {
+	if err := validateLease(owner, ttl); err != nil {
+		return 0, err
+	}
+	id, err := i.GetID()
+	if err != nil {
+		return id, err
+	}
+	if err := i.lease(id, owner, ttl, false); err != nil {
+		i.handle.Unset(id - i.start)
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetSpecificLeasedID tries to reserve the specified id, leased to the
+// owner for the ttl. If the owner already holds the lease of the id, the
+// lease is renewed.
+func (i *Idm) GetSpecificLeasedID(id uint64, owner string, ttl time.Duration) error {
+	if err := validateLease(owner, ttl); err != nil {
+		return err
+	}
+	if err := i.GetSpecificID(id); err != nil {
+		if err != bitseq.ErrBitAllocated || i.RenewLease(id, owner, ttl) != nil {
+			return err
+		}
+		return nil
+	}
+	if err := i.lease(id, owner, ttl, false); err != nil {
+		i.handle.Unset(id - i.start)
+		return err
+	}
+	return nil
+}
+
+// RenewLease extends by the ttl the lease of the id held by the owner
+func (i *Idm) RenewLease(id uint64, owner string, ttl time.Duration) error {
+	if err := validateLease(owner, ttl); err != nil {
+		return err
+	}
+	if i.handle == nil {
+		return fmt.Errorf("ID set is not initialized")
+	}
+	return i.lease(id, owner, ttl, true)
+}
+
+// Leases returns the leases of the set, ordered by id
+func (i *Idm) Leases() []Lease {
+	if i.leases == nil {
+		return nil
+	}
+	if err := i.leases.refresh(); err != nil {
+		logrus.Warnf("Failed to read the leases of id set %s: %v", i.leases.id, err)
+	}
+
+	i.leases.Lock()
+	list := make([]Lease, 0, len(i.leases.leases))
+	for _, l := range i.leases.leases {
+		list = append(list, *l)
+	}
+	i.leases.Unlock()
+
+	sort.Sort(leasesByID(list))
+	return list
+}
+
+// ReleaseExpired releases the ids whose lease expired and returns them
+func (i *Idm) ReleaseExpired() ([]uint64, error) {
+	if i.leases == nil {
+		return nil, nil
+	}
+
+	var expired []uint64
+	now := time.Now()
+	// The leases are dropped first, should the ids release fail they are
+	// leaked rather than handed out while still leased
+	err := i.leases.update(func(ls *leaseSet) error {
+		expired = expired[:0]
+		for id, l := range ls.leases {
+			if now.After(l.Expiry) {
+				expired = append(expired, id)
+				delete(ls.leases, id)
+			}
+		}
+		if len(expired) == 0 {
+			return errNoChange
+		}
+		return nil
+	})
+	if err == errNoChange {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(idList(expired))
+	for _, id := range expired {
+		if err := i.handle.Unset(id - i.start); err != nil {
+			logrus.Warnf("Failed to release id %d of expired lease: %v", id, err)
+		}
+	}
+
+	return expired, nil
+}
+
+// StartReaper releases the ids of the expired leases every interval, until
+// the returned function is called
+func (i *Idm) StartReaper(interval time.Duration) func() {
+	stopCh := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				expired, err := i.ReleaseExpired()
+				if err != nil {
+					logrus.Warnf("Failed to release the expired leases: %v", err)
+					continue
+				}
+				for _, id := range expired {
+					logrus.Infof("Released id %d of expired lease", id)
+				}
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(stopCh) }) }
+}
+
+// lease sets or, if renew is true, extends the lease of the id
+func (i *Idm) lease(id uint64, owner string, ttl time.Duration, renew bool) error {
+	return i.leases.update(func(ls *leaseSet) error {
+		if renew {
+			l, ok := ls.leases[id]
+			if !ok || l.Owner != owner {
+				return types.ForbiddenErrorf("id %d is not leased to %s", id, owner)
+			}
+		}
+		ls.leases[id] = &Lease{ID: id, Owner: owner, Expiry: time.Now().Add(ttl)}
+		return nil
+	})
+}
+
+// dropLease removes the lease of the released id, if any
+func (i *Idm) dropLease(id uint64) {
+	if i.leases == nil {
+		return
+	}
+	err := i.leases.update(func(ls *leaseSet) error {
+		if _, ok := ls.leases[id]; !ok {
+			return errNoChange
+		}
+		delete(ls.leases, id)
+		return nil
+	})
+	if err != nil && err != errNoChange {
+		logrus.Warnf("Failed to drop the lease of id %d: %v", id, err)
+	}
+}
+
+func validateLease(owner string, ttl time.Duration) error {
+	if owner == "" {
+		return types.BadRequestErrorf("missing lease owner")
+	}
+	if ttl <= 0 {
+		return types.BadRequestErrorf("invalid lease ttl %v", ttl)
+	}
+	return nil
+}
+
+type leasesByID []Lease
+
+func (l leasesByID) Len() int           { return len(l) }
+func (l leasesByID) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l leasesByID) Less(i, j int) bool { return l[i].ID < l[j].ID }
+
+type idList []uint64
+
+func (l idList) Len() int           { return len(l) }
+func (l idList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l idList) Less(i, j int) bool { return l[i] < l[j] }
+
+// errNoChange tells update there is nothing to write
+var errNoChange = fmt.Errorf("no change")
+
+// leaseSet holds the leases of an id set. It is stored next to the id set
+// bitseq handle.
+type leaseSet struct {
+	id       string
+	leases   map[uint64]*Lease
+	dbIndex  uint64
+	dbExists bool
+	store    datastore.DataStore
+	sync.Mutex
+}
+
+func newLeaseSet(ds datastore.DataStore, id string) *leaseSet {
+	return &leaseSet{id: id, store: ds, leases: make(map[uint64]*Lease)}
+}
+
+func (ls *leaseSet) getCopy() *leaseSet {
+	leases := make(map[uint64]*Lease, len(ls.leases))
+	for id, l := range ls.leases {
+		lc := *l
+		leases[id] = &lc
+	}
+	return &leaseSet{
+		id:       ls.id,
+		leases:   leases,
+		dbIndex:  ls.dbIndex,
+		dbExists: ls.dbExists,
+		store:    ls.store,
+	}
+}
+
+// refresh reads the leases from the datastore, if any
+func (ls *leaseSet) refresh() error {
+	ls.Lock()
+	store := ls.store
+	ls.Unlock()
+	if store == nil {
+		return nil
+	}
+	if err := store.GetObject(datastore.Key(ls.Key()...), ls); err != nil && err != datastore.ErrKeyNotFound {
+		return err
+	}
+	return nil
+}
+
+// update atomically applies the change to the leases and persists them
+func (ls *leaseSet) update(change func(nls *leaseSet) error) error {
+	for {
+		if err := ls.refresh(); err != nil {
+			return err
+		}
+
+		// Create a private copy of ls and work on it
+		ls.Lock()
+		nls := ls.getCopy()
+		ls.Unlock()
+
+		if err := change(nls); err != nil {
+			return err
+		}
+
+		// Attempt to write private copy to store
+		if err := nls.writeToStore(); err != nil {
+			if _, ok := err.(types.RetryError); !ok {
+				return fmt.Errorf("internal failure while updating the leases: %v", err)
+			}
+			// Retry
+			continue
+		}
+
+		ls.Lock()
+		ls.leases = nls.leases
+		ls.dbIndex = nls.dbIndex
+		ls.dbExists = nls.dbExists
+		ls.Unlock()
+		return nil
+	}
+}
+
+// Key provides the Key to be used in KV Store
+func (ls *leaseSet) Key() []string {
+	ls.Lock()
+	defer ls.Unlock()
+	return []string{"idm-lease", ls.id}
+}
+
+// KeyPrefix returns the immediate parent key that can be used for tree walk
+func (ls *leaseSet) KeyPrefix() []string {
+	return []string{"idm-lease"}
+}
+
+// Value marshals the data to be stored in the KV store
+func (ls *leaseSet) Value() []byte {
+	ls.Lock()
+	defer ls.Unlock()
+
+	list := make([]*Lease, 0, len(ls.leases))
+	for _, l := range ls.leases {
+		list = append(list, l)
+	}
+	b, err := json.Marshal(map[string]interface{}{"id": ls.id, "leases": list})
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// SetValue unmarshals the data from the KV store
+func (ls *leaseSet) SetValue(value []byte) error {
+	var m struct {
+		ID     string   `json:"id"`
+		Leases []*Lease `json:"leases"`
+	}
+	if err := json.Unmarshal(value, &m); err != nil {
+		return err
+	}
+
+	ls.Lock()
+	ls.id = m.ID
+	ls.leases = make(map[uint64]*Lease, len(m.Leases))
+	for _, l := range m.Leases {
+		ls.leases[l.ID] = l
+	}
+	ls.Unlock()
+
+	return nil
+}
+
+// Index returns the latest DB Index as seen by this object
+func (ls *leaseSet) Index() uint64 {
+	ls.Lock()
+	defer ls.Unlock()
+	return ls.dbIndex
+}
+
+// SetIndex method allows the datastore to store the latest DB Index into this object
+func (ls *leaseSet) SetIndex(index uint64) {
+	ls.Lock()
+	ls.dbIndex = index
+	ls.dbExists = true
+	ls.Unlock()
+}
+
+// Exists method is true if this object has been stored in the DB.
+func (ls *leaseSet) Exists() bool {
+	ls.Lock()
+	defer ls.Unlock()
+	return ls.dbExists
+}
+
+// New method returns a lease set based on the receiver one
+func (ls *leaseSet) New() datastore.KVObject {
+	ls.Lock()
+	defer ls.Unlock()
+
+	return &leaseSet{store: ls.store}
+}
+
+// CopyTo deep copies the lease set into the passed destination object
+func (ls *leaseSet) CopyTo(o datastore.KVObject) error {
+	ls.Lock()
+	defer ls.Unlock()
+
+	dstLs := o.(*leaseSet)
+	if ls == dstLs {
+		return nil
+	}
+	nls := ls.getCopy()
+	dstLs.Lock()
+	dstLs.id = nls.id
+	dstLs.leases = nls.leases
+	dstLs.dbIndex = nls.dbIndex
+	dstLs.dbExists = nls.dbExists
+	dstLs.store = nls.store
+	dstLs.Unlock()
+
+	return nil
+}
+
+// Skip provides a way for a KV Object to avoid persisting it in the KV Store
+func (ls *leaseSet) Skip() bool {
+	return false
+}
+
+// DataScope method returns the storage scope of the datastore
+func (ls *leaseSet) DataScope() string {
+	ls.Lock()
+	defer ls.Unlock()
+
+	return ls.store.Scope()
+}
+
+func (ls *leaseSet) writeToStore() error {
+	ls.Lock()
+	store := ls.store
+	ls.Unlock()
+	if store == nil {
+		return nil
+	}
+	err := store.PutObjectAtomic(ls)
+	if err == datastore.ErrKeyModified {
+		return types.RetryErrorf("failed to perform atomic write (%v). Retry might fix the error", err)
+	}
+	return err
+}
@@ -2,9 +2,12 @@ package ipam
 
 import (
 	"fmt"
+	"math"
+	"math/rand"
 	"net"
 	"sort"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/bitseq"
@@ -21,9 +24,18 @@ const (
 	// The biggest configurable host subnets
 	minNetSize   = 8
 	minNetSizeV6 = 64
+	// The smallest pool host part, in bits, tracked by a sparse set rather
+	// than by a bitmask
+	sparseMinHostBits = 32
 	// datastore keyes for ipam objects
 	dsConfigKey = "ipam/" + ipamapi.DefaultIPAM + "/config"
 	dsDataKey   = "ipam/" + ipamapi.DefaultIPAM + "/data"
+	dsSparseKey = "ipam/" + ipamapi.DefaultIPAM + "/sparse"
+)
+
+var (
+	rndMu sync.Mutex
+	rnd   = rand.New(rand.NewSource(time.Now().UnixNano()))
 )
 
 // Allocator provides per address space ipv4/ipv6 book keeping
@@ -33,10 +45,31 @@ type Allocator struct {
 	addrSpaces map[string]*addrSpace
 	// stores        []datastore.Datastore
 	// Allocated addresses in each address space's subnet
-	addresses map[SubnetKey]*bitseq.Handle
+	addresses map[SubnetKey]addrSet
 	sync.Mutex
 }
 
+// addrSet keeps track of the addresses allocated from a pool by their
+// ordinal in the pool. It is implemented by the bitseq handle and, for the
+// very large IPv6 pools, by the sparse set.
+type addrSet interface {
+	SetAny() (uint64, error)
+	SetAnyInRange(start, end uint64) (uint64, error)
+	SetAnyInRangeFrom(start, end, from uint64) (uint64, error)
+	SetAnySerialInRange(start, end uint64) (uint64, error)
+	Set(ordinal uint64) error
+	Unset(ordinal uint64) error
+	SetRange(start, end uint64) error
+	UnsetRange(start, end uint64) error
+	IsSet(ordinal uint64) bool
+	WalkSet(start, end uint64, walker func(ordinal uint64) bool)
+	Bits() uint64
+	Unselected() uint64
+	CheckConsistency() error
+	Destroy() error
+	String() string
+}
+
 // NewAllocator returns an instance of libnetwork ipam
 func NewAllocator(lcDs, glDs datastore.DataStore) (*Allocator, error) {
 	a := &Allocator{}
@@ -48,7 +81,7 @@ func NewAllocator(lcDs, glDs datastore.DataStore) (*Allocator, error) {
 	}
 
 	// Initialize bitseq map
-	a.addresses = make(map[SubnetKey]*bitseq.Handle)
+	a.addresses = make(map[SubnetKey]addrSet)
 
 	// Initialize address spaces
 	a.addrSpaces = make(map[string]*addrSpace)
@@ -154,11 +187,12 @@ func (a *Allocator) initializeAddressSpace(as string, ds datastore.DataStore) er
 		}
 	}
 	a.addrSpaces[as] = &addrSpace{
-		subnets: map[SubnetKey]*PoolData{},
-		id:      dsConfigKey + "/" + as,
-		scope:   scope,
-		ds:      ds,
-		alloc:   a,
+		subnets:      map[SubnetKey]*PoolData{},
+		reservations: map[string]*ipamapi.Reservation{},
+		id:           dsConfigKey + "/" + as,
+		scope:        scope,
+		ds:           ds,
+		alloc:        a,
 	}
 	a.Unlock()
 
@@ -199,6 +233,11 @@ func (a *Allocator) GetDefaultAddressSpaces() (string, string, error) {
 // RequestPool returns an address pool along with its unique id.
 func (a *Allocator) RequestPool(addressSpace, pool, subPool string, options map[string]string, v6 bool) (string, *net.IPNet, map[string]string, error) {
 	log.Debugf("RequestPool(%s, %s, %s, %v, %t)", addressSpace, pool, subPool, options, v6)
+	strategy, err := parseStrategy(options)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
 retry:
 	k, nw, ipr, pdf, err := a.parsePoolRequest(addressSpace, pool, subPool, v6)
 	if err != nil {
@@ -214,7 +253,7 @@ retry:
 		return "", nil, nil, err
 	}
 
-	insert, err := aSpace.updatePoolDBOnAdd(*k, nw, ipr, pdf)
+	insert, err := aSpace.updatePoolDBOnAdd(*k, nw, ipr, strategy, pdf)
 	if err != nil {
 		if _, ok := err.(types.MaskableError); ok {
 			log.Debugf("Retrying predefined pool search: %v", err)
@@ -327,8 +366,18 @@ func (a *Allocator) insertBitMask(key SubnetKey, pool *net.IPNet) error {
 		numAddresses--
 	}
 
-	// Generate the new address masks. AddressMask content may come from datastore
-	h, err := bitseq.NewHandle(dsDataKey, store, key.String(), numAddresses)
+	// Generate the new address masks. AddressMask content may come from datastore.
+	// The very large pools are tracked by a sparse set, unless they were
+	// created with a bitmask.
+	var (
+		h   addrSet
+		err error
+	)
+	if ipVer == v6 && bits-ones >= sparseMinHostBits && !hasBitmask(store, key) {
+		h, err = newSparseSet(dsSparseKey, store, key.String(), numAddresses)
+	} else {
+		h, err = bitseq.NewHandle(dsDataKey, store, key.String(), numAddresses)
+	}
 	if err != nil {
 		return err
 	}
@@ -348,7 +397,16 @@ func (a *Allocator) insertBitMask(key SubnetKey, pool *net.IPNet) error {
 	return nil
 }
 
-func (a *Allocator) retrieveBitmask(k SubnetKey, n *net.IPNet) (*bitseq.Handle, error) {
+// hasBitmask returns whether the bitmask of the pool is in the datastore
+func hasBitmask(store datastore.DataStore, key SubnetKey) bool {
+	if store == nil || store.KVStore() == nil {
+		return false
+	}
+	ok, err := store.KVStore().Exists(datastore.Key(dsDataKey, key.String()))
+	return err == nil && ok
+}
+
+func (a *Allocator) retrieveBitmask(k SubnetKey, n *net.IPNet) (addrSet, error) {
 	a.Lock()
 	bm, ok := a.addresses[k]
 	a.Unlock()
@@ -374,6 +432,29 @@ func (a *Allocator) getPredefineds(as string) []*net.IPNet {
 	return l
 }
 
+// SetPredefinedPools replaces the predefined pools of the passed default
+// address space. The pools are carved out in the given order by the
+// requests which do not specify a pool.
+func (a *Allocator) SetPredefinedPools(as string, pools []*net.IPNet) error {
+	if as != localAddressSpace && as != globalAddressSpace {
+		return types.BadRequestErrorf("predefined pools can only be set for the default address spaces")
+	}
+
+	l := make([]*net.IPNet, 0, len(pools))
+	for _, pool := range pools {
+		if pool == nil {
+			return types.BadRequestErrorf("invalid nil predefined pool for address space %s", as)
+		}
+		l = append(l, types.GetIPNetCanonical(pool))
+	}
+
+	a.Lock()
+	a.predefined[as] = l
+	a.Unlock()
+
+	return nil
+}
+
 func (a *Allocator) getPredefinedPool(as string, ipV6 bool) (*net.IPNet, error) {
 	var v ipVersion
 	v = v4
@@ -450,7 +531,7 @@ func (a *Allocator) RequestAddress(poolID string, prefAddress net.IP, opts map[s
 		return nil, nil, types.InternalErrorf("could not find bitmask in datastore for %s on address %v request from pool %s: %v",
 			k.String(), prefAddress, poolID, err)
 	}
-	ip, err := a.getAddress(p.Pool, bm, prefAddress, p.Range)
+	ip, err := a.getAddress(p.Pool, bm, prefAddress, p.Range, p.strategy())
 	if err != nil {
 		return nil, nil, err
 	}
@@ -492,6 +573,11 @@ func (a *Allocator) ReleaseAddress(poolID string, address net.IP) error {
 		return ipamapi.ErrIPOutOfRange
 	}
 
+	if rsv := aSpace.reservationFor(address); rsv != nil {
+		aSpace.Unlock()
+		return types.ForbiddenErrorf("address %s is held by reservation %s", address, rsv.Name)
+	}
+
 	c := p
 	for c.Range != nil {
 		k = c.ParentKey
@@ -515,7 +601,7 @@ func (a *Allocator) ReleaseAddress(poolID string, address net.IP) error {
 	return bm.Unset(ipToUint64(h))
 }
 
-func (a *Allocator) getAddress(nw *net.IPNet, bitmask *bitseq.Handle, prefAddress net.IP, ipr *AddressRange) (net.IP, error) {
+func (a *Allocator) getAddress(nw *net.IPNet, bitmask addrSet, prefAddress net.IP, ipr *AddressRange, strategy string) (net.IP, error) {
 	var (
 		ordinal uint64
 		err     error
@@ -527,17 +613,30 @@ func (a *Allocator) getAddress(nw *net.IPNet, bitmask *bitseq.Handle, prefAddres
 	if bitmask.Unselected() <= 0 {
 		return nil, ipamapi.ErrNoAvailableIPs
 	}
-	if ipr == nil && prefAddress == nil {
-		ordinal, err = bitmask.SetAny()
-	} else if prefAddress != nil {
+	if prefAddress == nil {
+		start, end := uint64(0), bitmask.Bits()-1
+		if ipr != nil {
+			start, end = ipr.Start, ipr.End
+		}
+		switch strategy {
+		case ipamapi.StrategySequential:
+			ordinal, err = bitmask.SetAnySerialInRange(start, end)
+		case ipamapi.StrategyRandom:
+			ordinal, err = bitmask.SetAnyInRangeFrom(start, end, randomOrdinal(start, end))
+		default:
+			if ipr == nil {
+				ordinal, err = bitmask.SetAny()
+			} else {
+				ordinal, err = bitmask.SetAnyInRange(start, end)
+			}
+		}
+	} else {
 		hostPart, e := types.GetHostPartIP(prefAddress, base.Mask)
 		if e != nil {
 			return nil, types.InternalErrorf("failed to allocate requested address %s: %v", prefAddress.String(), e)
 		}
 		ordinal = ipToUint64(types.GetMinimalIP(hostPart))
 		err = bitmask.Set(ordinal)
-	} else {
-		ordinal, err = bitmask.SetAnyInRange(ipr.Start, ipr.End)
 	}
 
 	switch err {
@@ -553,6 +652,33 @@ func (a *Allocator) getAddress(nw *net.IPNet, bitmask *bitseq.Handle, prefAddres
 	}
 }
 
+// parseStrategy returns the address allocation strategy requested in the
+// pool options
+func parseStrategy(options map[string]string) (string, error) {
+	s, ok := options[ipamapi.AllocationStrategy]
+	if !ok {
+		return "", nil
+	}
+	switch s {
+	case ipamapi.StrategyLowest, ipamapi.StrategySequential, ipamapi.StrategyRandom:
+		return s, nil
+	default:
+		return "", types.BadRequestErrorf("invalid address allocation strategy: %q", s)
+	}
+}
+
+// randomOrdinal returns a random ordinal between start and end included
+func randomOrdinal(start, end uint64) uint64 {
+	rndMu.Lock()
+	r := uint64(rnd.Int63())<<1 | uint64(rnd.Int63()&1)
+	rndMu.Unlock()
+
+	if n := end - start; n != math.MaxUint64 {
+		r %= n + 1
+	}
+	return start + r
+}
+
 // DumpDatabase dumps the internal info
 func (a *Allocator) DumpDatabase() string {
 	a.Lock()
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"net"
 	"strconv"
@@ -476,6 +477,58 @@ func TestPredefinedPool(t *testing.T) {
 	}
 }
 
+func TestSetPredefinedPools(t *testing.T) {
+	a, err := getAllocator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.getPredefinedPool(localAddressSpace, true); err == nil {
+		t.Fatalf("Expected failure for IPv6 predefined pool with default configuration")
+	}
+
+	pools, err := ipamutils.SplitPools([]*ipamutils.PredefinedPool{
+		{Base: "172.80.0.0/23", Size: 24},
+		{Base: "fd00:80::/63", Size: 64},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.SetPredefinedPools("blue", pools); err == nil {
+		t.Fatalf("Expected failure for non default addr space")
+	}
+	if err := a.SetPredefinedPools(localAddressSpace, pools); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		v6       bool
+		expected []string
+	}{
+		{false, []string{"172.80.0.0/24", "172.80.1.0/24"}},
+		{true, []string{"fd00:80::/64", "fd00:80:0:1::/64"}},
+	} {
+		for _, expected := range tc.expected {
+			_, nw, _, err := a.RequestPool(localAddressSpace, "", "", nil, tc.v6)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nw.String() != expected {
+				t.Fatalf("Unexpected predefined pool. Expected %s. Got %s", expected, nw)
+			}
+		}
+		if _, _, _, err := a.RequestPool(localAddressSpace, "", "", nil, tc.v6); err == nil {
+			t.Fatalf("Expected failure on exhausted predefined pools")
+		}
+	}
+
+	// The other default address space keeps the built-in pools
+	if _, nw, _, err := a.RequestPool(globalAddressSpace, "", "", nil, false); err != nil || nw.String() != "10.0.0.0/24" {
+		t.Fatalf("Unexpected global predefined pool: %v (%v)", nw, err)
+	}
+}
+
 func TestRemoveSubnet(t *testing.T) {
 	a, err := getAllocator()
 	if err != nil {
@@ -695,6 +748,477 @@ func TestRequestReleaseAddressFromSubPool(t *testing.T) {
 	}
 }
 
+func TestPoolsUsage(t *testing.T) {
+	a, err := getAllocator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid, _, _, err := a.RequestPool(localAddressSpace, "172.28.0.0/24", "", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spid, _, _, err := a.RequestPool(localAddressSpace, "172.29.0.0/16", "172.29.30.0/28", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := a.RequestAddress(pid, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := a.RequestAddress(spid, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := a.PoolsUsage(localAddressSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage := make(map[string]*ipamapi.PoolUsage, len(list))
+	for _, u := range list {
+		usage[u.PoolID] = u
+	}
+
+	u, ok := usage[pid]
+	if !ok {
+		t.Fatalf("Missing usage of pool %s", pid)
+	}
+	// Network and broadcast addresses are reserved
+	if u.Total != 256 || u.Allocated != 5 || u.Free != 251 || u.SubPool != nil {
+		t.Fatalf("Unexpected usage of pool %s: %+v", pid, u)
+	}
+
+	u, ok = usage[spid]
+	if !ok {
+		t.Fatalf("Missing usage of pool %s", spid)
+	}
+	if u.Total != 16 || u.Allocated != 1 || u.Free != 15 || u.SubPool.String() != "172.29.30.0/28" {
+		t.Fatalf("Unexpected usage of pool %s: %+v", spid, u)
+	}
+
+	ppid := SubnetKey{AddressSpace: localAddressSpace, Subnet: "172.29.0.0/16"}
+	u, ok = usage[ppid.String()]
+	if !ok {
+		t.Fatalf("Missing usage of parent pool %s", ppid.String())
+	}
+	if u.Total != 65536 || u.Allocated != 3 {
+		t.Fatalf("Unexpected usage of pool %s: %+v", ppid.String(), u)
+	}
+
+	var addrs []string
+	if err := a.WalkAllocatedAddresses(pid, func(ip net.IP) bool {
+		addrs = append(addrs, ip.String())
+		return false
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"172.28.0.0", "172.28.0.1", "172.28.0.2", "172.28.0.3", "172.28.0.255"}
+	if fmt.Sprintf("%v", addrs) != fmt.Sprintf("%v", expected) {
+		t.Fatalf("Unexpected allocated addresses. Expected %v. Got %v", expected, addrs)
+	}
+
+	addrs = nil
+	if err := a.WalkAllocatedAddresses(spid, func(ip net.IP) bool {
+		addrs = append(addrs, ip.String())
+		return false
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"172.29.30.0"}
+	if fmt.Sprintf("%v", addrs) != fmt.Sprintf("%v", expected) {
+		t.Fatalf("Unexpected allocated addresses. Expected %v. Got %v", expected, addrs)
+	}
+
+	// The walk stops when asked to
+	addrs = nil
+	if err := a.WalkAllocatedAddresses(pid, func(ip net.IP) bool {
+		addrs = append(addrs, ip.String())
+		return len(addrs) == 2
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 {
+		t.Fatalf("Walk did not stop. Got %v", addrs)
+	}
+
+	if err := a.WalkAllocatedAddresses("bogus", func(net.IP) bool { return false }); err == nil {
+		t.Fatal("Expected failure for invalid pool id")
+	}
+}
+
+func TestReservations(t *testing.T) {
+	a, err := getAllocator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid, _, _, err := a.RequestPool(localAddressSpace, "10.10.0.0/24", "", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.RequestAddress(pid, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	vip := &ipamapi.Reservation{Name: "vip", Owner: "lb", Label: "load balancer VIP",
+		Start: net.ParseIP("10.10.0.2"), End: net.ParseIP("10.10.0.4")}
+	if err := a.Reserve(localAddressSpace, vip); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Reserve(localAddressSpace, vip); err == nil {
+		t.Fatal("Expected failure on duplicated reservation")
+	}
+	if err := a.Reserve(localAddressSpace, &ipamapi.Reservation{Name: "other", Start: net.ParseIP("10.10.0.4")}); err == nil {
+		t.Fatal("Expected failure on overlapping reservation")
+	}
+	if err := a.Reserve(localAddressSpace, &ipamapi.Reservation{Name: "taken", Start: net.ParseIP("10.10.0.1")}); err == nil {
+		t.Fatal("Expected failure on reservation of an allocated address")
+	}
+	if err := a.Reserve(localAddressSpace, &ipamapi.Reservation{Name: "huge", Start: net.ParseIP("10.0.0.0"), End: net.ParseIP("10.255.255.255")}); err == nil {
+		t.Fatal("Expected failure on oversized reservation")
+	}
+
+	// Reserved addresses are skipped by the dynamic allocation
+	ip, _, err := a.RequestAddress(pid, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.IP.String() != "10.10.0.5" {
+		t.Fatalf("Unexpected address %s", ip)
+	}
+	if _, _, err := a.RequestAddress(pid, net.ParseIP("10.10.0.3"), nil); err == nil {
+		t.Fatal("Expected failure on request of a reserved address")
+	}
+	if err := a.ReleaseAddress(pid, net.ParseIP("10.10.0.3")); err == nil {
+		t.Fatal("Expected failure on release of a reserved address")
+	}
+
+	// Reservations apply to the pools added later
+	db := &ipamapi.Reservation{Name: "db", Owner: "ops", Label: "reserved for DB", Start: net.ParseIP("10.20.0.10")}
+	if err := a.Reserve(localAddressSpace, db); err != nil {
+		t.Fatal(err)
+	}
+	pid2, _, _, err := a.RequestPool(localAddressSpace, "10.20.0.0/24", "", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.RequestAddress(pid2, net.ParseIP("10.20.0.10"), nil); err == nil {
+		t.Fatal("Expected failure on request of a reserved address")
+	}
+
+	// Reservations are persisted with the address space
+	b, err := NewAllocator(a.addrSpaces[localAddressSpace].ds, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := b.Reservations(localAddressSpace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != "db" || list[1].Name != "vip" {
+		t.Fatalf("Unexpected reservations: %v", list)
+	}
+	if list[1].Owner != "lb" || list[1].Label != "load balancer VIP" ||
+		!list[1].Start.Equal(vip.Start) || !list[1].End.Equal(vip.End) {
+		t.Fatalf("Unexpected reservation: %+v", list[1])
+	}
+	if !list[0].End.Equal(db.Start) {
+		t.Fatalf("Unexpected reservation end address: %+v", list[0])
+	}
+
+	if err := b.Unreserve(localAddressSpace, "vip"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Unreserve(localAddressSpace, "vip"); err == nil {
+		t.Fatal("Expected failure on release of an unknown reservation")
+	}
+	if _, _, err := b.RequestAddress(pid, net.ParseIP("10.10.0.3"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reservations are rejected in an address space which is not persisted
+	c, err := NewAllocator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Reserve(localAddressSpace, &ipamapi.Reservation{Name: "vip", Start: net.ParseIP("10.10.0.2")})
+	if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Expected a forbidden error on a reservation in a volatile address space, got: %v", err)
+	}
+}
+
+func TestAllocationStrategies(t *testing.T) {
+	a, err := getAllocator()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := a.RequestPool(localAddressSpace, "10.30.0.0/24", "", map[string]string{ipamapi.AllocationStrategy: "bogus"}, false); err == nil {
+		t.Fatal("Expected failure on invalid allocation strategy")
+	}
+
+	opts := map[string]string{ipamapi.AllocationStrategy: ipamapi.StrategySequential}
+	pid, _, _, err := a.RequestPool(localAddressSpace, "10.30.0.0/24", "", opts, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		ip, _, err := a.RequestAddress(pid, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf("10.30.0.%d", i); ip.IP.String() != expected {
+			t.Fatalf("Unexpected address. Expected %s. Got %s", expected, ip.IP)
+		}
+	}
+
+	// A released address is not handed out again right away
+	if err := a.ReleaseAddress(pid, net.ParseIP("10.30.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	ip, _, err := a.RequestAddress(pid, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.IP.String() != "10.30.0.4" {
+		t.Fatalf("Unexpected address. Expected 10.30.0.4. Got %s", ip.IP)
+	}
+
+	// The strategy and the cursor are persisted
+	b, err := NewAllocator(a.addrSpaces[localAddressSpace].ds, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, _, err = b.RequestAddress(pid, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.IP.String() != "10.30.0.5" {
+		t.Fatalf("Unexpected address. Expected 10.30.0.5. Got %s", ip.IP)
+	}
+
+	// Sequential allocation from a sub pool
+	spid, _, _, err := a.RequestPool(localAddressSpace, "10.32.0.0/16", "10.32.1.0/30", opts, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last net.IP
+	for i := 0; i < 4; i++ {
+		ip, _, err := a.RequestAddress(spid, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last = ip.IP
+	}
+	if err := a.ReleaseAddress(spid, net.ParseIP("10.32.1.1")); err != nil {
+		t.Fatal(err)
+	}
+	if last.String() != "10.32.1.3" {
+		t.Fatalf("Unexpected address. Expected 10.32.1.3. Got %s", last)
+	}
+	ip, _, err = a.RequestAddress(spid, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.IP.String() != "10.32.1.1" {
+		t.Fatalf("Unexpected address after wrap around. Expected 10.32.1.1. Got %s", ip.IP)
+	}
+
+	opts = map[string]string{ipamapi.AllocationStrategy: ipamapi.StrategyRandom}
+	rpid, _, _, err := a.RequestPool(localAddressSpace, "10.31.0.0/16", "", opts, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	lowest := true
+	for i := 1; i <= 20; i++ {
+		ip, _, err := a.RequestAddress(rpid, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[ip.IP.String()] {
+			t.Fatalf("Address %s allocated twice", ip.IP)
+		}
+		seen[ip.IP.String()] = true
+		if ip.IP.String() != fmt.Sprintf("10.31.0.%d", i) {
+			lowest = false
+		}
+	}
+	if lowest {
+		t.Fatal("Random strategy allocated the lowest addresses")
+	}
+}
+
+func TestSparseSet(t *testing.T) {
+	ds, err := randomLocalStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newSparseSet(dsSparseKey, ds, "test", math.MaxUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := uint64(0); i < 3; i++ {
+		o, err := s.SetAny()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o != i {
+			t.Fatalf("Unexpected ordinal. Expected %d. Got %d", i, o)
+		}
+	}
+	if err := s.Set(1); err != bitseq.ErrBitAllocated {
+		t.Fatalf("Expected ErrBitAllocated. Got %v", err)
+	}
+	if err := s.Set(math.MaxUint64 - 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Unset(1); err != nil {
+		t.Fatal(err)
+	}
+	if s.IsSet(1) || !s.IsSet(2) {
+		t.Fatalf("Unexpected set state: %s", s)
+	}
+	if s.Unselected() != math.MaxUint64-3 {
+		t.Fatalf("Unexpected number of unselected ordinals: %d", s.Unselected())
+	}
+
+	// The search from the last ordinal wraps around
+	o, err := s.SetAnyInRangeFrom(0, math.MaxUint64-1, math.MaxUint64-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o != 1 {
+		t.Fatalf("Unexpected ordinal. Expected 1. Got %d", o)
+	}
+
+	for _, expected := range []uint64{10, 11} {
+		o, err := s.SetAnySerialInRange(10, 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o != expected {
+			t.Fatalf("Unexpected ordinal. Expected %d. Got %d", expected, o)
+		}
+	}
+	if err := s.Unset(10); err != nil {
+		t.Fatal(err)
+	}
+
+	// The state is persisted
+	s, err = newSparseSet(dsSparseKey, ds, "test", math.MaxUint64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err = s.SetAnySerialInRange(10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o != 12 {
+		t.Fatalf("Unexpected ordinal. Expected 12. Got %d", o)
+	}
+
+	var set []uint64
+	s.WalkSet(0, math.MaxUint64, func(o uint64) bool {
+		set = append(set, o)
+		return false
+	})
+	if fmt.Sprintf("%v", set) != fmt.Sprintf("%v", []uint64{0, 1, 2, 11, 12, math.MaxUint64 - 1}) {
+		t.Fatalf("Unexpected set ordinals: %v", set)
+	}
+
+	if err := s.SetRange(10, 13); err != bitseq.ErrBitAllocated {
+		t.Fatalf("Expected ErrBitAllocated. Got %v", err)
+	}
+	if err := s.SetRange(13, 16); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UnsetRange(2, 14); err != nil {
+		t.Fatal(err)
+	}
+	set = nil
+	s.WalkSet(0, 20, func(o uint64) bool {
+		set = append(set, o)
+		return false
+	})
+	if fmt.Sprintf("%v", set) != fmt.Sprintf("%v", []uint64{0, 1, 15, 16}) {
+		t.Fatalf("Unexpected set ordinals after range operations: %v", set)
+	}
+
+	if err := s.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLargeIPv6Pools(t *testing.T) {
+	a, err := getAllocator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := a.addrSpaces[localAddressSpace].ds
+
+	// A pool created with a bitmask keeps it
+	legacy := SubnetKey{AddressSpace: localAddressSpace, Subnet: "2001:db8:1::/64"}
+	if _, err := bitseq.NewHandle(dsDataKey, ds, legacy.String(), math.MaxUint64); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		pool   string
+		sparse bool
+	}{
+		{"2001:db8::/48", true},
+		{"2001:db8:1::/64", false},
+		{"2001:db8:2::/64", true},
+		{"2001:db8:3::/112", false},
+	} {
+		pid, _, _, err := a.RequestPool(localAddressSpace, tc.pool, "", map[string]string{ipamapi.AllocationStrategy: ipamapi.StrategyRandom}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k := SubnetKey{}
+		k.FromString(pid)
+		bm, err := a.retrieveBitmask(k, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := bm.(*sparseSet); ok != tc.sparse {
+			t.Fatalf("Unexpected backend for pool %s: %T", tc.pool, bm)
+		}
+
+		_, nw, _ := net.ParseCIDR(tc.pool)
+		allocated := make(map[string]bool)
+		for i := 0; i < 50; i++ {
+			ip, _, err := a.RequestAddress(pid, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !nw.Contains(ip.IP) || allocated[ip.IP.String()] {
+				t.Fatalf("Unexpected address %s from pool %s", ip.IP, tc.pool)
+			}
+			allocated[ip.IP.String()] = true
+		}
+
+		ip := generateAddress(12345, nw)
+		if _, _, err := a.RequestAddress(pid, ip, nil); err != nil && !allocated[ip.String()] {
+			t.Fatal(err)
+		}
+		if err := a.ReleaseAddress(pid, ip); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := a.RequestAddress(pid, ip, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.ReleasePool(pid); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func TestGetAddress(t *testing.T) {
 	input := []string{
 		/*"10.0.0.0/8", "10.0.0.0/9", "10.0.0.0/10",*/ "10.0.0.0/11", "10.0.0.0/12", "10.0.0.0/13", "10.0.0.0/14",
@@ -900,7 +1424,7 @@ func assertGetAddress(t *testing.T, subnet string) {
 	start := time.Now()
 	run := 0
 	for err != ipamapi.ErrNoAvailableIPs {
-		_, err = a.getAddress(sub, bm, nil, nil)
+		_, err = a.getAddress(sub, bm, nil, nil, ipamapi.StrategyLowest)
 		run++
 	}
 	if printTime {
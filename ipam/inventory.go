@@ -0,0 +1,144 @@
+package ipam
+
+import (
+	"net"
+	"sort"
+
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/types"
+)
+
+// PoolsUsage returns the utilization of the pools of the passed address
+// space, or of all the address spaces if none is specified
+func (a *Allocator) PoolsUsage(addressSpace string) ([]*ipamapi.PoolUsage, error) {
+	var spaces []string
+	if addressSpace != "" {
+		spaces = []string{addressSpace}
+	} else {
+		a.Lock()
+		for as := range a.addrSpaces {
+			spaces = append(spaces, as)
+		}
+		a.Unlock()
+		sort.Strings(spaces)
+	}
+
+	var list []*ipamapi.PoolUsage
+	for _, as := range spaces {
+		if err := a.refresh(as); err != nil {
+			return nil, err
+		}
+
+		aSpace, err := a.getAddrSpace(as)
+		if err != nil {
+			return nil, err
+		}
+
+		aSpace.Lock()
+		keys := make([]SubnetKey, 0, len(aSpace.subnets))
+		pools := make(map[SubnetKey]PoolData, len(aSpace.subnets))
+		for k, p := range aSpace.subnets {
+			keys = append(keys, k)
+			pools[k] = *p
+		}
+		aSpace.Unlock()
+		sort.Sort(subnetKeys(keys))
+
+		for _, k := range keys {
+			p := pools[k]
+			u := &ipamapi.PoolUsage{
+				AddressSpace: as,
+				PoolID:       k.String(),
+				Pool:         types.GetIPNetCopy(p.Pool),
+				RefCount:     p.RefCount,
+			}
+
+			bmKey := k
+			if p.Range != nil {
+				bmKey = p.ParentKey
+			}
+			bm, err := a.retrieveBitmask(bmKey, p.Pool)
+			if err != nil {
+				return nil, err
+			}
+
+			if p.Range == nil {
+				u.Total = bm.Bits()
+				u.Free = bm.Unselected()
+				u.Allocated = u.Total - u.Free
+			} else {
+				u.SubPool = types.GetIPNetCopy(p.Range.Sub)
+				u.Total = p.Range.End - p.Range.Start + 1
+				bm.WalkSet(p.Range.Start, p.Range.End, func(uint64) bool {
+					u.Allocated++
+					return false
+				})
+				u.Free = u.Total - u.Allocated
+			}
+
+			list = append(list, u)
+		}
+	}
+
+	return list, nil
+}
+
+// WalkAllocatedAddresses invokes the walker, in ascending order, for each
+// address allocated from the pool identified by the passed id. The walk
+// stops when the walker returns true.
+func (a *Allocator) WalkAllocatedAddresses(poolID string, walker func(net.IP) bool) error {
+	k := SubnetKey{}
+	if err := k.FromString(poolID); err != nil {
+		return types.BadRequestErrorf("invalid pool id: %s", poolID)
+	}
+
+	if err := a.refresh(k.AddressSpace); err != nil {
+		return err
+	}
+
+	aSpace, err := a.getAddrSpace(k.AddressSpace)
+	if err != nil {
+		return err
+	}
+
+	aSpace.Lock()
+	p, ok := aSpace.subnets[k]
+	if !ok {
+		aSpace.Unlock()
+		return types.NotFoundErrorf("cannot find address pool for poolID:%s", poolID)
+	}
+	pool := types.GetIPNetCopy(p.Pool)
+	ipr := p.Range
+	if ipr != nil {
+		k = p.ParentKey
+	}
+	aSpace.Unlock()
+
+	bm, err := a.retrieveBitmask(k, pool)
+	if err != nil {
+		return err
+	}
+
+	start, end := uint64(0), bm.Bits()-1
+	if ipr != nil {
+		start, end = ipr.Start, ipr.End
+	}
+	walkAddresses(bm, pool, start, end, walker)
+
+	return nil
+}
+
+func walkAddresses(bm addrSet, pool *net.IPNet, start, end uint64, walker func(net.IP) bool) {
+	bm.WalkSet(start, end, func(ordinal uint64) bool {
+		return walker(generateAddress(ordinal, pool))
+	})
+}
+
+// subnetKeys sorts the pools by address space, subnet and child subnet
+type subnetKeys []SubnetKey
+
+func (s subnetKeys) Len() int      { return len(s) }
+func (s subnetKeys) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s subnetKeys) Less(i, j int) bool {
+	return s[i].String() < s[j].String()
+}
@@ -0,0 +1,390 @@
+package ipam
+
+import (
+	"bytes"
+	"net"
+	"sort"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/bitseq"
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/types"
+)
+
+// The biggest number of addresses a reservation can hold
+const maxReservationSize = 1 << 16
+
+// Reserve holds the addresses of the reservation out of the dynamic
+// allocation. The addresses already allocated from the pools of the
+// address space cannot be reserved. The reservation also applies to the
+// pools later added to the address space. Reservations are only accepted
+// on the address spaces backed by a datastore, as they would otherwise not
+// survive a restart.
+func (a *Allocator) Reserve(addressSpace string, r *ipamapi.Reservation) error {
+	log.Debugf("Reserve(%s, %+v)", addressSpace, r)
+	rsv, err := validateReservation(r)
+	if err != nil {
+		return err
+	}
+
+retry:
+	if err := a.refresh(addressSpace); err != nil {
+		return err
+	}
+
+	aSpace, err := a.getAddrSpace(addressSpace)
+	if err != nil {
+		return err
+	}
+	if aSpace.store() == nil {
+		return types.ForbiddenErrorf("reservations are not supported in address space %s as it is not persisted", addressSpace)
+	}
+
+	aSpace.Lock()
+	if _, ok := aSpace.reservations[rsv.Name]; ok {
+		aSpace.Unlock()
+		return types.ForbiddenErrorf("reservation %s already exists in address space %s", rsv.Name, addressSpace)
+	}
+	for _, o := range aSpace.reservations {
+		if reservationsOverlap(o, rsv) {
+			aSpace.Unlock()
+			return types.ForbiddenErrorf("reservation %s overlaps with reservation %s", rsv.Name, o.Name)
+		}
+	}
+	pools := aSpace.masterPools()
+	if aSpace.reservations == nil {
+		aSpace.reservations = make(map[string]*ipamapi.Reservation)
+	}
+	aSpace.reservations[rsv.Name] = rsv
+	aSpace.Unlock()
+
+	if err := a.holdReservation(pools, rsv); err != nil {
+		aSpace.Lock()
+		delete(aSpace.reservations, rsv.Name)
+		aSpace.Unlock()
+		return err
+	}
+
+	if err := a.writeToStore(aSpace); err != nil {
+		a.dropReservation(pools, rsv)
+		if _, ok := err.(types.RetryError); !ok {
+			aSpace.Lock()
+			delete(aSpace.reservations, rsv.Name)
+			aSpace.Unlock()
+			return types.InternalErrorf("reservation %s failed because of %v", rsv.Name, err)
+		}
+		goto retry
+	}
+
+	return nil
+}
+
+// Unreserve releases the reservation with the given name, its addresses
+// become available to the dynamic allocation
+func (a *Allocator) Unreserve(addressSpace, name string) error {
+	log.Debugf("Unreserve(%s, %s)", addressSpace, name)
+
+retry:
+	if err := a.refresh(addressSpace); err != nil {
+		return err
+	}
+
+	aSpace, err := a.getAddrSpace(addressSpace)
+	if err != nil {
+		return err
+	}
+
+	aSpace.Lock()
+	rsv, ok := aSpace.reservations[name]
+	if !ok {
+		aSpace.Unlock()
+		return types.NotFoundErrorf("cannot find reservation %s in address space %s", name, addressSpace)
+	}
+	pools := aSpace.masterPools()
+	delete(aSpace.reservations, name)
+	aSpace.Unlock()
+
+	if err := a.writeToStore(aSpace); err != nil {
+		if _, ok := err.(types.RetryError); !ok {
+			return types.InternalErrorf("reservation %s removal failed because of %v", name, err)
+		}
+		goto retry
+	}
+
+	a.dropReservation(pools, rsv)
+
+	return nil
+}
+
+// Reservations returns the reservations of the address space, sorted by name
+func (a *Allocator) Reservations(addressSpace string) ([]*ipamapi.Reservation, error) {
+	if err := a.refresh(addressSpace); err != nil {
+		return nil, err
+	}
+
+	aSpace, err := a.getAddrSpace(addressSpace)
+	if err != nil {
+		return nil, err
+	}
+
+	aSpace.Lock()
+	list := aSpace.reservationList()
+	aSpace.Unlock()
+
+	sort.Sort(reservationsByName(list))
+
+	return list, nil
+}
+
+func validateReservation(r *ipamapi.Reservation) (*ipamapi.Reservation, error) {
+	if r == nil || r.Name == "" {
+		return nil, types.BadRequestErrorf("reservation name is required")
+	}
+	if r.Start == nil {
+		return nil, types.BadRequestErrorf("reservation %s has no start address", r.Name)
+	}
+
+	rsv := copyReservation(r)
+	if rsv.End == nil {
+		rsv.End = types.GetIPCopy(rsv.Start)
+	}
+	if v4 := rsv.Start.To4(); v4 != nil {
+		rsv.Start = v4
+	}
+	if v4 := rsv.End.To4(); v4 != nil {
+		rsv.End = v4
+	}
+
+	if len(rsv.Start) != len(rsv.End) {
+		return nil, types.BadRequestErrorf("reservation %s start and end addresses belong to different families", r.Name)
+	}
+	if bytes.Compare(rsv.Start, rsv.End) > 0 {
+		return nil, types.BadRequestErrorf("reservation %s start address %s is after end address %s", r.Name, rsv.Start, rsv.End)
+	}
+
+	// Only the trailing 8 bytes of the addresses may differ for the size
+	// of the range to be computed
+	tail := len(rsv.Start) - 8
+	if tail < 0 {
+		tail = 0
+	}
+	if !bytes.Equal(rsv.Start[:tail], rsv.End[:tail]) ||
+		ipToUint64(rsv.End[tail:])-ipToUint64(rsv.Start[tail:]) >= maxReservationSize {
+		return nil, types.BadRequestErrorf("reservation %s exceeds the maximum of %d addresses", r.Name, maxReservationSize)
+	}
+
+	return rsv, nil
+}
+
+func copyReservation(r *ipamapi.Reservation) *ipamapi.Reservation {
+	return &ipamapi.Reservation{
+		Name:  r.Name,
+		Owner: r.Owner,
+		Label: r.Label,
+		Start: types.GetIPCopy(r.Start),
+		End:   types.GetIPCopy(r.End),
+	}
+}
+
+func reservationsOverlap(a, b *ipamapi.Reservation) bool {
+	if len(a.Start) != len(b.Start) {
+		return false
+	}
+	return bytes.Compare(a.Start, b.End) <= 0 && bytes.Compare(b.Start, a.End) <= 0
+}
+
+// reservationFor returns the reservation holding the address, if any.
+// Caller must hold the address space lock.
+func (aSpace *addrSpace) reservationFor(ip net.IP) *ipamapi.Reservation {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, rsv := range aSpace.reservations {
+		if len(rsv.Start) == len(ip) && bytes.Compare(rsv.Start, ip) <= 0 && bytes.Compare(ip, rsv.End) <= 0 {
+			return rsv
+		}
+	}
+	return nil
+}
+
+// masterPools returns the pools owning a bitmask. Caller must hold the
+// address space lock.
+func (aSpace *addrSpace) masterPools() map[SubnetKey]*net.IPNet {
+	pools := make(map[SubnetKey]*net.IPNet)
+	for k, p := range aSpace.subnets {
+		if p.Range == nil {
+			pools[k] = types.GetIPNetCopy(p.Pool)
+		}
+	}
+	return pools
+}
+
+// reservationList returns a copy of the reservations. Caller must hold the
+// address space lock.
+func (aSpace *addrSpace) reservationList() []*ipamapi.Reservation {
+	list := make([]*ipamapi.Reservation, 0, len(aSpace.reservations))
+	for _, rsv := range aSpace.reservations {
+		list = append(list, copyReservation(rsv))
+	}
+	return list
+}
+
+// reservedOrdinals returns the ordinals of the pool addresses held by the
+// reservation. The network and broadcast addresses are never part of it.
+func reservedOrdinals(bm addrSet, pool *net.IPNet, rsv *ipamapi.Reservation) (uint64, uint64, bool) {
+	first := pool.IP.Mask(pool.Mask)
+	if v4 := first.To4(); v4 != nil {
+		first = v4
+	}
+	if first == nil || len(first) != len(rsv.Start) {
+		return 0, 0, false
+	}
+	last, err := types.GetBroadcastIP(first, pool.Mask)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	start, end := rsv.Start, rsv.End
+	if bytes.Compare(start, first) < 0 {
+		start = first
+	}
+	if bytes.Compare(end, last) > 0 {
+		end = last
+	}
+	if bytes.Compare(start, end) > 0 {
+		return 0, 0, false
+	}
+
+	hs, err := types.GetHostPartIP(start, pool.Mask)
+	if err != nil {
+		return 0, 0, false
+	}
+	he, err := types.GetHostPartIP(end, pool.Mask)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	lo, hi := ipToUint64(hs), ipToUint64(he)
+	max := bm.Bits() - 1
+	if getAddressVersion(pool.IP) == v4 {
+		max--
+	}
+	if lo < 1 {
+		lo = 1
+	}
+	if hi > max {
+		hi = max
+	}
+	if lo > hi {
+		return 0, 0, false
+	}
+
+	return lo, hi, true
+}
+
+// holdReservation marks the reserved addresses as allocated in the pools
+// bitmasks. It fails if any of them is already allocated.
+func (a *Allocator) holdReservation(pools map[SubnetKey]*net.IPNet, rsv *ipamapi.Reservation) error {
+	var held []SubnetKey
+	for k, pool := range pools {
+		bm, err := a.retrieveBitmask(k, pool)
+		if err != nil {
+			a.dropReservationFrom(held, pools, rsv)
+			return err
+		}
+		lo, hi, ok := reservedOrdinals(bm, pool, rsv)
+		if !ok {
+			continue
+		}
+		if err := bm.SetRange(lo, hi); err != nil {
+			a.dropReservationFrom(held, pools, rsv)
+			if err == bitseq.ErrBitAllocated {
+				o := lo
+				bm.WalkSet(lo, hi, func(ordinal uint64) bool {
+					o = ordinal
+					return true
+				})
+				return types.ForbiddenErrorf("cannot reserve address %s: %v", generateAddress(o, pool), ipamapi.ErrIPAlreadyAllocated)
+			}
+			return err
+		}
+		held = append(held, k)
+	}
+
+	return nil
+}
+
+// applyReservations marks the addresses held by the reservations as
+// allocated in the bitmask of a newly added pool
+func (a *Allocator) applyReservations(k SubnetKey, pool *net.IPNet, list []*ipamapi.Reservation) error {
+	if len(list) == 0 {
+		return nil
+	}
+
+	bm, err := a.retrieveBitmask(k, pool)
+	if err != nil {
+		return err
+	}
+
+	for _, rsv := range list {
+		lo, hi, ok := reservedOrdinals(bm, pool, rsv)
+		if !ok {
+			continue
+		}
+		// Set the runs of free addresses between the allocated ones
+		from := lo
+		var err error
+		bm.WalkSet(lo, hi, func(ordinal uint64) bool {
+			if ordinal > from {
+				if err = bm.SetRange(from, ordinal-1); err != nil {
+					return true
+				}
+			}
+			from = ordinal + 1
+			return false
+		})
+		if err != nil {
+			return err
+		}
+		if from <= hi {
+			if err := bm.SetRange(from, hi); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (a *Allocator) dropReservation(pools map[SubnetKey]*net.IPNet, rsv *ipamapi.Reservation) {
+	keys := make([]SubnetKey, 0, len(pools))
+	for k := range pools {
+		keys = append(keys, k)
+	}
+	a.dropReservationFrom(keys, pools, rsv)
+}
+
+// dropReservationFrom marks the reserved addresses as free in the bitmasks
+// of the given pools
+func (a *Allocator) dropReservationFrom(keys []SubnetKey, pools map[SubnetKey]*net.IPNet, rsv *ipamapi.Reservation) {
+	for _, k := range keys {
+		bm, err := a.retrieveBitmask(k, pools[k])
+		if err != nil {
+			log.Warnf("Failed to release reservation %s from pool %s: %v", rsv.Name, k.String(), err)
+			continue
+		}
+		lo, hi, ok := reservedOrdinals(bm, pools[k], rsv)
+		if !ok {
+			continue
+		}
+		if err := bm.UnsetRange(lo, hi); err != nil {
+			log.Warnf("Failed to release the addresses of reservation %s from pool %s: %v", rsv.Name, k.String(), err)
+		}
+	}
+}
+
+type reservationsByName []*ipamapi.Reservation
+
+func (r reservationsByName) Len() int           { return len(r) }
+func (r reservationsByName) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r reservationsByName) Less(i, j int) bool { return r[i].Name < r[j].Name }
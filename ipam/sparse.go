@@ -0,0 +1,508 @@
+package ipam
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/docker/libnetwork/bitseq"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/types"
+)
+
+// sparseSet keeps the allocated ordinals of a pool as a sorted list. Unlike
+// the bitseq run-length encoding, its size only depends on the number of
+// allocations, no matter how they are scattered across the pool. It is used
+// for the very large IPv6 pools and offers the same semantics as the bitseq
+// handle, including the errors.
+type sparseSet struct {
+	bits     uint64
+	ordinals []uint64
+	curr     uint64
+	app      string
+	id       string
+	dbIndex  uint64
+	dbExists bool
+	store    datastore.DataStore
+	sync.Mutex
+}
+
+// newSparseSet returns the sparse set of numElements ordinals identified by
+// app and id, reading its state from the datastore if present
+func newSparseSet(app string, ds datastore.DataStore, id string, numElements uint64) (*sparseSet, error) {
+	s := &sparseSet{
+		app:   app,
+		id:    id,
+		store: ds,
+		bits:  numElements,
+	}
+
+	if s.store == nil {
+		return s, nil
+	}
+
+	if err := s.store.GetObject(datastore.Key(s.Key()...), s); err != nil && err != datastore.ErrKeyNotFound {
+		return nil, err
+	}
+
+	if !s.Exists() {
+		if err := s.writeToStore(); err != nil {
+			return nil, fmt.Errorf("failed to write sparse set to store: %v", err)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *sparseSet) getCopy() *sparseSet {
+	ordinals := make([]uint64, len(s.ordinals))
+	copy(ordinals, s.ordinals)
+	return &sparseSet{
+		bits:     s.bits,
+		ordinals: ordinals,
+		curr:     s.curr,
+		app:      s.app,
+		id:       s.id,
+		dbIndex:  s.dbIndex,
+		dbExists: s.dbExists,
+		store:    s.store,
+	}
+}
+
+// index returns the position of the first ordinal not lower than o
+func (s *sparseSet) index(o uint64) int {
+	return sort.Search(len(s.ordinals), func(i int) bool { return s.ordinals[i] >= o })
+}
+
+func (s *sparseSet) has(o uint64) bool {
+	i := s.index(o)
+	return i < len(s.ordinals) && s.ordinals[i] == o
+}
+
+func (s *sparseSet) insert(o uint64) {
+	i := s.index(o)
+	s.ordinals = append(s.ordinals, 0)
+	copy(s.ordinals[i+1:], s.ordinals[i:])
+	s.ordinals[i] = o
+}
+
+func (s *sparseSet) remove(o uint64) {
+	i := s.index(o)
+	if i < len(s.ordinals) && s.ordinals[i] == o {
+		s.ordinals = append(s.ordinals[:i], s.ordinals[i+1:]...)
+	}
+}
+
+// firstAvailable returns the first unset ordinal between from and end
+func (s *sparseSet) firstAvailable(from, end uint64) (uint64, bool) {
+	o := from
+	for i := s.index(from); i < len(s.ordinals) && s.ordinals[i] == o; i++ {
+		if o == end {
+			return 0, false
+		}
+		o++
+	}
+	if o > end {
+		return 0, false
+	}
+	return o, true
+}
+
+// update atomically applies the change to the set and persists it
+func (s *sparseSet) update(change func(ns *sparseSet) (uint64, error)) (uint64, error) {
+	for {
+		s.Lock()
+		store := s.store
+		s.Unlock()
+		if store != nil {
+			if err := store.GetObject(datastore.Key(s.Key()...), s); err != nil && err != datastore.ErrKeyNotFound {
+				return 0, err
+			}
+		}
+
+		// Create a private copy of s and work on it
+		s.Lock()
+		ns := s.getCopy()
+		s.Unlock()
+
+		ret, err := change(ns)
+		if err != nil {
+			return ret, err
+		}
+
+		// Attempt to write private copy to store
+		if err := ns.writeToStore(); err != nil {
+			if _, ok := err.(types.RetryError); !ok {
+				return ret, fmt.Errorf("internal failure while updating the sparse set: %v", err)
+			}
+			// Retry
+			continue
+		}
+
+		s.Lock()
+		s.ordinals = ns.ordinals
+		s.curr = ns.curr
+		s.dbIndex = ns.dbIndex
+		s.dbExists = ns.dbExists
+		s.Unlock()
+		return ret, nil
+	}
+}
+
+func (s *sparseSet) validateRange(start, end uint64) error {
+	if start > end || end >= s.Bits() {
+		return fmt.Errorf("invalid bit range [%d, %d]", start, end)
+	}
+	return nil
+}
+
+func (s *sparseSet) validateOrdinal(ordinal uint64) error {
+	if ordinal >= s.Bits() {
+		return fmt.Errorf("bit does not belong to the sequence")
+	}
+	return nil
+}
+
+func (s *sparseSet) setAny(start, end, from uint64, serial bool) (uint64, error) {
+	if s.Unselected() == 0 {
+		return 0, bitseq.ErrNoBitAvailable
+	}
+	return s.update(func(ns *sparseSet) (uint64, error) {
+		if serial {
+			from = ns.curr
+		}
+		if from < start || from > end {
+			from = start
+		}
+		o, ok := ns.firstAvailable(from, end)
+		if !ok && from > start {
+			// Wrap around
+			o, ok = ns.firstAvailable(start, end)
+		}
+		if !ok {
+			return 0, bitseq.ErrNoBitAvailable
+		}
+		ns.insert(o)
+		if serial {
+			ns.curr = o + 1
+		}
+		return o, nil
+	})
+}
+
+// SetAny sets the first unset ordinal and returns it
+func (s *sparseSet) SetAny() (uint64, error) {
+	return s.setAny(0, s.Bits()-1, 0, false)
+}
+
+// SetAnyInRange sets the first unset ordinal between start and end and returns it
+func (s *sparseSet) SetAnyInRange(start, end uint64) (uint64, error) {
+	if err := s.validateRange(start, end); err != nil {
+		return 0, err
+	}
+	return s.setAny(start, end, start, false)
+}
+
+// SetAnyInRangeFrom sets the first unset ordinal between start and end,
+// searching from the passed ordinal and wrapping around, and returns it
+func (s *sparseSet) SetAnyInRangeFrom(start, end, from uint64) (uint64, error) {
+	if err := s.validateRange(start, end); err != nil {
+		return 0, err
+	}
+	return s.setAny(start, end, from, false)
+}
+
+// SetAnySerialInRange sets the first unset ordinal between start and end
+// following the last one set by this function, and returns it
+func (s *sparseSet) SetAnySerialInRange(start, end uint64) (uint64, error) {
+	if err := s.validateRange(start, end); err != nil {
+		return 0, err
+	}
+	return s.setAny(start, end, 0, true)
+}
+
+// Set sets the passed ordinal
+func (s *sparseSet) Set(ordinal uint64) error {
+	if err := s.validateOrdinal(ordinal); err != nil {
+		return err
+	}
+	_, err := s.update(func(ns *sparseSet) (uint64, error) {
+		if ns.has(ordinal) {
+			return ordinal, bitseq.ErrBitAllocated
+		}
+		ns.insert(ordinal)
+		return ordinal, nil
+	})
+	return err
+}
+
+// Unset unsets the passed ordinal
+func (s *sparseSet) Unset(ordinal uint64) error {
+	if err := s.validateOrdinal(ordinal); err != nil {
+		return err
+	}
+	_, err := s.update(func(ns *sparseSet) (uint64, error) {
+		ns.remove(ordinal)
+		return ordinal, nil
+	})
+	return err
+}
+
+// SetRange sets the ordinals between start and end included. It fails with
+// ErrBitAllocated, leaving the set untouched, if any of them is already set.
+func (s *sparseSet) SetRange(start, end uint64) error {
+	if err := s.validateRange(start, end); err != nil {
+		return err
+	}
+	_, err := s.update(func(ns *sparseSet) (uint64, error) {
+		i := ns.index(start)
+		if i < len(ns.ordinals) && ns.ordinals[i] <= end {
+			return ns.ordinals[i], bitseq.ErrBitAllocated
+		}
+		ordinals := make([]uint64, 0, uint64(len(ns.ordinals))+end-start+1)
+		ordinals = append(ordinals, ns.ordinals[:i]...)
+		for o := start; o <= end; o++ {
+			ordinals = append(ordinals, o)
+		}
+		ns.ordinals = append(ordinals, ns.ordinals[i:]...)
+		return start, nil
+	})
+	return err
+}
+
+// UnsetRange unsets the ordinals between start and end included
+func (s *sparseSet) UnsetRange(start, end uint64) error {
+	if err := s.validateRange(start, end); err != nil {
+		return err
+	}
+	_, err := s.update(func(ns *sparseSet) (uint64, error) {
+		i, j := ns.index(start), ns.index(end+1)
+		ns.ordinals = append(ns.ordinals[:i], ns.ordinals[j:]...)
+		return start, nil
+	})
+	return err
+}
+
+// IsSet returns whether the passed ordinal is set
+func (s *sparseSet) IsSet(ordinal uint64) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.has(ordinal)
+}
+
+// WalkSet invokes the walker, in ascending order, for each set ordinal
+// between start and end included. The walk stops when the walker returns true.
+func (s *sparseSet) WalkSet(start, end uint64, walker func(ordinal uint64) bool) {
+	s.Lock()
+	ordinals := make([]uint64, len(s.ordinals))
+	copy(ordinals, s.ordinals)
+	s.Unlock()
+
+	for i := sort.Search(len(ordinals), func(i int) bool { return ordinals[i] >= start }); i < len(ordinals) && ordinals[i] <= end; i++ {
+		if walker(ordinals[i]) {
+			return
+		}
+	}
+}
+
+// Bits returns the number of ordinals of the set
+func (s *sparseSet) Bits() uint64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.bits
+}
+
+// Unselected returns the number of unset ordinals
+func (s *sparseSet) Unselected() uint64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.bits - uint64(len(s.ordinals))
+}
+
+// CheckConsistency is a no-op, the sorted list cannot be corrupted the way
+// the bitseq sequences can
+func (s *sparseSet) CheckConsistency() error {
+	return nil
+}
+
+// Destroy removes the set from the datastore
+func (s *sparseSet) Destroy() error {
+	for {
+		if err := s.deleteFromStore(); err != nil {
+			if _, ok := err.(types.RetryError); !ok {
+				return fmt.Errorf("internal failure while destroying the sparse set: %v", err)
+			}
+			// Fetch latest
+			if err := s.store.GetObject(datastore.Key(s.Key()...), s); err != nil {
+				if err == datastore.ErrKeyNotFound { // already removed
+					return nil
+				}
+				return fmt.Errorf("failed to fetch from store when destroying the sparse set: %v", err)
+			}
+			continue
+		}
+		return nil
+	}
+}
+
+func (s *sparseSet) String() string {
+	s.Lock()
+	defer s.Unlock()
+	return fmt.Sprintf("App: %s, ID: %s, DBIndex: 0x%x, bits: %d, unselected: %d, ordinals: %v",
+		s.app, s.id, s.dbIndex, s.bits, s.bits-uint64(len(s.ordinals)), s.ordinals)
+}
+
+// MarshalJSON encodes the sparse set into json message
+func (s *sparseSet) MarshalJSON() ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	return json.Marshal(map[string]interface{}{
+		"id":       s.id,
+		"bits":     s.bits,
+		"curr":     s.curr,
+		"ordinals": s.ordinals,
+	})
+}
+
+// UnmarshalJSON decodes json message into the sparse set
+func (s *sparseSet) UnmarshalJSON(data []byte) error {
+	var m struct {
+		ID       string   `json:"id"`
+		Bits     uint64   `json:"bits"`
+		Curr     uint64   `json:"curr"`
+		Ordinals []uint64 `json:"ordinals"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	s.Lock()
+	s.id = m.ID
+	s.bits = m.Bits
+	s.curr = m.Curr
+	s.ordinals = m.Ordinals
+	s.Unlock()
+	return nil
+}
+
+// Key provides the Key to be used in KV Store
+func (s *sparseSet) Key() []string {
+	s.Lock()
+	defer s.Unlock()
+	return []string{s.app, s.id}
+}
+
+// KeyPrefix returns the immediate parent key that can be used for tree walk
+func (s *sparseSet) KeyPrefix() []string {
+	s.Lock()
+	defer s.Unlock()
+	return []string{s.app}
+}
+
+// Value marshals the data to be stored in the KV store
+func (s *sparseSet) Value() []byte {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// SetValue unmarshals the data from the KV store
+func (s *sparseSet) SetValue(value []byte) error {
+	return json.Unmarshal(value, s)
+}
+
+// Index returns the latest DB Index as seen by this object
+func (s *sparseSet) Index() uint64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.dbIndex
+}
+
+// SetIndex method allows the datastore to store the latest DB Index into this object
+func (s *sparseSet) SetIndex(index uint64) {
+	s.Lock()
+	s.dbIndex = index
+	s.dbExists = true
+	s.Unlock()
+}
+
+// Exists method is true if this object has been stored in the DB.
+func (s *sparseSet) Exists() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.dbExists
+}
+
+// New method returns a sparse set based on the receiver one
+func (s *sparseSet) New() datastore.KVObject {
+	s.Lock()
+	defer s.Unlock()
+
+	return &sparseSet{
+		app:   s.app,
+		store: s.store,
+	}
+}
+
+// CopyTo deep copies the sparse set into the passed destination object
+func (s *sparseSet) CopyTo(o datastore.KVObject) error {
+	s.Lock()
+	defer s.Unlock()
+
+	dstS := o.(*sparseSet)
+	if s == dstS {
+		return nil
+	}
+	dstS.Lock()
+	dstS.bits = s.bits
+	dstS.ordinals = make([]uint64, len(s.ordinals))
+	copy(dstS.ordinals, s.ordinals)
+	dstS.curr = s.curr
+	dstS.app = s.app
+	dstS.id = s.id
+	dstS.dbIndex = s.dbIndex
+	dstS.dbExists = s.dbExists
+	dstS.store = s.store
+	dstS.Unlock()
+
+	return nil
+}
+
+// Skip provides a way for a KV Object to avoid persisting it in the KV Store
+func (s *sparseSet) Skip() bool {
+	return false
+}
+
+// DataScope method returns the storage scope of the datastore
+func (s *sparseSet) DataScope() string {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.store.Scope()
+}
+
+func (s *sparseSet) writeToStore() error {
+	s.Lock()
+	store := s.store
+	s.Unlock()
+	if store == nil {
+		return nil
+	}
+	err := store.PutObjectAtomic(s)
+	if err == datastore.ErrKeyModified {
+		return types.RetryErrorf("failed to perform atomic write (%v). Retry might fix the error", err)
+	}
+	return err
+}
+
+func (s *sparseSet) deleteFromStore() error {
+	s.Lock()
+	store := s.store
+	s.Unlock()
+	if store == nil {
+		return nil
+	}
+	return store.DeleteObjectAtomic(s)
+}
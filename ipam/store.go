@@ -39,6 +39,7 @@ func (aSpace *addrSpace) SetValue(value []byte) error {
 		return err
 	}
 	aSpace.subnets = rc.subnets
+	aSpace.reservations = rc.reservations
 	return nil
 }
 
@@ -25,17 +25,20 @@ type PoolData struct {
 	Pool      *net.IPNet
 	Range     *AddressRange `json:",omitempty"`
 	RefCount  int
+	// Strategy is how the addresses of the pool are picked
+	Strategy string `json:",omitempty"`
 }
 
 // addrSpace contains the pool configurations for the address space
 type addrSpace struct {
-	subnets  map[SubnetKey]*PoolData
-	dbIndex  uint64
-	dbExists bool
-	id       string
-	scope    string
-	ds       datastore.DataStore
-	alloc    *Allocator
+	subnets      map[SubnetKey]*PoolData
+	reservations map[string]*ipamapi.Reservation
+	dbIndex      uint64
+	dbExists     bool
+	id           string
+	scope        string
+	ds           datastore.DataStore
+	alloc        *Allocator
 	sync.Mutex
 }
 
@@ -106,8 +109,8 @@ func (s *SubnetKey) FromString(str string) error {
 
 // String returns the string form of the PoolData object
 func (p *PoolData) String() string {
-	return fmt.Sprintf("ParentKey: %s, Pool: %s, Range: %s, RefCount: %d",
-		p.ParentKey.String(), p.Pool.String(), p.Range, p.RefCount)
+	return fmt.Sprintf("ParentKey: %s, Pool: %s, Range: %s, RefCount: %d, Strategy: %s",
+		p.ParentKey.String(), p.Pool.String(), p.Range, p.RefCount, p.strategy())
 }
 
 // MarshalJSON returns the JSON encoding of the PoolData object
@@ -122,6 +125,9 @@ func (p *PoolData) MarshalJSON() ([]byte, error) {
 	if p.Range != nil {
 		m["Range"] = p.Range
 	}
+	if p.Strategy != "" {
+		m["Strategy"] = p.Strategy
+	}
 	return json.Marshal(m)
 }
 
@@ -134,6 +140,7 @@ func (p *PoolData) UnmarshalJSON(data []byte) error {
 			Pool      string
 			Range     *AddressRange `json:",omitempty"`
 			RefCount  int
+			Strategy  string
 		}
 	)
 
@@ -144,6 +151,7 @@ func (p *PoolData) UnmarshalJSON(data []byte) error {
 	p.ParentKey = t.ParentKey
 	p.Range = t.Range
 	p.RefCount = t.RefCount
+	p.Strategy = t.Strategy
 	if t.Pool != "" {
 		if p.Pool, err = types.ParseCIDR(t.Pool); err != nil {
 			return err
@@ -170,6 +178,10 @@ func (aSpace *addrSpace) MarshalJSON() ([]byte, error) {
 		m["Subnets"] = s
 	}
 
+	if len(aSpace.reservations) > 0 {
+		m["Reservations"] = aSpace.reservationList()
+	}
+
 	return json.Marshal(m)
 }
 
@@ -204,6 +216,18 @@ func (aSpace *addrSpace) UnmarshalJSON(data []byte) error {
 		}
 	}
 
+	if v, ok := m["Reservations"]; ok {
+		rb, _ := json.Marshal(v)
+		var rl []*ipamapi.Reservation
+		if err := json.Unmarshal(rb, &rl); err != nil {
+			return err
+		}
+		aSpace.reservations = make(map[string]*ipamapi.Reservation, len(rl))
+		for _, rsv := range rl {
+			aSpace.reservations[rsv.Name] = rsv
+		}
+	}
+
 	return nil
 }
 
@@ -220,6 +244,7 @@ func (p *PoolData) CopyTo(dstP *PoolData) error {
 	}
 
 	dstP.RefCount = p.RefCount
+	dstP.Strategy = p.Strategy
 	return nil
 }
 
@@ -242,6 +267,11 @@ func (aSpace *addrSpace) CopyTo(o datastore.KVObject) error {
 		v.CopyTo(dstAspace.subnets[k])
 	}
 
+	dstAspace.reservations = make(map[string]*ipamapi.Reservation, len(aSpace.reservations))
+	for name, rsv := range aSpace.reservations {
+		dstAspace.reservations[name] = copyReservation(rsv)
+	}
+
 	return nil
 }
 
@@ -257,7 +287,15 @@ func (aSpace *addrSpace) New() datastore.KVObject {
 	}
 }
 
-func (aSpace *addrSpace) updatePoolDBOnAdd(k SubnetKey, nw *net.IPNet, ipr *AddressRange, pdf bool) (func() error, error) {
+// strategy returns how the addresses of the pool are picked
+func (p *PoolData) strategy() string {
+	if p.Strategy == "" {
+		return ipamapi.StrategyLowest
+	}
+	return p.Strategy
+}
+
+func (aSpace *addrSpace) updatePoolDBOnAdd(k SubnetKey, nw *net.IPNet, ipr *AddressRange, strategy string, pdf bool) (func() error, error) {
 	aSpace.Lock()
 	defer aSpace.Unlock()
 
@@ -276,8 +314,8 @@ func (aSpace *addrSpace) updatePoolDBOnAdd(k SubnetKey, nw *net.IPNet, ipr *Addr
 			return nil, ipamapi.ErrPoolOverlap
 		}
 		// This is a new master pool, add it along with corresponding bitmask
-		aSpace.subnets[k] = &PoolData{Pool: nw, RefCount: 1}
-		return func() error { return aSpace.alloc.insertBitMask(k, nw) }, nil
+		aSpace.subnets[k] = &PoolData{Pool: nw, RefCount: 1, Strategy: strategy}
+		return aSpace.poolInserter(k, nw), nil
 	}
 
 	// This is a new non-master pool
@@ -286,6 +324,7 @@ func (aSpace *addrSpace) updatePoolDBOnAdd(k SubnetKey, nw *net.IPNet, ipr *Addr
 		Pool:      nw,
 		Range:     ipr,
 		RefCount:  1,
+		Strategy:  strategy,
 	}
 	aSpace.subnets[k] = p
 
@@ -298,7 +337,20 @@ func (aSpace *addrSpace) updatePoolDBOnAdd(k SubnetKey, nw *net.IPNet, ipr *Addr
 
 	// Parent pool does not exist, add it along with corresponding bitmask
 	aSpace.subnets[p.ParentKey] = &PoolData{Pool: nw, RefCount: 1}
-	return func() error { return aSpace.alloc.insertBitMask(p.ParentKey, nw) }, nil
+	return aSpace.poolInserter(p.ParentKey, nw), nil
+}
+
+// poolInserter returns the function adding the bitmask of a new master pool,
+// with the addresses held by the reservations already marked as allocated.
+// Caller must hold the address space lock.
+func (aSpace *addrSpace) poolInserter(k SubnetKey, nw *net.IPNet) func() error {
+	rsvs := aSpace.reservationList()
+	return func() error {
+		if err := aSpace.alloc.insertBitMask(k, nw); err != nil {
+			return err
+		}
+		return aSpace.alloc.applyReservations(k, nw, rsvs)
+	}
 }
 
 func (aSpace *addrSpace) updatePoolDBOnRemoval(k SubnetKey) (func() error, error) {
@@ -0,0 +1,261 @@
+package libnetwork
+
+import (
+	"bytes"
+	"net"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/ipamapi"
+)
+
+// Kinds of the address inconsistencies reported by the IPAM audit
+const (
+	// AddressLeaked is an address the IPAM reports as allocated which no
+	// endpoint, gateway or auxiliary address of the network holds
+	AddressLeaked = "leaked"
+	// AddressDuplicated is an address held by more than one endpoint
+	AddressDuplicated = "duplicated"
+	// AddressNotAllocated is an endpoint address the IPAM does not report
+	// as allocated, it may be handed out again
+	AddressNotAllocated = "not-allocated"
+)
+
+// leakGracePeriod is the time for which an address must have been reported
+// as leaked by the previous audits before a repair releases it. An endpoint
+// being created holds its address before it is stored.
+var leakGracePeriod = time.Minute
+
+// IpamAuditIssue is an address on which the network endpoints and the IPAM
+// state disagree
+type IpamAuditIssue struct {
+	Kind    string
+	Network string
+	PoolID  string
+	Address net.IP
+	// Endpoints lists the ids of the endpoints holding the address
+	Endpoints []string
+	// Repaired is set once a leaked address was released. Only the addresses
+	// already reported as leaked by an audit at least a grace period earlier
+	// are released.
+	Repaired bool
+}
+
+// IpamAudit is the outcome of the cross-check of the endpoint addresses
+// against the allocations of the IPAM drivers
+type IpamAudit struct {
+	// Networks lists the ids of the audited networks
+	Networks []string
+	// Skipped lists the ids of the networks whose IPAM driver cannot
+	// report its allocations, or which could not be audited
+	Skipped []string
+	Issues  []*IpamAuditIssue
+}
+
+func (c *controller) AuditIpam(repair bool) (*IpamAudit, error) {
+	networks, err := c.getNetworksFromStore()
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	prev := c.leaks
+	c.Unlock()
+
+	now := time.Now()
+	seen := make(map[string]time.Time)
+	audit := &IpamAudit{}
+	for _, n := range networks {
+		if n.inDelete || n.hasSpecialDriver() {
+			continue
+		}
+
+		ipam, _, err := c.getIPAMDriver(n.ipamType)
+		if err != nil {
+			log.Warnf("Skipping ipam audit of network %s: %v", n.Name(), err)
+			audit.Skipped = append(audit.Skipped, n.ID())
+			continue
+		}
+		inv, ok := ipam.(ipamapi.Inventory)
+		if !ok {
+			audit.Skipped = append(audit.Skipped, n.ID())
+			continue
+		}
+
+		leaks, issues, err := n.auditIpam(ipam, inv)
+		if err != nil {
+			log.Warnf("Skipping ipam audit of network %s: %v", n.Name(), err)
+			audit.Skipped = append(audit.Skipped, n.ID())
+			continue
+		}
+
+		// Only release the addresses which stayed leaked for the grace
+		// period, the others may be held by an endpoint being created
+		var confirmed []*IpamAuditIssue
+		for _, l := range leaks {
+			key := l.Network + "/" + l.PoolID + "/" + l.Address.String()
+			first, ok := prev[key]
+			if !ok {
+				first = now
+			}
+			seen[key] = first
+			if ok && now.Sub(first) >= leakGracePeriod {
+				confirmed = append(confirmed, l)
+			}
+		}
+		if repair && len(confirmed) > 0 {
+			n.releaseLeaks(ipam, confirmed)
+		}
+
+		audit.Networks = append(audit.Networks, n.ID())
+		audit.Issues = append(audit.Issues, append(leaks, issues...)...)
+	}
+
+	c.Lock()
+	c.leaks = seen
+	c.Unlock()
+
+	return audit, nil
+}
+
+// addressOwner is an address along with the endpoints holding it
+type addressOwner struct {
+	ip  net.IP
+	eps []string
+}
+
+// addressOwners returns the owners of the addresses held by the network
+// endpoints, in the order the endpoints were found
+func (n *network) addressOwners() ([]*addressOwner, map[string]*addressOwner, error) {
+	epl, err := n.getEndpointsFromStore()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var list []*addressOwner
+	owners := make(map[string]*addressOwner)
+	for _, ep := range epl {
+		iface := ep.Iface()
+		if iface == nil {
+			continue
+		}
+		for _, addr := range []*net.IPNet{iface.Address(), iface.AddressIPv6()} {
+			if addr == nil {
+				continue
+			}
+			o, ok := owners[addr.IP.String()]
+			if !ok {
+				o = &addressOwner{ip: addr.IP}
+				owners[addr.IP.String()] = o
+				list = append(list, o)
+			}
+			o.eps = append(o.eps, ep.ID())
+		}
+	}
+
+	return list, owners, nil
+}
+
+// auditIpam returns the addresses of the network leaked by the ipam driver,
+// and the other inconsistencies
+func (n *network) auditIpam(ipam ipamapi.Ipam, inv ipamapi.Inventory) ([]*IpamAuditIssue, []*IpamAuditIssue, error) {
+	list, owners, err := n.addressOwners()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var issues, leaks []*IpamAuditIssue
+	for _, d := range append(n.getIPInfo(4), n.getIPInfo(6)...) {
+		held := heldAddresses(ipam, d)
+		allocated := make(map[string]bool)
+		err := inv.WalkAllocatedAddresses(d.PoolID, func(ip net.IP) bool {
+			allocated[ip.String()] = true
+			if _, ok := owners[ip.String()]; !ok && !held(ip) {
+				leaks = append(leaks, &IpamAuditIssue{Kind: AddressLeaked, Network: n.ID(), PoolID: d.PoolID, Address: ip})
+			}
+			return false
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, o := range list {
+			if !d.Pool.Contains(o.ip) {
+				continue
+			}
+			if len(o.eps) > 1 {
+				issues = append(issues, &IpamAuditIssue{Kind: AddressDuplicated, Network: n.ID(), PoolID: d.PoolID, Address: o.ip, Endpoints: o.eps})
+			}
+			if !allocated[o.ip.String()] {
+				issues = append(issues, &IpamAuditIssue{Kind: AddressNotAllocated, Network: n.ID(), PoolID: d.PoolID, Address: o.ip, Endpoints: o.eps})
+			}
+		}
+	}
+
+	return leaks, issues, nil
+}
+
+// releaseLeaks releases the leaked addresses. The endpoints are read again
+// first, an endpoint created during the audit may have been handed one of
+// them.
+func (n *network) releaseLeaks(ipam ipamapi.Ipam, leaks []*IpamAuditIssue) {
+	_, owners, err := n.addressOwners()
+	if err != nil {
+		log.Warnf("Not releasing the leaked addresses of network %s: %v", n.Name(), err)
+		return
+	}
+
+	for _, l := range leaks {
+		if _, ok := owners[l.Address.String()]; ok {
+			continue
+		}
+		if err := ipam.ReleaseAddress(l.PoolID, l.Address); err != nil {
+			log.Warnf("Failed to release leaked address %s of network %s: %v", l.Address, n.Name(), err)
+			continue
+		}
+		log.Infof("Released leaked address %s of network %s", l.Address, n.Name())
+		l.Repaired = true
+	}
+}
+
+// heldAddresses returns a function telling whether the address of the pool
+// is rightfully allocated without being held by an endpoint: the network
+// and broadcast addresses, the gateway, the auxiliary addresses and the
+// addresses under reservation.
+func heldAddresses(ipam ipamapi.Ipam, d *IpamInfo) func(net.IP) bool {
+	addrs := make(map[string]bool)
+	addrs[d.Pool.IP.Mask(d.Pool.Mask).String()] = true
+	if d.Pool.IP.To4() != nil {
+		bcast := make(net.IP, net.IPv4len)
+		for i, b := range d.Pool.IP.To4() {
+			bcast[i] = b | ^d.Pool.Mask[len(d.Pool.Mask)-net.IPv4len+i]
+		}
+		addrs[bcast.String()] = true
+	}
+	if d.Gateway != nil {
+		addrs[d.Gateway.IP.String()] = true
+	}
+	for _, aux := range d.AuxAddresses {
+		addrs[aux.IP.String()] = true
+	}
+
+	var rsvs []*ipamapi.Reservation
+	if r, ok := ipam.(ipamapi.Reserver); ok {
+		var err error
+		if rsvs, err = r.Reservations(d.AddressSpace); err != nil {
+			log.Debugf("Failed to retrieve the reservations of address space %s: %v", d.AddressSpace, err)
+		}
+	}
+
+	return func(ip net.IP) bool {
+		if addrs[ip.String()] {
+			return true
+		}
+		for _, r := range rsvs {
+			if bytes.Compare(ip.To16(), r.Start.To16()) >= 0 && bytes.Compare(ip.To16(), r.End.To16()) <= 0 {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -17,10 +17,26 @@ const (
 	DefaultIPAM = "default"
 	// NullIPAM is the name of the built-in null ipam driver
 	NullIPAM = "null"
+	// DHCPIPAM is the name of the built-in dhcp ipam driver
+	DHCPIPAM = "dhcp"
 	// PluginEndpointType represents the Endpoint Type used by Plugin system
 	PluginEndpointType = "IpamDriver"
 	// RequestAddressType represents the Address Type used when requesting an address
 	RequestAddressType = "RequestAddressType"
+	// AllocationStrategy is the RequestPool option selecting how the
+	// addresses of the pool are picked
+	AllocationStrategy = "com.docker.network.ipam.allocation_strategy"
+)
+
+// Address allocation strategies
+const (
+	// StrategyLowest picks the lowest free address
+	StrategyLowest = "lowest"
+	// StrategySequential picks the first free address following the last
+	// allocated one, wrapping around at the end of the pool
+	StrategySequential = "sequential"
+	// StrategyRandom picks a free address at random
+	StrategyRandom = "random"
 )
 
 // Callback provides a Callback interface for registering an IPAM instance into LibNetwork
@@ -78,6 +94,58 @@ type Ipam interface {
 	ReleaseAddress(string, net.IP) error
 }
 
+// Inventory is an optional interface the IPAM drivers can implement to
+// report the utilization of their pools
+type Inventory interface {
+	// PoolsUsage returns the utilization of the pools of the passed address
+	// space, or of all the address spaces if none is specified
+	PoolsUsage(addressSpace string) ([]*PoolUsage, error)
+	// WalkAllocatedAddresses invokes the walker, in ascending order, for each
+	// address allocated from the pool identified by the passed id. The walk
+	// stops when the walker returns true.
+	WalkAllocatedAddresses(poolID string, walker func(net.IP) bool) error
+}
+
+// PoolUsage reports the utilization of an address pool
+type PoolUsage struct {
+	AddressSpace string
+	PoolID       string
+	// Pool the addresses are allocated from
+	Pool *net.IPNet
+	// SubPool restricting the allocation, if any
+	SubPool *net.IPNet
+	// RefCount is the number of requests for the pool
+	RefCount int
+	// Total, Allocated and Free count the addresses of the pool, or of the
+	// sub pool if present. The reserved addresses count as allocated.
+	Total     uint64
+	Allocated uint64
+	Free      uint64
+}
+
+// Reserver is an optional interface the IPAM drivers can implement to hold
+// addresses out of the dynamic allocation on behalf of an owner
+type Reserver interface {
+	// Reserve holds the addresses of the reservation in the passed address space
+	Reserve(addressSpace string, r *Reservation) error
+	// Unreserve releases the reservation with the given name
+	Unreserve(addressSpace, name string) error
+	// Reservations returns the reservations of the passed address space
+	Reservations(addressSpace string) ([]*Reservation, error)
+}
+
+// Reservation is a named range of addresses held for an owner
+type Reservation struct {
+	// Name identifies the reservation in its address space
+	Name string
+	// Owner and Label describe who holds the addresses and why
+	Owner string
+	Label string
+	// Start and End are the first and the last reserved addresses
+	Start net.IP
+	End   net.IP
+}
+
 // Capability represents the requirements and capabilities of the IPAM driver
 type Capability struct {
 	// Whether on address request, libnetwork must
@@ -13,6 +13,13 @@ import (
 
 // Init registers the built-in ipam service with libnetwork
 func Init(ic ipamapi.Callback, l, g interface{}) error {
+	return InitWithPools(ic, l, g, nil, nil)
+}
+
+// InitWithPools registers the built-in ipam service with libnetwork, the
+// passed pools replacing the predefined ones of the local and global
+// default address spaces when specified
+func InitWithPools(ic ipamapi.Callback, l, g interface{}, local, global []*ipamutils.PredefinedPool) error {
 	var (
 		ok                bool
 		localDs, globalDs datastore.DataStore
@@ -37,6 +44,23 @@ func Init(ic ipamapi.Callback, l, g interface{}) error {
 		return err
 	}
 
+	lAs, gAs, err := a.GetDefaultAddressSpaces()
+	if err != nil {
+		return err
+	}
+	for as, pools := range map[string][]*ipamutils.PredefinedPool{lAs: local, gAs: global} {
+		if len(pools) == 0 {
+			continue
+		}
+		nws, err := ipamutils.SplitPools(pools)
+		if err != nil {
+			return err
+		}
+		if err := a.SetPredefinedPools(as, nws); err != nil {
+			return err
+		}
+	}
+
 	cps := &ipamapi.Capability{RequiresRequestReplay: true}
 
 	return ic.RegisterIpamDriverWithCapabilities(ipamapi.DefaultIPAM, a, cps)
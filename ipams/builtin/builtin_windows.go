@@ -3,7 +3,10 @@
 package builtin
 
 import (
+	"fmt"
+
 	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/ipamutils"
 
 	windowsipam "github.com/docker/libnetwork/ipams/windowsipam"
 )
@@ -14,3 +17,13 @@ func Init(ic ipamapi.Callback, l, g interface{}) error {
 
 	return initFunc(ic, l, g)
 }
+
+// InitWithPools registers the built-in ipam service with libnetwork.
+// Predefined pools are not supported on windows.
+func InitWithPools(ic ipamapi.Callback, l, g interface{}, local, global []*ipamutils.PredefinedPool) error {
+	if len(local) > 0 || len(global) > 0 {
+		return fmt.Errorf("predefined pools are not supported by the built-in ipam on windows")
+	}
+
+	return Init(ic, l, g)
+}
@@ -0,0 +1,81 @@
+package dhcp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	clientPort = 68
+	serverPort = 67
+	maxMsgSize = 1500
+)
+
+type udpConn struct {
+	pc net.PacketConn
+}
+
+// dialInterface opens a broadcast capable UDP socket bound to the DHCP
+// client port of the passed interface. The interface does not need to
+// carry any address.
+func dialInterface(ifname string) (conn, error) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dhcp socket: %v", err)
+	}
+
+	if err := setupSocket(fd, ifname); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	f := os.NewFile(uintptr(fd), "dhcp-"+ifname)
+	defer f.Close()
+
+	pc, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dhcp connection on %s: %v", ifname, err)
+	}
+
+	return &udpConn{pc: pc}, nil
+}
+
+func setupSocket(fd int, ifname string) error {
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		return fmt.Errorf("failed to set SO_REUSEADDR on dhcp socket: %v", err)
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1); err != nil {
+		return fmt.Errorf("failed to set SO_BROADCAST on dhcp socket: %v", err)
+	}
+	if err := syscall.BindToDevice(fd, ifname); err != nil {
+		return fmt.Errorf("failed to bind dhcp socket to interface %s: %v", ifname, err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Port: clientPort}); err != nil {
+		return fmt.Errorf("failed to bind dhcp socket to port %d: %v", clientPort, err)
+	}
+	return nil
+}
+
+func (c *udpConn) send(b []byte) error {
+	_, err := c.pc.WriteTo(b, &net.UDPAddr{IP: net.IPv4bcast, Port: serverPort})
+	return err
+}
+
+func (c *udpConn) receive(deadline time.Time) ([]byte, error) {
+	if err := c.pc.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	b := make([]byte, maxMsgSize)
+	n, _, err := c.pc.ReadFrom(b)
+	if err != nil {
+		return nil, err
+	}
+	return b[:n], nil
+}
+
+func (c *udpConn) close() error {
+	return c.pc.Close()
+}
@@ -0,0 +1,9 @@
+// +build !linux
+
+package dhcp
+
+import "fmt"
+
+func dialInterface(ifname string) (conn, error) {
+	return nil, fmt.Errorf("dhcp ipam driver is not supported on this platform")
+}
@@ -0,0 +1,495 @@
+// Package dhcp implements the dhcp ipam driver. The endpoint addresses are
+// leased from the DHCP server reachable through the parent interface passed
+// in the pool options, the leases are renewed in background for as long as
+// the endpoints exist.
+package dhcp
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/discoverapi"
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+)
+
+const (
+	defaultAS = "dhcp"
+	// InterfaceOpt is the pool option carrying the name of the parent
+	// interface the DHCP server is reachable through
+	InterfaceOpt = "dhcp_interface"
+	// infiniteLease is the lease time value meaning the lease never expires
+	infiniteLease = 0xffffffff * time.Second
+)
+
+var (
+	exchangeTimeout  = 2 * time.Second
+	exchangeRetries  = 3
+	minRenewInterval = 10 * time.Second
+
+	rnd   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	rndMu sync.Mutex
+
+	errLeaseRefused = fmt.Errorf("dhcp server refused the lease renewal")
+)
+
+// conn sends and receives the DHCP messages on a parent interface
+type conn interface {
+	send(b []byte) error
+	receive(deadline time.Time) ([]byte, error)
+	close() error
+}
+
+type pool struct {
+	id      string
+	nw      *net.IPNet
+	parent  string
+	gateway net.IP
+	refCnt  int
+}
+
+type lease struct {
+	sync.Mutex
+	parent  string
+	mac     net.HardwareAddr
+	address *net.IPNet
+	server  net.IP
+	renewal time.Duration
+	expiry  time.Time
+	stop    chan struct{}
+}
+
+type allocator struct {
+	sync.Mutex
+	pools  map[string]*pool
+	leases map[string]*lease
+	dial   func(ifname string) (conn, error)
+	// The exchanges share the client port, they are serialized
+	xchgMu sync.Mutex
+}
+
+// Init registers the dhcp ipam driver with libnetwork
+func Init(ic ipamapi.Callback, l, g interface{}) error {
+	cps := &ipamapi.Capability{RequiresMACAddress: true, RequiresRequestReplay: true}
+	return ic.RegisterIpamDriverWithCapabilities(ipamapi.DHCPIPAM, newAllocator(dialInterface), cps)
+}
+
+func newAllocator(dial func(string) (conn, error)) *allocator {
+	return &allocator{
+		pools:  make(map[string]*pool),
+		leases: make(map[string]*lease),
+		dial:   dial,
+	}
+}
+
+func (a *allocator) GetDefaultAddressSpaces() (string, string, error) {
+	return defaultAS, defaultAS, nil
+}
+
+// RequestPool returns the pool the DHCP server on the parent interface leases
+// the addresses from. If no pool is specified, it is learned from the server
+// offer. The router advertised by the server is returned as the gateway.
+func (a *allocator) RequestPool(addressSpace, poolStr, subPool string, options map[string]string, v6 bool) (string, *net.IPNet, map[string]string, error) {
+	log.Debugf("RequestPool(%s, %s, %s, %v, %t)", addressSpace, poolStr, subPool, options, v6)
+	if addressSpace != defaultAS {
+		return "", nil, nil, types.BadRequestErrorf("unknown address space: %s", addressSpace)
+	}
+	if subPool != "" {
+		return "", nil, nil, types.BadRequestErrorf("dhcp ipam driver does not handle specific address subpool requests")
+	}
+	if v6 {
+		return "", nil, nil, types.BadRequestErrorf("dhcp ipam driver does not handle IPv6 address pool requests")
+	}
+	parent := options[InterfaceOpt]
+	if parent == "" {
+		return "", nil, nil, types.BadRequestErrorf("dhcp ipam driver requires the %s pool option", InterfaceOpt)
+	}
+
+	var nw *net.IPNet
+	if poolStr != "" {
+		var err error
+		if _, nw, err = net.ParseCIDR(poolStr); err != nil || nw.IP.To4() == nil {
+			return "", nil, nil, types.BadRequestErrorf("invalid IPv4 address pool: %s", poolStr)
+		}
+	}
+
+	offer, err := a.probe(parent)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if nw == nil {
+		mask := offer.ipOption(optSubnetMask)
+		if mask == nil {
+			return "", nil, nil, types.BadRequestErrorf("dhcp server on %s advertises no subnet mask, the pool must be specified", parent)
+		}
+		nw = &net.IPNet{IP: offer.yiaddr.Mask(net.IPMask(mask)), Mask: net.IPMask(mask)}
+	} else if !nw.Contains(offer.yiaddr) {
+		return "", nil, nil, types.ForbiddenErrorf("dhcp server on %s offers address %s out of pool %s", parent, offer.yiaddr, nw)
+	}
+
+	id := fmt.Sprintf("%s/%s/%s", defaultAS, parent, nw)
+	gw := offer.ipOption(optRouter)
+	if gw != nil && !nw.Contains(gw) {
+		gw = nil
+	}
+
+	a.Lock()
+	p, ok := a.pools[id]
+	if !ok {
+		p = &pool{id: id, nw: types.GetIPNetCopy(nw), parent: parent}
+		a.pools[id] = p
+	}
+	p.gateway = gw
+	p.refCnt++
+	a.Unlock()
+
+	var meta map[string]string
+	if gw != nil {
+		meta = map[string]string{netlabel.Gateway: (&net.IPNet{IP: gw, Mask: nw.Mask}).String()}
+	}
+
+	return id, nw, meta, nil
+}
+
+// ReleasePool releases the pool, along with the leases still held on it
+func (a *allocator) ReleasePool(poolID string) error {
+	log.Debugf("ReleasePool(%s)", poolID)
+	a.Lock()
+	p, ok := a.pools[poolID]
+	if !ok {
+		a.Unlock()
+		return types.NotFoundErrorf("unknown pool id: %s", poolID)
+	}
+	p.refCnt--
+	if p.refCnt > 0 {
+		a.Unlock()
+		return nil
+	}
+	delete(a.pools, poolID)
+	var held []*lease
+	for k, l := range a.leases {
+		if l.parent == p.parent && p.nw.Contains(l.address.IP) {
+			held = append(held, l)
+			delete(a.leases, k)
+		}
+	}
+	a.Unlock()
+
+	for _, l := range held {
+		a.dropLease(l)
+	}
+
+	return nil
+}
+
+// RequestAddress leases an address for the endpoint MAC address from the
+// DHCP server. Gateway and auxiliary addresses are not leased.
+func (a *allocator) RequestAddress(poolID string, prefAddress net.IP, opts map[string]string) (*net.IPNet, map[string]string, error) {
+	log.Debugf("RequestAddress(%s, %v, %v)", poolID, prefAddress, opts)
+	a.Lock()
+	p, ok := a.pools[poolID]
+	if ok {
+		p = &pool{id: p.id, nw: types.GetIPNetCopy(p.nw), parent: p.parent, gateway: types.GetIPCopy(p.gateway)}
+	}
+	a.Unlock()
+	if !ok {
+		return nil, nil, types.NotFoundErrorf("unknown pool id: %s", poolID)
+	}
+
+	if opts[ipamapi.RequestAddressType] == netlabel.Gateway {
+		gw := prefAddress
+		if gw == nil {
+			gw = p.gateway
+		}
+		if gw == nil {
+			return nil, nil, nil
+		}
+		return &net.IPNet{IP: gw, Mask: p.nw.Mask}, nil, nil
+	}
+
+	macStr := opts[netlabel.MacAddress]
+	if macStr == "" {
+		// Auxiliary addresses are outside of the DHCP server control,
+		// libnetwork keeps them as they are
+		if prefAddress != nil {
+			return nil, nil, ipamapi.ErrIPOutOfRange
+		}
+		return nil, nil, types.BadRequestErrorf("dhcp ipam driver requires the endpoint mac address")
+	}
+	mac, err := net.ParseMAC(macStr)
+	if err != nil {
+		return nil, nil, types.BadRequestErrorf("invalid mac address %s: %v", macStr, err)
+	}
+
+	l, err := a.acquire(p, mac, prefAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	k := leaseKey(poolID, l.address.IP)
+	a.Lock()
+	old := a.leases[k]
+	a.leases[k] = l
+	a.Unlock()
+	if old != nil {
+		close(old.stop)
+	}
+
+	if l.renewal > 0 {
+		go a.keepAlive(l)
+	}
+
+	return types.GetIPNetCopy(l.address), nil, nil
+}
+
+// ReleaseAddress releases the lease of the address to the DHCP server
+func (a *allocator) ReleaseAddress(poolID string, address net.IP) error {
+	log.Debugf("ReleaseAddress(%s, %v)", poolID, address)
+	k := leaseKey(poolID, address)
+	a.Lock()
+	l, ok := a.leases[k]
+	delete(a.leases, k)
+	a.Unlock()
+	if !ok {
+		// Gateway and auxiliary addresses are not leased
+		return nil
+	}
+
+	a.dropLease(l)
+
+	return nil
+}
+
+func (a *allocator) DiscoverNew(dType discoverapi.DiscoveryType, data interface{}) error {
+	return nil
+}
+
+func (a *allocator) DiscoverDelete(dType discoverapi.DiscoveryType, data interface{}) error {
+	return nil
+}
+
+func leaseKey(poolID string, ip net.IP) string {
+	return poolID + "/" + ip.String()
+}
+
+func newXid() uint32 {
+	rndMu.Lock()
+	defer rndMu.Unlock()
+	return rnd.Uint32()
+}
+
+// probe returns the offer of the DHCP server on the parent interface to a
+// random client, the offered address is not requested
+func (a *allocator) probe(parent string) (*message, error) {
+	req := newMessage(msgDiscover, newXid(), netutils.GenerateRandomMAC())
+	req.options[optParamList] = []byte{optSubnetMask, optRouter}
+	return a.exchange(parent, req, msgOffer)
+}
+
+// acquire obtains a lease for the mac address through the
+// discover/offer/request/ack sequence
+func (a *allocator) acquire(p *pool, mac net.HardwareAddr, prefAddress net.IP) (*lease, error) {
+	xid := newXid()
+
+	disc := newMessage(msgDiscover, xid, mac)
+	disc.options[optParamList] = []byte{optSubnetMask, optRouter}
+	if prefAddress != nil {
+		disc.setIPOption(optRequestedIP, prefAddress)
+	}
+	offer, err := a.exchange(p.parent, disc, msgOffer)
+	if err != nil {
+		return nil, err
+	}
+
+	req := newMessage(msgRequest, xid, mac)
+	req.options[optParamList] = []byte{optSubnetMask, optRouter}
+	req.setIPOption(optRequestedIP, offer.yiaddr)
+	if server := offer.ipOption(optServerID); server != nil {
+		req.setIPOption(optServerID, server)
+	}
+	ack, err := a.exchange(p.parent, req, msgAck, msgNak)
+	if err != nil {
+		return nil, err
+	}
+	if ack.msgType() == msgNak {
+		return nil, types.ForbiddenErrorf("dhcp server on %s refused the lease of %s to %s", p.parent, offer.yiaddr, mac)
+	}
+
+	l := &lease{
+		parent:  p.parent,
+		mac:     mac,
+		address: &net.IPNet{IP: ack.yiaddr.To4(), Mask: p.nw.Mask},
+		server:  ack.ipOption(optServerID),
+		stop:    make(chan struct{}),
+	}
+	l.update(ack)
+
+	if !p.nw.Contains(l.address.IP) {
+		a.release(l)
+		return nil, types.ForbiddenErrorf("dhcp server on %s leased address %s out of pool %s", p.parent, l.address.IP, p.nw)
+	}
+	if prefAddress != nil && !prefAddress.Equal(l.address.IP) {
+		a.release(l)
+		return nil, types.ForbiddenErrorf("dhcp server on %s leased address %s in place of the requested %s", p.parent, l.address.IP, prefAddress)
+	}
+
+	log.Debugf("Leased %s to %s on %s until %s", l.address, mac, p.parent, l.expiry)
+
+	return l, nil
+}
+
+// update refreshes the lease timers from the server ack
+func (l *lease) update(ack *message) {
+	l.Lock()
+	defer l.Unlock()
+
+	lt := ack.durationOption(optLeaseTime)
+	if lt == 0 || lt == infiniteLease {
+		l.renewal = 0
+		l.expiry = time.Time{}
+		return
+	}
+	l.expiry = time.Now().Add(lt)
+	l.renewal = ack.durationOption(optRenewalTime)
+	if l.renewal == 0 || l.renewal >= lt {
+		l.renewal = lt / 2
+	}
+}
+
+// renew extends the lease with the server which granted it
+func (a *allocator) renew(l *lease) error {
+	l.Lock()
+	req := newMessage(msgRequest, newXid(), l.mac)
+	req.ciaddr = types.GetIPCopy(l.address.IP)
+	parent := l.parent
+	l.Unlock()
+
+	ack, err := a.exchange(parent, req, msgAck, msgNak)
+	if err != nil {
+		return err
+	}
+	if ack.msgType() == msgNak {
+		return errLeaseRefused
+	}
+	l.update(ack)
+
+	return nil
+}
+
+// keepAlive renews the lease in background until it is released or it
+// expires
+func (a *allocator) keepAlive(l *lease) {
+	l.Lock()
+	wait := l.renewal
+	l.Unlock()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-time.After(wait):
+		}
+
+		err := a.renew(l)
+
+		l.Lock()
+		ip, parent, renewal, expiry := l.address.IP, l.parent, l.renewal, l.expiry
+		l.Unlock()
+
+		if err == nil {
+			log.Debugf("Renewed lease of %s on %s until %s", ip, parent, expiry)
+			wait = renewal
+			continue
+		}
+
+		remaining := expiry.Sub(time.Now())
+		if err == errLeaseRefused || remaining <= 0 {
+			log.Errorf("Lease of %s on %s expired: %v", ip, parent, err)
+			return
+		}
+		log.Warnf("Failed to renew lease of %s on %s: %v", ip, parent, err)
+		wait = remaining / 2
+		if wait < minRenewInterval {
+			wait = minRenewInterval
+		}
+	}
+}
+
+func (a *allocator) dropLease(l *lease) {
+	close(l.stop)
+	if err := a.release(l); err != nil {
+		log.Warnf("Failed to release lease of %s on %s: %v", l.address.IP, l.parent, err)
+	}
+}
+
+// release gives the leased address back to the server
+func (a *allocator) release(l *lease) error {
+	l.Lock()
+	req := newMessage(msgRelease, newXid(), l.mac)
+	req.flags = 0
+	req.ciaddr = types.GetIPCopy(l.address.IP)
+	if l.server != nil {
+		req.setIPOption(optServerID, l.server)
+	}
+	parent := l.parent
+	l.Unlock()
+
+	_, err := a.exchange(parent, req)
+	return err
+}
+
+// exchange sends the message on the parent interface and waits for the
+// server reply of one of the expected types. The message is resent on
+// timeout. If no reply type is expected, the message is sent only once.
+func (a *allocator) exchange(parent string, req *message, expect ...byte) (*message, error) {
+	a.xchgMu.Lock()
+	defer a.xchgMu.Unlock()
+
+	c, err := a.dial(parent)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	b := req.marshal()
+	for i := 0; i < exchangeRetries; i++ {
+		if err := c.send(b); err != nil {
+			return nil, fmt.Errorf("failed to send dhcp message on %s: %v", parent, err)
+		}
+		if len(expect) == 0 {
+			return nil, nil
+		}
+
+		deadline := time.Now().Add(exchangeTimeout)
+		for {
+			rb, err := c.receive(deadline)
+			if err != nil {
+				if nErr, ok := err.(net.Error); ok && nErr.Timeout() {
+					break
+				}
+				return nil, fmt.Errorf("failed to receive dhcp message on %s: %v", parent, err)
+			}
+			rsp, err := parseMessage(rb)
+			if err != nil {
+				log.Debugf("Discarding dhcp message received on %s: %v", parent, err)
+				continue
+			}
+			if rsp.op != opReply || rsp.xid != req.xid {
+				continue
+			}
+			for _, t := range expect {
+				if rsp.msgType() == t {
+					return rsp, nil
+				}
+			}
+		}
+	}
+
+	return nil, types.NoServiceErrorf("no dhcp server answered on %s", parent)
+}
@@ -0,0 +1,283 @@
+package dhcp
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/netlabel"
+	_ "github.com/docker/libnetwork/testutils"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// fakeServer leases the addresses of 192.168.50.0/24 starting from .100
+type fakeServer struct {
+	sync.Mutex
+	leaseTime time.Duration
+	next      byte
+	leases    map[string]net.IP
+	renewals  int
+	releases  int
+	replies   [][]byte
+}
+
+func newFakeServer(leaseTime time.Duration) *fakeServer {
+	return &fakeServer{leaseTime: leaseTime, next: 100, leases: make(map[string]net.IP)}
+}
+
+func (s *fakeServer) dial(ifname string) (conn, error) {
+	return s, nil
+}
+
+func (s *fakeServer) send(b []byte) error {
+	req, err := parseMessage(b)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	mac := req.chaddr.String()
+	rsp := &message{op: opReply, xid: req.xid, options: make(map[byte][]byte)}
+	rsp.setIPOption(optServerID, net.ParseIP("192.168.50.2"))
+	rsp.setIPOption(optSubnetMask, net.IP(net.CIDRMask(24, 32)))
+	rsp.setIPOption(optRouter, net.ParseIP("192.168.50.1"))
+	rsp.setDurationOption(optLeaseTime, s.leaseTime)
+
+	switch req.msgType() {
+	case msgDiscover:
+		ip, ok := s.leases[mac]
+		if !ok {
+			ip = req.ipOption(optRequestedIP)
+			if ip == nil {
+				ip = net.IPv4(192, 168, 50, s.next).To4()
+				s.next++
+			}
+		}
+		rsp.yiaddr = ip
+		rsp.options[optMessageType] = []byte{msgOffer}
+	case msgRequest:
+		if !req.ciaddr.Equal(net.IPv4zero) {
+			if !s.leases[mac].Equal(req.ciaddr) {
+				rsp.options[optMessageType] = []byte{msgNak}
+				break
+			}
+			s.renewals++
+			rsp.yiaddr = req.ciaddr
+		} else {
+			rsp.yiaddr = req.ipOption(optRequestedIP)
+			s.leases[mac] = rsp.yiaddr
+		}
+		rsp.options[optMessageType] = []byte{msgAck}
+	case msgRelease:
+		s.releases++
+		delete(s.leases, mac)
+		return nil
+	}
+
+	s.replies = append(s.replies, rsp.marshal())
+
+	return nil
+}
+
+func (s *fakeServer) receive(deadline time.Time) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.replies) == 0 {
+		return nil, timeoutError{}
+	}
+	b := s.replies[0]
+	s.replies = s.replies[1:]
+	return b, nil
+}
+
+func (s *fakeServer) close() error {
+	return nil
+}
+
+func TestMessageMarshal(t *testing.T) {
+	mac, _ := net.ParseMAC("02:42:ac:11:00:02")
+	m := newMessage(msgRequest, 0x12345678, mac)
+	m.ciaddr = net.ParseIP("192.168.50.100")
+	m.setIPOption(optRequestedIP, net.ParseIP("192.168.50.101"))
+	m.setDurationOption(optLeaseTime, time.Hour)
+
+	b := m.marshal()
+	if len(b) < minMessageLen {
+		t.Fatalf("Unexpected message length %d", len(b))
+	}
+
+	p, err := parseMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.op != opRequest || p.xid != m.xid || p.flags != flagBroadcast || p.msgType() != msgRequest {
+		t.Fatalf("Unexpected message header: %+v", p)
+	}
+	if p.chaddr.String() != mac.String() || !p.ciaddr.Equal(m.ciaddr) {
+		t.Fatalf("Unexpected message addresses: %s %s", p.chaddr, p.ciaddr)
+	}
+	if ip := p.ipOption(optRequestedIP); !ip.Equal(net.ParseIP("192.168.50.101")) {
+		t.Fatalf("Unexpected requested address %s", ip)
+	}
+	if d := p.durationOption(optLeaseTime); d != time.Hour {
+		t.Fatalf("Unexpected lease time %s", d)
+	}
+	if id := p.options[optClientID]; len(id) != 7 || id[0] != htypeEthernet {
+		t.Fatalf("Unexpected client id % x", id)
+	}
+
+	if _, err := parseMessage(b[:100]); err == nil {
+		t.Fatalf("Expected failure on short message")
+	}
+	b[cookieOffset] = 0
+	if _, err := parseMessage(b); err == nil {
+		t.Fatalf("Expected failure on invalid magic cookie")
+	}
+}
+
+func TestRequestPool(t *testing.T) {
+	s := newFakeServer(time.Hour)
+	a := newAllocator(s.dial)
+	opts := map[string]string{InterfaceOpt: "eth0"}
+
+	if _, _, _, err := a.RequestPool(defaultAS, "", "", nil, false); err == nil {
+		t.Fatalf("Expected failure without parent interface")
+	}
+	if _, _, _, err := a.RequestPool(defaultAS, "", "", opts, true); err == nil {
+		t.Fatalf("Expected failure on IPv6 pool request")
+	}
+	if _, _, _, err := a.RequestPool(defaultAS, "10.0.0.0/24", "", opts, false); err == nil {
+		t.Fatalf("Expected failure on pool not matching the server offer")
+	}
+
+	id, nw, meta, err := a.RequestPool(defaultAS, "", "", opts, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nw.String() != "192.168.50.0/24" {
+		t.Fatalf("Unexpected pool %s", nw)
+	}
+	if meta[netlabel.Gateway] != "192.168.50.1/24" {
+		t.Fatalf("Unexpected gateway %v", meta)
+	}
+
+	id2, _, _, err := a.RequestPool(defaultAS, "192.168.50.0/24", "", opts, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != id2 {
+		t.Fatalf("Unexpected pool id %s. Expected %s", id2, id)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := a.ReleasePool(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.ReleasePool(id); err == nil {
+		t.Fatalf("Expected failure on releasing unknown pool")
+	}
+}
+
+func TestRequestReleaseAddress(t *testing.T) {
+	s := newFakeServer(time.Hour)
+	a := newAllocator(s.dial)
+
+	id, _, _, err := a.RequestPool(defaultAS, "", "", map[string]string{InterfaceOpt: "eth0"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gw, _, err := a.RequestAddress(id, nil, map[string]string{ipamapi.RequestAddressType: netlabel.Gateway})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gw.String() != "192.168.50.1/24" {
+		t.Fatalf("Unexpected gateway %s", gw)
+	}
+
+	if _, _, err := a.RequestAddress(id, nil, nil); err == nil {
+		t.Fatalf("Expected failure without mac address")
+	}
+	if _, _, err := a.RequestAddress(id, net.ParseIP("192.168.50.10"), nil); err != ipamapi.ErrIPOutOfRange {
+		t.Fatalf("Unexpected error for auxiliary address: %v", err)
+	}
+
+	ip, _, err := a.RequestAddress(id, nil, map[string]string{netlabel.MacAddress: "02:42:ac:11:00:02"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "192.168.50.101/24" {
+		t.Fatalf("Unexpected address %s", ip)
+	}
+
+	ip2, _, err := a.RequestAddress(id, net.ParseIP("192.168.50.200"), map[string]string{netlabel.MacAddress: "02:42:ac:11:00:03"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip2.String() != "192.168.50.200/24" {
+		t.Fatalf("Unexpected address %s", ip2)
+	}
+
+	if err := a.ReleaseAddress(id, ip.IP); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.ReleaseAddress(id, gw.IP); err != nil {
+		t.Fatal(err)
+	}
+	if s.releases != 1 || len(s.leases) != 1 {
+		t.Fatalf("Unexpected server state after release: %d releases, %d leases", s.releases, len(s.leases))
+	}
+
+	if err := a.ReleasePool(id); err != nil {
+		t.Fatal(err)
+	}
+	if s.releases != 2 || len(s.leases) != 0 {
+		t.Fatalf("Unexpected server state after pool release: %d releases, %d leases", s.releases, len(s.leases))
+	}
+}
+
+func TestLeaseRenewal(t *testing.T) {
+	s := newFakeServer(2 * time.Second)
+	a := newAllocator(s.dial)
+
+	id, _, _, err := a.RequestPool(defaultAS, "", "", map[string]string{InterfaceOpt: "eth0"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip, _, err := a.RequestAddress(id, nil, map[string]string{netlabel.MacAddress: "02:42:ac:11:00:02"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+
+	s.Lock()
+	renewals := s.renewals
+	s.Unlock()
+	if renewals == 0 {
+		t.Fatalf("Expected the lease to be renewed")
+	}
+
+	if err := a.ReleaseAddress(id, ip.IP); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+
+	s.Lock()
+	defer s.Unlock()
+	if s.renewals != renewals {
+		t.Fatalf("Unexpected renewal after release")
+	}
+}
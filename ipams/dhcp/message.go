@@ -0,0 +1,200 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// BOOTP operation codes
+const (
+	opRequest = 1
+	opReply   = 2
+)
+
+// DHCP message types (RFC 2132, option 53)
+const (
+	msgDiscover = 1
+	msgOffer    = 2
+	msgRequest  = 3
+	msgDecline  = 4
+	msgAck      = 5
+	msgNak      = 6
+	msgRelease  = 7
+)
+
+// DHCP option codes used by the driver
+const (
+	optPad         = 0
+	optSubnetMask  = 1
+	optRouter      = 3
+	optRequestedIP = 50
+	optLeaseTime   = 51
+	optMessageType = 53
+	optServerID    = 54
+	optParamList   = 55
+	optRenewalTime = 58
+	optRebindTime  = 59
+	optClientID    = 61
+	optEnd         = 255
+)
+
+// Message layout
+const (
+	headerLen       = 236
+	minMessageLen   = 300
+	chaddrOffset    = 28
+	cookieOffset    = headerLen
+	optionsOffset   = headerLen + 4
+	hardwareAddrLen = 16
+	htypeEthernet   = 1
+	flagBroadcast   = 0x8000
+)
+
+var magicCookie = []byte{99, 130, 83, 99}
+
+// message is a DHCP message, only the fields the driver makes use of are
+// decoded
+type message struct {
+	op      byte
+	xid     uint32
+	flags   uint16
+	ciaddr  net.IP
+	yiaddr  net.IP
+	chaddr  net.HardwareAddr
+	options map[byte][]byte
+}
+
+func newMessage(msgType byte, xid uint32, mac net.HardwareAddr) *message {
+	m := &message{
+		op:      opRequest,
+		xid:     xid,
+		flags:   flagBroadcast,
+		chaddr:  mac,
+		options: make(map[byte][]byte),
+	}
+	m.options[optMessageType] = []byte{msgType}
+	m.options[optClientID] = append([]byte{htypeEthernet}, mac...)
+	return m
+}
+
+func (m *message) marshal() []byte {
+	b := make([]byte, optionsOffset, minMessageLen)
+	b[0] = m.op
+	b[1] = htypeEthernet
+	b[2] = byte(len(m.chaddr))
+	binary.BigEndian.PutUint32(b[4:8], m.xid)
+	binary.BigEndian.PutUint16(b[10:12], m.flags)
+	if ip := m.ciaddr.To4(); ip != nil {
+		copy(b[12:16], ip)
+	}
+	if ip := m.yiaddr.To4(); ip != nil {
+		copy(b[16:20], ip)
+	}
+	copy(b[chaddrOffset:chaddrOffset+hardwareAddrLen], m.chaddr)
+	copy(b[cookieOffset:optionsOffset], magicCookie)
+
+	// The message type goes first, the other options follow in code order
+	codes := make([]int, 0, len(m.options))
+	for c := range m.options {
+		if c != optMessageType {
+			codes = append(codes, int(c))
+		}
+	}
+	sort.Ints(codes)
+	if v, ok := m.options[optMessageType]; ok {
+		b = append(b, optMessageType, byte(len(v)))
+		b = append(b, v...)
+	}
+	for _, c := range codes {
+		v := m.options[byte(c)]
+		b = append(b, byte(c), byte(len(v)))
+		b = append(b, v...)
+	}
+	b = append(b, optEnd)
+
+	for len(b) < minMessageLen {
+		b = append(b, optPad)
+	}
+
+	return b
+}
+
+func parseMessage(b []byte) (*message, error) {
+	if len(b) < optionsOffset {
+		return nil, fmt.Errorf("dhcp message too short: %d bytes", len(b))
+	}
+	for i, c := range magicCookie {
+		if b[cookieOffset+i] != c {
+			return nil, fmt.Errorf("dhcp message has an invalid magic cookie")
+		}
+	}
+
+	hlen := int(b[2])
+	if hlen > hardwareAddrLen {
+		return nil, fmt.Errorf("dhcp message has an invalid hardware address length %d", hlen)
+	}
+
+	m := &message{
+		op:      b[0],
+		xid:     binary.BigEndian.Uint32(b[4:8]),
+		flags:   binary.BigEndian.Uint16(b[10:12]),
+		ciaddr:  net.IP(append([]byte(nil), b[12:16]...)),
+		yiaddr:  net.IP(append([]byte(nil), b[16:20]...)),
+		chaddr:  net.HardwareAddr(append([]byte(nil), b[chaddrOffset:chaddrOffset+hlen]...)),
+		options: make(map[byte][]byte),
+	}
+
+	for i := optionsOffset; i < len(b); {
+		code := b[i]
+		if code == optEnd {
+			break
+		}
+		if code == optPad {
+			i++
+			continue
+		}
+		if i+1 >= len(b) || i+2+int(b[i+1]) > len(b) {
+			return nil, fmt.Errorf("dhcp message has a truncated option %d", code)
+		}
+		l := int(b[i+1])
+		m.options[code] = append(m.options[code], b[i+2:i+2+l]...)
+		i += 2 + l
+	}
+
+	return m, nil
+}
+
+func (m *message) msgType() byte {
+	if v := m.options[optMessageType]; len(v) == 1 {
+		return v[0]
+	}
+	return 0
+}
+
+func (m *message) setIPOption(code byte, ip net.IP) {
+	m.options[code] = append([]byte(nil), ip.To4()...)
+}
+
+// ipOption returns the first address carried by the option, if any
+func (m *message) ipOption(code byte) net.IP {
+	if v := m.options[code]; len(v) >= net.IPv4len {
+		return net.IP(append([]byte(nil), v[:net.IPv4len]...))
+	}
+	return nil
+}
+
+func (m *message) durationOption(code byte) time.Duration {
+	if v := m.options[code]; len(v) == 4 {
+		return time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+	}
+	return 0
+}
+
+func (m *message) setDurationOption(code byte, d time.Duration) {
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint32(v, uint32(d/time.Second))
+	m.options[code] = v
+}
@@ -24,6 +24,8 @@ type GetCapabilityResponse struct {
 	Response
 	RequiresMACAddress    bool
 	RequiresRequestReplay bool
+	BatchAddresses        bool
+	Inventory             bool
 }
 
 // ToCapability converts the capability response into the internal ipam driver capaility structure
@@ -92,3 +94,61 @@ type ReleaseAddressRequest struct {
 type ReleaseAddressResponse struct {
 	Response
 }
+
+// RequestAddressesRequest represents the expected data in a ``request addresses`` batch request message
+type RequestAddressesRequest struct {
+	Requests []RequestAddressRequest
+}
+
+// RequestAddressesResponse represents the response message to a ``request addresses`` batch request,
+// it carries one response per request, in the requests order
+type RequestAddressesResponse struct {
+	Response
+	Responses []RequestAddressResponse
+}
+
+// ReleaseAddressesRequest represents the expected data in a ``release addresses`` batch request message
+type ReleaseAddressesRequest struct {
+	Requests []ReleaseAddressRequest
+}
+
+// ReleaseAddressesResponse represents the response message to a ``release addresses`` batch request,
+// it carries one response per request, in the requests order
+type ReleaseAddressesResponse struct {
+	Response
+	Responses []ReleaseAddressResponse
+}
+
+// GetPoolsUsageRequest represents the expected data in a ``get pools usage`` request message
+type GetPoolsUsageRequest struct {
+	AddressSpace string
+}
+
+// PoolUsage represents the utilization of a pool in a ``get pools usage`` response message
+type PoolUsage struct {
+	AddressSpace string
+	PoolID       string
+	Pool         string // CIDR format
+	SubPool      string // CIDR format
+	RefCount     int
+	Total        uint64
+	Allocated    uint64
+	Free         uint64
+}
+
+// GetPoolsUsageResponse represents the response message to a ``get pools usage`` request
+type GetPoolsUsageResponse struct {
+	Response
+	Pools []PoolUsage
+}
+
+// GetAllocatedAddressesRequest represents the expected data in a ``get allocated addresses`` request message
+type GetAllocatedAddressesRequest struct {
+	PoolID string
+}
+
+// GetAllocatedAddressesResponse represents the response message to a ``get allocated addresses`` request
+type GetAllocatedAddressesResponse struct {
+	Response
+	Addresses []string
+}
@@ -0,0 +1,140 @@
+package remote
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/docker/libnetwork/ipams/remote/api"
+	"github.com/docker/libnetwork/types"
+)
+
+// maxAddressBatch is the maximum number of requests sent in a batch call
+const maxAddressBatch = 128
+
+// addressCall is an address request or release waiting for its batch
+type addressCall struct {
+	poolID  string
+	address string
+	options map[string]string
+	// results
+	retAddress *net.IPNet
+	retData    map[string]string
+	err        error
+	done       chan struct{}
+}
+
+// batcher coalesces the calls issued while a plugin call is in flight into
+// a single batch call. A lone call is not delayed, it is sent as soon as the
+// plugin is idle, so batches only form when many endpoints are created or
+// deleted at once.
+type batcher struct {
+	sync.Mutex
+	queue   []*addressCall
+	running bool
+	flush   func([]*addressCall)
+}
+
+func newBatcher(flush func([]*addressCall)) *batcher {
+	return &batcher{flush: flush}
+}
+
+// do queues the call and waits for its completion
+func (b *batcher) do(c *addressCall) {
+	c.done = make(chan struct{})
+
+	b.Lock()
+	b.queue = append(b.queue, c)
+	if !b.running {
+		b.running = true
+		go b.run()
+	}
+	b.Unlock()
+
+	<-c.done
+}
+
+func (b *batcher) run() {
+	b.Lock()
+	for len(b.queue) > 0 {
+		calls := b.queue
+		if len(calls) > maxAddressBatch {
+			calls = calls[:maxAddressBatch]
+		}
+		b.queue = b.queue[len(calls):]
+		b.Unlock()
+
+		b.flush(calls)
+		for _, c := range calls {
+			close(c.done)
+		}
+
+		b.Lock()
+	}
+	b.running = false
+	b.Unlock()
+}
+
+// flushRequests sends the address requests to the plugin, as a single
+// request if there is only one of them
+func (a *allocator) flushRequests(calls []*addressCall) {
+	if len(calls) == 1 {
+		c := calls[0]
+		c.retAddress, c.retData, c.err = a.requestAddress(c.poolID, c.address, c.options)
+		return
+	}
+
+	req := &api.RequestAddressesRequest{Requests: make([]api.RequestAddressRequest, 0, len(calls))}
+	for _, c := range calls {
+		req.Requests = append(req.Requests, api.RequestAddressRequest{PoolID: c.poolID, Address: c.address, Options: c.options})
+	}
+	res := &api.RequestAddressesResponse{}
+	err := a.call("RequestAddresses", req, res)
+	if err == nil && len(res.Responses) != len(calls) {
+		err = fmt.Errorf("remote: %d responses to %d address requests", len(res.Responses), len(calls))
+	}
+	for i, c := range calls {
+		if err != nil {
+			c.err = err
+			continue
+		}
+		r := &res.Responses[i]
+		if !r.IsSuccess() {
+			c.err = fmt.Errorf("remote: %s", r.GetError())
+			continue
+		}
+		if r.Address != "" {
+			c.retAddress, c.err = types.ParseCIDR(r.Address)
+		}
+		c.retData = r.Data
+	}
+}
+
+// flushReleases sends the address releases to the plugin, as a single
+// release if there is only one of them
+func (a *allocator) flushReleases(calls []*addressCall) {
+	if len(calls) == 1 {
+		c := calls[0]
+		c.err = a.releaseAddress(c.poolID, c.address)
+		return
+	}
+
+	req := &api.ReleaseAddressesRequest{Requests: make([]api.ReleaseAddressRequest, 0, len(calls))}
+	for _, c := range calls {
+		req.Requests = append(req.Requests, api.ReleaseAddressRequest{PoolID: c.poolID, Address: c.address})
+	}
+	res := &api.ReleaseAddressesResponse{}
+	err := a.call("ReleaseAddresses", req, res)
+	if err == nil && len(res.Responses) != len(calls) {
+		err = fmt.Errorf("remote: %d responses to %d address releases", len(res.Responses), len(calls))
+	}
+	for i, c := range calls {
+		if err != nil {
+			c.err = err
+			continue
+		}
+		if r := &res.Responses[i]; !r.IsSuccess() {
+			c.err = fmt.Errorf("remote: %s", r.GetError())
+		}
+	}
+}
@@ -15,6 +15,13 @@ import (
 type allocator struct {
 	endpoint *plugins.Client
 	name     string
+	// requests and releases batch the address calls, they are set
+	// when the plugin advertises the batch calls support
+	requests *batcher
+	releases *batcher
+	// inventory is set when the plugin advertises the GetPoolsUsage and
+	// GetAllocatedAddresses calls support
+	inventory bool
 }
 
 // PluginResponse is the interface for the plugin request responses
@@ -64,6 +71,11 @@ func (a *allocator) getCapabilities() (*ipamapi.Capability, error) {
 	if err := a.call("GetCapabilities", nil, &res); err != nil {
 		return nil, err
 	}
+	if res.BatchAddresses {
+		a.requests = newBatcher(a.flushRequests)
+		a.releases = newBatcher(a.flushReleases)
+	}
+	a.inventory = res.Inventory
 	return res.ToCapability(), nil
 }
 
@@ -96,14 +108,23 @@ func (a *allocator) ReleasePool(poolID string) error {
 
 // RequestAddress requests an address from the address pool
 func (a *allocator) RequestAddress(poolID string, address net.IP, options map[string]string) (*net.IPNet, map[string]string, error) {
-	var (
-		prefAddress string
-		retAddress  *net.IPNet
-		err         error
-	)
+	var prefAddress string
 	if address != nil {
 		prefAddress = address.String()
 	}
+	if a.requests == nil {
+		return a.requestAddress(poolID, prefAddress, options)
+	}
+	c := &addressCall{poolID: poolID, address: prefAddress, options: options}
+	a.requests.do(c)
+	return c.retAddress, c.retData, c.err
+}
+
+func (a *allocator) requestAddress(poolID, prefAddress string, options map[string]string) (*net.IPNet, map[string]string, error) {
+	var (
+		retAddress *net.IPNet
+		err        error
+	)
 	req := &api.RequestAddressRequest{PoolID: poolID, Address: prefAddress, Options: options}
 	res := &api.RequestAddressResponse{}
 	if err := a.call("RequestAddress", req, res); err != nil {
@@ -121,11 +142,80 @@ func (a *allocator) ReleaseAddress(poolID string, address net.IP) error {
 	if address != nil {
 		relAddress = address.String()
 	}
+	if a.releases == nil {
+		return a.releaseAddress(poolID, relAddress)
+	}
+	c := &addressCall{poolID: poolID, address: relAddress}
+	a.releases.do(c)
+	return c.err
+}
+
+func (a *allocator) releaseAddress(poolID, relAddress string) error {
 	req := &api.ReleaseAddressRequest{PoolID: poolID, Address: relAddress}
 	res := &api.ReleaseAddressResponse{}
 	return a.call("ReleaseAddress", req, res)
 }
 
+// PoolsUsage returns the utilization of the pools of the passed address space
+func (a *allocator) PoolsUsage(addressSpace string) ([]*ipamapi.PoolUsage, error) {
+	if !a.inventory {
+		return nil, types.NotImplementedErrorf("remote ipam driver %s does not report the pools usage", a.name)
+	}
+	req := &api.GetPoolsUsageRequest{AddressSpace: addressSpace}
+	res := &api.GetPoolsUsageResponse{}
+	if err := a.call("GetPoolsUsage", req, res); err != nil {
+		return nil, err
+	}
+
+	list := make([]*ipamapi.PoolUsage, 0, len(res.Pools))
+	for _, p := range res.Pools {
+		u := &ipamapi.PoolUsage{
+			AddressSpace: p.AddressSpace,
+			PoolID:       p.PoolID,
+			RefCount:     p.RefCount,
+			Total:        p.Total,
+			Allocated:    p.Allocated,
+			Free:         p.Free,
+		}
+		var err error
+		if u.Pool, err = types.ParseCIDR(p.Pool); err != nil {
+			return nil, fmt.Errorf("remote: invalid pool %q for pool id %s: %v", p.Pool, p.PoolID, err)
+		}
+		if p.SubPool != "" {
+			if u.SubPool, err = types.ParseCIDR(p.SubPool); err != nil {
+				return nil, fmt.Errorf("remote: invalid sub pool %q for pool id %s: %v", p.SubPool, p.PoolID, err)
+			}
+		}
+		list = append(list, u)
+	}
+
+	return list, nil
+}
+
+// WalkAllocatedAddresses invokes the walker for each address allocated from the pool
+func (a *allocator) WalkAllocatedAddresses(poolID string, walker func(net.IP) bool) error {
+	if !a.inventory {
+		return types.NotImplementedErrorf("remote ipam driver %s does not report the allocated addresses", a.name)
+	}
+	req := &api.GetAllocatedAddressesRequest{PoolID: poolID}
+	res := &api.GetAllocatedAddressesResponse{}
+	if err := a.call("GetAllocatedAddresses", req, res); err != nil {
+		return err
+	}
+
+	for _, s := range res.Addresses {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return fmt.Errorf("remote: invalid address %q for pool id %s", s, poolID)
+		}
+		if walker(ip) {
+			break
+		}
+	}
+
+	return nil
+}
+
 // DiscoverNew is a notification for a new discovery event, such as a new global datastore
 func (a *allocator) DiscoverNew(dType discoverapi.DiscoveryType, data interface{}) error {
 	return nil
@@ -9,11 +9,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/pkg/plugins"
 	"github.com/docker/libnetwork/ipamapi"
 	_ "github.com/docker/libnetwork/testutils"
+	"github.com/docker/libnetwork/types"
 )
 
 func decodeToMap(r *http.Request) (res map[string]interface{}, err error) {
@@ -290,3 +293,271 @@ func TestRemoteDriver(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func isNotImplementedErr(err error) bool {
+	_, ok := err.(types.NotImplementedError)
+	return ok
+}
+
+func TestRemoteInventory(t *testing.T) {
+	var plugin = "test-ipam-driver-inventory"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	handle(t, mux, "GetCapabilities", func(msg map[string]interface{}) interface{} {
+		return map[string]interface{}{"Inventory": true}
+	})
+
+	handle(t, mux, "GetPoolsUsage", func(msg map[string]interface{}) interface{} {
+		if v, ok := msg["AddressSpace"]; !ok || v.(string) != "white" {
+			t.Fatalf("Unexpected address space in pools usage request: %v", msg)
+		}
+		return map[string]interface{}{
+			"Pools": []map[string]interface{}{
+				{
+					"AddressSpace": "white",
+					"PoolID":       "white/172.20.0.0/16/172.20.3.0/24",
+					"Pool":         "172.20.0.0/16",
+					"SubPool":      "172.20.3.0/24",
+					"RefCount":     1,
+					"Total":        256,
+					"Allocated":    2,
+					"Free":         254,
+				},
+			},
+		}
+	})
+
+	handle(t, mux, "GetAllocatedAddresses", func(msg map[string]interface{}) interface{} {
+		if _, ok := msg["PoolID"]; !ok {
+			t.Fatalf("Missing PoolID in allocated addresses request")
+		}
+		return map[string]interface{}{
+			"Addresses": []string{"172.20.3.1", "172.20.3.2"},
+		}
+	})
+
+	p, err := plugins.Get(plugin, ipamapi.PluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := newAllocator(plugin, p.Client)
+	inv, ok := d.(ipamapi.Inventory)
+	if !ok {
+		t.Fatal("Remote allocator does not implement the inventory interface")
+	}
+
+	// The calls are not made until the capability is known
+	if _, err := inv.PoolsUsage("white"); !isNotImplementedErr(err) {
+		t.Fatalf("Expected a not implemented error, got: %v", err)
+	}
+	if err := inv.WalkAllocatedAddresses("white/172.20.0.0/16", func(net.IP) bool { return false }); !isNotImplementedErr(err) {
+		t.Fatalf("Expected a not implemented error, got: %v", err)
+	}
+
+	if _, err := d.(*allocator).getCapabilities(); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := inv.PoolsUsage("white")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("Unexpected pools usage: %v", list)
+	}
+	u := list[0]
+	if u.Pool.String() != "172.20.0.0/16" || u.SubPool.String() != "172.20.3.0/24" ||
+		u.Total != 256 || u.Allocated != 2 || u.Free != 254 || u.RefCount != 1 {
+		t.Fatalf("Unexpected pool usage: %+v", u)
+	}
+
+	var addrs []string
+	if err := inv.WalkAllocatedAddresses(u.PoolID, func(ip net.IP) bool {
+		addrs = append(addrs, ip.String())
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "172.20.3.1" {
+		t.Fatalf("Unexpected allocated addresses: %v", addrs)
+	}
+}
+
+// setupBatchPlugin starts a plugin handing out sequential addresses. The
+// single calls are slowed down for the concurrent requests to pile up.
+func setupBatchPlugin(t *testing.T, plugin string, batch bool) (func(), map[string]int) {
+	var (
+		mu    sync.Mutex
+		next  = 1
+		calls = make(map[string]int)
+	)
+
+	allocate := func(msg map[string]interface{}) map[string]interface{} {
+		if v, ok := msg["Address"]; ok && v.(string) == "172.20.255.255" {
+			return map[string]interface{}{"Error": "address not available"}
+		}
+		ip := fmt.Sprintf("172.20.%d.%d/16", next/256, next%256)
+		next++
+		return map[string]interface{}{"Address": ip}
+	}
+
+	mux := http.NewServeMux()
+	cleanup := setupPlugin(t, plugin, mux)
+
+	handle(t, mux, "GetCapabilities", func(msg map[string]interface{}) interface{} {
+		return map[string]interface{}{"BatchAddresses": batch}
+	})
+
+	handle(t, mux, "RequestAddress", func(msg map[string]interface{}) interface{} {
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		calls["RequestAddress"]++
+		return allocate(msg)
+	})
+
+	handle(t, mux, "RequestAddresses", func(msg map[string]interface{}) interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		calls["RequestAddresses"]++
+		var res []interface{}
+		for _, r := range msg["Requests"].([]interface{}) {
+			res = append(res, allocate(r.(map[string]interface{})))
+		}
+		return map[string]interface{}{"Responses": res}
+	})
+
+	handle(t, mux, "ReleaseAddress", func(msg map[string]interface{}) interface{} {
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		calls["ReleaseAddress"]++
+		return map[string]interface{}{}
+	})
+
+	handle(t, mux, "ReleaseAddresses", func(msg map[string]interface{}) interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		calls["ReleaseAddresses"]++
+		res := make([]interface{}, len(msg["Requests"].([]interface{})))
+		for i := range res {
+			res[i] = map[string]interface{}{}
+		}
+		return map[string]interface{}{"Responses": res}
+	})
+
+	return cleanup, calls
+}
+
+func requestConcurrently(t *testing.T, d ipamapi.Ipam, count int) []*net.IPNet {
+	var wg sync.WaitGroup
+	addrs := make([]*net.IPNet, count)
+	errs := make([]error, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			addrs[i], _, errs[i] = d.RequestAddress("white/172.20.0.0/16", nil, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[addrs[i].String()] {
+			t.Fatalf("Address %s handed out twice", addrs[i])
+		}
+		seen[addrs[i].String()] = true
+	}
+
+	return addrs
+}
+
+func TestRemoteBatchAddresses(t *testing.T) {
+	var plugin = "test-ipam-driver-batch"
+
+	cleanup, calls := setupBatchPlugin(t, plugin, true)
+	defer cleanup()
+
+	p, err := plugins.Get(plugin, ipamapi.PluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := newAllocator(plugin, p.Client)
+	if _, err := d.(*allocator).getCapabilities(); err != nil {
+		t.Fatal(err)
+	}
+
+	addrs := requestConcurrently(t, d, 50)
+	if calls["RequestAddresses"] == 0 || calls["RequestAddress"]+calls["RequestAddresses"] >= 50 {
+		t.Fatalf("Address requests were not batched: %v", calls)
+	}
+
+	// A failed request in a batch fails alone
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var pref net.IP
+			if i == 5 {
+				pref = net.ParseIP("172.20.255.255")
+			}
+			_, _, errs[i] = d.RequestAddress("white/172.20.0.0/16", pref, nil)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if (i == 5) != (err != nil) {
+			t.Fatalf("Unexpected result for request %d: %v", i, err)
+		}
+	}
+
+	errs = make([]error, len(addrs))
+	for i := range addrs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = d.ReleaseAddress("white/172.20.0.0/16", addrs[i].IP)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls["ReleaseAddresses"] == 0 || calls["ReleaseAddress"]+calls["ReleaseAddresses"] >= len(addrs) {
+		t.Fatalf("Address releases were not batched: %v", calls)
+	}
+}
+
+func TestRemoteBatchAddressesUnsupported(t *testing.T) {
+	var plugin = "test-ipam-driver-no-batch"
+
+	cleanup, calls := setupBatchPlugin(t, plugin, false)
+	defer cleanup()
+
+	p, err := plugins.Get(plugin, ipamapi.PluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := newAllocator(plugin, p.Client)
+	if _, err := d.(*allocator).getCapabilities(); err != nil {
+		t.Fatal(err)
+	}
+
+	requestConcurrently(t, d, 10)
+	if calls["RequestAddresses"] != 0 || calls["RequestAddress"] != 10 {
+		t.Fatalf("Unexpected plugin calls: %v", calls)
+	}
+}
@@ -2,10 +2,23 @@
 package ipamutils
 
 import (
+	"fmt"
+	"math/big"
 	"net"
 	"sync"
 )
 
+// The biggest number of networks which can be carved out of a predefined
+// pool is 2^maxPoolSplitBits
+const maxPoolSplitBits = 16
+
+// PredefinedPool is a base network the predefined networks are carved out
+// of, each of them with a prefix length of Size
+type PredefinedPool struct {
+	Base string
+	Size int
+}
+
 var (
 	// PredefinedBroadNetworks contains a list of 31 IPv4 private networks with host size 16 and 12
 	// (172.17-31.x.x/16, 192.168.x.x/20) which do not overlap with the networks in `PredefinedGranularNetworks`
@@ -48,3 +61,32 @@ func initGranularPredefinedNetworks() []*net.IPNet {
 	}
 	return pl
 }
+
+// SplitPools returns the networks carved out of the passed pools, in order
+func SplitPools(pools []*PredefinedPool) ([]*net.IPNet, error) {
+	var pl []*net.IPNet
+	for _, p := range pools {
+		_, base, err := net.ParseCIDR(p.Base)
+		if err != nil {
+			return nil, fmt.Errorf("invalid predefined pool base %q: %v", p.Base, err)
+		}
+		ones, bits := base.Mask.Size()
+		if p.Size < ones || p.Size > bits {
+			return nil, fmt.Errorf("invalid size %d for predefined pool %s", p.Size, base)
+		}
+		if p.Size-ones > maxPoolSplitBits {
+			return nil, fmt.Errorf("predefined pool %s split in /%d networks exceeds the maximum of %d networks", base, p.Size, 1<<maxPoolSplitBits)
+		}
+
+		mask := net.CIDRMask(p.Size, bits)
+		start := new(big.Int).SetBytes(base.IP)
+		for i := 0; i < 1<<uint(p.Size-ones); i++ {
+			offset := new(big.Int).Lsh(big.NewInt(int64(i)), uint(bits-p.Size))
+			b := new(big.Int).Add(start, offset).Bytes()
+			ip := make(net.IP, len(base.IP))
+			copy(ip[len(ip)-len(b):], b)
+			pl = append(pl, &net.IPNet{IP: ip, Mask: mask})
+		}
+	}
+	return pl, nil
+}
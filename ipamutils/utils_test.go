@@ -24,3 +24,35 @@ func TestGranularPredefined(t *testing.T) {
 	}
 
 }
+
+func TestSplitPools(t *testing.T) {
+	pl, err := SplitPools([]*PredefinedPool{
+		{Base: "100.64.0.0/14", Size: 16},
+		{Base: "fd00:1::/48", Size: 64},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pl) != 4+65536 {
+		t.Fatalf("Unexpected number of networks: %d", len(pl))
+	}
+	for i, expected := range []string{"100.64.0.0/16", "100.65.0.0/16", "100.66.0.0/16", "100.67.0.0/16", "fd00:1::/64", "fd00:1:0:1::/64"} {
+		if pl[i].String() != expected {
+			t.Fatalf("Unexpected network %d. Expected %s. Got %s", i, expected, pl[i])
+		}
+	}
+	if last := pl[len(pl)-1].String(); last != "fd00:1:0:ffff::/64" {
+		t.Fatalf("Unexpected last network %s", last)
+	}
+
+	for _, bad := range []*PredefinedPool{
+		{Base: "100.64.0.0", Size: 16},
+		{Base: "100.64.0.0/14", Size: 12},
+		{Base: "100.64.0.0/14", Size: 33},
+		{Base: "fd00::/8", Size: 64},
+	} {
+		if _, err := SplitPools([]*PredefinedPool{bad}); err == nil {
+			t.Fatalf("Expected failure for pool %+v", bad)
+		}
+	}
+}
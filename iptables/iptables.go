@@ -391,6 +391,12 @@ func RawCombinedOutput(args ...string) error {
 	return nil
 }
 
+// RawNative behaves as Raw with the difference it will always invoke
+// `iptables` binary
+func RawNative(args ...string) ([]byte, error) {
+	return raw(args...)
+}
+
 // RawCombinedOutputNative behave as RawCombinedOutput with the difference it
 // will always invoke `iptables` binary
 func RawCombinedOutputNative(args ...string) error {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
@@ -543,3 +544,481 @@ func (b *badDriver) NetworkFree(id string) error {
 
 func (b *badDriver) EventNotify(etype driverapi.EventType, nid, tableName, key string, value []byte) {
 }
+
+func TestEndpointUpdateAddress(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	c, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	if err := c.(*controller).drvRegistry.AddDriver(auDriverName, auDriverInit, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ipamOpt := NetworkOptionIpam(ipamapi.DefaultIPAM, "", []*IpamConf{{PreferredPool: "10.37.0.0/24"}}, nil, nil)
+	nw, err := c.NewNetwork(auDriverName, "aunet", "", ipamOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nw.Delete()
+
+	ep, err := nw.CreateEndpoint("ep0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete(false)
+
+	ipam, _, err := c.(*controller).getIPAMDriver(ipamapi.DefaultIPAM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	poolID := nw.(*network).getIPInfo(4)[0].PoolID
+	oldAddr := ep.Info().Iface().Address()
+	newIP := net.ParseIP("10.37.0.10")
+
+	// A driver failure leaves the endpoint at its old address
+	aud.failUpdate = true
+	if err := ep.UpdateAddress(newIP, nil); err == nil {
+		t.Fatal("Expected failure from the driver address update")
+	}
+	if !types.CompareIPNet(ep.Info().Iface().Address(), oldAddr) {
+		t.Fatalf("Endpoint address changed to %v on failure", ep.Info().Iface().Address())
+	}
+	if _, _, err := ipam.RequestAddress(poolID, oldAddr.IP, nil); err == nil {
+		t.Fatalf("Old address %s was released on failure", oldAddr.IP)
+	}
+	if _, _, err := ipam.RequestAddress(poolID, newIP, nil); err != nil {
+		t.Fatalf("New address %s was not released on failure: %v", newIP, err)
+	}
+	if err := ipam.ReleaseAddress(poolID, newIP); err != nil {
+		t.Fatal(err)
+	}
+
+	aud.failUpdate = false
+	if err := ep.UpdateAddress(newIP, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !ep.Info().Iface().Address().IP.Equal(newIP) {
+		t.Fatalf("Endpoint has address %v instead of %s", ep.Info().Iface().Address(), newIP)
+	}
+	if !aud.addr.IP.Equal(newIP) {
+		t.Fatalf("Driver was updated with address %v instead of %s", aud.addr, newIP)
+	}
+	if _, _, err := ipam.RequestAddress(poolID, newIP, nil); err == nil {
+		t.Fatalf("New address %s is not allocated", newIP)
+	}
+	if _, _, err := ipam.RequestAddress(poolID, oldAddr.IP, nil); err != nil {
+		t.Fatalf("Old address %s was not released: %v", oldAddr.IP, err)
+	}
+	if err := ipam.ReleaseAddress(poolID, oldAddr.IP); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var auDriverName = "address updater driver"
+
+// auDriver creates the networks and endpoints and supports the address
+// updates, failing them on demand
+type auDriver struct {
+	badDriver
+	failUpdate bool
+	addr       *net.IPNet
+}
+
+var aud = auDriver{}
+
+func auDriverInit(reg driverapi.DriverCallback, opt map[string]interface{}) error {
+	return reg.RegisterDriver(auDriverName, &aud, driverapi.Capability{DataScope: datastore.LocalScope})
+}
+
+func (d *auDriver) CreateNetwork(nid string, options map[string]interface{}, nInfo driverapi.NetworkInfo, ipV4Data, ipV6Data []driverapi.IPAMData) error {
+	return nil
+}
+func (d *auDriver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo, options map[string]interface{}) error {
+	return nil
+}
+func (d *auDriver) Type() string {
+	return auDriverName
+}
+func (d *auDriver) UpdateEndpointAddress(nid, eid string, addr, addrv6 *net.IPNet, jinfo driverapi.JoinInfo) error {
+	if d.failUpdate {
+		return fmt.Errorf("I will not update any address")
+	}
+	d.addr = addr
+	return nil
+}
+
+func TestEndpointTrafficRecord(t *testing.T) {
+	et := &endpointTraffic{id: "ep1"}
+	now := time.Date(2016, 10, 1, 10, 15, 0, 0, time.UTC)
+
+	et.record(EndpointTraffic{External: TrafficCounters{TxBytes: 100, TxPackets: 2}}, now, false)
+	et.record(EndpointTraffic{External: TrafficCounters{TxBytes: 150, TxPackets: 3}}, now.Add(10*time.Minute), false)
+	if len(et.Buckets) != 1 || et.Buckets[0].Traffic.External.TxBytes != 150 || et.Buckets[0].Traffic.External.TxPackets != 3 {
+		t.Fatalf("Unexpected history after two samples in the same hour: %v", et.Buckets)
+	}
+
+	// Counters reset, as on container restart
+	et.record(EndpointTraffic{External: TrafficCounters{TxBytes: 40, TxPackets: 1}}, now.Add(time.Hour), true)
+	if len(et.Buckets) != 2 || et.Buckets[1].Traffic.External.TxBytes != 40 {
+		t.Fatalf("Unexpected history after counters reset: %v", et.Buckets)
+	}
+	if et.Last != (EndpointTraffic{}) {
+		t.Fatalf("Expected the last sample to be cleared once the endpoint left the sandbox, got: %v", et.Last)
+	}
+
+	b, err := json.Marshal(et)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ett := &endpointTraffic{}
+	if err := json.Unmarshal(b, ett); err != nil {
+		t.Fatal(err)
+	}
+	if ett.id != et.id || len(ett.Buckets) != 2 || !ett.Buckets[0].Start.Equal(et.Buckets[0].Start) ||
+		ett.Buckets[1].Traffic != et.Buckets[1].Traffic {
+		t.Fatalf("JSON marsh/unmarsh failed.\nOriginal:\n%#v\nDecoded:\n%#v", et, ett)
+	}
+
+	et.record(EndpointTraffic{}, now.Add(trafficRetention+2*time.Hour), false)
+	if len(et.Buckets) != 1 {
+		t.Fatalf("Expected the buckets past the retention to be dropped, got: %v", et.Buckets)
+	}
+}
+
+func TestParseTrafficCounters(t *testing.T) {
+	out := []byte(`Chain DOCKER_ACCOUNTING (2 references)
+    pkts      bytes target     prot opt in     out     source               destination
+      10     1200 RETURN     all  --  *      eth0    0.0.0.0/0            172.18.0.0/16        /* ep1/intra/tx */
+       2      300 RETURN     all  --  *      eth0    0.0.0.0/0            172.19.0.0/16        /* ep1/cross/tx */
+       5      500 RETURN     all  --  *      eth0    0.0.0.0/0            0.0.0.0/0            /* ep1/external/tx */
+       7      900 RETURN     all  --  eth0   *       172.18.0.0/16        0.0.0.0/0            /* ep1/intra/rx */
+       1       60 RETURN     all  --  eth1   *       0.0.0.0/0            0.0.0.0/0            /* ep2/external/rx */
+`)
+	counters, err := parseTrafficCounters(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep1, ok := counters["ep1"]
+	if !ok {
+		t.Fatalf("Missing counters for ep1: %v", counters)
+	}
+	expected := EndpointTraffic{
+		IntraNetwork: TrafficCounters{TxPackets: 10, TxBytes: 1200, RxPackets: 7, RxBytes: 900},
+		CrossNetwork: TrafficCounters{TxPackets: 2, TxBytes: 300},
+		External:     TrafficCounters{TxPackets: 5, TxBytes: 500},
+	}
+	if *ep1 != expected {
+		t.Fatalf("Unexpected counters for ep1.\nExpected: %v\nGot: %v", expected, *ep1)
+	}
+	if ep2, ok := counters["ep2"]; !ok || ep2.External.RxBytes != 60 {
+		t.Fatalf("Unexpected counters for ep2: %v", counters)
+	}
+
+	rules := endpointTrafficRules([]byte(`-N DOCKER_ACCOUNTING
+-A DOCKER_ACCOUNTING -d 172.18.0.0/16 -o eth0 -m comment --comment "ep1/intra/tx" -j RETURN
+-A DOCKER_ACCOUNTING -i eth1 -m comment --comment ep2/external/rx -j RETURN
+`), "ep1")
+	if len(rules) != 1 || rules[0][0] != "DOCKER_ACCOUNTING" || rules[0][len(rules[0])-3] != "ep1/intra/tx" {
+		t.Fatalf("Unexpected rules for ep1: %v", rules)
+	}
+}
+
+func TestSubnetDraining(t *testing.T) {
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	c, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	ctrlr := c.(*controller)
+
+	ipam, _, err := ctrlr.getIPAMDriver(ipamapi.DefaultIPAM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	as, _, err := ipam.GetDefaultAddressSpaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	poolID, pool, _, err := ipam.RequestPool(as, "10.36.0.0/24", "", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ipam.ReleasePool(poolID)
+	gw, _, err := ipam.RequestAddress(poolID, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ipam.ReleaseAddress(poolID, gw.IP)
+
+	d := &IpamInfo{PoolID: poolID, IPAMData: driverapi.IPAMData{AddressSpace: as, Pool: pool, Gateway: gw}}
+	n := &network{name: "drainnet", ctrlr: ctrlr, ipamV4Info: []*IpamInfo{d}}
+
+	// The gateway does not keep the pool in use
+	ip, err := poolInUse(ipam, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != nil {
+		t.Fatalf("Unexpected address in use %s", ip)
+	}
+
+	ep := &endpoint{name: "ep0", network: n, iface: &endpointInterface{}}
+	if err := ep.assignAddressVersion(4, ipam); err != nil {
+		t.Fatal(err)
+	}
+	defer ipam.ReleaseAddress(poolID, ep.iface.addr.IP)
+
+	if ip, err = poolInUse(ipam, d); err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(ep.iface.addr.IP) {
+		t.Fatalf("Expected address %s in use, got %v", ep.iface.addr.IP, ip)
+	}
+
+	if !ctrlr.drainPool(poolID) {
+		t.Fatal("Failed to drain the pool")
+	}
+	if ctrlr.drainPool(poolID) {
+		t.Fatal("Expected failure on draining the pool twice")
+	}
+
+	// No address is allocated from a draining pool
+	ep1 := &endpoint{name: "ep1", network: n, iface: &endpointInterface{}}
+	if err := ep1.assignAddressVersion(4, ipam); err == nil {
+		t.Fatal("Expected failure on allocation from a draining pool")
+	}
+	ep1.prefAddress = net.ParseIP("10.36.0.100")
+	if err := ep1.assignAddressVersion(4, ipam); err == nil {
+		t.Fatal("Expected failure on allocation of a preferred address from a draining pool")
+	}
+
+	ctrlr.undrainPool(poolID)
+	if err := ep1.assignAddressVersion(4, ipam); err != nil {
+		t.Fatal(err)
+	}
+	ipam.ReleaseAddress(poolID, ep1.iface.addr.IP)
+}
+
+func TestAuditIpam(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	c, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	ipamOpt := NetworkOptionIpam(ipamapi.DefaultIPAM, "", []*IpamConf{{PreferredPool: "10.35.0.0/24"}}, nil, nil)
+	nw, err := c.NewNetwork("bridge", "auditnet", "", ipamOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nw.Delete()
+
+	ep, err := nw.CreateEndpoint("ep0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete(false)
+
+	audit, err := c.AuditIpam(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audit.Networks) != 1 || len(audit.Issues) != 0 {
+		t.Fatalf("Unexpected audit of a consistent network: %+v", audit)
+	}
+
+	ipam, _, err := c.(*controller).getIPAMDriver(ipamapi.DefaultIPAM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	poolID := nw.(*network).getIPInfo(4)[0].PoolID
+
+	// Leak an address and drop the one of the endpoint behind the back of the network
+	leaked := net.ParseIP("10.35.0.100")
+	if _, _, err := ipam.RequestAddress(poolID, leaked, nil); err != nil {
+		t.Fatal(err)
+	}
+	epIP := ep.Info().Iface().Address().IP
+	if err := ipam.ReleaseAddress(poolID, epIP); err != nil {
+		t.Fatal(err)
+	}
+
+	// A leak is only released once reported by a previous audit
+	defer func(p time.Duration) { leakGracePeriod = p }(leakGracePeriod)
+	leakGracePeriod = 0
+
+	audit, err = c.AuditIpam(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audit.Issues) != 2 {
+		t.Fatalf("Expected 2 issues. Got: %+v", audit.Issues)
+	}
+	if i := audit.Issues[0]; i.Kind != AddressLeaked || !i.Address.Equal(leaked) || i.Repaired {
+		t.Fatalf("Unexpected leak issue on the first report: %+v", i)
+	}
+
+	audit, err = c.AuditIpam(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audit.Issues) != 2 {
+		t.Fatalf("Expected 2 issues. Got: %+v", audit.Issues)
+	}
+	if i := audit.Issues[0]; i.Kind != AddressLeaked || !i.Address.Equal(leaked) || !i.Repaired {
+		t.Fatalf("Unexpected leak issue: %+v", i)
+	}
+	if i := audit.Issues[1]; i.Kind != AddressNotAllocated || !i.Address.Equal(epIP) || len(i.Endpoints) != 1 || i.Endpoints[0] != ep.ID() {
+		t.Fatalf("Unexpected not allocated issue: %+v", i)
+	}
+
+	audit, err = c.AuditIpam(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audit.Issues) != 1 || audit.Issues[0].Kind != AddressNotAllocated {
+		t.Fatalf("Unexpected issues after the repair: %+v", audit.Issues)
+	}
+
+	// Restore the endpoint address so that its deletion goes through
+	if _, _, err := ipam.RequestAddress(poolID, epIP, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	c, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipamOpt := NetworkOptionIpam(ipamapi.DefaultIPAM, "", []*IpamConf{{PreferredPool: "10.36.0.0/24"}}, nil, nil)
+	nw, err := c.NewNetwork("bridge", "exportnet", "", ipamOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := nw.CreateEndpoint("ep0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := c.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var se StateExport
+	if err := json.Unmarshal(state, &se); err != nil {
+		t.Fatal(err)
+	}
+	if se.Version != stateExportVersion || len(se.Networks) != 2 || len(se.Endpoints) != 1 {
+		t.Fatalf("Unexpected exported state: %s", state)
+	}
+
+	epIP := ep.Info().Iface().Address().IP
+	if err := ep.Delete(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := nw.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	c.Stop()
+
+	// A network overlapping with the exported one fails the import
+	cfgOptions, err = OptionBoltdbWithRandomDBFile()
+	c, err = New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipamOpt = NetworkOptionIpam(ipamapi.DefaultIPAM, "", []*IpamConf{{PreferredPool: "10.36.0.0/16"}}, nil, nil)
+	onw, err := c.NewNetwork("bridge", "othernet", "", ipamOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Import(state); err == nil {
+		t.Fatal("Expected failure importing a network overlapping with an existing one")
+	} else if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Unexpected error type: %v", err)
+	}
+	if err := onw.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	c.Stop()
+
+	cfgOptions, err = OptionBoltdbWithRandomDBFile()
+	c, err = New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	if err := c.Import(state); err != nil {
+		t.Fatal(err)
+	}
+	inw, err := c.NetworkByName("exportnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inw.ID() != nw.ID() {
+		t.Fatalf("Imported network has id %s instead of %s", inw.ID(), nw.ID())
+	}
+	info := inw.(*network).getIPInfo(4)
+	if len(info) != 1 || info[0].Pool.String() != "10.36.0.0/24" {
+		t.Fatalf("Unexpected imported network pools %v", info)
+	}
+
+	// The endpoint is imported with its address, which is reserved
+	iep, err := inw.EndpointByName("ep0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !iep.Info().Iface().Address().IP.Equal(epIP) {
+		t.Fatalf("Imported endpoint has address %v instead of %s", iep.Info().Iface().Address(), epIP)
+	}
+	ipam, _, err := c.(*controller).getIPAMDriver(ipamapi.DefaultIPAM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ipam.RequestAddress(info[0].PoolID, epIP, nil); err == nil {
+		t.Fatalf("Expected failure requesting the imported endpoint address %s", epIP)
+	}
+
+	// The network is created in the driver
+	ep1, err := inw.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep1.Info().Iface().Address().IP.Equal(epIP) {
+		t.Fatalf("New endpoint got the address %s of the imported one", epIP)
+	}
+
+	if err := c.Import(state); err == nil {
+		t.Fatal("Expected failure importing the state twice")
+	}
+
+	for _, e := range []Endpoint{ep1, iep} {
+		if err := e.Delete(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := inw.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
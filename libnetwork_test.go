@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -16,6 +17,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/plugins"
@@ -1214,6 +1216,10 @@ func (f *fakeSandbox) ResolveService(name string) ([]*net.SRV, []net.IP, error)
 	return nil, nil, nil
 }
 
+func (f *fakeSandbox) Capture(ifaceName, bpfFilter string, snaplen int, duration time.Duration) (io.ReadCloser, error) {
+	return nil, nil
+}
+
 func (f *fakeSandbox) Endpoints() []libnetwork.Endpoint {
 	return nil
 }
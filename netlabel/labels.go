@@ -27,6 +27,12 @@ const (
 	// ExposedPorts constant represents the container's Exposed Ports
 	ExposedPorts = Prefix + ".endpoint.exposedports"
 
+	// QosPolicy constant represents the bandwidth limits of the endpoint
+	QosPolicy = Prefix + ".endpoint.qospolicy"
+
+	// MirrorTo constant represents the id of the endpoint receiving a copy of the endpoint traffic
+	MirrorTo = Prefix + ".endpoint.mirror_to"
+
 	//EnableIPv6 constant represents enabling IPV6 at network level
 	EnableIPv6 = Prefix + ".enable_ipv6"
 
@@ -42,11 +48,19 @@ const (
 	// OverlayVxlanIDList constant represents a list of VXLAN Ids as csv
 	OverlayVxlanIDList = DriverPrefix + ".overlay.vxlanid_list"
 
+	// OverlayVxlanIDLeaseTTL constant represents the time to live of the
+	// leases of the VXLAN Ids handed out by the overlay network manager
+	OverlayVxlanIDLeaseTTL = DriverPrefix + ".overlay.vxlanid_lease_ttl"
+
 	// Gateway represents the gateway for the network
 	Gateway = Prefix + ".gateway"
 
 	// Internal constant represents that the network is internal which disables default gateway service
 	Internal = Prefix + ".internal"
+
+	// NodeLabelPrefix constant marks the daemon labels advertised to the
+	// cluster nodes, such as the node zone, rack or role
+	NodeLabelPrefix = Prefix + ".node."
 )
 
 var (
@@ -53,6 +53,14 @@ type Network interface {
 
 	// Return certain operational data belonging to this network
 	Info() NetworkInfo
+
+	// AddSubnet allocates the pool described by the passed ipam
+	// configuration and adds it to the network
+	AddSubnet(cfg *IpamConf, v6 bool) error
+
+	// RemoveSubnet removes the passed master pool from the network and
+	// releases it. It fails while endpoints hold addresses of the pool.
+	RemoveSubnet(pool string) error
 }
 
 // NetworkInfo returns some configuration and operational information about the network
@@ -1230,76 +1238,100 @@ func (n *network) ipamAllocateVersion(ipVer int, ipam ipamapi.Ipam) error {
 	}
 
 	if len(*cfgList) == 0 {
-		if ipVer == 6 {
+		if ipVer == 6 && !n.hasPredefinedV6Pools() {
 			return nil
 		}
 		*cfgList = []*IpamConf{{}}
 	}
 
-	*infoList = make([]*IpamInfo, len(*cfgList))
+	*infoList = make([]*IpamInfo, 0, len(*cfgList))
 
 	log.Debugf("Allocating IPv%d pools for network %s (%s)", ipVer, n.Name(), n.ID())
 
-	for i, cfg := range *cfgList {
-		if err = cfg.Validate(); err != nil {
-			return err
-		}
-		d := &IpamInfo{}
-		(*infoList)[i] = d
-
-		d.AddressSpace = n.addrSpace
-		d.PoolID, d.Pool, d.Meta, err = n.requestPoolHelper(ipam, n.addrSpace, cfg.PreferredPool, cfg.SubPool, n.ipamOptions, ipVer == 6)
+	defer func() {
 		if err != nil {
-			return err
-		}
-
-		defer func() {
-			if err != nil {
+			for _, d := range *infoList {
 				if err := ipam.ReleasePool(d.PoolID); err != nil {
 					log.Warnf("Failed to release address pool %s after failure to create network %s (%s)", d.PoolID, n.Name(), n.ID())
 				}
 			}
-		}()
+			*infoList = nil
+		}
+	}()
 
-		if gws, ok := d.Meta[netlabel.Gateway]; ok {
-			if d.Gateway, err = types.ParseCIDR(gws); err != nil {
-				return types.BadRequestErrorf("failed to parse gateway address (%v) returned by ipam driver: %v", gws, err)
-			}
+	for _, cfg := range *cfgList {
+		var d *IpamInfo
+		if d, err = n.ipamAllocateConf(ipVer, ipam, cfg); err != nil {
+			return err
 		}
+		*infoList = append(*infoList, d)
+	}
 
-		// If user requested a specific gateway, libnetwork will allocate it
-		// irrespective of whether ipam driver returned a gateway already.
-		// If none of the above is true, libnetwork will allocate one.
-		if cfg.Gateway != "" || d.Gateway == nil {
-			var gatewayOpts = map[string]string{
-				ipamapi.RequestAddressType: netlabel.Gateway,
-			}
-			if d.Gateway, _, err = ipam.RequestAddress(d.PoolID, net.ParseIP(cfg.Gateway), gatewayOpts); err != nil {
-				return types.InternalErrorf("failed to allocate gateway (%v): %v", cfg.Gateway, err)
+	return nil
+}
+
+// ipamAllocateConf requests the pool described by the ipam configuration,
+// along with its gateway and auxiliary addresses. On failure the pool is
+// released.
+func (n *network) ipamAllocateConf(ipVer int, ipam ipamapi.Ipam, cfg *IpamConf) (d *IpamInfo, err error) {
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	d = &IpamInfo{}
+	d.AddressSpace = n.addrSpace
+	d.PoolID, d.Pool, d.Meta, err = n.requestPoolHelper(ipam, n.addrSpace, cfg.PreferredPool, cfg.SubPool, n.ipamOptions, ipVer == 6)
+	if err != nil {
+		return nil, err
+	}
+
+	poolID := d.PoolID
+	defer func() {
+		if err != nil {
+			if err := ipam.ReleasePool(poolID); err != nil {
+				log.Warnf("Failed to release address pool %s after failure to create network %s (%s)", poolID, n.Name(), n.ID())
 			}
 		}
+	}()
 
-		// Auxiliary addresses must be part of the master address pool
-		// If they fall into the container addressable pool, libnetwork will reserve them
-		if cfg.AuxAddresses != nil {
-			var ip net.IP
-			d.IPAMData.AuxAddresses = make(map[string]*net.IPNet, len(cfg.AuxAddresses))
-			for k, v := range cfg.AuxAddresses {
-				if ip = net.ParseIP(v); ip == nil {
-					return types.BadRequestErrorf("non parsable secondary ip address (%s:%s) passed for network %s", k, v, n.Name())
-				}
-				if !d.Pool.Contains(ip) {
-					return types.ForbiddenErrorf("auxilairy address: (%s:%s) must belong to the master pool: %s", k, v, d.Pool)
-				}
-				// Attempt reservation in the container addressable pool, silent the error if address does not belong to that pool
-				if d.IPAMData.AuxAddresses[k], _, err = ipam.RequestAddress(d.PoolID, ip, nil); err != nil && err != ipamapi.ErrIPOutOfRange {
-					return types.InternalErrorf("failed to allocate secondary ip address (%s:%s): %v", k, v, err)
-				}
+	if gws, ok := d.Meta[netlabel.Gateway]; ok {
+		if d.Gateway, err = types.ParseCIDR(gws); err != nil {
+			return nil, types.BadRequestErrorf("failed to parse gateway address (%v) returned by ipam driver: %v", gws, err)
+		}
+	}
+
+	// If user requested a specific gateway, libnetwork will allocate it
+	// irrespective of whether ipam driver returned a gateway already.
+	// If none of the above is true, libnetwork will allocate one.
+	if cfg.Gateway != "" || d.Gateway == nil {
+		var gatewayOpts = map[string]string{
+			ipamapi.RequestAddressType: netlabel.Gateway,
+		}
+		if d.Gateway, _, err = ipam.RequestAddress(d.PoolID, net.ParseIP(cfg.Gateway), gatewayOpts); err != nil {
+			return nil, types.InternalErrorf("failed to allocate gateway (%v): %v", cfg.Gateway, err)
+		}
+	}
+
+	// Auxiliary addresses must be part of the master address pool
+	// If they fall into the container addressable pool, libnetwork will reserve them
+	if cfg.AuxAddresses != nil {
+		var ip net.IP
+		d.IPAMData.AuxAddresses = make(map[string]*net.IPNet, len(cfg.AuxAddresses))
+		for k, v := range cfg.AuxAddresses {
+			if ip = net.ParseIP(v); ip == nil {
+				return nil, types.BadRequestErrorf("non parsable secondary ip address (%s:%s) passed for network %s", k, v, n.Name())
+			}
+			if !d.Pool.Contains(ip) {
+				return nil, types.ForbiddenErrorf("auxilairy address: (%s:%s) must belong to the master pool: %s", k, v, d.Pool)
+			}
+			// Attempt reservation in the container addressable pool, silent the error if address does not belong to that pool
+			if d.IPAMData.AuxAddresses[k], _, err = ipam.RequestAddress(d.PoolID, ip, nil); err != nil && err != ipamapi.ErrIPOutOfRange {
+				return nil, types.InternalErrorf("failed to allocate secondary ip address (%s:%s): %v", k, v, err)
 			}
 		}
 	}
 
-	return nil
+	return d, nil
 }
 
 func (n *network) ipamRelease() {
@@ -1335,26 +1367,31 @@ func (n *network) ipamReleaseVersion(ipVer int, ipam ipamapi.Ipam) {
 	log.Debugf("releasing IPv%d pools from network %s (%s)", ipVer, n.Name(), n.ID())
 
 	for _, d := range *infoList {
-		if d.Gateway != nil {
-			if err := ipam.ReleaseAddress(d.PoolID, d.Gateway.IP); err != nil {
-				log.Warnf("Failed to release gateway ip address %s on delete of network %s (%s): %v", d.Gateway.IP, n.Name(), n.ID(), err)
-			}
+		n.ipamReleaseInfo(ipam, d)
+	}
+
+	*infoList = nil
+}
+
+// ipamReleaseInfo releases the gateway, the auxiliary addresses and the pool
+func (n *network) ipamReleaseInfo(ipam ipamapi.Ipam, d *IpamInfo) {
+	if d.Gateway != nil {
+		if err := ipam.ReleaseAddress(d.PoolID, d.Gateway.IP); err != nil {
+			log.Warnf("Failed to release gateway ip address %s on delete of network %s (%s): %v", d.Gateway.IP, n.Name(), n.ID(), err)
 		}
-		if d.IPAMData.AuxAddresses != nil {
-			for k, nw := range d.IPAMData.AuxAddresses {
-				if d.Pool.Contains(nw.IP) {
-					if err := ipam.ReleaseAddress(d.PoolID, nw.IP); err != nil && err != ipamapi.ErrIPOutOfRange {
-						log.Warnf("Failed to release secondary ip address %s (%v) on delete of network %s (%s): %v", k, nw.IP, n.Name(), n.ID(), err)
-					}
+	}
+	if d.IPAMData.AuxAddresses != nil {
+		for k, nw := range d.IPAMData.AuxAddresses {
+			if d.Pool.Contains(nw.IP) {
+				if err := ipam.ReleaseAddress(d.PoolID, nw.IP); err != nil && err != ipamapi.ErrIPOutOfRange {
+					log.Warnf("Failed to release secondary ip address %s (%v) on delete of network %s (%s): %v", k, nw.IP, n.Name(), n.ID(), err)
 				}
 			}
 		}
-		if err := ipam.ReleasePool(d.PoolID); err != nil {
-			log.Warnf("Failed to release address pool %s on delete of network %s (%s): %v", d.PoolID, n.Name(), n.ID(), err)
-		}
 	}
-
-	*infoList = nil
+	if err := ipam.ReleasePool(d.PoolID); err != nil {
+		log.Warnf("Failed to release address pool %s on delete of network %s (%s): %v", d.PoolID, n.Name(), n.ID(), err)
+	}
 }
 
 func (n *network) getIPInfo(ipVer int) []*IpamInfo {
@@ -1406,6 +1443,32 @@ func (n *network) deriveAddressSpace() (string, error) {
 	return local, nil
 }
 
+// hasPredefinedV6Pools tells whether the built-in ipam was configured with
+// IPv6 predefined pools for the network address space, in which case an IPv6
+// pool is automatically allocated to the network
+func (n *network) hasPredefinedV6Pools() bool {
+	if n.ipamType != ipamapi.DefaultIPAM {
+		return false
+	}
+
+	c := n.getController()
+	if c.cfg == nil {
+		return false
+	}
+
+	pools := c.cfg.Ipam.LocalPools
+	if n.DataScope() == datastore.GlobalScope {
+		pools = c.cfg.Ipam.GlobalPools
+	}
+	for _, p := range pools {
+		if ip, _, err := net.ParseCIDR(p.Base); err == nil && ip.To4() == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (n *network) Info() NetworkInfo {
 	return n
 }
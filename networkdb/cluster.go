@@ -385,7 +385,7 @@ func (nDB *NetworkDB) bulkSync(nid string, nodes []string, all bool) ([]string,
 // Bulk sync all the table entries belonging to a set of networks to a
 // single peer node. It can be unsolicited or can be in response to an
 // unsolicited bulk sync
-func (nDB *NetworkDB) bulkSyncNode(networks []string, node string, unsolicited bool) error {
+func (nDB *NetworkDB) bulkSyncNode(networks []string, node string, unsolicited bool) (err error) {
 	var msgs [][]byte
 
 	logrus.Debugf("%s: Initiating bulk sync for networks %v with node %s", nDB.config.NodeName, networks, node)
@@ -433,6 +433,21 @@ func (nDB *NetworkDB) bulkSyncNode(networks []string, node string, unsolicited b
 	}
 	nDB.RUnlock()
 
+	rec := &BulkSyncRecord{
+		Node:        node,
+		Networks:    networks,
+		Sent:        true,
+		Unsolicited: unsolicited,
+		Entries:     len(msgs),
+		Time:        time.Now(),
+	}
+	defer func() {
+		if err != nil {
+			rec.Error = err.Error()
+		}
+		nDB.recordBulkSync(rec)
+	}()
+
 	// Create a compound message
 	compound := makeCompoundMessage(msgs)
 
@@ -470,6 +485,7 @@ func (nDB *NetworkDB) bulkSyncNode(networks []string, node string, unsolicited b
 		select {
 		case <-t.C:
 			logrus.Errorf("Bulk sync to node %s timed out", node)
+			rec.TimedOut = true
 		case <-ch:
 			nDB.Lock()
 			delete(nDB.bulkSyncAckTbl, node)
@@ -477,6 +493,7 @@ func (nDB *NetworkDB) bulkSyncNode(networks []string, node string, unsolicited b
 
 			logrus.Debugf("%s: Bulk sync to node %s took %s", nDB.config.NodeName, node, time.Now().Sub(startTime))
 		}
+		rec.Duration = time.Now().Sub(startTime)
 		t.Stop()
 	}
 
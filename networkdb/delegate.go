@@ -14,7 +14,13 @@ type delegate struct {
 }
 
 func (d *delegate) NodeMeta(limit int) []byte {
-	return []byte{}
+	meta, err := encodeNodeMeta(d.nDB.nodeMeta(), limit)
+	if err != nil {
+		logrus.Errorf("Could not advertise the node metadata: %v", err)
+		return []byte{}
+	}
+
+	return meta
 }
 
 func (nDB *NetworkDB) handleNetworkEvent(nEvent *NetworkEvent) bool {
@@ -204,6 +210,13 @@ func (nDB *NetworkDB) handleBulkSync(buf []byte) {
 
 	nDB.handleMessage(bsm.Payload, true)
 
+	nDB.recordBulkSync(&BulkSyncRecord{
+		Node:        bsm.NodeName,
+		Networks:    bsm.Networks,
+		Unsolicited: bsm.Unsolicited,
+		Time:        time.Now(),
+	})
+
 	// Don't respond to a bulk sync which was not unsolicited
 	if !bsm.Unsolicited {
 		nDB.RLock()
@@ -0,0 +1,259 @@
+package networkdb
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hashicorp/serf/serf"
+)
+
+// bulkSyncHistorySize is the number of bulk syncs kept in the history
+const bulkSyncHistorySize = 64
+
+// Member states reported by the diagnostics
+const (
+	// MemberAlive is the state of the nodes in the cluster
+	MemberAlive = "alive"
+	// MemberLeft is the state of the nodes which left the cluster or
+	// failed, while their networks are still known
+	MemberLeft = "left"
+)
+
+// MemberInfo describes a node of the cluster as known by this NetworkDB
+// instance.
+type MemberInfo struct {
+	NodeMeta
+	Name  string `json:"name"`
+	Addr  net.IP `json:"addr,omitempty"`
+	Port  uint16 `json:"port,omitempty"`
+	State string `json:"state"`
+	Self  bool   `json:"self,omitempty"`
+}
+
+// NetworkInfo describes the attachment of a node to a network.
+type NetworkInfo struct {
+	ID      string           `json:"id"`
+	LTime   serf.LamportTime `json:"ltime"`
+	Leaving bool             `json:"leaving,omitempty"`
+}
+
+// EntryInfo describes a table entry. Deleting entries are the
+// tombstones lingering in the cluster until they are reaped.
+type EntryInfo struct {
+	Table    string           `json:"table"`
+	Network  string           `json:"network"`
+	Key      string           `json:"key"`
+	Owner    string           `json:"owner"`
+	LTime    serf.LamportTime `json:"ltime"`
+	Deleting bool             `json:"deleting,omitempty"`
+	Value    []byte           `json:"value,omitempty"`
+}
+
+// QueueInfo holds the number of messages waiting in the broadcast
+// queues: the network events queue and the table events queue of each
+// network this node joined.
+type QueueInfo struct {
+	Network int            `json:"network"`
+	Tables  map[string]int `json:"tables"`
+}
+
+// BulkSyncRecord describes a bulk sync sent to or received from a node.
+type BulkSyncRecord struct {
+	Node        string    `json:"node"`
+	Networks    []string  `json:"networks"`
+	Sent        bool      `json:"sent"`
+	Unsolicited bool      `json:"unsolicited,omitempty"`
+	Time        time.Time `json:"time"`
+	// Entries is the number of table entries sent
+	Entries int `json:"entries,omitempty"`
+	// Duration of an unsolicited bulk sync, until it was acknowledged
+	Duration time.Duration `json:"duration,omitempty"`
+	TimedOut bool          `json:"timed_out,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+func (nDB *NetworkDB) recordBulkSync(rec *BulkSyncRecord) {
+	nDB.Lock()
+	defer nDB.Unlock()
+
+	nDB.bulkSyncHistory = append(nDB.bulkSyncHistory, rec)
+	if len(nDB.bulkSyncHistory) > bulkSyncHistorySize {
+		nDB.bulkSyncHistory = nDB.bulkSyncHistory[len(nDB.bulkSyncHistory)-bulkSyncHistorySize:]
+	}
+}
+
+// Members returns the nodes of the cluster and their state, including
+// the nodes which left but whose networks are still known. The members
+// are sorted by name.
+func (nDB *NetworkDB) Members() []*MemberInfo {
+	var members []*MemberInfo
+	for _, n := range nDB.Nodes() {
+		members = append(members, &MemberInfo{
+			NodeMeta: n.NodeMeta,
+			Name:     n.Name,
+			Addr:     n.Addr,
+			Port:     n.Port,
+			State:    MemberAlive,
+			Self:     n.Name == nDB.config.NodeName,
+		})
+	}
+
+	nDB.RLock()
+	for name := range nDB.networks {
+		if _, ok := nDB.nodes[name]; !ok {
+			members = append(members, &MemberInfo{Name: name, State: MemberLeft})
+		}
+	}
+	nDB.RUnlock()
+
+	sort.Sort(byMemberName(members))
+	return members
+}
+
+type byMemberName []*MemberInfo
+
+func (b byMemberName) Len() int           { return len(b) }
+func (b byMemberName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byMemberName) Less(i, j int) bool { return b[i].Name < b[j].Name }
+
+// NodeNetworks returns, for each node, the networks it participates in
+// or is leaving.
+func (nDB *NetworkDB) NodeNetworks() map[string][]*NetworkInfo {
+	nDB.RLock()
+	defer nDB.RUnlock()
+
+	nodeNetworks := make(map[string][]*NetworkInfo, len(nDB.networks))
+	for node, networks := range nDB.networks {
+		infos := make([]*NetworkInfo, 0, len(networks))
+		for nid, n := range networks {
+			infos = append(infos, &NetworkInfo{ID: nid, LTime: n.ltime, Leaving: n.leaving})
+		}
+		sort.Sort(byNetworkID(infos))
+		nodeNetworks[node] = infos
+	}
+
+	return nodeNetworks
+}
+
+type byNetworkID []*NetworkInfo
+
+func (b byNetworkID) Len() int           { return len(b) }
+func (b byNetworkID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byNetworkID) Less(i, j int) bool { return b[i].ID < b[j].ID }
+
+// TableEntries returns the entries of the tables of a network, or of
+// all the networks if nid is empty, tombstones included. The entries
+// are ordered by network, table and key.
+func (nDB *NetworkDB) TableEntries(nid string) []*EntryInfo {
+	prefix := "/"
+	if nid != "" {
+		prefix = "/" + nid + "/"
+	}
+
+	nDB.RLock()
+	defer nDB.RUnlock()
+
+	entries := []*EntryInfo{}
+	nDB.indexes[byNetwork].WalkPrefix(prefix, func(path string, v interface{}) bool {
+		e, ok := v.(*entry)
+		if !ok {
+			return false
+		}
+
+		params := strings.SplitN(path[1:], "/", 3)
+		if len(params) != 3 {
+			return false
+		}
+
+		entries = append(entries, &EntryInfo{
+			Network:  params[0],
+			Table:    params[1],
+			Key:      params[2],
+			Owner:    e.node,
+			LTime:    e.ltime,
+			Deleting: e.deleting,
+			Value:    e.value,
+		})
+		return false
+	})
+
+	return entries
+}
+
+// QueueLengths returns the number of messages waiting in the broadcast
+// queues of this node.
+func (nDB *NetworkDB) QueueLengths() *QueueInfo {
+	qi := &QueueInfo{Tables: make(map[string]int)}
+	if nDB.networkBroadcasts != nil {
+		qi.Network = nDB.networkBroadcasts.NumQueued()
+	}
+
+	nDB.RLock()
+	defer nDB.RUnlock()
+
+	for nid, n := range nDB.networks[nDB.config.NodeName] {
+		if n.tableBroadcasts != nil {
+			qi.Tables[nid] = n.tableBroadcasts.NumQueued()
+		}
+	}
+
+	return qi
+}
+
+// BulkSyncHistory returns the most recent bulk syncs this node sent and
+// received, oldest first.
+func (nDB *NetworkDB) BulkSyncHistory() []*BulkSyncRecord {
+	nDB.RLock()
+	defer nDB.RUnlock()
+
+	history := make([]*BulkSyncRecord, len(nDB.bulkSyncHistory))
+	copy(history, nDB.bulkSyncHistory)
+	return history
+}
+
+// DiagnosticHandler returns a read-only HTTP handler exposing the state
+// of this NetworkDB instance in JSON. It serves the following paths:
+//
+//	/members   the cluster members and their state
+//	/networks  the networks each node participates in
+//	/entries   the table entries, of the network passed in the network
+//	           query parameter if any
+//	/queues    the broadcast queue lengths
+//	/bulksync  the bulk sync history
+//
+// The handler is not mounted by NetworkDB, it is up to the caller to
+// expose it.
+func (nDB *NetworkDB) DiagnosticHandler() http.Handler {
+	views := map[string]func(r *http.Request) interface{}{
+		"/members":  func(r *http.Request) interface{} { return nDB.Members() },
+		"/networks": func(r *http.Request) interface{} { return nDB.NodeNetworks() },
+		"/entries": func(r *http.Request) interface{} {
+			return nDB.TableEntries(r.URL.Query().Get("network"))
+		},
+		"/queues":   func(r *http.Request) interface{} { return nDB.QueueLengths() },
+		"/bulksync": func(r *http.Request) interface{} { return nDB.BulkSyncHistory() },
+	}
+
+	mux := http.NewServeMux()
+	for path, view := range views {
+		view := view
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" {
+				http.Error(w, "diagnostics are read-only", http.StatusMethodNotAllowed)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(view(r)); err != nil {
+				logrus.Errorf("Could not write the NetworkDB diagnostics for %s: %v", r.URL.Path, err)
+			}
+		})
+	}
+
+	return mux
+}
@@ -21,4 +21,7 @@ func (e *eventDelegate) NotifyLeave(n *memberlist.Node) {
 }
 
 func (e *eventDelegate) NotifyUpdate(n *memberlist.Node) {
+	e.nDB.Lock()
+	e.nDB.nodes[n.Name] = n
+	e.nDB.Unlock()
 }
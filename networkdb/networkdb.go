@@ -80,6 +80,10 @@ type NetworkDB struct {
 
 	// Reference to the memberlist's keyring to add & remove keys
 	keyring *memberlist.Keyring
+
+	// The most recent bulk syncs sent and received, oldest
+	// first.
+	bulkSyncHistory []*BulkSyncRecord
 }
 
 // network describes the node/network attachment.
@@ -118,6 +122,16 @@ type Config struct {
 	// Keys to be added to the Keyring of the memberlist. Key at index
 	// 0 is the primary key
 	Keys [][]byte
+
+	// Labels advertised to the cluster describing this node, such
+	// as its zone, rack or role.
+	Labels map[string]string
+
+	// Version of libnetwork advertised to the cluster.
+	Version string
+
+	// Capabilities advertised to the cluster.
+	Capabilities []string
 }
 
 // entry defines a table entry
@@ -155,6 +169,10 @@ func New(c *Config) (*NetworkDB, error) {
 	nDB.indexes[byTable] = radix.New()
 	nDB.indexes[byNetwork] = radix.New()
 
+	if _, err := encodeNodeMeta(nDB.nodeMeta(), memberlist.MetaMaxSize); err != nil {
+		return nil, fmt.Errorf("invalid node metadata: %v", err)
+	}
+
 	if err := nDB.clusterInit(); err != nil {
 		return nil, err
 	}
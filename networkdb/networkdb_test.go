@@ -1,16 +1,21 @@
 package networkdb
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/go-events"
+	"github.com/hashicorp/memberlist"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -319,6 +324,143 @@ func TestNetworkDBNodeLeave(t *testing.T) {
 	dbs[1].Close()
 }
 
+func TestNetworkDBNodeMeta(t *testing.T) {
+	var dbs []*NetworkDB
+	for i, zone := range []string{"zone1", "zone2"} {
+		db, err := New(&Config{
+			NodeName:     fmt.Sprintf("node%d", i+1),
+			BindPort:     int(atomic.AddInt32(&dbPort, 1)),
+			Labels:       map[string]string{"zone": zone},
+			Version:      "1.0",
+			Capabilities: []string{"driver.overlay"},
+		})
+		require.NoError(t, err)
+
+		if i != 0 {
+			err = db.Join([]string{fmt.Sprintf("localhost:%d", db.config.BindPort-1)})
+			assert.NoError(t, err)
+		}
+		dbs = append(dbs, db)
+	}
+	dbs[0].verifyNodeExistence(t, "node2", true)
+
+	nodes := dbs[0].Nodes()
+	require.Len(t, nodes, 2)
+	for i, n := range nodes {
+		assert.Equal(t, fmt.Sprintf("node%d", i+1), n.Name)
+		assert.Equal(t, fmt.Sprintf("zone%d", i+1), n.Labels["zone"])
+		assert.Equal(t, "1.0", n.Version)
+		assert.True(t, n.HasCapability("driver.overlay"))
+		assert.False(t, n.HasCapability("driver.bridge"))
+	}
+
+	// The delegate honours the limit memberlist passes
+	d := &delegate{nDB: dbs[0]}
+	assert.NotEmpty(t, d.NodeMeta(memberlist.MetaMaxSize))
+	assert.Empty(t, d.NodeMeta(8))
+
+	// The metadata must fit in the memberlist limit
+	_, err := New(&Config{
+		NodeName: "node3",
+		BindPort: int(atomic.AddInt32(&dbPort, 1)),
+		Labels:   map[string]string{"role": strings.Repeat("x", 1024)},
+	})
+	assert.Error(t, err)
+
+	closeNetworkDBInstances(dbs)
+}
+
+func TestNetworkDBDiagnostics(t *testing.T) {
+	dbs := createNetworkDBInstances(t, 2, "node")
+
+	err := dbs[0].JoinNetwork("network1")
+	assert.NoError(t, err)
+
+	// Joining a network known on another node bulk syncs with it
+	dbs[1].verifyNetworkExistence(t, "node1", "network1", true)
+
+	err = dbs[1].JoinNetwork("network1")
+	assert.NoError(t, err)
+
+	err = dbs[0].CreateEntry("test_table", "network1", "test_key", []byte("test_value"))
+	assert.NoError(t, err)
+
+	err = dbs[0].CreateEntry("test_table", "network1", "deleted_key", []byte("test_value"))
+	assert.NoError(t, err)
+	err = dbs[0].DeleteEntry("test_table", "network1", "deleted_key")
+	assert.NoError(t, err)
+
+	dbs[1].verifyEntryExistence(t, "test_table", "network1", "test_key", "test_value", true)
+
+	server := httptest.NewServer(dbs[1].DiagnosticHandler())
+	defer server.Close()
+
+	get := func(path string, v interface{}) {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+
+	var members []*MemberInfo
+	get("/members", &members)
+	require.Len(t, members, 2)
+	assert.Equal(t, "node1", members[0].Name)
+	assert.Equal(t, MemberAlive, members[0].State)
+	assert.False(t, members[0].Self)
+	assert.True(t, members[1].Self)
+
+	var nodeNetworks map[string][]*NetworkInfo
+	get("/networks", &nodeNetworks)
+	for _, node := range []string{"node1", "node2"} {
+		require.Len(t, nodeNetworks[node], 1)
+		assert.Equal(t, "network1", nodeNetworks[node][0].ID)
+	}
+
+	var entries []*EntryInfo
+	get("/entries?network=network1", &entries)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "deleted_key", entries[0].Key)
+	assert.True(t, entries[0].Deleting)
+	assert.Equal(t, "test_key", entries[1].Key)
+	assert.Equal(t, "node1", entries[1].Owner)
+	assert.False(t, entries[1].Deleting)
+	assert.True(t, entries[1].LTime > 0)
+
+	get("/entries?network=network2", &entries)
+	assert.Len(t, entries, 0)
+
+	var qi QueueInfo
+	get("/queues", &qi)
+	_, ok := qi.Tables["network1"]
+	assert.True(t, ok)
+
+	var history []*BulkSyncRecord
+	get("/bulksync", &history)
+	// The bulk sync sent to node1 and its reply
+	var sent, received bool
+	for _, b := range history {
+		assert.Equal(t, "node1", b.Node)
+		assert.Equal(t, []string{"network1"}, b.Networks)
+		if b.Sent {
+			sent = true
+			assert.True(t, b.Unsolicited)
+			assert.Empty(t, b.Error)
+		} else {
+			received = true
+		}
+	}
+	assert.True(t, sent && received)
+
+	resp, err := http.Post(server.URL+"/members", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	closeNetworkDBInstances(dbs)
+}
+
 func TestNetworkDBWatch(t *testing.T) {
 	dbs := createNetworkDBInstances(t, 2, "node")
 	err := dbs[0].JoinNetwork("network1")
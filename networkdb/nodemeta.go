@@ -0,0 +1,111 @@
+package networkdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// NodeMeta is the metadata a node advertises to the cluster along
+// with its membership.
+type NodeMeta struct {
+	// Labels describing the node placement and purpose, such as its
+	// zone, rack or role.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Version of libnetwork the node runs.
+	Version string `json:"version,omitempty"`
+
+	// Capabilities is the list of features the node supports.
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// NodeInfo describes a cluster node as known by this NetworkDB
+// instance.
+type NodeInfo struct {
+	NodeMeta
+
+	// Name is the cluster wide unique name of the node.
+	Name string
+
+	// Addr and Port are the address the node gossips from.
+	Addr net.IP
+	Port uint16
+}
+
+// HasCapability returns whether the node advertised the capability.
+func (ni *NodeInfo) HasCapability(capability string) bool {
+	for _, c := range ni.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeNodeMeta encodes the node metadata, failing if it is larger than
+// limit bytes
+func encodeNodeMeta(meta *NodeMeta, limit int) ([]byte, error) {
+	buf, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(buf) > limit {
+		return nil, fmt.Errorf("node metadata of %d bytes exceeds the limit of %d bytes", len(buf), limit)
+	}
+
+	return buf, nil
+}
+
+func decodeNodeMeta(buf []byte) (NodeMeta, error) {
+	var meta NodeMeta
+	if len(buf) == 0 {
+		return meta, nil
+	}
+
+	err := json.Unmarshal(buf, &meta)
+	return meta, err
+}
+
+func (nDB *NetworkDB) nodeMeta() *NodeMeta {
+	return &NodeMeta{
+		Labels:       nDB.config.Labels,
+		Version:      nDB.config.Version,
+		Capabilities: nDB.config.Capabilities,
+	}
+}
+
+// Nodes returns the nodes of the cluster, including this one, along
+// with the metadata they advertise. The nodes are sorted by name.
+func (nDB *NetworkDB) Nodes() []*NodeInfo {
+	nDB.RLock()
+	defer nDB.RUnlock()
+
+	nodes := make([]*NodeInfo, 0, len(nDB.nodes))
+	for _, n := range nDB.nodes {
+		meta, err := decodeNodeMeta(n.Meta)
+		if err != nil {
+			logrus.Warnf("Could not decode the metadata of node %s: %v", n.Name, err)
+		}
+
+		nodes = append(nodes, &NodeInfo{
+			NodeMeta: meta,
+			Name:     n.Name,
+			Addr:     n.Addr,
+			Port:     n.Port,
+		})
+	}
+
+	sort.Sort(byNodeName(nodes))
+	return nodes
+}
+
+type byNodeName []*NodeInfo
+
+func (b byNodeName) Len() int           { return len(b) }
+func (b byNodeName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byNodeName) Less(i, j int) bool { return b[i].Name < b[j].Name }
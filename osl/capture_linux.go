@@ -0,0 +1,211 @@
+package osl
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+const (
+	pcapMagic            = 0xa1b2c3d4
+	pcapVersionMajor     = 2
+	pcapVersionMinor     = 4
+	pcapLinkTypeEthernet = 1
+	maxSnaplen           = 65535
+	captureReadTimeout   = 100 * time.Millisecond
+)
+
+// captureReader is the consumer side of a packet capture. Closing it stops
+// the capture and releases the packet socket.
+type captureReader struct {
+	*io.PipeReader
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *captureReader) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.PipeReader.Close()
+}
+
+func (n *networkNamespace) Capture(ifaceName, bpfFilter string, snaplen int, duration time.Duration) (io.ReadCloser, error) {
+	if snaplen <= 0 || snaplen > maxSnaplen {
+		snaplen = maxSnaplen
+	}
+
+	filter, err := parseBPFFilter(bpfFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := n.nlHandle.LinkByName(ifaceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s in netns %s: %v", ifaceName, n.path, err)
+	}
+
+	// The packet socket is bound to the namespace it is created in, so open
+	// it from within the sandbox. It does not receive any traffic until it is
+	// bound below, which guarantees the filter applies to every packet.
+	var fd int
+	err = nsInvoke(n.nsPath(), func(nsFD int) error { return nil }, func(callerFD int) error {
+		var serr error
+		fd, serr = syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0)
+		return serr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open packet socket in netns %s: %v", n.path, err)
+	}
+
+	if err := setupCaptureSocket(fd, link.Attrs().Index, filter); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to setup capture on %s in netns %s: %v", ifaceName, n.path, err)
+	}
+
+	pr, pw := io.Pipe()
+	r := &captureReader{PipeReader: pr, done: make(chan struct{})}
+	go capturePackets(fd, snaplen, duration, pw, r.done)
+
+	return r, nil
+}
+
+func setupCaptureSocket(fd, ifIndex int, filter []syscall.SockFilter) error {
+	if len(filter) > 0 {
+		if err := syscall.AttachLsf(fd, filter); err != nil {
+			return fmt.Errorf("failed to attach filter: %v", err)
+		}
+	}
+
+	tv := syscall.NsecToTimeval(captureReadTimeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return fmt.Errorf("failed to set read timeout: %v", err)
+	}
+
+	sa := &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ALL), Ifindex: ifIndex}
+	if err := syscall.Bind(fd, sa); err != nil {
+		return fmt.Errorf("failed to bind: %v", err)
+	}
+
+	return nil
+}
+
+// capturePackets reads packets from the socket and writes them in pcap
+// format until the duration elapses, the reader is closed or an error occurs.
+// A zero duration captures until the reader is closed.
+func capturePackets(fd, snaplen int, duration time.Duration, w *io.PipeWriter, done <-chan struct{}) {
+	defer syscall.Close(fd)
+
+	var deadline time.Time
+	if duration > 0 {
+		deadline = time.Now().Add(duration)
+	}
+
+	if err := writePcapHeader(w, snaplen); err != nil {
+		w.CloseWithError(err)
+		return
+	}
+
+	buf := make([]byte, snaplen)
+	for deadline.IsZero() || time.Now().Before(deadline) {
+		select {
+		case <-done:
+			w.Close()
+			return
+		default:
+		}
+
+		// MSG_TRUNC makes recvfrom return the original length of the packet
+		length, _, err := syscall.Recvfrom(fd, buf, syscall.MSG_TRUNC)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			w.CloseWithError(fmt.Errorf("failed to read packet: %v", err))
+			return
+		}
+
+		capLen := length
+		if capLen > snaplen {
+			capLen = snaplen
+		}
+		if err := writePcapRecord(w, time.Now(), buf[:capLen], length); err != nil {
+			return
+		}
+	}
+
+	w.Close()
+}
+
+func writePcapHeader(w io.Writer, snaplen int) error {
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:4], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:6], pcapVersionMajor)
+	binary.LittleEndian.PutUint16(hdr[6:8], pcapVersionMinor)
+	// thiszone and sigfigs are always zero
+	binary.LittleEndian.PutUint32(hdr[16:20], uint32(snaplen))
+	binary.LittleEndian.PutUint32(hdr[20:24], pcapLinkTypeEthernet)
+	_, err := w.Write(hdr)
+	return err
+}
+
+func writePcapRecord(w io.Writer, ts time.Time, data []byte, origLen int) error {
+	rec := make([]byte, 16+len(data))
+	binary.LittleEndian.PutUint32(rec[0:4], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:8], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:12], uint32(len(data)))
+	binary.LittleEndian.PutUint32(rec[12:16], uint32(origLen))
+	copy(rec[16:], data)
+	_, err := w.Write(rec)
+	return err
+}
+
+// parseBPFFilter parses a classic BPF program in the decimal format produced
+// by "tcpdump -ddd": the number of instructions followed by one "code jt jf k"
+// instruction per line. Commas are accepted as instruction separators as well.
+func parseBPFFilter(filter string) ([]syscall.SockFilter, error) {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		return nil, nil
+	}
+
+	lines := strings.FieldsFunc(filter, func(r rune) bool { return r == '\n' || r == ',' })
+	count, err := strconv.Atoi(strings.TrimSpace(lines[0]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bpf instruction count %q: %v", lines[0], err)
+	}
+	if count != len(lines)-1 {
+		return nil, fmt.Errorf("bpf program declares %d instructions but has %d", count, len(lines)-1)
+	}
+
+	prog := make([]syscall.SockFilter, 0, count)
+	for _, l := range lines[1:] {
+		f := strings.Fields(l)
+		if len(f) != 4 {
+			return nil, fmt.Errorf("invalid bpf instruction %q", l)
+		}
+		var v [4]uint64
+		for i, s := range f {
+			if v[i], err = strconv.ParseUint(s, 10, 32); err != nil {
+				return nil, fmt.Errorf("invalid bpf instruction %q: %v", l, err)
+			}
+		}
+		if v[1] > 0xff || v[2] > 0xff || v[0] > 0xffff {
+			return nil, fmt.Errorf("invalid bpf instruction %q: value out of range", l)
+		}
+		prog = append(prog, syscall.SockFilter{Code: uint16(v[0]), Jt: uint8(v[1]), Jf: uint8(v[2]), K: uint32(v[3])})
+	}
+
+	return prog, nil
+}
+
+func htons(v uint16) uint16 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return nl.NativeEndian().Uint16(b)
+}
@@ -0,0 +1,109 @@
+package osl
+
+import "sync"
+
+// HostLinkMonitor tracks the operational state of a set of host links, such
+// as the parents of the macvlan and ipvlan networks, and notifies the state
+// changes of the watched links to its handler along with their previous
+// state. The netlink subscription is only held while links are watched.
+type HostLinkMonitor struct {
+	handler func(ev LinkEvent, prev LinkState)
+	links   map[string]*hostLink
+	done    chan struct{}
+	sync.Mutex
+}
+
+type hostLink struct {
+	refCnt int
+	state  LinkState
+}
+
+// NewHostLinkMonitor returns a monitor notifying the state changes of the
+// watched host links to the handler
+func NewHostLinkMonitor(handler func(ev LinkEvent, prev LinkState)) *HostLinkMonitor {
+	return &HostLinkMonitor{
+		handler: handler,
+		links:   make(map[string]*hostLink),
+	}
+}
+
+// Watch adds the link to the watched ones. A link can be watched more than
+// once, it is then watched until as many Unwatch calls.
+func (m *HostLinkMonitor) Watch(name string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if l, ok := m.links[name]; ok {
+		l.refCnt++
+		return nil
+	}
+
+	if m.done == nil {
+		done := make(chan struct{})
+		if err := WatchHostLinks(m.handleEvent, done); err != nil {
+			return err
+		}
+		m.done = done
+	}
+	m.links[name] = &hostLink{refCnt: 1, state: HostLinkState(name)}
+
+	return nil
+}
+
+// Unwatch removes the link from the watched ones, and stops the netlink
+// subscription when no link is watched anymore
+func (m *HostLinkMonitor) Unwatch(name string) {
+	m.Lock()
+	defer m.Unlock()
+
+	l, ok := m.links[name]
+	if !ok {
+		return
+	}
+	if l.refCnt--; l.refCnt > 0 {
+		return
+	}
+	delete(m.links, name)
+
+	if len(m.links) == 0 && m.done != nil {
+		close(m.done)
+		m.done = nil
+	}
+}
+
+// State returns the last known state of a watched link
+func (m *HostLinkMonitor) State(name string) LinkState {
+	m.Lock()
+	defer m.Unlock()
+
+	if l, ok := m.links[name]; ok {
+		return l.state
+	}
+	return LinkStateUnknown
+}
+
+// Stop unwatches all the links
+func (m *HostLinkMonitor) Stop() {
+	m.Lock()
+	defer m.Unlock()
+
+	m.links = make(map[string]*hostLink)
+	if m.done != nil {
+		close(m.done)
+		m.done = nil
+	}
+}
+
+func (m *HostLinkMonitor) handleEvent(ev LinkEvent) {
+	m.Lock()
+	l, ok := m.links[ev.Name]
+	if !ok || l.state == ev.State {
+		m.Unlock()
+		return
+	}
+	prev := l.state
+	l.state = ev.State
+	m.Unlock()
+
+	m.handler(ev, prev)
+}
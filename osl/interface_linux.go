@@ -29,6 +29,8 @@ type nwIface struct {
 	llAddrs     []*net.IPNet
 	routes      []*net.IPNet
 	bridge      bool
+	qos         *types.QosPolicy
+	operState   LinkState
 	ns          *networkNamespace
 	sync.Mutex
 }
@@ -109,6 +111,13 @@ func (i *nwIface) Routes() []*net.IPNet {
 	return routes
 }
 
+func (i *nwIface) OperState() LinkState {
+	i.Lock()
+	defer i.Unlock()
+
+	return i.operState
+}
+
 func (n *networkNamespace) Interfaces() []Interface {
 	n.Lock()
 	defer n.Unlock()
@@ -174,6 +183,67 @@ func (i *nwIface) Remove() error {
 	return nil
 }
 
+func (i *nwIface) SetAddresses(addr, addrv6 *net.IPNet) error {
+	i.Lock()
+	n := i.ns
+	i.Unlock()
+
+	n.Lock()
+	nlh := n.nlHandle
+	n.Unlock()
+
+	iface, err := nlh.LinkByName(i.DstName())
+	if err != nil {
+		return err
+	}
+
+	if addr != nil {
+		if err := replaceAddress(nlh, iface, i.Address(), &netlink.Addr{IPNet: addr}); err != nil {
+			return fmt.Errorf("failed to set address %s on interface %s: %v", addr, i.DstName(), err)
+		}
+		i.Lock()
+		i.address = types.GetIPNetCopy(addr)
+		i.Unlock()
+	}
+
+	if addrv6 != nil {
+		if err := replaceAddress(nlh, iface, i.AddressIPv6(), &netlink.Addr{IPNet: addrv6, Flags: syscall.IFA_F_NODAD}); err != nil {
+			return fmt.Errorf("failed to set IPv6 address %s on interface %s: %v", addrv6, i.DstName(), err)
+		}
+		i.Lock()
+		i.addressIPv6 = types.GetIPNetCopy(addrv6)
+		i.Unlock()
+	}
+
+	return nil
+}
+
+// replaceAddress swaps the old address of the link for the new one. The old
+// address goes first, deleting a primary address would otherwise take along
+// the new one when it is a secondary address of the same subnet.
+func replaceAddress(nlh *netlink.Handle, link netlink.Link, old *net.IPNet, addr *netlink.Addr) error {
+	if types.CompareIPNet(old, addr.IPNet) {
+		return nil
+	}
+
+	if old != nil {
+		if err := nlh.AddrDel(link, &netlink.Addr{IPNet: old}); err != nil && err != syscall.EADDRNOTAVAIL {
+			return err
+		}
+	}
+
+	if err := nlh.AddrAdd(link, addr); err != nil {
+		if old != nil {
+			if e := nlh.AddrAdd(link, &netlink.Addr{IPNet: old, Flags: addr.Flags}); e != nil {
+				log.Warnf("Failed to restore address %s on interface %s: %v", old, link.Attrs().Name, e)
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
 // Returns the sandbox's side veth interface statistics
 func (i *nwIface) Statistics() (*types.InterfaceStatistics, error) {
 	i.Lock()
@@ -305,10 +375,30 @@ func (n *networkNamespace) AddInterface(srcName, dstPrefix string, options ...If
 		return fmt.Errorf("error setting interface %q routes to %q: %v", iface.Attrs().Name, i.Routes(), err)
 	}
 
+	// Enforce the bandwidth limits. They do not survive the move to the
+	// namespace, hence they are set from inside it.
+	if i.qos != nil {
+		if err := nsInvoke(path, func(nsFD int) error { return nil }, func(callerFD int) error {
+			return SetRateLimits(iface, i.qos.MaxEgressBandwidth, i.qos.MaxIngressBandwidth)
+		}); err != nil {
+			return err
+		}
+	}
+
 	n.Lock()
 	n.iFaces = append(n.iFaces, i)
 	n.Unlock()
 
+	// Record the initial state, the later changes are tracked by the
+	// link watch of the sandbox
+	state, err := n.linkState(i.DstName())
+	if err != nil {
+		log.Debugf("Failed to retrieve the state of link %s in netns %s: %v", i.DstName(), path, err)
+	}
+	i.Lock()
+	i.operState = state
+	i.Unlock()
+
 	return nil
 }
 
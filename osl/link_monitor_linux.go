@@ -0,0 +1,199 @@
+package osl
+
+import (
+	"fmt"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/ns"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// WatchHostLinks subscribes to link events in the host network namespace
+// and invokes the handler for each of them until done is closed.
+func WatchHostLinks(handler func(LinkEvent), done <-chan struct{}) error {
+	ch := make(chan netlink.LinkUpdate)
+
+	if err := subscribeLinks(ch, done); err != nil {
+		return fmt.Errorf("failed to subscribe to host link events: %v", err)
+	}
+
+	go func() {
+		for u := range ch {
+			handler(linkEventFromUpdate(u))
+		}
+	}()
+
+	return nil
+}
+
+func subscribeLinks(ch chan<- netlink.LinkUpdate, done <-chan struct{}) error {
+	// The netlink socket is bound to the namespace of the thread which
+	// creates it, so make sure we are in the host namespace.
+	defer InitOSContext()()
+
+	return netlink.LinkSubscribe(ch, done)
+}
+
+func (n *networkNamespace) WatchLinks(handler func(LinkEvent)) error {
+	n.Lock()
+	n.linkHandler = handler
+	if n.linkWatch != nil {
+		n.Unlock()
+		return nil
+	}
+	done := make(chan struct{})
+	n.linkWatch = done
+	n.Unlock()
+
+	ch := make(chan netlink.LinkUpdate)
+	err := nsInvoke(n.nsPath(), func(nsFD int) error { return nil }, func(callerFD int) error {
+		return netlink.LinkSubscribe(ch, done)
+	})
+	if err != nil {
+		n.Lock()
+		n.linkWatch = nil
+		n.Unlock()
+		return fmt.Errorf("failed to subscribe to link events in netns %s: %v", n.path, err)
+	}
+
+	n.seedOperState()
+
+	go func() {
+		for u := range ch {
+			ev := linkEventFromUpdate(u)
+			n.updateOperState(ev)
+
+			n.Lock()
+			h := n.linkHandler
+			n.Unlock()
+			if h != nil {
+				h(ev)
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (n *networkNamespace) stopLinkWatch() {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.linkWatch != nil {
+		close(n.linkWatch)
+		n.linkWatch = nil
+	}
+	n.linkHandler = nil
+}
+
+// seedOperState records the current state of the interfaces already in
+// the sandbox, so that they are not reported as unknown until they flap.
+func (n *networkNamespace) seedOperState() {
+	for _, i := range n.Interfaces() {
+		state, err := n.linkState(i.DstName())
+		if err != nil {
+			log.Debugf("Failed to retrieve the state of link %s in netns %s: %v", i.DstName(), n.path, err)
+			continue
+		}
+		iface := i.(*nwIface)
+		iface.Lock()
+		iface.operState = state
+		iface.Unlock()
+	}
+}
+
+// linkState returns the current operational state of the link in the
+// namespace, by the same rule as the link events
+func (n *networkNamespace) linkState(name string) (LinkState, error) {
+	n.Lock()
+	nlh := n.nlHandle
+	path := n.path
+	isDefault := n.isDefault
+	n.Unlock()
+
+	l, err := nlh.LinkByName(name)
+	if err != nil {
+		return LinkStateUnknown, err
+	}
+
+	if isDefault {
+		defer InitOSContext()()
+		return linkStateByIndex(l.Attrs().Index)
+	}
+
+	state := LinkStateUnknown
+	err = nsInvoke(path, func(nsFD int) error { return nil }, func(callerFD int) error {
+		var err error
+		state, err = linkStateByIndex(l.Attrs().Index)
+		return err
+	})
+	return state, err
+}
+
+// HostLinkState returns the current operational state of the host link
+func HostLinkState(name string) LinkState {
+	l, err := ns.NlHandle().LinkByName(name)
+	if err != nil {
+		return LinkStateUnknown
+	}
+
+	defer InitOSContext()()
+	state, err := linkStateByIndex(l.Attrs().Index)
+	if err != nil {
+		log.Debugf("Failed to retrieve the state of host link %s: %v", name, err)
+		return LinkStateUnknown
+	}
+	return state
+}
+
+// linkStateByIndex queries the flags of the link in the namespace of the
+// calling thread. They are not all exposed by the netlink link attributes.
+func linkStateByIndex(index int) (LinkState, error) {
+	req := nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(index)
+	req.AddData(msg)
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	if err != nil {
+		return LinkStateUnknown, err
+	}
+	if len(msgs) == 0 {
+		return LinkStateUnknown, fmt.Errorf("link %d not found", index)
+	}
+
+	return linkStateFromFlags(nl.DeserializeIfInfomsg(msgs[0]).Flags), nil
+}
+
+func (n *networkNamespace) updateOperState(ev LinkEvent) {
+	for _, i := range n.Interfaces() {
+		if i.DstName() != ev.Name {
+			continue
+		}
+		iface := i.(*nwIface)
+		iface.Lock()
+		iface.operState = ev.State
+		iface.Unlock()
+	}
+}
+
+func linkEventFromUpdate(u netlink.LinkUpdate) LinkEvent {
+	ev := LinkEvent{
+		Name:  u.Link.Attrs().Name,
+		Index: u.Link.Attrs().Index,
+		State: linkStateFromFlags(u.IfInfomsg.Flags),
+	}
+	if u.Header.Type == syscall.RTM_DELLINK {
+		ev.State = LinkStateDeleted
+	}
+	return ev
+}
+
+func linkStateFromFlags(flags uint32) LinkState {
+	if flags&syscall.IFF_UP != 0 && flags&syscall.IFF_RUNNING != 0 {
+		return LinkStateUp
+	}
+	return LinkStateDown
+}
@@ -0,0 +1,14 @@
+// +build !linux
+
+package osl
+
+// WatchHostLinks subscribes to link events in the host network namespace
+// and invokes the handler for each of them until done is closed.
+func WatchHostLinks(handler func(LinkEvent), done <-chan struct{}) error {
+	return nil
+}
+
+// HostLinkState returns the current operational state of the host link
+func HostLinkState(name string) LinkState {
+	return LinkStateUnknown
+}
@@ -45,6 +45,8 @@ type networkNamespace struct {
 	nextIfIndex  int
 	isDefault    bool
 	nlHandle     *netlink.Handle
+	linkHandler  func(LinkEvent)
+	linkWatch    chan struct{}
 	sync.Mutex
 }
 
@@ -372,6 +374,7 @@ func (n *networkNamespace) Key() string {
 }
 
 func (n *networkNamespace) Destroy() error {
+	n.stopLinkWatch()
 	if n.nlHandle != nil {
 		n.nlHandle.Delete()
 	}
@@ -1,6 +1,10 @@
 package osl
 
-import "net"
+import (
+	"net"
+
+	"github.com/docker/libnetwork/types"
+)
 
 func (nh *neigh) processNeighOptions(options ...NeighOption) {
 	for _, opt := range options {
@@ -71,3 +75,9 @@ func (n *networkNamespace) Routes(routes []*net.IPNet) IfaceOption {
 		i.routes = routes
 	}
 }
+
+func (n *networkNamespace) QosPolicy(policy *types.QosPolicy) IfaceOption {
+	return func(i *nwIface) {
+		i.qos = policy
+	}
+}
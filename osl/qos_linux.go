@@ -0,0 +1,154 @@
+package osl
+
+import (
+	"fmt"
+	"math"
+	"syscall"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+const (
+	// Priority of the ingress policer filter. It is evaluated before any
+	// other filter attached to the ingress hook, which still sees the
+	// conforming packets.
+	policerPriority = 1
+	// Minimum burst, in bytes, allowed by the token buckets
+	minBurst = 32 * 1024
+	// Maximum time a packet can wait in the egress token bucket queue
+	shaperLatencyMs = 50
+)
+
+// SetRateLimits enforces the bandwidth limits, in bytes per second, on the
+// link. The traffic the link transmits is shaped by a tbf qdisc, the traffic
+// it receives is dropped by an ingress policer once above the rate. A zero
+// rate leaves the matching direction unlimited. The link must belong to the
+// network namespace of the calling thread.
+func SetRateLimits(link netlink.Link, txRate, rxRate uint64) error {
+	if txRate > math.MaxUint32 {
+		return types.BadRequestErrorf("egress rate %d exceeds the supported maximum of %d bytes per second", txRate, uint64(math.MaxUint32))
+	}
+	if rxRate > math.MaxUint32/8 {
+		return types.BadRequestErrorf("ingress rate %d exceeds the supported maximum of %d bytes per second", rxRate, uint64(math.MaxUint32/8))
+	}
+
+	if txRate != 0 {
+		if err := addShaper(link, txRate); err != nil {
+			return fmt.Errorf("failed to shape the egress traffic of %s: %v", link.Attrs().Name, err)
+		}
+	}
+
+	if rxRate != 0 {
+		if err := addPolicer(link, rxRate); err != nil {
+			return fmt.Errorf("failed to police the ingress traffic of %s: %v", link.Attrs().Name, err)
+		}
+	}
+
+	return nil
+}
+
+func burstSize(rate uint64) uint32 {
+	// Allow bursts of a tenth of a second worth of traffic
+	burst := rate / 10
+	if burst < minBurst {
+		burst = minBurst
+	}
+	return uint32(burst)
+}
+
+func addShaper(link netlink.Link, rate uint64) error {
+	burst := burstSize(rate)
+	tbf := &netlink.Tbf{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(1, 0),
+			Parent:    netlink.HANDLE_ROOT,
+		},
+		Rate:   rate,
+		Limit:  uint32(rate*shaperLatencyMs/1000) + burst,
+		Buffer: uint32(netlink.Xmittime(rate, burst)),
+	}
+	return netlink.QdiscAdd(tbf)
+}
+
+// ingressParent returns the filter parent of the ingress hook of the link,
+// adding an ingress qdisc unless a clsact or ingress qdisc is already there.
+func ingressParent(link netlink.Link) (uint32, error) {
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return 0, err
+	}
+	for _, q := range qdiscs {
+		if q.Attrs().Parent != netlink.HANDLE_INGRESS {
+			continue
+		}
+		if q.Type() == "clsact" {
+			return netlink.HANDLE_MIN_INGRESS, nil
+		}
+		return q.Attrs().Handle, nil
+	}
+
+	ingress := &netlink.Ingress{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_INGRESS,
+		},
+	}
+	if err := netlink.QdiscAdd(ingress); err != nil {
+		return 0, err
+	}
+
+	return ingress.Handle, nil
+}
+
+func addPolicer(link netlink.Link, rate uint64) error {
+	parent, err := ingressParent(link)
+	if err != nil {
+		return err
+	}
+
+	// Let the library compute the token bucket parameters, the u32 police
+	// attribute is then assembled here as the library only supports it on
+	// fw filters.
+	fw, err := netlink.NewFw(netlink.FilterAttrs{}, netlink.FilterFwAttrs{
+		Rate:   uint32(rate * 8),
+		Buffer: burstSize(rate),
+		Mtu:    uint32(link.Attrs().MTU),
+		Action: netlink.TC_POLICE_SHOT,
+	})
+	if err != nil {
+		return err
+	}
+
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWTFILTER, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+	req.AddData(&nl.TcMsg{
+		Family:  nl.FAMILY_ALL,
+		Ifindex: int32(link.Attrs().Index),
+		Parent:  parent,
+		Info:    netlink.MakeHandle(policerPriority, nl.Swap16(syscall.ETH_P_ALL)),
+	})
+	req.AddData(nl.NewRtAttr(nl.TCA_KIND, nl.ZeroTerminated("u32")))
+
+	options := nl.NewRtAttr(nl.TCA_OPTIONS, nil)
+	// match all
+	sel := nl.TcU32Sel{
+		Nkeys: 1,
+		Flags: nl.TC_U32_TERMINAL,
+	}
+	sel.Keys = append(sel.Keys, nl.TcU32Key{})
+	nl.NewRtAttrChild(options, nl.TCA_U32_SEL, sel.Serialize())
+
+	police := nl.NewRtAttrChild(options, nl.TCA_U32_POLICE, nil)
+	nl.NewRtAttrChild(police, nl.TCA_POLICE_TBF, fw.Police.Serialize())
+	nl.NewRtAttrChild(police, nl.TCA_POLICE_RATE, netlink.SerializeRtab(fw.Rtab))
+	// Conforming packets continue the classification
+	conform := int32(netlink.TC_ACT_UNSPEC)
+	nl.NewRtAttrChild(police, nl.TCA_POLICE_RESULT, nl.Uint32Attr(uint32(conform)))
+	req.AddData(options)
+
+	_, err = req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
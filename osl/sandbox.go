@@ -2,7 +2,9 @@
 package osl
 
 import (
+	"io"
 	"net"
+	"time"
 
 	"github.com/docker/libnetwork/types"
 )
@@ -56,6 +58,17 @@ type Sandbox interface {
 	// Returns an interface with methods to get sandbox state.
 	Info() Info
 
+	// Capture returns a stream of the packets seen on the named interface in
+	// pcap format. The optional filter is a classic BPF program in the
+	// "tcpdump -ddd" format. The capture stops when the duration elapses, or
+	// when the stream is closed if the duration is zero.
+	Capture(ifaceName, bpfFilter string, snaplen int, duration time.Duration) (io.ReadCloser, error)
+
+	// WatchLinks starts monitoring the operational state of the links in
+	// the sandbox. The handler is invoked for every link event and replaces
+	// any previously registered handler.
+	WatchLinks(handler func(LinkEvent)) error
+
 	// Destroy the sandbox
 	Destroy() error
 
@@ -98,6 +111,9 @@ type IfaceOptionSetter interface {
 
 	// Address returns an option setter to set interface routes.
 	Routes([]*net.IPNet) IfaceOption
+
+	// QosPolicy returns an option setter to set the bandwidth limits of the interface.
+	QosPolicy(*types.QosPolicy) IfaceOption
 }
 
 // Info represents all possible information that
@@ -160,6 +176,50 @@ type Interface interface {
 	// and moving it out of the sandbox.
 	Remove() error
 
+	// SetAddresses replaces the IPv4 and IPv6 addresses of the interface.
+	// A nil address leaves the corresponding one unchanged.
+	SetAddresses(addr, addrv6 *net.IPNet) error
+
 	// Statistics returns the statistics for this interface
 	Statistics() (*types.InterfaceStatistics, error)
+
+	// OperState returns the last known operational state of the interface
+	OperState() LinkState
+}
+
+// LinkState represents the operational state of a network link
+type LinkState int
+
+const (
+	// LinkStateUnknown is reported before any state has been observed for the link
+	LinkStateUnknown LinkState = iota
+	// LinkStateUp is reported when the link is administratively up and running
+	LinkStateUp
+	// LinkStateDown is reported when the link is administratively down or has no carrier
+	LinkStateDown
+	// LinkStateDeleted is reported when the link has been removed from the namespace
+	LinkStateDeleted
+)
+
+func (s LinkState) String() string {
+	switch s {
+	case LinkStateUp:
+		return "up"
+	case LinkStateDown:
+		return "down"
+	case LinkStateDeleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
+// LinkEvent describes a change in the operational state of a network link
+type LinkEvent struct {
+	// Name of the link in the namespace where the event was observed
+	Name string
+	// Index of the link in the namespace where the event was observed
+	Index int
+	// State is the operational state of the link after the change
+	State LinkState
 }
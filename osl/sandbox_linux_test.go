@@ -2,8 +2,10 @@ package osl
 
 import (
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/hex"
 	"io"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
@@ -225,3 +227,294 @@ func TestDisableIPv6DAD(t *testing.T) {
 		t.Fatalf("Unexpected interface flags: 0x%x. Expected to contain 0x%x", addrList[0].Flags, syscall.IFA_F_NODAD)
 	}
 }
+
+func TestWatchLinks(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key, true, false)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer s.Destroy()
+
+	n, ok := s.(*networkNamespace)
+	if !ok {
+		t.Fatalf("The sandbox interface returned is not of type networkNamespace")
+	}
+
+	events := make(chan LinkEvent, 16)
+	if err := s.WatchLinks(func(ev LinkEvent) { events <- ev }); err != nil {
+		t.Fatalf("Failed to watch sandbox links: %v", err)
+	}
+
+	lo, err := n.nlHandle.LinkByName("lo")
+	if err != nil {
+		t.Fatalf("Failed to find the loopback link: %v", err)
+	}
+
+	if err := n.nlHandle.LinkSetDown(lo); err != nil {
+		t.Fatalf("Failed to set the loopback link down: %v", err)
+	}
+	waitLinkEvent(t, events, "lo", LinkStateDown)
+
+	if err := n.nlHandle.LinkSetUp(lo); err != nil {
+		t.Fatalf("Failed to set the loopback link up: %v", err)
+	}
+	waitLinkEvent(t, events, "lo", LinkStateUp)
+}
+
+func TestHostLinkMonitor(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "parent0", TxQLen: 0},
+		PeerName:  "parent1",
+	}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatalf("Failed to create a veth pair: %v", err)
+	}
+	peer, err := netlink.LinkByName("parent1")
+	if err != nil {
+		t.Fatalf("Failed to find the veth peer: %v", err)
+	}
+	for _, l := range []netlink.Link{veth, peer} {
+		if err := netlink.LinkSetUp(l); err != nil {
+			t.Fatalf("Failed to set %s up: %v", l.Attrs().Name, err)
+		}
+	}
+
+	events := make(chan LinkEvent, 16)
+	m := NewHostLinkMonitor(func(ev LinkEvent, prev LinkState) {
+		if prev != ev.State {
+			events <- ev
+		}
+	})
+	defer m.Stop()
+
+	// Watched twice, by two networks sharing the parent
+	for i := 0; i < 2; i++ {
+		if err := m.Watch("parent0"); err != nil {
+			t.Fatalf("Failed to watch the parent link: %v", err)
+		}
+	}
+	if state := m.State("parent0"); state != LinkStateUp {
+		t.Fatalf("Expected the parent link to be up, got %s", state)
+	}
+
+	if err := netlink.LinkSetDown(peer); err != nil {
+		t.Fatalf("Failed to set the veth peer down: %v", err)
+	}
+	waitLinkEvent(t, events, "parent0", LinkStateDown)
+
+	m.Unwatch("parent0")
+	if err := netlink.LinkDel(veth); err != nil {
+		t.Fatalf("Failed to delete the veth pair: %v", err)
+	}
+	waitLinkEvent(t, events, "parent0", LinkStateDeleted)
+
+	m.Unwatch("parent0")
+	if state := m.State("parent0"); state != LinkStateUnknown {
+		t.Fatalf("Expected the unwatched link state to be unknown, got %s", state)
+	}
+}
+
+func waitLinkEvent(t *testing.T, events <-chan LinkEvent, name string, state LinkState) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Name == name && ev.State == state {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for link %s to be %s", name, state)
+		}
+	}
+}
+
+func TestParseBPFFilter(t *testing.T) {
+	prog, err := parseBPFFilter("")
+	if err != nil || prog != nil {
+		t.Fatalf("Expected an empty program for an empty filter, got %v, %v", prog, err)
+	}
+
+	// tcpdump -ddd udp (truncated to the ipv4 check)
+	prog, err = parseBPFFilter("4\n40 0 0 12\n21 0 1 2048\n6 0 0 65535\n6 0 0 0\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prog) != 4 {
+		t.Fatalf("Expected 4 instructions, got %d", len(prog))
+	}
+	if prog[1].Code != 21 || prog[1].Jt != 0 || prog[1].Jf != 1 || prog[1].K != 2048 {
+		t.Fatalf("Unexpected instruction: %+v", prog[1])
+	}
+
+	if _, err := parseBPFFilter("2,6 0 0 65535,6 0 0 0"); err != nil {
+		t.Fatalf("Failed to parse comma separated program: %v", err)
+	}
+
+	for _, f := range []string{"3\n6 0 0 0", "1\n6 0 0", "1\n6 0 256 0", "x\n6 0 0 0"} {
+		if _, err := parseBPFFilter(f); err == nil {
+			t.Fatalf("Expected failure for filter %q", f)
+		}
+	}
+}
+
+func TestCapture(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key, true, false)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer s.Destroy()
+
+	if _, err := s.Capture("nonexistent", "", 0, time.Second); err == nil {
+		t.Fatalf("Expected capture on a missing interface to fail")
+	}
+
+	r, err := s.Capture("lo", "", 128, 0)
+	if err != nil {
+		t.Fatalf("Failed to start capture: %v", err)
+	}
+	defer r.Close()
+
+	s.InvokeFunc(func() {
+		c, err := net.Dial("udp", "127.0.0.1:5353")
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		c.Write(make([]byte, 512))
+		c.Close()
+	})
+
+	hdr := make([]byte, 24+16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		t.Fatalf("Failed to read capture: %v", err)
+	}
+	if m := binary.LittleEndian.Uint32(hdr[0:4]); m != pcapMagic {
+		t.Fatalf("Unexpected pcap magic %x", m)
+	}
+	if capLen := binary.LittleEndian.Uint32(hdr[32:36]); capLen != 128 {
+		t.Fatalf("Expected packet to be truncated to 128 bytes, got %d", capLen)
+	}
+	if origLen := binary.LittleEndian.Uint32(hdr[36:40]); origLen <= 512 {
+		t.Fatalf("Unexpected original packet length %d", origLen)
+	}
+}
+
+func TestSetRateLimits(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: vethName1, TxQLen: 0},
+		PeerName:  vethName2,
+	}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatalf("Failed to create a veth pair: %v", err)
+	}
+	link, err := netlink.LinkByName(vethName1)
+	if err != nil {
+		t.Fatalf("Failed to find the veth link: %v", err)
+	}
+	peer, err := netlink.LinkByName(vethName2)
+	if err != nil {
+		t.Fatalf("Failed to find the veth peer link: %v", err)
+	}
+
+	if err := SetRateLimits(link, math.MaxUint32+1, 0); err == nil {
+		t.Fatalf("Expected failure for an egress rate out of range")
+	}
+
+	if err := SetRateLimits(link, 1<<20, 0); err != nil {
+		t.Fatalf("Failed to set the egress rate limit: %v", err)
+	}
+
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tbf *netlink.Tbf
+	for _, q := range qdiscs {
+		if q, ok := q.(*netlink.Tbf); ok {
+			tbf = q
+		}
+	}
+	if tbf == nil || tbf.Rate != 1<<20 {
+		t.Fatalf("Expected a tbf qdisc with rate %d, got: %v", 1<<20, qdiscs)
+	}
+
+	if err := SetRateLimits(peer, 0, 1<<20); err != nil {
+		// The policer requires the kernel to support the police action
+		t.Skipf("Could not set the ingress rate limit: %v", err)
+	}
+
+	filters, err := netlink.FilterList(peer, netlink.MakeHandle(0xffff, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) != 1 || filters[0].Type() != "u32" {
+		t.Fatalf("Expected a single u32 policer filter, got: %v", filters)
+	}
+}
+
+func TestSetAddresses(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key, true, false)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer s.Destroy()
+
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: vethName1, TxQLen: 0},
+		PeerName:  vethName2,
+	}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatalf("Failed to create a veth pair: %v", err)
+	}
+
+	addr, _ := types.ParseCIDR("192.168.1.100/24")
+	if err := s.AddInterface(vethName2, sboxIfaceName, s.InterfaceOptions().Address(addr)); err != nil {
+		t.Fatalf("Failed to add interface to sandbox: %v", err)
+	}
+
+	iface := s.Info().Interfaces()[0]
+	newAddr, _ := types.ParseCIDR("192.168.1.101/24")
+	if err := iface.SetAddresses(newAddr, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !types.CompareIPNet(iface.Address(), newAddr) {
+		t.Fatalf("Unexpected interface address %s. Expected %s", iface.Address(), newAddr)
+	}
+
+	nlh := s.(*networkNamespace).nlHandle
+	link, err := nlh.LinkByName(iface.DstName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := nlh.AddrList(link, nl.FAMILY_V4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || !types.CompareIPNet(addrs[0].IPNet, newAddr) {
+		t.Fatalf("Unexpected addresses on the interface: %v", addrs)
+	}
+}
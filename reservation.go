@@ -0,0 +1,52 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/types"
+)
+
+// defaultReserver returns the default IPAM driver along with the address
+// space the reservation requests apply to
+func (c *controller) defaultReserver(addressSpace string) (ipamapi.Reserver, string, error) {
+	ipam, _, err := c.getIPAMDriver(ipamapi.DefaultIPAM)
+	if err != nil {
+		return nil, "", err
+	}
+
+	r, ok := ipam.(ipamapi.Reserver)
+	if !ok {
+		return nil, "", types.NotImplementedErrorf("ipam driver %q does not support address reservations", ipamapi.DefaultIPAM)
+	}
+
+	if addressSpace == "" {
+		if addressSpace, _, err = ipam.GetDefaultAddressSpaces(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return r, addressSpace, nil
+}
+
+func (c *controller) Reservations(addressSpace string) ([]*ipamapi.Reservation, error) {
+	r, as, err := c.defaultReserver(addressSpace)
+	if err != nil {
+		return nil, err
+	}
+	return r.Reservations(as)
+}
+
+func (c *controller) Reserve(addressSpace string, rsv *ipamapi.Reservation) error {
+	r, as, err := c.defaultReserver(addressSpace)
+	if err != nil {
+		return err
+	}
+	return r.Reserve(as, rsv)
+}
+
+func (c *controller) Unreserve(addressSpace, name string) error {
+	r, as, err := c.defaultReserver(addressSpace)
+	if err != nil {
+		return err
+	}
+	return r.Unreserve(as, name)
+}
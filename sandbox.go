@@ -4,6 +4,7 @@ import (
 	"container/heap"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 	"sync"
@@ -50,6 +51,11 @@ type Sandbox interface {
 	ResolveService(name string) ([]*net.SRV, []net.IP, error)
 	// Endpoints returns all the endpoints connected to the sandbox
 	Endpoints() []Endpoint
+	// Capture streams in pcap format the packets seen on the named sandbox
+	// interface, optionally filtered by a classic BPF program in the
+	// "tcpdump -ddd" format, for the given duration. A zero duration
+	// captures until the returned stream is closed.
+	Capture(ifaceName, bpfFilter string, snaplen int, duration time.Duration) (io.ReadCloser, error)
 }
 
 // SandboxOption is an option setter function type used to pass various options to
@@ -175,6 +181,21 @@ func (sb *sandbox) Statistics() (map[string]*types.InterfaceStatistics, error) {
 	return m, nil
 }
 
+func (sb *sandbox) Capture(ifaceName, bpfFilter string, snaplen int, duration time.Duration) (io.ReadCloser, error) {
+	sb.Lock()
+	osb := sb.osSbox
+	sb.Unlock()
+	if osb == nil {
+		return nil, types.ForbiddenErrorf("sandbox %s for container %s has no network namespace", sb.ID(), sb.ContainerID())
+	}
+
+	if sb.config.useDefaultSandBox {
+		return nil, types.ForbiddenErrorf("capture is not supported on the host sandbox of container %s", sb.ContainerID())
+	}
+
+	return osb.Capture(ifaceName, bpfFilter, snaplen, duration)
+}
+
 func (sb *sandbox) Delete() error {
 	return sb.delete(false)
 }
@@ -406,6 +427,36 @@ func (sb *sandbox) updateGateway(ep *endpoint) error {
 	return nil
 }
 
+// updateInterfaceAddress reprograms the addresses of the endpoint interface
+// and, if the endpoint provides the sandbox gateway, the gateways
+func (sb *sandbox) updateInterfaceAddress(ep *endpoint, addr, addrv6 *net.IPNet) error {
+	sb.Lock()
+	osSbox := sb.osSbox
+	sb.Unlock()
+	if osSbox == nil {
+		return nil
+	}
+
+	ep.Lock()
+	srcName := ep.iface.srcName
+	ep.Unlock()
+
+	for _, iface := range osSbox.Info().Interfaces() {
+		if iface.SrcName() != srcName {
+			continue
+		}
+		if err := iface.SetAddresses(addr, addrv6); err != nil {
+			return fmt.Errorf("failed to update the addresses of interface %s: %v", srcName, err)
+		}
+	}
+
+	if ep == sb.getGatewayEndpoint() {
+		return sb.updateGateway(ep)
+	}
+
+	return nil
+}
+
 func (sb *sandbox) ResolveIP(ip string) string {
 	var svc string
 	log.Debugf("IP To resolve %v", ip)
@@ -778,10 +829,30 @@ func (sb *sandbox) populateNetworkResources(ep *endpoint) error {
 		if i.mac != nil {
 			ifaceOptions = append(ifaceOptions, sb.osSbox.InterfaceOptions().MacAddress(i.mac))
 		}
+		if joinInfo != nil && joinInfo.qosPolicy != nil {
+			ifaceOptions = append(ifaceOptions, sb.osSbox.InterfaceOptions().QosPolicy(joinInfo.qosPolicy))
+		}
 
 		if err := sb.osSbox.AddInterface(i.srcName, i.dstPrefix, ifaceOptions...); err != nil {
 			return fmt.Errorf("failed to add interface %s to sandbox: %v", i.srcName, err)
 		}
+
+		if !sb.config.useDefaultSandBox {
+			if err := sb.osSbox.WatchLinks(sb.handleLinkEvent); err != nil {
+				log.Warnf("Failed to monitor link state for container %s: %v", sb.ContainerID(), err)
+			}
+		}
+
+		sb.seedOperState(ep)
+
+		for _, iface := range sb.osSbox.Info().Interfaces() {
+			if !sb.controller.trafficAccounting() || iface.SrcName() != i.srcName {
+				continue
+			}
+			if err := sb.setupTrafficAccounting(ep, iface.DstName()); err != nil {
+				log.Warnf("Failed to set up traffic accounting for endpoint %s: %v", ep.ID(), err)
+			}
+		}
 	}
 
 	if joinInfo != nil {
@@ -822,6 +893,57 @@ func (sb *sandbox) populateNetworkResources(ep *endpoint) error {
 	return nil
 }
 
+// handleLinkEvent updates the operational state of the endpoint which owns
+// the link reported by the osl sandbox.
+func (sb *sandbox) handleLinkEvent(ev osl.LinkEvent) {
+	sb.Lock()
+	osSbox := sb.osSbox
+	sb.Unlock()
+
+	if osSbox == nil {
+		return
+	}
+
+	for _, i := range osSbox.Info().Interfaces() {
+		if i.DstName() != ev.Name {
+			continue
+		}
+		for _, ep := range sb.getConnectedEndpoints() {
+			if !ep.hasInterface(i.SrcName()) {
+				continue
+			}
+			ep.Lock()
+			prev := ep.operState
+			ep.operState = ev.State
+			ep.Unlock()
+			if prev != ev.State {
+				log.Infof("Interface %s of endpoint %s in container %s is %s", ev.Name, ep.Name(), sb.ContainerID(), ev.State)
+			}
+		}
+	}
+}
+
+// seedOperState records the current state of the endpoint interface in the
+// sandbox, as the link events only report its later changes.
+func (sb *sandbox) seedOperState(ep *endpoint) {
+	sb.Lock()
+	osSbox := sb.osSbox
+	sb.Unlock()
+
+	if osSbox == nil {
+		return
+	}
+
+	for _, i := range osSbox.Info().Interfaces() {
+		if !ep.hasInterface(i.SrcName()) {
+			continue
+		}
+		ep.Lock()
+		ep.operState = i.OperState()
+		ep.Unlock()
+	}
+}
+
 func (sb *sandbox) clearNetworkResources(origEp *endpoint) error {
 	ep := sb.getEndpoint(origEp.id)
 	if ep == nil {
@@ -834,6 +956,9 @@ func (sb *sandbox) clearNetworkResources(origEp *endpoint) error {
 	inDelete := sb.inDelete
 	sb.Unlock()
 	if osSbox != nil {
+		if sb.controller.trafficAccounting() {
+			sb.finalizeTrafficAccounting(ep)
+		}
 		releaseOSSboxResources(osSbox, ep)
 	}
 
@@ -0,0 +1,74 @@
+package libnetwork
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/testutils"
+	"github.com/vishvananda/netlink"
+)
+
+func TestEndpointOperState(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "opstate0", TxQLen: 0},
+		PeerName:  "opstate1",
+	}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatal(err)
+	}
+	peer, err := netlink.LinkByName("opstate1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetUp(peer); err != nil {
+		t.Fatal(err)
+	}
+	defer netlink.LinkDel(peer)
+
+	osSbox, err := osl.NewSandbox(osl.GenerateKey("opstate"), true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osSbox.Destroy()
+
+	ep := &endpoint{name: "ep1", id: "ep1", iface: &endpointInterface{srcName: "opstate0", dstPrefix: "eth"}}
+	sb := &sandbox{id: "opstate", containerID: "opstate", osSbox: osSbox, endpoints: epHeap{ep}}
+
+	if state := ep.OperState(); state != osl.LinkStateUnknown {
+		t.Fatalf("Expected unknown state before join, got %s", state)
+	}
+
+	// As on join, the state is known as soon as the interface is added
+	if err := osSbox.AddInterface("opstate0", "eth"); err != nil {
+		t.Fatal(err)
+	}
+	if err := osSbox.WatchLinks(sb.handleLinkEvent); err != nil {
+		t.Fatal(err)
+	}
+	sb.seedOperState(ep)
+	waitOperState(t, ep, osl.LinkStateUp)
+
+	// Losing the carrier brings the endpoint down
+	if err := netlink.LinkSetDown(peer); err != nil {
+		t.Fatal(err)
+	}
+	waitOperState(t, ep, osl.LinkStateDown)
+
+	if err := netlink.LinkSetUp(peer); err != nil {
+		t.Fatal(err)
+	}
+	waitOperState(t, ep, osl.LinkStateUp)
+}
+
+func waitOperState(t *testing.T, ep *endpoint, state osl.LinkState) {
+	for i := 0; i < 50; i++ {
+		if ep.OperState() == state {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("Endpoint %s state is %s, expected %s", ep.Name(), ep.OperState(), state)
+}
@@ -0,0 +1,370 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+)
+
+// stateExportVersion is the version of the state documents produced by
+// Export. Import accepts the documents up to this version.
+const stateExportVersion = 1
+
+// Categories of the records of an exported state
+const (
+	stateNetworks  = "networks"
+	stateEndpoints = "endpoints"
+	stateSandboxes = "sandboxes"
+	stateDrivers   = "drivers"
+)
+
+// ipamKeyPrefix is the first key of the records of the default IPAM
+const ipamKeyPrefix = "ipam"
+
+// StateRecord is a local store record of an exported state. Key is the key
+// chain of the record, as returned by datastore.ParseKey. The JSON values
+// are exported as is in Value, the others, such as the bit sequences, in
+// Data.
+type StateRecord struct {
+	Key   []string        `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Data  []byte          `json:"data,omitempty"`
+}
+
+func (r *StateRecord) value() []byte {
+	if len(r.Value) > 0 {
+		return r.Value
+	}
+	return r.Data
+}
+
+// StateExport is the document holding the local scope state of a controller
+type StateExport struct {
+	Version int `json:"version"`
+	// Networks holds the network and endpoint count records. The network
+	// records carry the pools and gateways of the networks.
+	Networks  []*StateRecord `json:"networks"`
+	Endpoints []*StateRecord `json:"endpoints"`
+	// Sandboxes holds the records of the sandboxes of the containers. They
+	// are not imported, the containers join the imported endpoints again.
+	Sandboxes []*StateRecord `json:"sandboxes"`
+	// Drivers holds the records the network drivers keep in the store
+	Drivers []*StateRecord `json:"drivers"`
+}
+
+// stateCategory returns the category of the records of the kind, or an
+// empty string for the records which are not exported: the intents of the
+// transactions in progress, the traffic history and the IPAM records. The
+// IPAM state of the local networks is not persisted by the default IPAM, the
+// pools and addresses are requested again from the network and endpoint
+// records on import.
+func stateCategory(kind string) string {
+	switch kind {
+	case datastore.NetworkKeyPrefix, epCntKeyPrefix:
+		return stateNetworks
+	case datastore.EndpointKeyPrefix:
+		return stateEndpoints
+	case sandboxPrefix:
+		return stateSandboxes
+	case datastore.TxnKeyPrefix, trafficKeyPrefix, ipamKeyPrefix:
+		return ""
+	default:
+		return stateDrivers
+	}
+}
+
+func (se *StateExport) categories() map[string][]*StateRecord {
+	return map[string][]*StateRecord{
+		stateNetworks:  se.Networks,
+		stateEndpoints: se.Endpoints,
+		stateSandboxes: se.Sandboxes,
+		stateDrivers:   se.Drivers,
+	}
+}
+
+func (c *controller) Export() ([]byte, error) {
+	store := c.getStore(datastore.LocalScope)
+	if store == nil {
+		return nil, types.NotFoundErrorf("no state to export: local store is not initialized")
+	}
+
+	kvList, err := store.KVStore().List(datastore.Key())
+	if err != nil && err != datastore.ErrKeyNotFound {
+		return nil, fmt.Errorf("failed to list the local store records: %v", err)
+	}
+
+	se := &StateExport{Version: stateExportVersion}
+	for _, kvPair := range kvList {
+		// Skip the directories
+		if len(kvPair.Value) == 0 {
+			continue
+		}
+		key, err := datastore.ParseKey(kvPair.Key)
+		if err != nil {
+			return nil, err
+		}
+		r := &StateRecord{Key: key}
+		if json.Valid(kvPair.Value) {
+			r.Value = kvPair.Value
+		} else {
+			r.Data = kvPair.Value
+		}
+		switch stateCategory(key[0]) {
+		case stateNetworks:
+			se.Networks = append(se.Networks, r)
+		case stateEndpoints:
+			se.Endpoints = append(se.Endpoints, r)
+		case stateSandboxes:
+			se.Sandboxes = append(se.Sandboxes, r)
+		case stateDrivers:
+			se.Drivers = append(se.Drivers, r)
+		}
+	}
+
+	return json.Marshal(se)
+}
+
+func (c *controller) Import(data []byte) (err error) {
+	var se StateExport
+	if err := json.Unmarshal(data, &se); err != nil {
+		return types.BadRequestErrorf("invalid state document: %v", err)
+	}
+	if se.Version < 1 || se.Version > stateExportVersion {
+		return types.BadRequestErrorf("unsupported state document version %d", se.Version)
+	}
+
+	store := c.getStore(datastore.LocalScope)
+	if store == nil {
+		return types.NotFoundErrorf("cannot import the state: local store is not initialized")
+	}
+
+	networks, endpoints, err := c.validateImport(&se)
+	if err != nil {
+		return err
+	}
+
+	var (
+		created  []*network
+		attached []*endpoint
+	)
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(attached) - 1; i >= 0; i-- {
+			if e := attached[i].Delete(true); e != nil {
+				log.Warnf("Failed to roll back imported endpoint %s: %v", attached[i].Name(), e)
+			}
+		}
+		for i := len(created) - 1; i >= 0; i-- {
+			if e := created[i].Delete(); e != nil {
+				log.Warnf("Failed to roll back imported network %s: %v", created[i].Name(), e)
+			}
+		}
+	}()
+
+	for _, n := range networks {
+		// Request the exported pools and gateways again
+		n.replayIpamConfig()
+		if err = c.createNetwork(n); err != nil {
+			log.Errorf("Failed to import network %s: %v", n.Name(), err)
+			return err
+		}
+		created = append(created, n)
+	}
+
+	for _, ep := range endpoints {
+		if err = ep.getNetwork().importEndpoint(ep); err != nil {
+			log.Errorf("Failed to import endpoint %s: %v", ep.Name(), err)
+			return err
+		}
+		attached = append(attached, ep)
+	}
+
+	// The drivers wrote their records for the networks and endpoints they
+	// created, the other ones are imported as they are
+	var count int
+	txn := store.NewTxn()
+	for _, r := range se.Drivers {
+		key := datastore.Key(r.Key...)
+		_, e := store.KVStore().Get(key)
+		switch {
+		case e == datastore.ErrKeyNotFound:
+			txn.PutRecord(r.Key, r.value(), nil)
+			count++
+		case e != nil:
+			err = fmt.Errorf("failed to check record %s: %v", key, e)
+			return err
+		}
+	}
+	if count > 0 {
+		if err = txn.Commit(); err != nil {
+			if err == datastore.ErrKeyModified {
+				err = types.RetryErrorf("local store modified while importing the state. retry might fix the error")
+			}
+			return err
+		}
+	}
+
+	log.Infof("Imported %d networks, %d endpoints and %d driver records, skipped %d sandboxes",
+		len(created), len(attached), count, len(se.Sandboxes))
+
+	return nil
+}
+
+// importEndpoint reserves the exported addresses of the endpoint and creates
+// it in the driver, detached from any sandbox
+func (n *network) importEndpoint(ep *endpoint) (err error) {
+	ipam, _, err := n.getController().getIPAMDriver(n.ipamType)
+	if err != nil {
+		return err
+	}
+
+	if err = ep.assignAddress(ipam, true, ep.Iface().AddressIPv6() != nil); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			ep.releaseAddress()
+		}
+	}()
+
+	if err = n.addEndpoint(ep); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if e := ep.deleteEndpoint(false); e != nil {
+				log.Warnf("cleaning up endpoint failed %s : %v", ep.Name(), e)
+			}
+		}
+	}()
+
+	if err = n.getController().updateToStore(ep); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if e := n.getController().deleteFromStore(ep); e != nil {
+				log.Warnf("error rolling back endpoint %s from store: %v", ep.Name(), e)
+			}
+		}
+	}()
+
+	n.getController().watchSvcRecord(ep)
+	defer func() {
+		if err != nil {
+			n.getController().unWatchSvcRecord(ep)
+		}
+	}()
+
+	return n.getEpCnt().IncEndpointCnt()
+}
+
+// validateImport checks the records of the state document are well formed
+// and that its networks clash with none of the existing ones. It returns the
+// networks and endpoints of the document.
+func (c *controller) validateImport(se *StateExport) ([]*network, []*endpoint, error) {
+	for category, records := range se.categories() {
+		for _, r := range records {
+			if len(r.Key) == 0 || len(r.value()) == 0 {
+				return nil, nil, types.BadRequestErrorf("invalid %s record %v", category, r.Key)
+			}
+			if stateCategory(r.Key[0]) != category {
+				return nil, nil, types.BadRequestErrorf("record %s does not belong to the %s", datastore.Key(r.Key...), category)
+			}
+		}
+	}
+
+	existing, err := c.getNetworksFromStore()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		networks []*network
+		ids      = make(map[string]*network)
+		names    = make(map[string]bool)
+	)
+	for _, r := range se.Networks {
+		if r.Key[0] != datastore.NetworkKeyPrefix {
+			continue
+		}
+		n := &network{ctrlr: c, persist: true, drvOnce: &sync.Once{}}
+		if err := n.SetValue(r.Value); err != nil {
+			return nil, nil, types.BadRequestErrorf("invalid network record %s: %v", datastore.Key(r.Key...), err)
+		}
+		if ids[n.ID()] != nil {
+			return nil, nil, types.BadRequestErrorf("network %s exported more than once", n.ID())
+		}
+		if names[n.Name()] {
+			return nil, nil, NetworkNameError(n.Name())
+		}
+		networks = append(networks, n)
+		ids[n.ID()] = n
+		names[n.Name()] = true
+
+		for _, e := range existing {
+			if e.ID() == n.ID() {
+				return nil, nil, types.ForbiddenErrorf("network %s already exists", n.ID())
+			}
+			if e.Name() == n.Name() {
+				return nil, nil, NetworkNameError(n.Name())
+			}
+			if err := n.checkPoolOverlap(e); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	// The endpoints must be the ones of the exported networks
+	for _, r := range se.Networks {
+		if r.Key[0] != datastore.NetworkKeyPrefix && (len(r.Key) < 2 || ids[r.Key[1]] == nil) {
+			return nil, nil, types.BadRequestErrorf("record %s does not belong to an exported network", datastore.Key(r.Key...))
+		}
+	}
+	var endpoints []*endpoint
+	for _, r := range se.Endpoints {
+		if len(r.Key) < 3 || ids[r.Key[1]] == nil {
+			return nil, nil, types.BadRequestErrorf("record %s does not belong to an exported network", datastore.Key(r.Key...))
+		}
+		ep := &endpoint{network: ids[r.Key[1]]}
+		if err := ep.SetValue(r.Value); err != nil || ep.iface == nil {
+			return nil, nil, types.BadRequestErrorf("invalid endpoint record %s: %v", datastore.Key(r.Key...), err)
+		}
+		if ep.generic == nil {
+			ep.generic = make(map[string]interface{})
+		}
+		// The sandboxes are the ones of the containers of the exporting
+		// host, the endpoints are imported detached
+		ep.sandboxID = ""
+		ep.joinInfo = nil
+		endpoints = append(endpoints, ep)
+	}
+
+	return networks, endpoints, nil
+}
+
+// checkPoolOverlap fails if a pool of the network overlaps with one of the
+// other network in the same address space
+func (n *network) checkPoolOverlap(o *network) error {
+	if n.ipamType != o.ipamType || n.addrSpace != o.addrSpace {
+		return nil
+	}
+	for _, v := range []int{4, 6} {
+		for _, i := range n.getIPInfo(v) {
+			for _, oi := range o.getIPInfo(v) {
+				if i.Pool != nil && oi.Pool != nil && netutils.NetworkOverlaps(i.Pool, oi.Pool) {
+					return types.ForbiddenErrorf("subnet %s of network %s overlaps with subnet %s of network %s",
+						i.Pool, n.Name(), oi.Pool, o.Name())
+				}
+			}
+		}
+	}
+	return nil
+}
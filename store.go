@@ -9,6 +9,7 @@ import (
 	"github.com/docker/libkv/store/etcd"
 	"github.com/docker/libkv/store/zookeeper"
 	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/types"
 )
 
 func registerKVStores() {
@@ -48,10 +49,53 @@ func (c *controller) initStores() error {
 		}
 	}
 
+	for _, store := range c.getStores() {
+		if err := store.RecoverTxns(); err != nil {
+			log.Warnf("Failed to recover the interrupted transactions of the %s store: %v", store.Scope(), err)
+		}
+	}
+
+	c.upgradeStores()
+
+	for _, store := range c.getStores() {
+		n, err := store.Reencrypt()
+		if err != nil {
+			log.Warnf("Failed to re-encrypt the records of the %s store: %v", store.Scope(), err)
+		}
+		if n > 0 {
+			log.Infof("Re-encrypted %d records of the %s store", n, store.Scope())
+		}
+	}
+
 	c.startWatch()
 	return nil
 }
 
+// upgradeStores migrates the records of the stores to their current schema
+// version, or only reports the ones needing it in dry run mode
+func (c *controller) upgradeStores() {
+	c.Lock()
+	dryRun := c.cfg.Daemon.UpgradeDryRun
+	c.Unlock()
+
+	for _, store := range c.getStores() {
+		keys, err := store.Upgrade(dryRun)
+		if err != nil {
+			log.Warnf("Failed to upgrade the records of the %s store: %v", store.Scope(), err)
+		}
+		for _, key := range keys {
+			if dryRun {
+				log.Infof("Record %s of the %s store needs a schema upgrade", key, store.Scope())
+			} else {
+				log.Debugf("Upgraded record %s of the %s store", key, store.Scope())
+			}
+		}
+		if len(keys) > 0 && !dryRun {
+			log.Infof("Upgraded %d records of the %s store", len(keys), store.Scope())
+		}
+	}
+}
+
 func (c *controller) closeStores() {
 	for _, store := range c.getStores() {
 		store.Close()
@@ -234,6 +278,34 @@ func (c *controller) updateToStore(kvObject datastore.KVObject) error {
 	return nil
 }
 
+// updateToStoreTxn atomically updates the objects, which must belong to the
+// same scope, in a single transaction
+func (c *controller) updateToStoreTxn(kvObjects ...datastore.KVObject) error {
+	if len(kvObjects) == 0 {
+		return nil
+	}
+	cs := c.getStore(kvObjects[0].DataScope())
+	if cs == nil {
+		return fmt.Errorf("datastore for scope %q is not initialized ", kvObjects[0].DataScope())
+	}
+
+	txn := cs.NewTxn()
+	for _, kvObject := range kvObjects {
+		if kvObject.DataScope() != cs.Scope() {
+			return fmt.Errorf("object type %T of scope %q in a %q scope transaction", kvObject, kvObject.DataScope(), cs.Scope())
+		}
+		txn.Put(kvObject)
+	}
+	if err := txn.Commit(); err != nil {
+		if err == datastore.ErrKeyModified {
+			return err
+		}
+		return fmt.Errorf("failed to update store in transaction: %v", err)
+	}
+
+	return nil
+}
+
 func (c *controller) deleteFromStore(kvObject datastore.KVObject) error {
 	cs := c.getStore(kvObject.DataScope())
 	if cs == nil {
@@ -280,13 +352,17 @@ func (c *controller) unWatchSvcRecord(ep *endpoint) {
 	c.unWatchCh <- ep
 }
 
-func (c *controller) networkWatchLoop(nw *netWatch, ep *endpoint, ecCh <-chan datastore.KVObject) {
+func (c *controller) networkWatchLoop(nw *netWatch, ep *endpoint, w *datastore.Watcher) {
 	for {
 		select {
 		case <-nw.stopCh:
 			return
-		case o := <-ecCh:
-			ec := o.(*endpointCnt)
+		case e := <-w.Events():
+			// The network is going away
+			if e.Type == datastore.WatchDelete {
+				break
+			}
+			ec := e.Object.(*endpointCnt)
 
 			epl, err := ec.n.getEndpointsFromStore()
 			if err != nil {
@@ -308,13 +384,14 @@ func (c *controller) networkWatchLoop(nw *netWatch, ep *endpoint, ecCh <-chan da
 				}
 
 				if ep, ok := nw.remoteEps[lEp.ID()]; ok {
-					// On a container rename EP ID will remain
-					// the same but the name will change. service
-					// records should reflect the change.
+					// On a container rename or an address change EP
+					// ID will remain the same but the name or the
+					// addresses will change. service records should
+					// reflect the change.
 					// Keep old EP entry in the delEpMap and add
 					// EP from the store (which has the new name)
 					// into the new list
-					if lEp.name == ep.name {
+					if lEp.name == ep.name && sameAddresses(lEp, ep) {
 						delete(delEpMap, lEp.ID())
 						continue
 					}
@@ -390,13 +467,13 @@ func (c *controller) processEndpointCreate(nmap map[string]*netWatch, ep *endpoi
 		return
 	}
 
-	ch, err := store.Watch(ep.getNetwork().getEpCnt(), nw.stopCh)
+	w, err := store.ResumableWatch(ep.getNetwork().getEpCnt(), false, nw.stopCh)
 	if err != nil {
 		log.Warnf("Error creating watch for network: %v", err)
 		return
 	}
 
-	go c.networkWatchLoop(nw, ep, ch)
+	go c.networkWatchLoop(nw, ep, w)
 }
 
 func (c *controller) processEndpointDelete(nmap map[string]*netWatch, ep *endpoint) {
@@ -473,3 +550,11 @@ var populateSpecial NetworkWalker = func(nw Network) bool {
 	}
 	return false
 }
+
+func sameAddresses(a, b *endpoint) bool {
+	ai, bi := a.Iface(), b.Iface()
+	if ai == nil || bi == nil {
+		return ai == bi
+	}
+	return types.CompareIPNet(ai.Address(), bi.Address()) && types.CompareIPNet(ai.AddressIPv6(), bi.AddressIPv6())
+}
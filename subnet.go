@@ -0,0 +1,254 @@
+package libnetwork
+
+import (
+	"net"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/types"
+)
+
+// subnetUpdater returns the network driver and ipam driver handling the
+// subnet changes on the network
+func (n *network) subnetUpdater() (driverapi.SubnetUpdater, ipamapi.Ipam, error) {
+	if n.hasSpecialDriver() {
+		return nil, nil, types.ForbiddenErrorf("subnets cannot be changed on network %s", n.Name())
+	}
+
+	d, err := n.driver(true)
+	if err != nil {
+		return nil, nil, err
+	}
+	su, ok := d.(driverapi.SubnetUpdater)
+	if !ok {
+		return nil, nil, types.NotImplementedErrorf("driver %s does not support subnet changes on live networks", n.Type())
+	}
+
+	ipam, _, err := n.getController().getIPAMDriver(n.ipamType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return su, ipam, nil
+}
+
+func (n *network) AddSubnet(cfg *IpamConf, v6 bool) (err error) {
+	if cfg == nil {
+		return types.BadRequestErrorf("missing ipam configuration for the subnet")
+	}
+	if v6 && !n.IPv6Enabled() {
+		return types.ForbiddenErrorf("cannot add an IPv6 subnet to network %s, IPv6 is not enabled", n.Name())
+	}
+
+	su, ipam, err := n.subnetUpdater()
+	if err != nil {
+		return err
+	}
+
+	ipVer := 4
+	if v6 {
+		ipVer = 6
+	}
+
+	d, err := n.ipamAllocateConf(ipVer, ipam, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			n.ipamReleaseInfo(ipam, d)
+		}
+	}()
+
+	if err = su.AddSubnet(n.ID(), d.IPAMData, v6); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if e := su.RemoveSubnet(n.ID(), d.IPAMData, v6); e != nil {
+				log.Warnf("Failed to roll back driver subnet %s on network %s: %v", d.Pool, n.Name(), e)
+			}
+		}
+	}()
+
+	n.Lock()
+	cfgList, infoList := n.ipamLists(ipVer)
+	oldCfg, oldInfo := *cfgList, *infoList
+	*cfgList = append(pinIpamConfigs(oldCfg, oldInfo), cfg)
+	*infoList = append(append([]*IpamInfo{}, oldInfo...), d)
+	n.Unlock()
+
+	if err = n.getController().updateToStore(n); err != nil {
+		n.Lock()
+		*cfgList, *infoList = oldCfg, oldInfo
+		n.Unlock()
+		return err
+	}
+
+	log.Debugf("Added subnet %s to network %s (%s)", d.Pool, n.Name(), n.ID())
+
+	return nil
+}
+
+func (n *network) RemoveSubnet(pool string) (err error) {
+	_, nw, err := net.ParseCIDR(pool)
+	if err != nil {
+		return types.BadRequestErrorf("invalid subnet %s: %v", pool, err)
+	}
+
+	su, ipam, err := n.subnetUpdater()
+	if err != nil {
+		return err
+	}
+
+	ipVer := 4
+	if nw.IP.To4() == nil {
+		ipVer = 6
+	}
+
+	n.Lock()
+	cfgList, infoList := n.ipamLists(ipVer)
+	i := -1
+	for j, d := range *infoList {
+		if types.CompareIPNet(d.Pool, nw) {
+			i = j
+			break
+		}
+	}
+	if i == -1 {
+		n.Unlock()
+		return types.NotFoundErrorf("subnet %s not found on network %s", pool, n.Name())
+	}
+	if ipVer == 4 && len(*infoList) == 1 {
+		n.Unlock()
+		return types.ForbiddenErrorf("cannot remove the only IPv4 subnet %s of network %s", pool, n.Name())
+	}
+	oldCfg, oldInfo := *cfgList, *infoList
+	d := oldInfo[i]
+	n.Unlock()
+
+	c := n.getController()
+	if !c.drainPool(d.PoolID) {
+		return types.ForbiddenErrorf("subnet %s is already being removed from network %s", pool, n.Name())
+	}
+	defer c.undrainPool(d.PoolID)
+
+	epl, err := n.getEndpointsFromStore()
+	if err != nil {
+		return err
+	}
+	for _, ep := range epl {
+		for _, addr := range []*net.IPNet{ep.Iface().Address(), ep.Iface().AddressIPv6()} {
+			if addr != nil && d.Pool.Contains(addr.IP) {
+				return types.ForbiddenErrorf("subnet %s is in use by endpoint %s (%s)", pool, ep.Name(), addr.IP)
+			}
+		}
+	}
+
+	// An endpoint being created may hold an address it has not stored yet
+	ip, err := poolInUse(ipam, d)
+	if err != nil {
+		return err
+	}
+	if ip != nil {
+		return types.ForbiddenErrorf("subnet %s is in use, address %s is allocated", pool, ip)
+	}
+
+	if err = su.RemoveSubnet(n.ID(), d.IPAMData, ipVer == 6); err != nil {
+		return err
+	}
+
+	pinned := pinIpamConfigs(oldCfg, oldInfo)
+	n.Lock()
+	*cfgList = append(pinned[:i:i], pinned[i+1:]...)
+	*infoList = append(append([]*IpamInfo{}, oldInfo[:i]...), oldInfo[i+1:]...)
+	n.Unlock()
+
+	if err = n.getController().updateToStore(n); err != nil {
+		n.Lock()
+		*cfgList, *infoList = oldCfg, oldInfo
+		n.Unlock()
+		if e := su.AddSubnet(n.ID(), d.IPAMData, ipVer == 6); e != nil {
+			log.Warnf("Failed to roll back driver subnet %s removal on network %s: %v", d.Pool, n.Name(), e)
+		}
+		return err
+	}
+
+	n.ipamReleaseInfo(ipam, d)
+
+	log.Debugf("Removed subnet %s from network %s (%s)", d.Pool, n.Name(), n.ID())
+
+	return nil
+}
+
+// drainPool stops the allocation of endpoint addresses from the pool. It
+// returns once the allocations in progress are over, or false if the pool
+// is already being drained.
+func (c *controller) drainPool(poolID string) bool {
+	c.poolsLock.Lock()
+	defer c.poolsLock.Unlock()
+
+	if c.drainingPools[poolID] {
+		return false
+	}
+	c.drainingPools[poolID] = true
+	return true
+}
+
+func (c *controller) undrainPool(poolID string) {
+	c.poolsLock.Lock()
+	delete(c.drainingPools, poolID)
+	c.poolsLock.Unlock()
+}
+
+// poolInUse returns an address of the pool allocated to an endpoint, as
+// reported by the ipam driver. It returns nil if the driver cannot report
+// its allocations.
+func poolInUse(ipam ipamapi.Ipam, d *IpamInfo) (net.IP, error) {
+	inv, ok := ipam.(ipamapi.Inventory)
+	if !ok {
+		return nil, nil
+	}
+
+	var inUse net.IP
+	held := heldAddresses(ipam, d)
+	err := inv.WalkAllocatedAddresses(d.PoolID, func(ip net.IP) bool {
+		if !held(ip) {
+			inUse = ip
+			return true
+		}
+		return false
+	})
+	if _, ok := err.(types.NotImplementedError); ok {
+		return nil, nil
+	}
+
+	return inUse, err
+}
+
+// ipamLists returns the ipam configuration and operational lists for the
+// ip version. Caller must hold the network lock.
+func (n *network) ipamLists(ipVer int) (*[]*IpamConf, *[]*IpamInfo) {
+	if ipVer == 6 {
+		return &n.ipamV6Config, &n.ipamV6Info
+	}
+	return &n.ipamV4Config, &n.ipamV4Info
+}
+
+// pinIpamConfigs returns a copy of the configurations where the ones which
+// did not specify a pool carry the one allocated to them. Once the network
+// has more than one subnet, the pools request replay cannot tell the
+// automatically chosen ones apart.
+func pinIpamConfigs(cfgList []*IpamConf, infoList []*IpamInfo) []*IpamConf {
+	l := make([]*IpamConf, 0, len(cfgList))
+	for i, c := range cfgList {
+		if c.PreferredPool == "" && i < len(infoList) && infoList[i].Pool != nil {
+			cc := *c
+			cc.PreferredPool = infoList[i].Pool.String()
+			c = &cc
+		}
+		l = append(l, c)
+	}
+	return l
+}
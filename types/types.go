@@ -28,9 +28,11 @@ type EncryptionKey struct {
 // UUID represents a globally unique ID of various resources like network and endpoint
 type UUID string
 
-// QosPolicy represents a quality of service policy on an endpoint
+// QosPolicy represents a quality of service policy on an endpoint.
+// Bandwidths are expressed in bytes per second, zero meaning unlimited.
 type QosPolicy struct {
-	MaxEgressBandwidth uint64
+	MaxEgressBandwidth  uint64
+	MaxIngressBandwidth uint64
 }
 
 // TransportPort represents a local Layer 4 endpoint
@@ -0,0 +1,5 @@
+package libnetwork
+
+// version is the libnetwork version advertised to the cluster. Builds
+// may set it with -ldflags "-X github.com/docker/libnetwork.version=<version>".
+var version = "0.8.0-dev.2"